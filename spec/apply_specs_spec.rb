@@ -56,6 +56,28 @@ describe 'apply-specs' do
     expect(rendered_errand_run).to include('TIMEOUT=1200')
   end
 
+  it 'applies the addons as the apply-specs field manager without forcing conflicts' do
+    expect(rendered_deploy_specs).to include('-field-manager apply-specs')
+    expect(rendered_deploy_specs).to include('-force-conflicts=false')
+  end
+
+  context 'when conflicts are forced' do
+    let(:default_properties) do
+      {
+        'addons' => ['metrics-server'],
+        'force-conflicts' => true
+      }
+    end
+
+    it 'passes it to apply-specs' do
+      expect(rendered_deploy_specs).to include('-force-conflicts=true')
+    end
+  end
+
+  it 'waits 300 seconds for each object to become ready by default' do
+    expect(rendered_deploy_specs).to include('-ready-timeout 300s')
+  end
+
   context 'when errand run timeout is re-configured' do
     let(:default_properties) do
       {
@@ -70,8 +92,52 @@ describe 'apply-specs' do
     end
   end
 
+  context 'when the readiness timeout is re-configured' do
+    let(:default_properties) do
+      {
+        'addons' => ["metrics-server"],
+        'ready-timeout-sec' => 60
+      }
+    end
+
+    it 'passes it to apply-specs' do
+      expect(rendered_deploy_specs).to include('-ready-timeout 60s')
+    end
+  end
+
+  let(:rendered_addons) do
+    YAML.safe_load(compiled_template('apply-specs', 'config/addons.yml', default_properties, link_spec))
+  end
+
+  let(:addon_names) { rendered_addons['addons'] }
+
+  it 'runs apply-specs with the rendered addons' do
+    expect(rendered_deploy_specs).to include('/var/vcap/packages/kubo-tools/bin/apply-specs')
+    expect(rendered_deploy_specs).to include('-config /var/vcap/jobs/apply-specs/config/addons.yml')
+  end
+
+  it 'renders the manifests of every addon in the registry' do
+    job_spec = YAML.safe_load(File.read(File.join(__dir__, '../jobs/apply-specs/spec')))
+    rendered = job_spec['templates'].values
+    Dir.glob(File.join(__dir__, '../jobs/apply-specs/templates/addons/*/addon.yml')).each do |descriptor|
+      name = File.basename(File.dirname(descriptor))
+      expect(rendered).to include("addons/#{name}/addon.yml")
+      YAML.safe_load(File.read(descriptor))['manifests'].each do |manifest|
+        expect(rendered).to include("addons/#{name}/#{manifest}")
+      end
+    end
+  end
+
+  it 'labels the objects with the release version' do
+    expect(rendered_addons['release-version']).to eq('dev')
+  end
+
+  it 'loads the addons from the registry in the job' do
+    expect(rendered_addons['registry']).to eq('/var/vcap/jobs/apply-specs/addons')
+  end
+
   it 'does not apply the standard storage class by default' do
-    expect(rendered_deploy_specs).to_not include('apply_spec "storage-class-gce.yml"')
+    expect(addon_names).to_not include('storage-class-gce')
   end
 
   context 'on GCE' do
@@ -89,7 +155,7 @@ describe 'apply-specs' do
     end
 
     it 'applies the standard storage class' do
-      expect(rendered_deploy_specs).to include('apply_spec "storage-class-gce.yml"')
+      expect(rendered_addons['addons']).to include('storage-class-gce')
     end
   end
 
@@ -108,7 +174,7 @@ describe 'apply-specs' do
     end
 
     it 'does not apply the standard storage class' do
-      expect(rendered_deploy_specs).to_not include('apply_spec "storage-class-gce.yml"')
+      expect(addon_names).to_not include('storage-class-gce')
     end
   end
 
@@ -123,7 +189,7 @@ describe 'apply-specs' do
     end
 
     it 'does not apply the standard storage class' do
-      expect(rendered_deploy_specs).to_not include('apply_spec "storage-class-gce.yml"')
+      expect(addon_names).to_not include('storage-class-gce')
     end
   end
 
@@ -135,26 +201,24 @@ describe 'apply-specs' do
     end
 
     it 'deploys only specified addons' do
-      rendered = rendered_deploy_specs
-      expect(rendered).to match(/apply_spec "metrics-server\/"/)
+      expect(rendered_addons['addons']).to eq(['metrics-server'])
     end
 
     it 'does not deploy unspecified addons' do
-      rendered = rendered_deploy_specs
-      expect(rendered).to_not match(/apply_spec "coredns.yml"/)
-      expect(rendered).to_not match(/apply_spec "i-should-not-be-here.yml"/)
+      expect(addon_names).to_not include('coredns')
     end
   end
 
-  context 'when addons are misconfigured' do
+  context 'when an addons-spec is given' do
     let(:default_properties) do
       {
-        'addons' => ['crap']
+        'addons' => ['coredns'],
+        'addons-spec' => "apiVersion: v1\nkind: Namespace\nmetadata:\n  name: team-a\n"
       }
     end
 
-    it 'deploys throws a templating error' do
-      expect {rendered_deploy_specs}.to raise_error(RuntimeError, "crap is not a supported addon")
+    it 'applies it as the addons-spec addon' do
+      expect(addon_names).to eq(['coredns', 'addons-spec'])
     end
   end
 end
@@ -0,0 +1,45 @@
+# frozen_string_literal: true
+
+require 'rspec'
+require 'spec_helper'
+require 'yaml'
+
+describe 'bbr-kube-apiserver' do
+  let(:link_spec) do
+    {
+      'etcd' => {
+        'address' => 'fake-etcd-address',
+        'properties' => {},
+        'instances' => [
+          { 'name' => 'etcd', 'index' => 0, 'address' => 'fake-etcd-address-0' },
+          { 'name' => 'etcd', 'index' => 1, 'address' => 'fake-etcd-address-1' }
+        ]
+      }
+    }
+  end
+
+  it 'backs up from one instance and restores to all' do
+    rendered = compiled_template('bbr-kube-apiserver', 'metadata', {}, link_spec)
+    expect(rendered).to include("backup_name: etcd\n")
+    expect(rendered).to include("backup_one_restore_all: true\n")
+  end
+
+  it 'snapshots etcd into the artifact directory' do
+    rendered = compiled_template('bbr-kube-apiserver', 'backup', {}, link_spec)
+    expect(rendered).to start_with("#!/bin/bash\n")
+    expect(rendered).to include('/var/vcap/packages/kubo-tools/bin/etcd-backup')
+    expect(rendered).to include('-endpoints "https://fake-etcd-address-0:2379,https://fake-etcd-address-1:2379"')
+    expect(rendered).to include('-artifact-dir "${BBR_ARTIFACT_DIRECTORY}"')
+  end
+
+  it 'restores on the bootstrap instance' do
+    rendered = compiled_template('bbr-kube-apiserver', 'restore', {}, link_spec, bootstrap: true)
+    expect(rendered).to include('/var/vcap/packages/kubo-tools/bin/etcd-restore')
+    expect(rendered).not_to include('-verify-only')
+  end
+
+  it 'only verifies the artifact on the other instances' do
+    rendered = compiled_template('bbr-kube-apiserver', 'restore', {}, link_spec, bootstrap: false)
+    expect(rendered).to include('-verify-only')
+  end
+end
@@ -2,6 +2,7 @@
 
 require 'rspec'
 require 'spec_helper'
+require 'json'
 require 'yaml'
 
 describe 'kube-apiserver' do
@@ -89,9 +90,9 @@ describe 'kube-apiserver' do
   end
 
   it 'sets feature gates if the property is defined' do
-    rendered_kube_apiserver_bpm_yml = compiled_template(
+    rendered_k8s_args = compiled_template(
       'kube-apiserver',
-      'config/bpm.yml',
+      'config/k8s-args.json',
       {
         'k8s-args' => {
           'feature-gates' => {
@@ -103,14 +104,14 @@ describe 'kube-apiserver' do
       link_spec
     )
 
-    bpm_yml = YAML.safe_load(rendered_kube_apiserver_bpm_yml)
-    expect(bpm_yml['processes'][0]['args']).to include('--feature-gates=CustomFeature1=true,CustomFeature2=false')
+    k8s_args = JSON.parse(rendered_k8s_args)['k8s-args']
+    expect(k8s_args['feature-gates']).to eq('CustomFeature1' => true, 'CustomFeature2' => false)
   end
 
   it 'set oidc properties' do
-    rendered_kube_apiserver_bpm_yml = compiled_template(
+    rendered_k8s_args = compiled_template(
       'kube-apiserver',
-      'config/bpm.yml',
+      'config/k8s-args.json',
       {
         'k8s-args' => {
           'oidc-username-prefix' => 'oidc:',
@@ -120,8 +121,8 @@ describe 'kube-apiserver' do
       link_spec
     )
 
-    bpm_yml = YAML.safe_load(rendered_kube_apiserver_bpm_yml)
-    expect(bpm_yml['processes'][0]['args']).to include('--oidc-username-prefix=oidc:')
-    expect(bpm_yml['processes'][0]['args']).to include('--oidc-groups-prefix=oidc:')
+    k8s_args = JSON.parse(rendered_k8s_args)['k8s-args']
+    expect(k8s_args['oidc-username-prefix']).to eq('oidc:')
+    expect(k8s_args['oidc-groups-prefix']).to eq('oidc:')
   end
 end
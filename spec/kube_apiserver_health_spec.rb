@@ -0,0 +1,28 @@
+# frozen_string_literal: true
+
+require 'rspec'
+require 'spec_helper'
+require 'yaml'
+
+describe 'kube-apiserver health' do
+  it 'probes the health endpoints with a client certificate' do
+    rendered = compiled_template('kube-apiserver', 'bin/ensure_apiserver_healthy', {}, {})
+    expect(rendered).to include('/var/vcap/packages/kubo-tools/bin/apiserver-health')
+    expect(rendered).to include('-kubeconfig /var/vcap/jobs/kube-apiserver/config/health-kubeconfig')
+    expect(rendered).not_to include('Bearer')
+
+    kubeconfig = YAML.safe_load(compiled_template('kube-apiserver', 'config/health-kubeconfig', {}, {}))
+    user = kubeconfig['users'][0]['user']
+    expect(user['client-certificate']).to eq('/var/vcap/jobs/kube-apiserver/config/kubelet-client-cert.pem')
+    expect(user['client-key']).to eq('/var/vcap/jobs/kube-apiserver/config/kubelet-client-key.pem')
+    expect(user).not_to have_key('token')
+  end
+
+  it 'waits for the restored API server to be healthy after a restore' do
+    rendered = compiled_template('bbr-kube-apiserver', 'post-restore-unlock', {}, {})
+    expect(rendered).to include('/var/vcap/jobs/kube-apiserver/bin/ensure_apiserver_healthy "300s"')
+
+    rendered = compiled_template('bbr-kube-apiserver', 'post-restore-unlock', { 'health-timeout-sec' => 30 }, {})
+    expect(rendered).to include('ensure_apiserver_healthy "30s"')
+  end
+end
@@ -2,14 +2,15 @@
 
 require 'rspec'
 require 'spec_helper'
+require 'json'
 require 'yaml'
 
 describe 'kube_controller_manager' do
   context 'horizontal pod autoscaling' do
     it 'sets the properties' do
-      rendered_kube_controller_manager_bpm_yml = compiled_template(
+      rendered_k8s_args = compiled_template(
         'kube-controller-manager',
-        'config/bpm.yml',
+        'config/k8s-args.json',
         'k8s-args' => {
           'horizontal-pod-autoscaler-downscale-delay' => '2m0s',
           'horizontal-pod-autoscaler-upscale-delay' => '2m0s',
@@ -19,12 +20,12 @@ describe 'kube_controller_manager' do
         }
       )
 
-      bpm_yml = YAML.safe_load(rendered_kube_controller_manager_bpm_yml)
-      expect(bpm_yml['processes'][0]['args']).to include('--horizontal-pod-autoscaler-downscale-delay=2m0s')
-      expect(bpm_yml['processes'][0]['args']).to include('--horizontal-pod-autoscaler-upscale-delay=2m0s')
-      expect(bpm_yml['processes'][0]['args']).to include('--horizontal-pod-autoscaler-sync-period=40s')
-      expect(bpm_yml['processes'][0]['args']).to include('--horizontal-pod-autoscaler-tolerance=0.2')
-      expect(bpm_yml['processes'][0]['args']).to include('--horizontal-pod-autoscaler-use-rest-clients=false')
+      k8s_args = JSON.parse(rendered_k8s_args)['k8s-args']
+      expect(k8s_args['horizontal-pod-autoscaler-downscale-delay']).to eq('2m0s')
+      expect(k8s_args['horizontal-pod-autoscaler-upscale-delay']).to eq('2m0s')
+      expect(k8s_args['horizontal-pod-autoscaler-sync-period']).to eq('40s')
+      expect(k8s_args['horizontal-pod-autoscaler-tolerance']).to eq('0.2')
+      expect(k8s_args['horizontal-pod-autoscaler-use-rest-clients']).to eq(false)
     end
   end
   it 'has no http proxy when no proxy is defined' do
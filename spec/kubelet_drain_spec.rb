@@ -3,15 +3,14 @@
 require 'rspec'
 require 'spec_helper'
 
-def get_k8s_disks_dir(rendered_drain)
-  lsblk_line = rendered_drain.split("\n").select { |line| line[/MOUNTPOINT/i] }
-  expect(lsblk_line.length).to be(1)
-  lsblk_line[0].match(/awk '\/(.+)\/ {print \$1}'/).captures[0]
+def drain_flag(rendered_drain, flag)
+  line = rendered_drain.split("\n").select { |l| l.strip.start_with?("-#{flag} ", "-#{flag}=") }
+  expect(line.length).to be(1)
+  line[0].strip.sub(/ \\$/, '').sub(/^-#{flag}[ =]/, '').delete('"')
 end
 
 describe 'kubelet drain' do
-
-  it 'substitutes in the k8s-args.root-dir property for get_k8s_disks()' do
+  it 'passes the k8s-args.root-dir property as the kubelet root dir' do
     manifest_properties = {
       'k8s-args' => {
         'root-dir' => '/var/vcap/data/kubelet'
@@ -19,13 +18,64 @@ describe 'kubelet drain' do
     }
 
     rendered_drain = compiled_template('kubelet', 'bin/drain', manifest_properties, {}, {}, nil, nil, nil)
-    k8s_disks_dir = get_k8s_disks_dir(rendered_drain)
-    expect(k8s_disks_dir).to eq(manifest_properties['k8s-args']['root-dir'].gsub("/", "\\/"))
+    expect(drain_flag(rendered_drain, 'kubelet-root-dir')).to eq('/var/vcap/data/kubelet')
+  end
+
+  it 'uses /var/lib/kubelet as the kubelet root dir by default' do
+    rendered_drain = compiled_template('kubelet', 'bin/drain', {}, {}, {}, nil, nil, nil)
+    expect(drain_flag(rendered_drain, 'kubelet-root-dir')).to eq('/var/lib/kubelet')
+  end
+
+  it 'passes the kubelet-drain properties to the drain binary' do
+    manifest_properties = {
+      'kubelet-drain-grace-period' => 30,
+      'kubectl-drain-timeout' => '5m',
+      'kubelet-drain-force' => false,
+      'kubelet-drain-ignore-daemonsets' => false,
+      'kubelet-drain-delete-local-data' => false,
+      'kubelet-drain-force-node' => true,
+      'kubelet-drain-force-node-workers' => 4,
+      'kubelet-drain-volume-detach-timeout' => '10m'
+    }
+
+    rendered_drain = compiled_template('kubelet', 'bin/drain', manifest_properties, {}, {}, nil, nil, nil)
+    expect(drain_flag(rendered_drain, 'grace-period')).to eq('30')
+    expect(drain_flag(rendered_drain, 'timeout')).to eq('5m')
+    expect(drain_flag(rendered_drain, 'force')).to eq('false')
+    expect(drain_flag(rendered_drain, 'ignore-daemonsets')).to eq('false')
+    expect(drain_flag(rendered_drain, 'delete-local-data')).to eq('false')
+    expect(drain_flag(rendered_drain, 'force-node')).to eq('true')
+    expect(drain_flag(rendered_drain, 'force-node-workers')).to eq('4')
+    expect(drain_flag(rendered_drain, 'volume-detach-timeout')).to eq('10m')
+  end
+
+  it 'keeps the defaults of the kubectl drain script' do
+    rendered_drain = compiled_template('kubelet', 'bin/drain', {}, {}, {}, nil, nil, nil)
+    expect(drain_flag(rendered_drain, 'grace-period')).to eq('10')
+    expect(drain_flag(rendered_drain, 'timeout')).to eq('0s')
+    expect(drain_flag(rendered_drain, 'force')).to eq('true')
+    expect(drain_flag(rendered_drain, 'force-node')).to eq('false')
+    expect(drain_flag(rendered_drain, 'volume-detach-timeout')).to eq('0s')
+  end
+
+  it 'writes force kill audits to the kubelet log dir' do
+    rendered_drain = compiled_template('kubelet', 'bin/drain', {}, {}, {}, nil, nil, nil)
+    expect(drain_flag(rendered_drain, 'audit-dir')).to eq('$LOG_DIR')
+    expect(rendered_drain).to include('LOG_DIR=/var/vcap/sys/log/kubelet')
+  end
+
+  it 'selects the node by its bosh id and reports the result on fd 3' do
+    rendered_drain = compiled_template('kubelet', 'bin/drain', {}, {}, {}, nil, nil, nil)
+    expect(drain_flag(rendered_drain, 'node-selector')).to match(/^bosh\.id=/)
+    expect(rendered_drain).to include('3>&1 1>>"$LOG_DIR/drain.stdout.log"')
   end
 
-  it 'uses /var/lib/kubelet for get_k8s_disks() by default' do
+  it 'prints the drain plan without redirecting output when run with --dry-run' do
     rendered_drain = compiled_template('kubelet', 'bin/drain', {}, {}, {}, nil, nil, nil)
-    k8s_disks_dir = get_k8s_disks_dir(rendered_drain)
-    expect(k8s_disks_dir).to eq("/var/lib/kubelet".gsub("/", "\\/"))
+    dry_run = rendered_drain.split("\n").select { |l| l.strip.start_with?('exec') && l.include?('-dry-run') }
+    expect(dry_run.length).to be(1)
+    expect(dry_run[0]).to include('"${flags[@]}"')
+    expect(dry_run[0]).not_to include('3>&1')
+    expect(rendered_drain).to include('if [ "${1:-}" = "--dry-run" ]; then')
   end
 end
@@ -0,0 +1,36 @@
+# frozen_string_literal: true
+
+require 'rspec'
+require 'spec_helper'
+
+describe 'kubelet post-start' do
+  let(:rendered_post_start) do
+    compiled_template('kubelet', 'bin/post-start', {}, {}, {}, nil, nil, 'node-uuid')
+  end
+
+  it 'selects the node by its bosh id' do
+    expect(rendered_post_start).to include('-node-selector "bosh.id=node-uuid"')
+  end
+
+  it 'reaches the API server through the kubelet kubeconfig' do
+    expect(rendered_post_start).to include('-kubeconfig /var/vcap/jobs/kubelet/config/kubeconfig')
+    expect(rendered_post_start).not_to include('master.cfcr.internal')
+    expect(rendered_post_start).not_to include('curl')
+  end
+
+  it 'loads the cached images into docker by default' do
+    expect(rendered_post_start).to include('-runtime docker -docker-socket unix:///var/vcap/sys/run/docker/docker.sock')
+  end
+
+  it 'loads the cached images into containerd when the kubelet uses a remote runtime' do
+    manifest_properties = {
+      'k8s-args' => {
+        'container-runtime' => 'remote',
+        'container-runtime-endpoint' => 'unix:///var/vcap/sys/run/containerd/containerd.sock'
+      }
+    }
+    rendered = compiled_template('kubelet', 'bin/post-start', manifest_properties, {}, {}, nil, nil, 'node-uuid')
+    expect(rendered).to include('-runtime containerd -containerd-address unix:///var/vcap/sys/run/containerd/containerd.sock')
+    expect(rendered).not_to include('docker.sock')
+  end
+end
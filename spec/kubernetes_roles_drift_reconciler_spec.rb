@@ -0,0 +1,32 @@
+# frozen_string_literal: true
+
+require 'rspec'
+require 'spec_helper'
+require 'yaml'
+
+describe 'kubernetes-roles drift reconciler' do
+  it 'checks the policies at every interval and serves metrics' do
+    rendered = compiled_template('kubernetes-roles', 'config/bpm.yml', {}, {})
+    process = YAML.safe_load(rendered)['processes'][0]
+    expect(process['name']).to eq('drift-reconciler')
+    expect(process['executable']).to eq('/var/vcap/packages/kubo-tools/bin/reconcile-policies')
+    expect(process['args']).to include('-reapply=false', '-force-conflicts=false', '5m', ':9841')
+    expect(process['args'].last).to eq('/var/vcap/jobs/kubernetes-roles/config/policies/')
+  end
+
+  it 'reapplies drifted policies when configured' do
+    properties = {
+      'drift-reconciler' => { 'reapply' => true, 'interval' => '30s', 'metrics-address' => '127.0.0.1:9841' }
+    }
+    rendered = compiled_template('kubernetes-roles', 'config/bpm.yml', properties, {})
+    args = YAML.safe_load(rendered)['processes'][0]['args']
+    expect(args).to include('-reapply=true', '30s', '127.0.0.1:9841')
+  end
+
+  it 'checks the policies once in the errand' do
+    rendered = compiled_template('kubernetes-roles', 'bin/run', {}, {})
+    expect(rendered).to include('/var/vcap/packages/kubo-tools/bin/reconcile-policies')
+    expect(rendered).to include('-reapply=false')
+    expect(rendered).not_to include('-interval')
+  end
+end
@@ -58,4 +58,17 @@ kind: YAML is weird
     specs_hash = YAML.load_stream(rendered_kubernetes_roles_yml).reject(&:nil?)
     expect(specs_hash).to be_empty
   end
+
+  it 'applies the policies with server-side apply as kubernetes-roles' do
+    rendered = compiled_template('kubernetes-roles', 'bin/apply_policies', {}, {})
+    expect(rendered).to include('/var/vcap/packages/kubo-tools/bin/apply-manifests')
+    expect(rendered).to include('-field-manager kubernetes-roles')
+    expect(rendered).to include('-force-conflicts=false')
+    expect(rendered).not_to include('kubectl')
+  end
+
+  it 'forces conflicts when configured' do
+    rendered = compiled_template('kubernetes-roles', 'bin/apply_policies', { 'force-conflicts' => true }, {})
+    expect(rendered).to include('-force-conflicts=true')
+  end
 end
@@ -2,13 +2,14 @@
 
 require 'rspec'
 require 'spec_helper'
+require 'json'
 require 'yaml'
 
 describe 'flag_generation_tests' do
 
   k8s_args = {
     'k8s-args' => {
-      'hash': {
+      'hash' => {
         'key1' => 'value1',
         'key2' => 'value2'
       },
@@ -21,25 +22,32 @@ describe 'flag_generation_tests' do
     }
   }
 
-  def test_bpm(template)
-    yaml = YAML.safe_load(template)
-    expect(yaml['processes'][0]['args']).to include('--hash=key1=value1,key2=value2')
-    expect(yaml['processes'][0]['args']).to include('--array=value1,value2')
-    expect(yaml['processes'][0]['args']).to include('--true=true')
-    expect(yaml['processes'][0]['args']).to include('--false=false')
-    expect(yaml['processes'][0]['args']).to include('--string=value')
-    expect(yaml['processes'][0]['args']).to include('--flagNil')
-    expect(yaml['processes'][0]['args']).to include('--colonSuffix=value:')
+  # The flags are rendered by k8s-args from the properties in
+  # config/k8s-args.json, in the order they are set.
+  def test_properties(job_name)
+    properties = JSON.parse(compiled_template(job_name, 'config/k8s-args.json', k8s_args))
+    expect(properties['k8s-args'].to_a).to eq(k8s_args['k8s-args'].to_a)
   end
 
-  def test_ctl(template)
-    expect(template).to include('--hash=key1=value1,key2=value2')
-    expect(template).to include('--array=value1,value2')
-    expect(template).to include('--true=true')
-    expect(template).to include('--false=false')
-    expect(template).to include('--string=value')
-    expect(template).to include('--flagNil')
-    expect(template).to include('--colonSuffix=value:')
+  def test_bpm(template, binary, allow_deprecated = false)
+    process = YAML.safe_load(template)['processes'][0]
+    expect(process['executable']).to eq('/var/vcap/packages/kubo-tools/bin/k8s-args')
+    expect(process['args'][0..3]).to eq([
+      "-binary=/var/vcap/packages/kubernetes/bin/#{binary}",
+      "-properties=/var/vcap/jobs/#{binary}/config/k8s-args.json",
+      "-allow-deprecated=#{allow_deprecated}",
+      '--'
+    ])
+    expect(process['args'].join(' ')).not_to include('--string=value')
+  end
+
+  def test_ctl(template, job_name, allow_deprecated)
+    expect(template).to include("/var/vcap/packages/kubo-tools/bin/k8s-args \\\n" \
+      "    -binary /var/vcap/packages/kubernetes/bin/#{job_name} \\\n" \
+      "    -properties /var/vcap/jobs/#{job_name}/config/k8s-args.json \\\n" \
+      "    -allow-deprecated=#{allow_deprecated} \\\n" \
+      "    -- \\\n")
+    expect(template).not_to include('--string=value')
   end
 
   context 'kube-controller-manager' do
@@ -49,7 +57,8 @@ describe 'flag_generation_tests' do
         'config/bpm.yml',
         k8s_args)
 
-      test_bpm(kube_controller_manager)
+      test_bpm(kube_controller_manager, 'kube-controller-manager')
+      test_properties('kube-controller-manager')
     end
   end
 
@@ -87,7 +96,8 @@ describe 'flag_generation_tests' do
         k8s_args,
         link_spec)
 
-      test_bpm(kube_apiserver)
+      test_bpm(kube_apiserver, 'kube-apiserver')
+      test_properties('kube-apiserver')
     end
   end
 
@@ -98,7 +108,17 @@ describe 'flag_generation_tests' do
         'bin/kubelet_ctl',
         k8s_args)
 
-      test_ctl(kubelet)
+      test_ctl(kubelet, 'kubelet', false)
+      test_properties('kubelet')
+    end
+
+    it 'leaves node-labels to kubelet_ctl' do
+      properties = JSON.parse(compiled_template(
+        'kubelet',
+        'config/k8s-args.json',
+        'k8s-args' => { 'node-labels' => 'foo=bar', 'v' => 2 }))
+
+      expect(properties['k8s-args']).to eq('v' => 2)
     end
   end
 
@@ -109,7 +129,8 @@ describe 'flag_generation_tests' do
         'bin/kube_proxy_ctl',
         k8s_args)
 
-      test_ctl(kube_proxy)
+      test_ctl(kube_proxy, 'kube-proxy', false)
+      test_properties('kube-proxy')
     end
   end
 
@@ -120,7 +141,24 @@ describe 'flag_generation_tests' do
         'config/bpm.yml',
         k8s_args)
 
-      test_bpm(kube_scheduler)
+      test_bpm(kube_scheduler, 'kube-scheduler')
+      test_properties('kube-scheduler')
+    end
+
+    it 'allows deprecated flags when asked to' do
+      kube_scheduler = compiled_template(
+        'kube-scheduler',
+        'config/bpm.yml',
+        k8s_args.merge('k8s-args-allow-deprecated' => true))
+
+      test_bpm(kube_scheduler, 'kube-scheduler', true)
+    end
+  end
+
+  context 'without k8s-args' do
+    it 'renders no flags' do
+      properties = JSON.parse(compiled_template('kube-scheduler', 'config/k8s-args.json', {}))
+      expect(properties).to eq('k8s-args' => {})
     end
   end
 end
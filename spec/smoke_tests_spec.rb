@@ -0,0 +1,114 @@
+# frozen_string_literal: true
+
+require 'rspec'
+require 'spec_helper'
+require 'json'
+
+describe 'smoke-tests' do
+  let(:links) do
+    {
+      'kube-apiserver' => {
+        'instances' => [],
+        'properties' => {
+          'admin-username' => 'meatloaf',
+          'admin-password' => 'madagascar-TEST',
+          'tls' => { 'kubernetes' => { 'ca' => 'a-ca' } }
+        }
+      }
+    }
+  end
+  let(:properties) { {} }
+  let(:test_config) do
+    JSON.parse(compiled_template('smoke-tests', 'config/test-config.json', properties, links))
+  end
+
+  it 'points the suite at the errand kubeconfig' do
+    expect(test_config['kubernetes']['path_to_kubeconfig']).to eq('/var/vcap/jobs/smoke-tests/config/kubeconfig')
+  end
+
+  it 'renders the defaults' do
+    expect(test_config['runner']).to eq('kubectl')
+    expect(test_config['test_image']).to eq('pcfkubo/nginx-bionic:1.0.0')
+    expect(test_config['timeouts']).to eq('default' => '60s', 'rollout' => '120s')
+    expect(test_config['test_groups']).to eq(%w[system-components deployment port-forwarding dns metrics-server networking rbac psp])
+    expect(test_config['dns']).to eq('service_ip' => '10.100.200.10', 'external_name' => 'cloudfoundry.org')
+    expect(test_config['cleanup']).to eq('max_age' => '2h')
+  end
+
+  it 'checks the grants of the admin user from the link' do
+    expect(test_config['rbac']).to eq('admin_username' => 'meatloaf', 'cloud_provider' => '')
+  end
+
+  context 'when a cloud provider is linked' do
+    let(:links) do
+      super().merge(
+        'cloud-provider' => {
+          'instances' => [],
+          'properties' => { 'cloud-provider' => { 'type' => 'vsphere' } }
+        }
+      )
+    end
+
+    it 'checks the cloud-provider grants too' do
+      expect(test_config['rbac']['cloud_provider']).to eq('vsphere')
+    end
+  end
+
+  context 'when the operator tunes the errand' do
+    let(:properties) do
+      {
+        'runner' => 'native',
+        'test-image' => 'registry.internal/nginx:1.2',
+        'timeouts' => { 'rollout' => '10m' },
+        'test-groups' => ['system-components'],
+        'kubedns-service-ip' => '10.200.0.10',
+        'dns-external-name' => '',
+        'cleanup' => { 'max-age' => '0s' }
+      }
+    end
+
+    it 'renders the configured values' do
+      expect(test_config['runner']).to eq('native')
+      expect(test_config['test_image']).to eq('registry.internal/nginx:1.2')
+      expect(test_config['timeouts']).to eq('default' => '60s', 'rollout' => '10m')
+      expect(test_config['test_groups']).to eq(['system-components'])
+      expect(test_config['dns']).to eq('service_ip' => '10.200.0.10', 'external_name' => '')
+      expect(test_config['cleanup']).to eq('max_age' => '0s')
+    end
+  end
+
+  context 'bin/run' do
+    let(:rendered_run) { compiled_template('smoke-tests', 'bin/run', properties, links) }
+
+    it 'passes the config file to the suite' do
+      expect(rendered_run).to include('-config=/var/vcap/jobs/smoke-tests/config/test-config.json')
+    end
+
+    it 'writes the reports to the job log directory' do
+      expect(rendered_run).to include('report_dir="/var/vcap/sys/log/smoke-tests"')
+      expect(rendered_run).to include('-report-dir="${report_dir}"')
+    end
+
+    it 'prints where the reports are' do
+      expect(rendered_run).to include('echo "JUnit report: ${report_dir}/junit.xml"')
+      expect(rendered_run).to include('echo "JSON summary: ${report_dir}/summary.json"')
+    end
+
+    it 'runs the specs serially by default' do
+      expect(rendered_run).to include('-parallelism=1')
+    end
+
+    context 'when parallelism is set' do
+      let(:properties) { { 'parallelism' => 4 } }
+
+      it 'passes it to the suite' do
+        expect(rendered_run).to include('-parallelism=4')
+      end
+
+      it 'prints where the per-node reports are' do
+        expect(rendered_run).to include('echo "JUnit reports: ${report_dir}/junit-*.xml"')
+        expect(rendered_run).to include('echo "JSON summaries: ${report_dir}/summary-*.json"')
+      end
+    end
+  end
+end
@@ -0,0 +1,48 @@
+// Package addons keeps the cluster addons of the apply-specs errand in the
+// state the deployment asks for. Addons are described in a registry, a
+// directory of descriptors, and applied in dependency order. Every object
+// applied is labelled with the addon it belongs to and the release that
+// applied it, so that objects of addons that are no longer wanted can be
+// found and pruned.
+package addons
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Config is the errand's configuration, rendered from the job properties.
+type Config struct {
+	// ReleaseVersion is the version of the release the errand runs from.
+	ReleaseVersion string `yaml:"release-version"`
+	// Registry is the directory of addon descriptors.
+	Registry string `yaml:"registry"`
+	// Addons are the names of the addons that should be in the cluster.
+	// Any other object labelled as an addon is pruned.
+	Addons []string `yaml:"addons"`
+}
+
+// LoadConfig reads the configuration at path.
+func LoadConfig(path string) (*Config, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c Config
+	if err := yaml.UnmarshalStrict(raw, &c); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if c.Registry == "" {
+		return nil, fmt.Errorf("%s: registry is not set", path)
+	}
+	seen := map[string]bool{}
+	for _, name := range c.Addons {
+		if seen[name] {
+			return nil, fmt.Errorf("%s: addon %s is listed twice", path, name)
+		}
+		seen[name] = true
+	}
+	return &c, nil
+}
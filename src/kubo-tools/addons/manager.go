@@ -0,0 +1,399 @@
+package addons
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"kubo-tools/apply"
+	"kubo-tools/kube"
+)
+
+const (
+	// NameLabel names the addon an object belongs to.
+	NameLabel = "addons.cfcr.io/name"
+	// VersionLabel is the version of the release that last applied the
+	// object.
+	VersionLabel = "addons.cfcr.io/release-version"
+)
+
+// Inventory is the ConfigMap that lists the kinds of the objects applied, so
+// that a later run knows where to look for objects to prune.
+var Inventory = struct{ Namespace, Name string }{"kube-system", "cfcr-addons-inventory"}
+
+// Action is what happened to an object: an apply.Action, or deleted.
+type Action string
+
+const (
+	Created   Action = "created"
+	Updated   Action = "updated"
+	Unchanged Action = "unchanged"
+	Deleted   Action = "deleted"
+)
+
+// Change is an object the manager created, updated, left alone or deleted.
+type Change struct {
+	Action Action
+	Addon  string
+	Object string
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s %s (%s)", c.Action, c.Object, c.Addon)
+}
+
+// Summary counts the changes by action, e.g. "2 created, 1 updated,
+// 10 unchanged, 3 deleted".
+func Summary(changes []Change) string {
+	counts := map[Action]int{}
+	for _, c := range changes {
+		counts[c.Action]++
+	}
+	return fmt.Sprintf("%d created, %d updated, %d unchanged, %d deleted", counts[Created], counts[Updated], counts[Unchanged], counts[Deleted])
+}
+
+// Manager applies addons and prunes the objects of addons that are gone.
+type Manager struct {
+	Client         *kube.Client
+	Log            *log.Logger
+	ReleaseVersion string
+	// ReadyTimeout bounds the wait for each object to become ready, unless
+	// its check or its ready-timeout annotation says otherwise; it defaults
+	// to five minutes.
+	ReadyTimeout time.Duration
+	// PollInterval is how often readiness is checked; it defaults to two
+	// seconds.
+	PollInterval time.Duration
+	// FieldManager is the field manager the objects are applied as; it
+	// defaults to apply-specs.
+	FieldManager string
+	// ForceConflicts takes over fields of the objects that other managers,
+	// such as an operator's kubectl edit, changed. Otherwise such an
+	// object fails to apply.
+	ForceConflicts bool
+
+	serverVersion *version
+}
+
+func (m *Manager) readyTimeout() time.Duration {
+	if m.ReadyTimeout == 0 {
+		return 5 * time.Minute
+	}
+	return m.ReadyTimeout
+}
+
+func (m *Manager) fieldManager() string {
+	if m.FieldManager == "" {
+		return "apply-specs"
+	}
+	return m.FieldManager
+}
+
+func (m *Manager) pollInterval() time.Duration {
+	if m.PollInterval == 0 {
+		return 2 * time.Second
+	}
+	return m.PollInterval
+}
+
+// desired is an object to apply, with the resource it lives in.
+type desired struct {
+	addon    string
+	object   kube.Object
+	resource kube.Resource
+}
+
+func (d desired) key() string {
+	return objectKey(d.resource, d.object.Namespace(), d.object.Name())
+}
+
+func objectKey(r kube.Resource, namespace, name string) string {
+	return r.QualifiedName() + " " + namespace + "/" + name
+}
+
+// Apply makes the cluster hold exactly the objects of the addons, which
+// must be in dependency order as Registry.Resolve returns them. Each addon
+// is applied, creating or updating every object in its manifests, and then
+// waited for until its readiness checks pass. An addon is skipped if one it
+// depends on failed, or if the cluster is older than it supports. Once all
+// addons are ready, the labelled objects that are in none of them are
+// deleted; nothing is pruned if any addon failed. The changes are returned,
+// and logged as they happen.
+func (m *Manager) Apply(addons []Addon) ([]Change, error) {
+	objects := map[string][]desired{}
+	for _, addon := range addons {
+		manifests, err := kube.ReadManifests(addon.Manifests...)
+		if err != nil {
+			return nil, fmt.Errorf("addon %s: %v", addon.Name, err)
+		}
+		for _, obj := range manifests {
+			objects[addon.Name] = append(objects[addon.Name], desired{addon: addon.Name, object: obj})
+		}
+		// Namespaces and CRDs go first, as the other objects may need
+		// them.
+		list := objects[addon.Name]
+		sort.SliceStable(list, func(i, j int) bool { return kindOrder(list[i].object.Kind()) < kindOrder(list[j].object.Kind()) })
+	}
+
+	kinds, err := m.inventory()
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []Change
+	var failures []string
+	failed := map[string]bool{}
+	want := map[string]bool{}
+	for _, addon := range addons {
+		applied, err := m.applyAddon(addon, objects[addon.Name], failed)
+		changes = append(changes, applied...)
+		for _, d := range objects[addon.Name] {
+			if d.resource.Name != "" {
+				want[d.key()] = true
+				kinds[d.object.APIVersion()+" "+d.object.Kind()] = true
+			}
+		}
+		if err != nil {
+			m.Log.Printf("addon %s failed: %v", addon.Name, err)
+			failures = append(failures, fmt.Sprintf("%s: %v", addon.Name, err))
+			failed[addon.Name] = true
+			continue
+		}
+		m.Log.Printf("addon %s is ready", addon.Name)
+	}
+	if err := m.writeInventory(kinds); err != nil {
+		return changes, err
+	}
+	if len(failures) > 0 {
+		return changes, fmt.Errorf("%d addons failed, so nothing was pruned: %s", len(failures), strings.Join(failures, "; "))
+	}
+
+	pruned, err := m.prune(kinds, want)
+	changes = append(changes, pruned...)
+	if err != nil {
+		return changes, err
+	}
+	return changes, m.writeInventory(kinds)
+}
+
+// applyAddon applies the objects of the addon and waits for it to be ready:
+// for the objects of the kinds that have readiness checks, and for the
+// addon's own checks. Its CRDs are waited for before the objects that may
+// be their custom resources are applied. The objects it applies get their
+// resource set.
+func (m *Manager) applyAddon(addon Addon, objects []desired, failed map[string]bool) ([]Change, error) {
+	for _, dep := range addon.DependsOn {
+		if failed[dep] {
+			return nil, fmt.Errorf("skipped, as addon %s failed", dep)
+		}
+	}
+	if addon.MinKubernetesVersion != "" {
+		if err := m.checkVersion(addon.MinKubernetesVersion); err != nil {
+			return nil, err
+		}
+	}
+
+	// The objects are sorted with Namespaces and CRDs first.
+	split := sort.Search(len(objects), func(i int) bool { return kindOrder(objects[i].object.Kind()) > 1 })
+	changes, err := m.applyObjects(objects[:split])
+	if err != nil {
+		return changes, err
+	}
+	crds, err := m.checksFor(objects[:split], nil)
+	if err != nil {
+		return changes, err
+	}
+	if len(crds) > 0 {
+		if err := m.waitReady(crds); err != nil {
+			return changes, err
+		}
+		m.Client.ForgetDiscovery()
+	}
+
+	applied, err := m.applyObjects(objects[split:])
+	changes = append(changes, applied...)
+	if err != nil {
+		return changes, err
+	}
+	checks, err := m.checksFor(objects[split:], addon.Readiness)
+	if err != nil {
+		return changes, err
+	}
+	return changes, m.waitReady(checks)
+}
+
+func (m *Manager) applyObjects(objects []desired) ([]Change, error) {
+	var changes []Change
+	var broken []string
+	for i := range objects {
+		d := &objects[i]
+		change, err := m.apply(d)
+		if err != nil {
+			m.Log.Printf("failed to apply %s (%s): %v", d.object, d.addon, err)
+			broken = append(broken, d.object.String())
+			continue
+		}
+		m.Log.Print(change)
+		changes = append(changes, change)
+	}
+	if len(broken) > 0 {
+		return changes, fmt.Errorf("failed to apply %d objects: %s", len(broken), strings.Join(broken, ", "))
+	}
+	return changes, nil
+}
+
+// checkVersion fails if the API server is older than min.
+func (m *Manager) checkVersion(min string) error {
+	if m.serverVersion == nil {
+		info, err := m.Client.ServerVersion()
+		if err != nil {
+			return fmt.Errorf("cannot tell the Kubernetes version: %v", err)
+		}
+		v, err := parseVersion(info.GitVersion)
+		if err != nil {
+			return fmt.Errorf("cannot tell the Kubernetes version: %v", err)
+		}
+		m.serverVersion = &v
+	}
+	want, err := parseVersion(min)
+	if err != nil {
+		return err
+	}
+	if m.serverVersion.less(want) {
+		return fmt.Errorf("needs Kubernetes %s or later, the cluster runs %d.%d", min, m.serverVersion[0], m.serverVersion[1])
+	}
+	return nil
+}
+
+func kindOrder(kind string) int {
+	switch kind {
+	case "Namespace":
+		return 0
+	case "CustomResourceDefinition":
+		return 1
+	default:
+		return 2
+	}
+}
+
+// apply applies the object labelled as the addon's.
+func (m *Manager) apply(d *desired) (Change, error) {
+	obj := d.object.DeepCopy()
+	obj.SetLabel(NameLabel, d.addon)
+	obj.SetLabel(VersionLabel, m.ReleaseVersion)
+
+	a := &apply.Applier{Client: m.Client, FieldManager: m.fieldManager(), Force: m.ForceConflicts}
+	result, err := a.Apply(obj)
+	if err != nil {
+		return Change{}, err
+	}
+	d.object, d.resource = result.Object, result.Resource
+	return Change{Action: Action(result.Action), Addon: d.addon, Object: result.Resource.QualifiedName() + " " + displayName(result.Object)}, nil
+}
+
+func displayName(obj kube.Object) string {
+	if obj.Namespace() == "" {
+		return obj.Name()
+	}
+	return obj.Namespace() + "/" + obj.Name()
+}
+
+// prune deletes the labelled objects of the kinds that are not wanted. A
+// kind the server no longer serves is dropped from kinds.
+func (m *Manager) prune(kinds map[string]bool, want map[string]bool) ([]Change, error) {
+	var sorted []string
+	for k := range kinds {
+		sorted = append(sorted, k)
+	}
+	// Namespaces and CRDs go last, after the objects in them.
+	sort.Slice(sorted, func(i, j int) bool {
+		ki, kj := kindOrder(strings.Fields(sorted[i])[1]), kindOrder(strings.Fields(sorted[j])[1])
+		return ki > kj || ki == kj && sorted[i] < sorted[j]
+	})
+
+	var changes []Change
+	var failed []string
+	for _, k := range sorted {
+		fields := strings.Fields(k)
+		resource, err := m.Client.ResourceFor(fields[0], fields[1])
+		if kube.IsNotFound(err) {
+			delete(kinds, k)
+			continue
+		}
+		if err != nil {
+			return changes, err
+		}
+
+		var list kube.ObjectList
+		err = m.Client.Do(kube.Request{Verb: "list", Resource: resource, Query: map[string][]string{"labelSelector": {NameLabel}}}, &list)
+		if err != nil {
+			return changes, err
+		}
+		for _, obj := range list.Items {
+			if want[objectKey(resource, obj.Namespace(), obj.Name())] {
+				continue
+			}
+			change := Change{Action: Deleted, Addon: obj.Labels()[NameLabel], Object: resource.QualifiedName() + " " + displayName(obj)}
+			err := m.Client.Do(kube.Request{Verb: "delete", Resource: resource, Namespace: obj.Namespace(), Name: obj.Name()}, nil)
+			if err != nil && !kube.IsNotFound(err) {
+				m.Log.Printf("failed to delete %s (%s): %v", change.Object, change.Addon, err)
+				failed = append(failed, change.Object)
+				continue
+			}
+			m.Log.Print(change)
+			changes = append(changes, change)
+		}
+	}
+	if len(failed) > 0 {
+		return changes, fmt.Errorf("failed to prune %d objects: %s", len(failed), strings.Join(failed, ", "))
+	}
+	return changes, nil
+}
+
+// inventory returns the kinds recorded by earlier runs, as "apiVersion kind".
+func (m *Manager) inventory() (map[string]bool, error) {
+	kinds := map[string]bool{}
+	var cm kube.Object
+	err := m.Client.Do(kube.Request{Verb: "get", Resource: kube.ConfigMaps, Namespace: Inventory.Namespace, Name: Inventory.Name}, &cm)
+	if kube.IsNotFound(err) {
+		return kinds, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading the addon inventory: %v", err)
+	}
+	data, _ := cm.Field("data.kinds").(string)
+	for _, line := range strings.Split(data, "\n") {
+		if len(strings.Fields(line)) == 2 {
+			kinds[line] = true
+		}
+	}
+	return kinds, nil
+}
+
+// writeInventory records the kinds before anything is pruned, so that a run
+// that fails half way still knows where to look next time.
+func (m *Manager) writeInventory(kinds map[string]bool) error {
+	var lines []string
+	for k := range kinds {
+		lines = append(lines, k)
+	}
+	sort.Strings(lines)
+	data := map[string]interface{}{"kinds": strings.Join(lines, "\n")}
+
+	err := m.Client.Do(kube.Request{Verb: "patch", Resource: kube.ConfigMaps, Namespace: Inventory.Namespace, Name: Inventory.Name, Body: map[string]interface{}{"data": data}}, nil)
+	if kube.IsNotFound(err) {
+		cm := kube.Object{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata":   map[string]interface{}{"name": Inventory.Name, "namespace": Inventory.Namespace},
+			"data":       data,
+		}
+		err = m.Client.Do(kube.Request{Verb: "create", Resource: kube.ConfigMaps, Namespace: Inventory.Namespace, Body: cm}, nil)
+	}
+	if err != nil {
+		return fmt.Errorf("writing the addon inventory: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,448 @@
+package addons_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"kubo-tools/addons"
+	"kubo-tools/kube"
+	"kubo-tools/kube/kubetest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const coredns = `apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: coredns
+  namespace: kube-system
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: coredns
+  namespace: kube-system
+spec:
+  replicas: 2
+  strategy:
+    rollingUpdate:
+      maxUnavailable: 1
+`
+
+const storageClass = `apiVersion: storage.k8s.io/v1
+kind: StorageClass
+metadata:
+  name: standard
+provisioner: kubernetes.io/gce-pd
+`
+
+// rolledOut gives a Deployment the status of a finished rollout.
+func rolledOut(obj map[string]interface{}) {
+	replicas := kube.Object(obj).Field("spec.replicas")
+	if replicas == nil {
+		replicas = float64(1)
+	}
+	obj["status"] = map[string]interface{}{"replicas": replicas, "updatedReplicas": replicas, "availableReplicas": replicas}
+}
+
+var _ = Describe("Manager", func() {
+	var (
+		server *kubetest.Server
+		tmpDir string
+		logs   *bytes.Buffer
+		m      *addons.Manager
+	)
+
+	write := func(name, content string) string {
+		path := filepath.Join(tmpDir, name)
+		Expect(ioutil.WriteFile(path, []byte(content), 0644)).To(Succeed())
+		return path
+	}
+
+	actions := func(changes []addons.Change) []string {
+		var lines []string
+		for _, c := range changes {
+			lines = append(lines, c.String())
+		}
+		return lines
+	}
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "addons")
+		Expect(err).NotTo(HaveOccurred())
+
+		server = kubetest.NewServer()
+		client, err := kube.NewClient(server.WriteKubeconfig(tmpDir, "admin-token"))
+		Expect(err).NotTo(HaveOccurred())
+
+		logs = &bytes.Buffer{}
+		m = &addons.Manager{Client: client, Log: log.New(logs, "", 0), ReleaseVersion: "0.21.0", ReadyTimeout: 5 * time.Second, PollInterval: time.Millisecond}
+		server.SetController(kube.Deployments, rolledOut)
+	})
+
+	AfterEach(func() {
+		server.Close()
+		os.RemoveAll(tmpDir)
+	})
+
+	It("creates the objects labelled with the addon and release", func() {
+		changes, err := m.Apply([]addons.Addon{
+			{Name: "coredns", Manifests: []string{write("coredns.yml", coredns)}},
+			{Name: "storage-class-gce", Manifests: []string{write("storage-class-gce.yml", storageClass)}},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(actions(changes)).To(Equal([]string{
+			"created serviceaccounts kube-system/coredns (coredns)",
+			"created deployments.apps kube-system/coredns (coredns)",
+			"created storageclasses.storage.k8s.io standard (storage-class-gce)",
+		}))
+		Expect(addons.Summary(changes)).To(Equal("3 created, 0 updated, 0 unchanged, 0 deleted"))
+
+		deployment := kube.Object(server.Get(kube.Deployments, "kube-system", "coredns"))
+		Expect(deployment.Labels()).To(Equal(map[string]string{"addons.cfcr.io/name": "coredns", "addons.cfcr.io/release-version": "0.21.0"}))
+		Expect(server.FieldManagers(kube.Deployments, "kube-system", "coredns")).To(HaveKeyWithValue("apply-specs", ContainElement(".spec.replicas")))
+		Expect(server.Get(kube.ConfigMaps, "kube-system", "cfcr-addons-inventory")).To(HaveKeyWithValue("data", HaveKeyWithValue("kinds",
+			"apps/v1 Deployment\nstorage.k8s.io/v1 StorageClass\nv1 ServiceAccount")))
+		Expect(logs.String()).To(ContainSubstring("created deployments.apps kube-system/coredns (coredns)"))
+	})
+
+	It("leaves objects that match alone and removes the fields dropped from a manifest", func() {
+		path := write("coredns.yml", coredns)
+		_, err := m.Apply([]addons.Addon{{Name: "coredns", Manifests: []string{path}}})
+		Expect(err).NotTo(HaveOccurred())
+		version := kube.Object(server.Get(kube.Deployments, "kube-system", "coredns")).Field("metadata.resourceVersion")
+
+		changes, err := m.Apply([]addons.Addon{{Name: "coredns", Manifests: []string{path}}})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(addons.Summary(changes)).To(Equal("0 created, 0 updated, 2 unchanged, 0 deleted"))
+		Expect(kube.Object(server.Get(kube.Deployments, "kube-system", "coredns")).Field("metadata.resourceVersion")).To(Equal(version))
+
+		write("coredns.yml", `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: coredns
+  namespace: kube-system
+spec:
+  replicas: 3
+`)
+		changes, err = m.Apply([]addons.Addon{{Name: "coredns", Manifests: []string{path}}})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(actions(changes)).To(Equal([]string{
+			"updated deployments.apps kube-system/coredns (coredns)",
+			"deleted serviceaccounts kube-system/coredns (coredns)",
+		}))
+
+		spec := server.Get(kube.Deployments, "kube-system", "coredns")["spec"]
+		Expect(spec).To(Equal(map[string]interface{}{"replicas": float64(3)}))
+		Expect(server.Get(kube.ServiceAccounts, "kube-system", "coredns")).To(BeNil())
+	})
+
+	Context("when an operator changed an object", func() {
+		var path string
+
+		BeforeEach(func() {
+			path = write("coredns.yml", coredns)
+			_, err := m.Apply([]addons.Addon{{Name: "coredns", Manifests: []string{path}}})
+			Expect(err).NotTo(HaveOccurred())
+
+			err = m.Client.Do(kube.Request{
+				Verb:      "patch",
+				Resource:  kube.Deployments,
+				Namespace: "kube-system",
+				Name:      "coredns",
+				Query:     map[string][]string{"fieldManager": {"kubectl-edit"}},
+				Body:      map[string]interface{}{"spec": map[string]interface{}{"replicas": 5, "paused": true}},
+			}, nil)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("keeps the fields the manifests do not set", func() {
+			changes, err := m.Apply([]addons.Addon{{Name: "coredns", Manifests: []string{path}}})
+			Expect(err).To(HaveOccurred())
+			Expect(changes).To(HaveLen(1))
+			Expect(server.Get(kube.Deployments, "kube-system", "coredns")["spec"]).To(HaveKeyWithValue("paused", true))
+		})
+
+		It("names the manager of the fields it conflicts on", func() {
+			_, err := m.Apply([]addons.Addon{{Name: "coredns", Manifests: []string{path}}})
+			Expect(err).To(MatchError(ContainSubstring("failed to apply 1 objects: Deployment kube-system/coredns")))
+			Expect(logs.String()).To(ContainSubstring("Deployment kube-system/coredns: conflicts with other field managers: kubectl-edit owns .spec.replicas; force the conflicts to make apply-specs own them"))
+			Expect(server.Get(kube.Deployments, "kube-system", "coredns")["spec"]).To(HaveKeyWithValue("replicas", float64(5)))
+		})
+
+		It("takes the fields over when forced", func() {
+			m.ForceConflicts = true
+			changes, err := m.Apply([]addons.Addon{{Name: "coredns", Manifests: []string{path}}})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(addons.Summary(changes)).To(Equal("0 created, 1 updated, 1 unchanged, 0 deleted"))
+			Expect(server.Get(kube.Deployments, "kube-system", "coredns")["spec"]).To(HaveKeyWithValue("replicas", float64(2)))
+			Expect(server.FieldManagers(kube.Deployments, "kube-system", "coredns")).To(Equal(map[string][]string{
+				"apply-specs": {
+					".metadata.labels.addons.cfcr.io/name",
+					".metadata.labels.addons.cfcr.io/release-version",
+					".spec.replicas",
+					".spec.strategy.rollingUpdate.maxUnavailable",
+				},
+				"kubectl-edit": {".spec.paused"},
+			}))
+		})
+	})
+
+	It("prunes the objects of addons that are no longer wanted", func() {
+		_, err := m.Apply([]addons.Addon{
+			{Name: "coredns", Manifests: []string{write("coredns.yml", coredns)}},
+			{Name: "storage-class-gce", Manifests: []string{write("storage-class-gce.yml", storageClass)}},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		server.Put(kube.StorageClasses, map[string]interface{}{"metadata": map[string]interface{}{"name": "operator-owned"}})
+
+		changes, err := m.Apply([]addons.Addon{{Name: "coredns", Manifests: []string{write("coredns.yml", coredns)}}})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(actions(changes)).To(ContainElement("deleted storageclasses.storage.k8s.io standard (storage-class-gce)"))
+		Expect(addons.Summary(changes)).To(Equal("0 created, 0 updated, 2 unchanged, 1 deleted"))
+		Expect(server.Get(kube.StorageClasses, "", "standard")).To(BeNil())
+		Expect(server.Get(kube.StorageClasses, "", "operator-owned")).NotTo(BeNil())
+	})
+
+	It("prunes nothing if an object fails to apply", func() {
+		_, err := m.Apply([]addons.Addon{{Name: "storage-class-gce", Manifests: []string{write("storage-class-gce.yml", storageClass)}}})
+		Expect(err).NotTo(HaveOccurred())
+
+		changes, err := m.Apply([]addons.Addon{{Name: "custom", Manifests: []string{write("custom.yml", `apiVersion: example.com/v1
+kind: Widget
+metadata:
+  name: w
+`)}}})
+		Expect(err).To(MatchError("1 addons failed, so nothing was pruned: custom: failed to apply 1 objects: Widget w"))
+		Expect(changes).To(BeEmpty())
+		Expect(server.Get(kube.StorageClasses, "", "standard")).NotTo(BeNil())
+		Expect(logs.String()).To(ContainSubstring("does not serve kind Widget in example.com/v1"))
+	})
+
+	Context("with readiness checks", func() {
+		var deps []addons.Addon
+
+		BeforeEach(func() {
+			server.SetController(kube.Deployments, nil)
+			deps = []addons.Addon{
+				{
+					Name:      "coredns",
+					Manifests: []string{write("coredns.yml", coredns)},
+					Readiness: []addons.Check{{Kind: "Deployment", Namespace: "kube-system", Name: "coredns"}},
+				},
+				{Name: "storage-class-gce", Manifests: []string{write("storage-class-gce.yml", storageClass)}, DependsOn: []string{"coredns"}},
+			}
+		})
+
+		rollOut := func() {
+			deployment := kube.Object(server.Get(kube.Deployments, "kube-system", "coredns")).DeepCopy()
+			deployment["status"] = map[string]interface{}{"replicas": 2, "updatedReplicas": 2, "availableReplicas": 2}
+			server.Put(kube.Deployments, deployment)
+		}
+
+		It("applies an addon once the addons it depends on are ready", func() {
+			done := make(chan error)
+			go func() {
+				_, err := m.Apply(deps)
+				done <- err
+			}()
+
+			Eventually(func() map[string]interface{} { return server.Get(kube.Deployments, "kube-system", "coredns") }).ShouldNot(BeNil())
+			Consistently(done, 20*time.Millisecond).ShouldNot(Receive())
+			Expect(server.Get(kube.StorageClasses, "", "standard")).To(BeNil())
+
+			rollOut()
+			Eventually(done).Should(Receive(BeNil()))
+			Expect(server.Get(kube.StorageClasses, "", "standard")).NotTo(BeNil())
+			Expect(logs.String()).To(ContainSubstring("addon coredns is ready"))
+		})
+
+		It("skips the dependents of an addon that is not ready and prunes nothing", func() {
+			m.ReadyTimeout = 20 * time.Millisecond
+			_, err := m.Apply(deps)
+			Expect(err).To(MatchError("2 addons failed, so nothing was pruned: " +
+				"coredns: 1 objects did not become ready: Deployment kube-system/coredns: 0 of 2 replicas updated (waited 20ms); " +
+				"storage-class-gce: skipped, as addon coredns failed"))
+			Expect(server.Get(kube.StorageClasses, "", "standard")).To(BeNil())
+		})
+
+		It("skips an addon that needs a newer Kubernetes", func() {
+			server.SetVersion("v1.15.3")
+			deps[1].MinKubernetesVersion = "1.16"
+			go func() {
+				defer GinkgoRecover()
+				Eventually(func() map[string]interface{} { return server.Get(kube.Deployments, "kube-system", "coredns") }).ShouldNot(BeNil())
+				rollOut()
+			}()
+			_, err := m.Apply(deps)
+			Expect(err).To(MatchError(HaveSuffix("storage-class-gce: needs Kubernetes 1.16 or later, the cluster runs 1.15")))
+		})
+
+		for _, c := range []struct {
+			kind     string
+			resource kube.Resource
+			status   map[string]interface{}
+			reason   string
+		}{
+			{"DaemonSet", kube.DaemonSets, map[string]interface{}{"observedGeneration": 2, "desiredNumberScheduled": 3, "updatedNumberScheduled": 3, "numberAvailable": 2}, "2 of 3 updated pods available"},
+			{"StatefulSet", kube.StatefulSets, map[string]interface{}{"observedGeneration": 2, "readyReplicas": 1, "currentRevision": "web-1", "updateRevision": "web-2", "updatedReplicas": 0}, "0 of 1 pods at revision web-2"},
+			{"APIService", kube.APIServices, map[string]interface{}{"conditions": []interface{}{map[string]interface{}{"type": "Available", "status": "False", "reason": "FailedDiscoveryCheck", "message": "no response"}}}, "Available is False: FailedDiscoveryCheck: no response"},
+			{"Deployment", kube.Deployments, map[string]interface{}{"observedGeneration": 1}, "waiting for the controller to observe the update"},
+		} {
+			c := c
+			It("reports why a "+c.kind+" is not ready", func() {
+				namespace := "kube-system"
+				if !c.resource.Namespaced {
+					namespace = ""
+				}
+				server.Put(c.resource, map[string]interface{}{
+					"metadata": map[string]interface{}{"name": "web", "namespace": namespace, "generation": 2},
+					"status":   c.status,
+				})
+				m.ReadyTimeout = 10 * time.Millisecond
+				_, err := m.Apply([]addons.Addon{{
+					Name:      "web",
+					Manifests: []string{write("storage-class-gce.yml", storageClass)},
+					Readiness: []addons.Check{{Kind: c.kind, Namespace: namespace, Name: "web"}},
+				}})
+				Expect(err).To(MatchError(ContainSubstring(c.kind + " " + strings.TrimPrefix(namespace+"/", "/") + "web: " + c.reason)))
+			})
+		}
+	})
+
+	Context("with workloads in the manifests", func() {
+		const workloads = `apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: widgets.example.com
+---
+apiVersion: example.com/v1
+kind: Widget
+metadata:
+  name: w
+  namespace: default
+---
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrate
+  namespace: default
+  annotations:
+    addons.cfcr.io/ready-timeout: 30ms
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: default
+`
+		widgets := kube.Resource{GroupVersion: "example.com/v1", Name: "widgets", Namespaced: true}
+		condition := func(conditionType, status string) func(map[string]interface{}) {
+			return func(obj map[string]interface{}) {
+				obj["status"] = map[string]interface{}{"conditions": []interface{}{
+					map[string]interface{}{"type": conditionType, "status": status, "reason": "Testing", "message": "set by the test"},
+				}}
+			}
+		}
+
+		var spec []addons.Addon
+
+		BeforeEach(func() {
+			server.AddResource(widgets, "Widget")
+			spec = []addons.Addon{{Name: "addons-spec", Manifests: []string{write("addon-spec.yml", workloads)}}}
+		})
+
+		It("waits for every object of a kind with a readiness check", func() {
+			server.SetController(kube.CRDs, condition("Established", "True"))
+			server.SetController(kube.Jobs, condition("Complete", "True"))
+
+			_, err := m.Apply(spec)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(server.Get(widgets, "default", "w")).NotTo(BeNil())
+			Expect(logs.String()).To(ContainSubstring("CustomResourceDefinition widgets.example.com is ready"))
+			Expect(logs.String()).To(ContainSubstring("Job default/migrate is ready"))
+			Expect(logs.String()).To(ContainSubstring("Deployment default/web is ready"))
+		})
+
+		It("applies no custom resources until their CRD is Established", func() {
+			m.ReadyTimeout = 10 * time.Millisecond
+			_, err := m.Apply(spec)
+			Expect(err).To(MatchError(ContainSubstring("addons-spec: 1 objects did not become ready: CustomResourceDefinition widgets.example.com: no Established condition yet (waited 10ms)")))
+			Expect(server.Get(widgets, "default", "w")).To(BeNil())
+		})
+
+		It("lists the objects that did not become ready, each after its own timeout", func() {
+			m.ReadyTimeout = 10 * time.Millisecond
+			server.SetController(kube.CRDs, condition("Established", "True"))
+			server.SetController(kube.Deployments, nil)
+
+			_, err := m.Apply(spec)
+			Expect(err).To(MatchError(HaveSuffix("addons-spec: 2 objects did not become ready: " +
+				"Deployment default/web: 0 of 1 replicas updated (waited 10ms); " +
+				"Job default/migrate: 0 of 1 completions, 0 active (waited 30ms)")))
+			Expect(logs.String()).To(ContainSubstring("Job default/migrate did not become ready within 30ms: 0 of 1 completions, 0 active"))
+		})
+
+		It("stops waiting for a Job that failed", func() {
+			m.ReadyTimeout = time.Minute
+			server.SetController(kube.CRDs, condition("Established", "True"))
+			server.SetController(kube.Jobs, condition("Failed", "True"))
+
+			_, err := m.Apply(spec)
+			Expect(err).To(MatchError(HaveSuffix("1 objects did not become ready: Job default/migrate: Failed is True: Testing: set by the test")))
+		})
+	})
+
+	It("applies nothing if a manifest cannot be read", func() {
+		_, err := m.Apply([]addons.Addon{
+			{Name: "coredns", Manifests: []string{write("coredns.yml", coredns)}},
+			{Name: "broken", Manifests: []string{write("broken.yml", "kind: [")}},
+		})
+		Expect(err).To(MatchError(HavePrefix("addon broken: ")))
+		Expect(server.RequestLines()).To(BeEmpty())
+	})
+})
+
+var _ = Describe("LoadConfig", func() {
+	var tmpDir string
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "addons")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	load := func(content string) (*addons.Config, error) {
+		path := filepath.Join(tmpDir, "addons.yml")
+		Expect(ioutil.WriteFile(path, []byte(content), 0644)).To(Succeed())
+		return addons.LoadConfig(path)
+	}
+
+	It("reads the release version, registry and addons", func() {
+		c, err := load("release-version: 0.21.0\nregistry: /addons\naddons: [coredns, metrics-server]\n")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c).To(Equal(&addons.Config{ReleaseVersion: "0.21.0", Registry: "/addons", Addons: []string{"coredns", "metrics-server"}}))
+	})
+
+	It("rejects unknown keys and duplicate addons", func() {
+		_, err := load("registry: /addons\naddon: [coredns]\n")
+		Expect(err).To(MatchError(ContainSubstring("field addon not found")))
+
+		_, err = load("registry: /addons\naddons: [coredns, coredns]\n")
+		Expect(err).To(MatchError(HaveSuffix("addon coredns is listed twice")))
+
+		_, err = load("addons: [coredns]\n")
+		Expect(err).To(MatchError(HaveSuffix("registry is not set")))
+	})
+})
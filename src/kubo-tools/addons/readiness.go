@@ -0,0 +1,268 @@
+package addons
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"kubo-tools/kube"
+)
+
+// ReadyTimeoutAnnotation sets how long to wait for an object in a manifest
+// to become ready, e.g. "10m", in place of the manager's ReadyTimeout.
+const ReadyTimeoutAnnotation = "addons.cfcr.io/ready-timeout"
+
+// Check names an object that must be ready before its addon counts as
+// applied: a Deployment, DaemonSet or StatefulSet whose rollout has
+// finished, a Job that has completed, a CustomResourceDefinition that is
+// Established or an APIService that is Available. The objects of these
+// kinds in an addon's manifests are checked without being listed.
+type Check struct {
+	Kind      string `yaml:"kind"`
+	Namespace string `yaml:"namespace"`
+	Name      string `yaml:"name"`
+	// Timeout bounds the wait for this object; it defaults to the
+	// manager's ReadyTimeout.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+func (c Check) String() string {
+	if c.Namespace == "" {
+		return c.Kind + " " + c.Name
+	}
+	return c.Kind + " " + c.Namespace + "/" + c.Name
+}
+
+// checkedKinds are the kinds a Check may name, with their resources and the
+// function that tells whether an object is ready, and if not, why. It
+// returns an error once the object cannot become ready, such as a failed
+// Job.
+var checkedKinds = map[string]struct {
+	resource kube.Resource
+	ready    func(kube.Object) (bool, string, error)
+}{
+	"Deployment":               {kube.Deployments, deploymentReady},
+	"DaemonSet":                {kube.DaemonSets, daemonSetReady},
+	"StatefulSet":              {kube.StatefulSets, statefulSetReady},
+	"Job":                      {kube.Jobs, jobReady},
+	"CustomResourceDefinition": {kube.CRDs, crdReady},
+	"APIService":               {kube.APIServices, apiServiceReady},
+}
+
+// CheckedKinds returns the kinds a readiness check may name, sorted.
+func CheckedKinds() []string {
+	var kinds []string
+	for k := range checkedKinds {
+		kinds = append(kinds, k)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// checksFor returns the checks for the applied objects of the kinds that
+// have one, followed by the extra checks that are not among them.
+func (m *Manager) checksFor(objects []desired, extra []Check) ([]Check, error) {
+	var checks []Check
+	seen := map[Check]bool{}
+	add := func(c Check) {
+		key := Check{Kind: c.Kind, Namespace: c.Namespace, Name: c.Name}
+		if !seen[key] {
+			seen[key] = true
+			checks = append(checks, c)
+		}
+	}
+	for _, d := range objects {
+		kind, ok := checkedKinds[d.object.Kind()]
+		if !ok || d.resource.Name == "" {
+			continue
+		}
+		c := Check{Kind: d.object.Kind(), Name: d.object.Name()}
+		if kind.resource.Namespaced {
+			c.Namespace = d.object.Namespace()
+		}
+		if s := d.object.Annotations()[ReadyTimeoutAnnotation]; s != "" {
+			timeout, err := time.ParseDuration(s)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s: %v", d.object, ReadyTimeoutAnnotation, err)
+			}
+			c.Timeout = timeout
+		}
+		add(c)
+	}
+	for _, c := range extra {
+		add(c)
+	}
+	return checks, nil
+}
+
+// waitReady polls the checks until all pass or each has run out of time.
+// Every check has its own timeout, counted from the start of the wait. The
+// error lists the objects that did not become ready and why.
+func (m *Manager) waitReady(checks []Check) error {
+	start := time.Now()
+	pending := checks
+	var notReady []string
+	for len(pending) > 0 {
+		var waiting []Check
+		for _, c := range pending {
+			timeout := c.Timeout
+			if timeout == 0 {
+				timeout = m.readyTimeout()
+			}
+			ok, reason, err := m.ready(c)
+			switch {
+			case ok:
+				m.Log.Printf("%s is ready", c)
+			case err != nil:
+				m.Log.Printf("%s will not become ready: %v", c, err)
+				notReady = append(notReady, fmt.Sprintf("%s: %v", c, err))
+			case time.Since(start) >= timeout:
+				m.Log.Printf("%s did not become ready within %s: %s", c, timeout, reason)
+				notReady = append(notReady, fmt.Sprintf("%s: %s (waited %s)", c, reason, timeout))
+			default:
+				waiting = append(waiting, c)
+			}
+		}
+		pending = waiting
+		if len(pending) > 0 {
+			time.Sleep(m.pollInterval())
+		}
+	}
+	if len(notReady) > 0 {
+		return fmt.Errorf("%d objects did not become ready: %s", len(notReady), strings.Join(notReady, "; "))
+	}
+	return nil
+}
+
+func (m *Manager) ready(c Check) (bool, string, error) {
+	kind := checkedKinds[c.Kind]
+	var obj kube.Object
+	err := m.Client.Do(kube.Request{Verb: "get", Resource: kind.resource, Namespace: c.Namespace, Name: c.Name}, &obj)
+	if kube.IsNotFound(err) {
+		return false, "not found", nil
+	}
+	if err != nil {
+		return false, err.Error(), nil
+	}
+	return kind.ready(obj)
+}
+
+// number returns the integer at the dotted path, or def if it is missing.
+func number(obj kube.Object, path string, def int) int {
+	if n, ok := obj.Field(path).(float64); ok {
+		return int(n)
+	}
+	return def
+}
+
+// condition returns the condition of the type in status.conditions, or nil.
+func condition(obj kube.Object, conditionType string) map[string]interface{} {
+	conditions, _ := obj.Field("status.conditions").([]interface{})
+	for _, c := range conditions {
+		if c, ok := c.(map[string]interface{}); ok && c["type"] == conditionType {
+			return c
+		}
+	}
+	return nil
+}
+
+func describe(c map[string]interface{}) string {
+	return fmt.Sprintf("%s is %v: %v: %v", c["type"], c["status"], c["reason"], c["message"])
+}
+
+// observed reports whether the controller has seen the latest spec, as
+// kubectl rollout status checks first.
+func observed(obj kube.Object) (bool, string) {
+	if number(obj, "status.observedGeneration", 0) < number(obj, "metadata.generation", 0) {
+		return false, "waiting for the controller to observe the update"
+	}
+	return true, ""
+}
+
+func deploymentReady(obj kube.Object) (bool, string, error) {
+	if ok, reason := observed(obj); !ok {
+		return ok, reason, nil
+	}
+	if c := condition(obj, "Progressing"); c != nil && c["reason"] == "ProgressDeadlineExceeded" {
+		return false, "", fmt.Errorf("%s", describe(c))
+	}
+	want := number(obj, "spec.replicas", 1)
+	updated := number(obj, "status.updatedReplicas", 0)
+	total := number(obj, "status.replicas", 0)
+	available := number(obj, "status.availableReplicas", 0)
+	switch {
+	case updated < want:
+		return false, fmt.Sprintf("%d of %d replicas updated", updated, want), nil
+	case total > updated:
+		return false, fmt.Sprintf("%d old replicas pending termination", total-updated), nil
+	case available < updated:
+		return false, fmt.Sprintf("%d of %d updated replicas available", available, updated), nil
+	}
+	return true, "", nil
+}
+
+func daemonSetReady(obj kube.Object) (bool, string, error) {
+	if ok, reason := observed(obj); !ok {
+		return ok, reason, nil
+	}
+	want := number(obj, "status.desiredNumberScheduled", 0)
+	updated := number(obj, "status.updatedNumberScheduled", 0)
+	available := number(obj, "status.numberAvailable", 0)
+	switch {
+	case updated < want:
+		return false, fmt.Sprintf("%d of %d pods updated", updated, want), nil
+	case available < want:
+		return false, fmt.Sprintf("%d of %d updated pods available", available, want), nil
+	}
+	return true, "", nil
+}
+
+func statefulSetReady(obj kube.Object) (bool, string, error) {
+	if ok, reason := observed(obj); !ok {
+		return ok, reason, nil
+	}
+	want := number(obj, "spec.replicas", 1)
+	ready := number(obj, "status.readyReplicas", 0)
+	if ready < want {
+		return false, fmt.Sprintf("%d of %d pods ready", ready, want), nil
+	}
+	current, _ := obj.Field("status.currentRevision").(string)
+	update, _ := obj.Field("status.updateRevision").(string)
+	if update != "" && current != update {
+		return false, fmt.Sprintf("%d of %d pods at revision %s", number(obj, "status.updatedReplicas", 0), want, update), nil
+	}
+	return true, "", nil
+}
+
+func jobReady(obj kube.Object) (bool, string, error) {
+	if c := condition(obj, "Failed"); c != nil && c["status"] == "True" {
+		return false, "", fmt.Errorf("%s", describe(c))
+	}
+	if c := condition(obj, "Complete"); c != nil && c["status"] == "True" {
+		return true, "", nil
+	}
+	return false, fmt.Sprintf("%d of %d completions, %d active", number(obj, "status.succeeded", 0), number(obj, "spec.completions", 1), number(obj, "status.active", 0)), nil
+}
+
+func crdReady(obj kube.Object) (bool, string, error) {
+	if c := condition(obj, "NamesAccepted"); c != nil && c["status"] == "False" {
+		return false, "", fmt.Errorf("%s", describe(c))
+	}
+	return conditionTrue(obj, "Established")
+}
+
+func apiServiceReady(obj kube.Object) (bool, string, error) {
+	return conditionTrue(obj, "Available")
+}
+
+func conditionTrue(obj kube.Object, conditionType string) (bool, string, error) {
+	c := condition(obj, conditionType)
+	if c == nil {
+		return false, fmt.Sprintf("no %s condition yet", conditionType), nil
+	}
+	if c["status"] != "True" {
+		return false, describe(c), nil
+	}
+	return true, "", nil
+}
@@ -0,0 +1,192 @@
+package addons
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// DescriptorFile is the file in each addon directory of a registry that
+// describes the addon.
+const DescriptorFile = "addon.yml"
+
+// Addon is a named set of manifests, as described by its descriptor.
+type Addon struct {
+	// Name is the name of the addon's directory in the registry.
+	Name string `yaml:"-"`
+	// Manifests are files or directories of manifests, relative to the
+	// addon's directory.
+	Manifests []string `yaml:"manifests"`
+	// DependsOn are the addons that must be applied and ready first.
+	DependsOn []string `yaml:"depends-on"`
+	// Readiness are the objects that must be ready before the addon
+	// counts as applied.
+	Readiness []Check `yaml:"readiness"`
+	// MinKubernetesVersion is the oldest version the addon runs on, as
+	// major.minor, e.g. "1.16".
+	MinKubernetesVersion string `yaml:"min-kubernetes-version"`
+}
+
+// Registry holds the addons described in a directory.
+type Registry map[string]*Addon
+
+// LoadRegistry reads the descriptor in every directory of dir. A directory
+// without one is not an addon and is skipped.
+func LoadRegistry(dir string) (Registry, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	r := Registry{}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, e.Name(), DescriptorFile)
+		raw, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		a := &Addon{Name: e.Name()}
+		if err := yaml.UnmarshalStrict(raw, a); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		if err := a.validate(); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		for i, m := range a.Manifests {
+			if !filepath.IsAbs(m) {
+				a.Manifests[i] = filepath.Join(dir, e.Name(), m)
+			}
+		}
+		r[a.Name] = a
+	}
+	for _, a := range r {
+		for _, dep := range a.DependsOn {
+			if r[dep] == nil {
+				return nil, fmt.Errorf("addon %s depends on %s, which is not in %s", a.Name, dep, dir)
+			}
+		}
+	}
+	return r, nil
+}
+
+func (a *Addon) validate() error {
+	if len(a.Manifests) == 0 {
+		return fmt.Errorf("addon %s has no manifests", a.Name)
+	}
+	for i, c := range a.Readiness {
+		kind, ok := checkedKinds[c.Kind]
+		if !ok {
+			return fmt.Errorf("addon %s: cannot check the readiness of kind %q, only of %s", a.Name, c.Kind, strings.Join(CheckedKinds(), ", "))
+		}
+		if c.Name == "" {
+			return fmt.Errorf("addon %s: a readiness check of %s has no name", a.Name, c.Kind)
+		}
+		if kind.resource.Namespaced && c.Namespace == "" {
+			a.Readiness[i].Namespace = "default"
+		}
+	}
+	if a.MinKubernetesVersion != "" {
+		if _, err := parseVersion(a.MinKubernetesVersion); err != nil {
+			return fmt.Errorf("addon %s: min-kubernetes-version: %v", a.Name, err)
+		}
+	}
+	return nil
+}
+
+// Names returns the names of the addons, sorted.
+func (r Registry) Names() []string {
+	var names []string
+	for name := range r {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Resolve returns the named addons in the order to apply them: every addon
+// comes after the addons it depends on, and otherwise in the order given.
+// The dependencies must be among the names, so that the cluster holds
+// exactly the addons asked for.
+func (r Registry) Resolve(names []string) ([]Addon, error) {
+	wanted := map[string]bool{}
+	for _, name := range names {
+		if r[name] == nil {
+			return nil, fmt.Errorf("addon %s is not in the registry, which has %s", name, strings.Join(r.Names(), ", "))
+		}
+		wanted[name] = true
+	}
+	for _, name := range names {
+		for _, dep := range r[name].DependsOn {
+			if !wanted[dep] {
+				return nil, fmt.Errorf("addon %s depends on %s, which is not enabled", name, dep)
+			}
+		}
+	}
+
+	var ordered []Addon
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := map[string]int{}
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		path = append(path, name)
+		switch state[name] {
+		case visiting:
+			return fmt.Errorf("addons depend on each other: %s", strings.Join(path, " -> "))
+		case done:
+			return nil
+		}
+		state[name] = visiting
+		for _, dep := range r[name].DependsOn {
+			if err := visit(dep, path); err != nil {
+				return err
+			}
+		}
+		state[name] = done
+		ordered = append(ordered, *r[name])
+		return nil
+	}
+	for _, name := range names {
+		if err := visit(name, nil); err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
+
+// version is a Kubernetes version as major and minor.
+type version [2]int
+
+// parseVersion reads "1.16", "v1.16.3" or "v1.16.3-gke.1" as 1.16.
+func parseVersion(s string) (version, error) {
+	parts := strings.SplitN(strings.TrimPrefix(s, "v"), ".", 3)
+	if len(parts) < 2 {
+		return version{}, fmt.Errorf("%q is not a version such as 1.16", s)
+	}
+	var v version
+	for i := range v {
+		n, err := strconv.Atoi(strings.TrimRight(parts[i], "+"))
+		if err != nil {
+			return version{}, fmt.Errorf("%q is not a version such as 1.16", s)
+		}
+		v[i] = n
+	}
+	return v, nil
+}
+
+func (v version) less(w version) bool {
+	return v[0] < w[0] || v[0] == w[0] && v[1] < w[1]
+}
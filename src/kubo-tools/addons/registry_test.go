@@ -0,0 +1,124 @@
+package addons_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"kubo-tools/addons"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Registry", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "registry")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	describe := func(name, descriptor string) {
+		Expect(os.MkdirAll(filepath.Join(dir, name), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(dir, name, "addon.yml"), []byte(descriptor), 0644)).To(Succeed())
+	}
+
+	names := func(list []addons.Addon) []string {
+		var names []string
+		for _, a := range list {
+			names = append(names, a.Name)
+		}
+		return names
+	}
+
+	It("reads a descriptor from each directory", func() {
+		describe("metrics-server", `manifests: [., /extra/rbac.yml]
+depends-on: [coredns]
+readiness:
+- kind: Deployment
+  namespace: kube-system
+  name: metrics-server
+- kind: APIService
+  name: v1beta1.metrics.k8s.io
+  timeout: 10m
+min-kubernetes-version: "1.16"
+`)
+		describe("coredns", "manifests: [coredns.yml]\nreadiness:\n- {kind: Deployment, name: coredns}\n")
+		Expect(os.MkdirAll(filepath.Join(dir, "not-an-addon"), 0755)).To(Succeed())
+
+		r, err := addons.LoadRegistry(dir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.Names()).To(Equal([]string{"coredns", "metrics-server"}))
+		Expect(*r["metrics-server"]).To(Equal(addons.Addon{
+			Name:      "metrics-server",
+			Manifests: []string{filepath.Join(dir, "metrics-server"), "/extra/rbac.yml"},
+			DependsOn: []string{"coredns"},
+			Readiness: []addons.Check{
+				{Kind: "Deployment", Namespace: "kube-system", Name: "metrics-server"},
+				{Kind: "APIService", Name: "v1beta1.metrics.k8s.io", Timeout: 10 * time.Minute},
+			},
+			MinKubernetesVersion: "1.16",
+		}))
+		Expect(r["coredns"].Readiness).To(Equal([]addons.Check{{Kind: "Deployment", Namespace: "default", Name: "coredns"}}))
+	})
+
+	It("rejects invalid descriptors", func() {
+		for descriptor, message := range map[string]string{
+			"manifests: []\n": "addon web has no manifests",
+			"manifests: [a.yml]\nreadiness: [{kind: Pod, name: web}]\n":         `cannot check the readiness of kind "Pod", only of APIService, CustomResourceDefinition, DaemonSet, Deployment, Job, StatefulSet`,
+			"manifests: [a.yml]\nreadiness: [{kind: Deployment}]\n":             "a readiness check of Deployment has no name",
+			"manifests: [a.yml]\nmin-kubernetes-version: latest\n":              `"latest" is not a version such as 1.16`,
+			"manifests: [a.yml]\ndepends-on: [coredns]\n":                       "addon web depends on coredns, which is not in " + dir,
+			"manifests: [a.yml]\nwait-for: [coredns]\n":                         "field wait-for not found",
+			"manifests: [a.yml]\nreadiness: [{kind: Deployment, name: web}]\n-": "did not find expected key",
+		} {
+			describe("web", descriptor)
+			_, err := addons.LoadRegistry(dir)
+			Expect(err).To(MatchError(ContainSubstring(message)), descriptor)
+		}
+	})
+
+	Describe("Resolve", func() {
+		var r addons.Registry
+
+		BeforeEach(func() {
+			describe("coredns", "manifests: [a.yml]\n")
+			describe("metrics-server", "manifests: [a.yml]\ndepends-on: [coredns]\n")
+			describe("dashboard", "manifests: [a.yml]\ndepends-on: [metrics-server, coredns]\n")
+			describe("storage-class-gce", "manifests: [a.yml]\n")
+			var err error
+			r, err = addons.LoadRegistry(dir)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("orders the addons after their dependencies", func() {
+			ordered, err := r.Resolve([]string{"dashboard", "storage-class-gce", "metrics-server", "coredns"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(names(ordered)).To(Equal([]string{"coredns", "metrics-server", "dashboard", "storage-class-gce"}))
+		})
+
+		It("rejects unknown addons and dependencies that are not enabled", func() {
+			_, err := r.Resolve([]string{"crap"})
+			Expect(err).To(MatchError("addon crap is not in the registry, which has coredns, dashboard, metrics-server, storage-class-gce"))
+
+			_, err = r.Resolve([]string{"metrics-server"})
+			Expect(err).To(MatchError("addon metrics-server depends on coredns, which is not enabled"))
+		})
+
+		It("rejects dependency cycles", func() {
+			describe("coredns", "manifests: [a.yml]\ndepends-on: [dashboard]\n")
+			r, err := addons.LoadRegistry(dir)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = r.Resolve([]string{"coredns", "metrics-server", "dashboard"})
+			Expect(err).To(MatchError("addons depend on each other: coredns -> dashboard -> metrics-server -> coredns"))
+		})
+	})
+})
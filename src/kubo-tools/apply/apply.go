@@ -0,0 +1,218 @@
+// Package apply applies objects with server-side apply, as the jobs that
+// keep objects in the cluster share it. Each job applies under its own
+// field manager, so the API server records which fields the job owns:
+// fields the job stops setting are removed, fields others set are left
+// alone, and a field both set is a conflict that names the other manager.
+//
+// Objects that kubectl apply created are owned by kubectl's managers until a
+// job first applies them. That first apply takes over the fields they own,
+// rather than conflict on every field the job changes.
+package apply
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"kubo-tools/kube"
+)
+
+// Action is what applying did to an object.
+type Action string
+
+const (
+	Created   Action = "created"
+	Updated   Action = "updated"
+	Unchanged Action = "unchanged"
+)
+
+// Result is the outcome of applying an object.
+type Result struct {
+	Action Action
+	// Resource is the collection the object is in.
+	Resource kube.Resource
+	// Object is the object as the API server stored it.
+	Object kube.Object
+}
+
+// Applier applies objects as one field manager.
+type Applier struct {
+	Client *kube.Client
+	// FieldManager names the job in the managed fields of the objects it
+	// applies, e.g. apply-specs.
+	FieldManager string
+	// Force takes over the fields other managers own, where otherwise
+	// applying fails with a *ConflictError.
+	Force bool
+}
+
+// Apply creates the object or sets the fields it has. A namespaced object
+// without a namespace goes in the default namespace.
+func (a *Applier) Apply(obj kube.Object) (*Result, error) {
+	resource, err := a.Client.ResourceFor(obj.APIVersion(), obj.Kind())
+	if err != nil {
+		return nil, err
+	}
+	obj = obj.DeepCopy()
+	if resource.Namespaced && obj.Namespace() == "" {
+		obj.SetNamespace("default")
+	}
+	if !resource.Namespaced {
+		delete(obj["metadata"].(map[string]interface{}), "namespace")
+	}
+
+	var live kube.Object
+	err = a.Client.Do(kube.Request{Verb: "get", Resource: resource, Namespace: obj.Namespace(), Name: obj.Name()}, &live)
+	if err != nil && !kube.IsNotFound(err) {
+		return nil, err
+	}
+	before, firstApply := "", false
+	if err == nil {
+		before, _ = live.Field("metadata.resourceVersion").(string)
+		firstApply = !managers(live)[a.FieldManager]
+	}
+
+	applied, err := a.patch(resource, obj, a.Force)
+	if kube.IsConflict(err) {
+		conflict := conflictError(obj, a.FieldManager, err.(*kube.Error))
+		if !firstApply || !conflict.clientSide() {
+			return nil, conflict
+		}
+		applied, err = a.patch(resource, obj, true)
+		if kube.IsConflict(err) {
+			return nil, conflictError(obj, a.FieldManager, err.(*kube.Error))
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{Action: Updated, Resource: resource, Object: applied}
+	switch after, _ := applied.Field("metadata.resourceVersion").(string); {
+	case before == "":
+		result.Action = Created
+	case before == after:
+		result.Action = Unchanged
+	}
+	return result, nil
+}
+
+func (a *Applier) patch(resource kube.Resource, obj kube.Object, force bool) (kube.Object, error) {
+	query := map[string][]string{"fieldManager": {a.FieldManager}}
+	if force {
+		query["force"] = []string{"true"}
+	}
+	var applied kube.Object
+	err := a.Client.Do(kube.Request{
+		Verb:      "patch",
+		Resource:  resource,
+		Namespace: obj.Namespace(),
+		Name:      obj.Name(),
+		Query:     query,
+		Body:      obj,
+		PatchType: kube.ApplyPatch,
+	}, &applied)
+	return applied, err
+}
+
+// clientSideManagers are the managers of the fields kubectl apply and
+// other client-side updates set, before the object was first applied
+// server-side.
+var clientSideManagers = map[string]bool{
+	"kubectl":                   true,
+	"kubectl-client-side-apply": true,
+	"before-first-apply":        true,
+}
+
+// managers returns the managers in the managed fields of obj.
+func managers(obj kube.Object) map[string]bool {
+	found := map[string]bool{}
+	entries, _ := obj.Field("metadata.managedFields").([]interface{})
+	for _, e := range entries {
+		entry, _ := e.(map[string]interface{})
+		if m, ok := entry["manager"].(string); ok {
+			found[m] = true
+		}
+	}
+	return found
+}
+
+// Conflict is a field another manager owns with a different value.
+type Conflict struct {
+	Manager string
+	Field   string
+}
+
+// ConflictError reports the fields of an object that other managers own.
+type ConflictError struct {
+	Object       string
+	FieldManager string
+	Conflicts    []Conflict
+	Err          error
+}
+
+func (e *ConflictError) Error() string {
+	if len(e.Conflicts) == 0 {
+		return fmt.Sprintf("%s: %v", e.Object, e.Err)
+	}
+	byManager := map[string][]string{}
+	for _, c := range e.Conflicts {
+		byManager[c.Manager] = append(byManager[c.Manager], c.Field)
+	}
+	var parts []string
+	for _, m := range e.Managers() {
+		parts = append(parts, fmt.Sprintf("%s owns %s", m, strings.Join(byManager[m], ", ")))
+	}
+	return fmt.Sprintf("%s: conflicts with other field managers: %s; force the conflicts to make %s own them", e.Object, strings.Join(parts, "; "), e.FieldManager)
+}
+
+// Managers returns the managers that own conflicting fields, sorted.
+func (e *ConflictError) Managers() []string {
+	seen := map[string]bool{}
+	var managers []string
+	for _, c := range e.Conflicts {
+		if !seen[c.Manager] {
+			seen[c.Manager] = true
+			managers = append(managers, c.Manager)
+		}
+	}
+	sort.Strings(managers)
+	return managers
+}
+
+// clientSide is whether every conflicting field is owned by kubectl's
+// client-side managers only.
+func (e *ConflictError) clientSide() bool {
+	for _, c := range e.Conflicts {
+		if !clientSideManagers[c.Manager] {
+			return false
+		}
+	}
+	return len(e.Conflicts) > 0
+}
+
+// quoted finds the manager in a cause such as
+// `conflict with "kubectl-edit" using apps/v1`.
+var quoted = regexp.MustCompile(`"([^"]*)"`)
+
+func conflictError(obj kube.Object, fieldManager string, err *kube.Error) *ConflictError {
+	e := &ConflictError{Object: obj.String(), FieldManager: fieldManager, Err: err}
+	for _, c := range err.Causes {
+		if c.Type != "FieldManagerConflict" {
+			continue
+		}
+		manager := c.Message
+		if m := quoted.FindStringSubmatch(c.Message); m != nil {
+			manager = m[1]
+		}
+		e.Conflicts = append(e.Conflicts, Conflict{Manager: manager, Field: c.Field})
+	}
+	sort.Slice(e.Conflicts, func(i, j int) bool {
+		if e.Conflicts[i].Manager != e.Conflicts[j].Manager {
+			return e.Conflicts[i].Manager < e.Conflicts[j].Manager
+		}
+		return e.Conflicts[i].Field < e.Conflicts[j].Field
+	})
+	return e
+}
@@ -0,0 +1,162 @@
+package apply_test
+
+import (
+	"io/ioutil"
+	"os"
+
+	"kubo-tools/apply"
+	"kubo-tools/kube"
+	"kubo-tools/kube/kubetest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func role(rules ...string) kube.Object {
+	var list []interface{}
+	for _, r := range rules {
+		list = append(list, map[string]interface{}{"apiGroups": []interface{}{""}, "resources": []interface{}{r}, "verbs": []interface{}{"get"}})
+	}
+	return kube.Object{
+		"apiVersion": "rbac.authorization.k8s.io/v1",
+		"kind":       "Role",
+		"metadata":   map[string]interface{}{"name": "reader", "labels": map[string]interface{}{"team": "a"}},
+		"rules":      list,
+	}
+}
+
+var _ = Describe("Applier", func() {
+	var (
+		server *kubetest.Server
+		tmpDir string
+		client *kube.Client
+		a      *apply.Applier
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "apply")
+		Expect(err).NotTo(HaveOccurred())
+
+		server = kubetest.NewServer()
+		client, err = kube.NewClient(server.WriteKubeconfig(tmpDir, "admin-token"))
+		Expect(err).NotTo(HaveOccurred())
+		a = &apply.Applier{Client: client, FieldManager: "kubernetes-roles"}
+	})
+
+	AfterEach(func() {
+		server.Close()
+		os.RemoveAll(tmpDir)
+	})
+
+	It("creates, leaves alone and updates objects", func() {
+		result, err := a.Apply(role("pods"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Action).To(Equal(apply.Created))
+		Expect(result.Resource).To(Equal(kube.Roles))
+		Expect(result.Object.Namespace()).To(Equal("default"))
+
+		result, err = a.Apply(role("pods"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Action).To(Equal(apply.Unchanged))
+
+		result, err = a.Apply(role("pods", "services"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Action).To(Equal(apply.Updated))
+		Expect(server.Get(kube.Roles, "default", "reader")["rules"]).To(HaveLen(2))
+
+		Expect(server.RequestLines()).To(ContainElement("PATCH /apis/rbac.authorization.k8s.io/v1/namespaces/default/roles/reader"))
+		Expect(server.Requests[len(server.Requests)-1].Query).To(Equal("fieldManager=kubernetes-roles"))
+	})
+
+	It("drops the namespace of an object that is not namespaced", func() {
+		obj := kube.Object{
+			"apiVersion": "rbac.authorization.k8s.io/v1",
+			"kind":       "ClusterRole",
+			"metadata":   map[string]interface{}{"name": "reader", "namespace": "kube-system"},
+		}
+		result, err := a.Apply(obj)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Object.Namespace()).To(BeEmpty())
+		Expect(server.Get(kube.ClusterRoles, "", "reader")).NotTo(BeNil())
+		Expect(obj.Namespace()).To(Equal("kube-system"))
+	})
+
+	Context("when another manager owns fields", func() {
+		BeforeEach(func() {
+			_, err := a.Apply(role("pods"))
+			Expect(err).NotTo(HaveOccurred())
+			other := &apply.Applier{Client: client, FieldManager: "operator", Force: true}
+			obj := role("secrets")
+			obj.SetLabel("team", "b")
+			_, err = other.Apply(obj)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("reports the conflicting manager and fields", func() {
+			_, err := a.Apply(role("pods"))
+			Expect(err).To(BeAssignableToTypeOf(&apply.ConflictError{}))
+			conflict := err.(*apply.ConflictError)
+			Expect(conflict.Managers()).To(Equal([]string{"operator"}))
+			Expect(conflict.Conflicts).To(Equal([]apply.Conflict{
+				{Manager: "operator", Field: ".metadata.labels.team"},
+				{Manager: "operator", Field: ".rules"},
+			}))
+			Expect(err).To(MatchError("Role default/reader: conflicts with other field managers: operator owns .metadata.labels.team, .rules; force the conflicts to make kubernetes-roles own them"))
+		})
+
+		It("takes the fields over when forced", func() {
+			a.Force = true
+			result, err := a.Apply(role("pods"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Action).To(Equal(apply.Updated))
+			Expect(result.Object.Labels()).To(HaveKeyWithValue("team", "a"))
+			Expect(server.FieldManagers(kube.Roles, "default", "reader")).NotTo(HaveKey("operator"))
+		})
+	})
+
+	Context("when kubectl apply created the object", func() {
+		BeforeEach(func() {
+			obj := role("pods")
+			obj.SetNamespace("default")
+			obj.SetLabel("team", "b")
+			err := client.Do(kube.Request{Verb: "create", Resource: kube.Roles, Namespace: "default", Query: map[string][]string{"fieldManager": {"kubectl"}}, Body: obj}, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(server.FieldManagers(kube.Roles, "default", "reader")).To(HaveKey("kubectl"))
+		})
+
+		It("takes over the fields kubectl owns on the first apply", func() {
+			result, err := a.Apply(role("pods", "services"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Action).To(Equal(apply.Updated))
+			Expect(result.Object.Labels()).To(HaveKeyWithValue("team", "a"))
+			Expect(server.FieldManagers(kube.Roles, "default", "reader")["kubernetes-roles"]).To(ConsistOf(".metadata.labels.team", ".rules"))
+			Expect(server.FieldManagers(kube.Roles, "default", "reader")).NotTo(HaveKey("kubectl"))
+			Expect(server.Requests[len(server.Requests)-1].Query).To(Equal("fieldManager=kubernetes-roles&force=true"))
+		})
+
+		It("reports conflicts with kubectl once the object has been applied", func() {
+			_, err := a.Apply(role("pods"))
+			Expect(err).NotTo(HaveOccurred())
+			err = client.Do(kube.Request{Verb: "patch", Resource: kube.Roles, Namespace: "default", Name: "reader", Query: map[string][]string{"fieldManager": {"kubectl"}}, Body: map[string]interface{}{"metadata": map[string]interface{}{"labels": map[string]interface{}{"team": "c"}}}}, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = a.Apply(role("pods"))
+			Expect(err).To(MatchError(ContainSubstring("kubectl owns .metadata.labels.team")))
+		})
+
+		It("reports conflicts with other managers on the first apply", func() {
+			err := client.Do(kube.Request{Verb: "patch", Resource: kube.Roles, Namespace: "default", Name: "reader", Query: map[string][]string{"fieldManager": {"operator"}}, Body: map[string]interface{}{"rules": []interface{}{}}}, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = a.Apply(role("pods"))
+			Expect(err).To(BeAssignableToTypeOf(&apply.ConflictError{}))
+			Expect(err.(*apply.ConflictError).Managers()).To(Equal([]string{"kubectl", "operator"}))
+		})
+	})
+
+	It("returns the error for a kind the server does not serve", func() {
+		_, err := a.Apply(kube.Object{"apiVersion": "example.com/v1", "kind": "Widget", "metadata": map[string]interface{}{"name": "w"}})
+		Expect(kube.IsNotFound(err)).To(BeTrue())
+	})
+})
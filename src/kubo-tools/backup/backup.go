@@ -0,0 +1,183 @@
+// Package backup backs up the cluster state of the bbr-kube-apiserver job:
+// an etcd v3 snapshot, written to the BBR artifact directory with metadata
+// that records what it was taken of. A restore checks the artifact and
+// writes its keys back through the etcd API, while the API servers are
+// stopped.
+package backup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"kubo-tools/etcd"
+	"kubo-tools/snapshot"
+)
+
+// Files of an artifact.
+const (
+	SnapshotFile = "etcd-snapshot.db"
+	MetadataFile = "metadata.json"
+)
+
+// Metadata describes a snapshot.
+type Metadata struct {
+	Time              time.Time `json:"time"`
+	KubernetesVersion string    `json:"kubernetes_version"`
+	EtcdVersion       string    `json:"etcd_version"`
+	// Revision is the etcd revision of the snapshot.
+	Revision int64 `json:"revision"`
+	Keys     int   `json:"keys"`
+	Size     int64 `json:"size"`
+	// SHA256 is the checksum of the snapshot file.
+	SHA256 string `json:"sha256"`
+}
+
+// Backup writes a snapshot of the etcd cluster and its metadata into dir,
+// and checks the snapshot as a restore would.
+func Backup(c *etcd.Client, kubernetesVersion, dir string) (*Metadata, error) {
+	etcdVersion, err := c.Version()
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, SnapshotFile)
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	size, err := c.Snapshot(io.MultiWriter(f, h))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("taking snapshot: %v", err)
+	}
+	s, err := snapshot.Read(path)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Metadata{
+		Time:              time.Now().UTC(),
+		KubernetesVersion: kubernetesVersion,
+		EtcdVersion:       etcdVersion,
+		Revision:          s.Revision,
+		Keys:              len(s.KeyValues),
+		Size:              size,
+		SHA256:            hex.EncodeToString(h.Sum(nil)),
+	}
+	raw, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, MetadataFile), append(raw, '\n'), 0600); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ReadMetadata reads the metadata of the artifact in dir.
+func ReadMetadata(dir string) (*Metadata, error) {
+	path := filepath.Join(dir, MetadataFile)
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m Metadata
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &m, nil
+}
+
+// Verify checks the artifact in dir against its metadata: the checksum and
+// the integrity hash of the snapshot, and its revision and keys. If
+// kubernetesVersion is given, the snapshot must have been taken of the
+// same minor version, as the API server may not read objects stored by
+// another.
+func Verify(dir, kubernetesVersion string) (*Metadata, *snapshot.Snapshot, error) {
+	m, err := ReadMetadata(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	path := filepath.Join(dir, SnapshotFile)
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if sum := sha256.Sum256(raw); hex.EncodeToString(sum[:]) != m.SHA256 {
+		return nil, nil, fmt.Errorf("%s has checksum %x, but the metadata records %s", path, sum, m.SHA256)
+	}
+	s, err := snapshot.Parse(raw)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if s.Revision != m.Revision || len(s.KeyValues) != m.Keys {
+		return nil, nil, fmt.Errorf("%s has %d keys at revision %d, but the metadata records %d keys at revision %d", path, len(s.KeyValues), s.Revision, m.Keys, m.Revision)
+	}
+	if kubernetesVersion != "" && minor(kubernetesVersion) != minor(m.KubernetesVersion) {
+		return nil, nil, fmt.Errorf("the snapshot was taken of Kubernetes %s, but this deployment runs %s; restore it with the release that took it", m.KubernetesVersion, kubernetesVersion)
+	}
+	return m, s, nil
+}
+
+// minor returns the major and minor version of v1.17.9, 1.17.
+func minor(version string) string {
+	parts := strings.SplitN(strings.TrimPrefix(version, "v"), ".", 3)
+	if len(parts) < 2 {
+		return version
+	}
+	return parts[0] + "." + parts[1]
+}
+
+// Restore replaces every key in the etcd cluster with the keys of the
+// snapshot, at new revisions. Keys attached to a lease, such as events,
+// are left out, as their leases are not restored. It returns how many keys
+// were restored and left out.
+//
+// The keys are deleted and then written in several transactions, so a
+// failure part way leaves etcd with only some of the keys. The error says
+// so, and restored is how many were written; restoring again from the same
+// snapshot replaces them all.
+func Restore(c *etcd.Client, s *snapshot.Snapshot) (restored, skipped int, err error) {
+	var puts []etcd.Put
+	for _, kv := range s.KeyValues {
+		if kv.Lease != 0 {
+			skipped++
+			continue
+		}
+		puts = append(puts, etcd.Put{Key: kv.Key, Value: kv.Value})
+	}
+	if _, err := c.DeleteAll(); err != nil {
+		return 0, 0, err
+	}
+	written, err := c.PutAll(puts)
+	if err != nil {
+		return written, skipped, fmt.Errorf("etcd is now partial: %d of the %d keys were written before %v; run the restore again from the same artifact", written, len(puts), err)
+	}
+	return written, skipped, nil
+}
+
+// BinaryVersion returns the version of the kube-apiserver binary at path,
+// e.g. v1.17.9.
+func BinaryVersion(path string) (string, error) {
+	out, err := exec.Command(path, "--version").Output()
+	if err != nil {
+		return "", fmt.Errorf("%s --version: %v", path, err)
+	}
+	// kube-apiserver prints "Kubernetes v1.17.9".
+	fields := strings.Fields(string(out))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("%s --version printed nothing", path)
+	}
+	return fields[len(fields)-1], nil
+}
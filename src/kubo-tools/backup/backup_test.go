@@ -0,0 +1,171 @@
+package backup_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"kubo-tools/backup"
+	"kubo-tools/etcd"
+	"kubo-tools/etcd/etcdtest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Backup", func() {
+	var (
+		server *etcdtest.Server
+		tmpDir string
+		dir    string
+		client *etcd.Client
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "backup")
+		Expect(err).NotTo(HaveOccurred())
+		dir = filepath.Join(tmpDir, "artifact")
+		Expect(os.Mkdir(dir, 0700)).To(Succeed())
+
+		server = etcdtest.NewServer()
+		server.Put("/registry/namespaces/default", "ns", 0)
+		server.Put("/registry/secrets/default/token", "secret", 0)
+		server.Put("/registry/events/default/e1", "event", 42)
+		client, err = etcd.NewClient(etcd.Config{Endpoints: []string{server.URL}, CACert: server.WriteCA(tmpDir)})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		server.Close()
+		os.RemoveAll(tmpDir)
+	})
+
+	It("writes a snapshot with its metadata", func() {
+		m, err := backup.Backup(client, "v1.17.9", dir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(m.KubernetesVersion).To(Equal("v1.17.9"))
+		Expect(m.EtcdVersion).To(Equal("3.4.3"))
+		Expect(m.Keys).To(Equal(3))
+		Expect(m.Revision).To(Equal(int64(4)))
+		Expect(m.SHA256).To(HaveLen(64))
+
+		read, err := backup.ReadMetadata(dir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(read.SHA256).To(Equal(m.SHA256))
+		info, err := os.Stat(filepath.Join(dir, backup.SnapshotFile))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Size()).To(Equal(m.Size))
+	})
+
+	It("uses the gateway path of etcd 3.3", func() {
+		server.SetVersion("3.3.10")
+		_, err := backup.Backup(client, "v1.17.9", dir)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("fails if the snapshot etcd streams is corrupted", func() {
+		server.CorruptSnapshots = true
+		_, err := backup.Backup(client, "v1.17.9", dir)
+		Expect(err).To(MatchError(ContainSubstring("it is corrupted")))
+	})
+
+	It("fails if no endpoint answers", func() {
+		client, err := etcd.NewClient(etcd.Config{Endpoints: []string{"https://127.0.0.1:1"}})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = backup.Backup(client, "v1.17.9", dir)
+		Expect(err).To(MatchError(ContainSubstring("etcd /version: no endpoint answered")))
+	})
+
+	Context("with an artifact", func() {
+		BeforeEach(func() {
+			_, err := backup.Backup(client, "v1.17.9", dir)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("verifies it", func() {
+			m, s, err := backup.Verify(dir, "v1.17.4")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(m.Keys).To(Equal(3))
+			Expect(s.KeyValues).To(HaveLen(3))
+		})
+
+		It("refuses a snapshot that does not match its checksum", func() {
+			path := filepath.Join(dir, backup.SnapshotFile)
+			raw, err := ioutil.ReadFile(path)
+			Expect(err).NotTo(HaveOccurred())
+			raw[100] ^= 0xff
+			Expect(ioutil.WriteFile(path, raw, 0600)).To(Succeed())
+
+			_, _, err = backup.Verify(dir, "")
+			Expect(err).To(MatchError(ContainSubstring("but the metadata records")))
+		})
+
+		It("refuses a snapshot of another Kubernetes minor version", func() {
+			_, _, err := backup.Verify(dir, "v1.18.2")
+			Expect(err).To(MatchError("the snapshot was taken of Kubernetes v1.17.9, but this deployment runs v1.18.2; restore it with the release that took it"))
+		})
+
+		It("restores the keys, leaving out those attached to leases", func() {
+			_, s, err := backup.Verify(dir, "")
+			Expect(err).NotTo(HaveOccurred())
+			server.Put("/registry/secrets/default/token", "changed", 0)
+			server.Put("/registry/secrets/default/new", "new", 0)
+
+			restored, skipped, err := backup.Restore(client, s)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(restored).To(Equal(2))
+			Expect(skipped).To(Equal(1))
+			Expect(server.Keys()).To(Equal(map[string]etcdtest.Value{
+				"/registry/namespaces/default":    {Value: "ns"},
+				"/registry/secrets/default/token": {Value: "secret"},
+			}))
+		})
+	})
+
+	It("restores many keys in several transactions", func() {
+		for i := 0; i < 300; i++ {
+			server.Put(fmt.Sprintf("/registry/configmaps/default/%03d", i), "v", 0)
+		}
+		_, err := backup.Backup(client, "v1.17.9", dir)
+		Expect(err).NotTo(HaveOccurred())
+		_, s, err := backup.Verify(dir, "")
+		Expect(err).NotTo(HaveOccurred())
+
+		restored, _, err := backup.Restore(client, s)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(restored).To(Equal(302))
+		Expect(server.Txns).To(Equal(3))
+		Expect(server.Keys()).To(HaveLen(302))
+	})
+
+	It("says etcd is partial when a transaction fails part way", func() {
+		for i := 0; i < 300; i++ {
+			server.Put(fmt.Sprintf("/registry/configmaps/default/%03d", i), "v", 0)
+		}
+		_, err := backup.Backup(client, "v1.17.9", dir)
+		Expect(err).NotTo(HaveOccurred())
+		_, s, err := backup.Verify(dir, "")
+		Expect(err).NotTo(HaveOccurred())
+
+		server.FailTxnsAfter(1)
+		restored, _, err := backup.Restore(client, s)
+		Expect(err).To(MatchError(HavePrefix("etcd is now partial: 128 of the 302 keys were written before ")))
+		Expect(err).To(MatchError(HaveSuffix("etcdserver: request timed out; run the restore again from the same artifact")))
+		Expect(restored).To(Equal(128))
+		Expect(server.Keys()).To(HaveLen(128))
+	})
+})
+
+var _ = Describe("BinaryVersion", func() {
+	It("reads the version kube-apiserver prints", func() {
+		tmpDir, err := ioutil.TempDir("", "backup")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(tmpDir)
+		path := filepath.Join(tmpDir, "kube-apiserver")
+		Expect(ioutil.WriteFile(path, []byte("#!/bin/sh\necho Kubernetes v1.17.9\n"), 0700)).To(Succeed())
+
+		Expect(backup.BinaryVersion(path)).To(Equal("v1.17.9"))
+	})
+})
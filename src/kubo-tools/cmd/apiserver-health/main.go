@@ -0,0 +1,51 @@
+// Command apiserver-health waits for an API server to be healthy: for
+// /livez, /readyz and /healthz to pass all their checks. While it waits it
+// logs the checks that fail, such as etcd or a post-start hook, whenever
+// they change. Endpoints the API server does not serve yet are skipped. It
+// is run by the post-start script of the kube-apiserver job and by the
+// post-restore-unlock script of the bbr-kube-apiserver job, with a
+// kubeconfig holding a client certificate. It exits 1 if the API server is
+// not healthy within -timeout; -timeout 0 probes once.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"kubo-tools/health"
+	"kubo-tools/kube"
+)
+
+func main() {
+	var (
+		kubeconfig string
+		timeout    time.Duration
+		interval   time.Duration
+	)
+	flag.StringVar(&kubeconfig, "kubeconfig", "", "kubeconfig of the API server to probe (required)")
+	flag.DurationVar(&timeout, "timeout", time.Minute, "how long to wait for the API server to be healthy")
+	flag.DurationVar(&interval, "interval", 2*time.Second, "time between probes")
+	flag.Parse()
+	if kubeconfig == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	client, err := kube.NewClient(kubeconfig)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "probing the API server failed: %v\n", err)
+		os.Exit(1)
+	}
+	p := &health.Prober{
+		Client:   client,
+		Log:      log.New(os.Stdout, "", log.LstdFlags|log.LUTC),
+		Interval: interval,
+	}
+	if _, err := p.Wait(timeout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
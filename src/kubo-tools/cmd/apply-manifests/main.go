@@ -0,0 +1,88 @@
+// Command apply-manifests applies the objects in manifest files and
+// directories with server-side apply, under the field manager given. It is
+// run by the post-start script of the kubernetes-roles job. Each object is
+// logged to stdout as created, updated or unchanged. Objects that fail
+// while the API server is starting, or while a CRD they need is not served
+// yet, are retried until -timeout; a conflict with another field manager
+// fails at once, naming the manager, unless -force-conflicts is set.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"kubo-tools/apply"
+	"kubo-tools/kube"
+)
+
+func main() {
+	var (
+		kubeconfig     string
+		fieldManager   string
+		forceConflicts bool
+		timeout        time.Duration
+	)
+	flag.StringVar(&kubeconfig, "kubeconfig", "", "kubeconfig with credentials to apply the objects (required)")
+	flag.StringVar(&fieldManager, "field-manager", "", "field manager to apply the objects as, e.g. the job name (required)")
+	flag.BoolVar(&forceConflicts, "force-conflicts", false, "take over fields other field managers own instead of failing")
+	flag.DurationVar(&timeout, "timeout", 2*time.Minute, "how long to retry objects the API server cannot apply yet")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s -kubeconfig FILE -field-manager NAME [flags] PATH...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if kubeconfig == "" || fieldManager == "" || flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	fail := func(err error) {
+		fmt.Fprintf(os.Stderr, "applying manifests failed: %v\n", err)
+		os.Exit(1)
+	}
+	objects, err := kube.ReadManifests(flag.Args()...)
+	if err != nil {
+		fail(err)
+	}
+	client, err := kube.NewClient(kubeconfig)
+	if err != nil {
+		fail(err)
+	}
+
+	logger := log.New(os.Stdout, "", log.LstdFlags|log.LUTC)
+	a := &apply.Applier{Client: client, FieldManager: fieldManager, Force: forceConflicts}
+	deadline := time.Now().Add(timeout)
+	for len(objects) > 0 {
+		var retry []kube.Object
+		for _, obj := range objects {
+			result, err := a.Apply(obj)
+			switch {
+			case err == nil:
+				logger.Printf("%s %s", result.Action, obj)
+			case retryable(err) && time.Now().Before(deadline):
+				logger.Printf("retrying %s: %v", obj, err)
+				retry = append(retry, obj)
+			default:
+				fail(err)
+			}
+		}
+		if len(retry) > 0 {
+			client.ForgetDiscovery()
+			time.Sleep(2 * time.Second)
+		}
+		objects = retry
+	}
+}
+
+// retryable reports whether the API server may apply the object later: it
+// did not answer, failed, asked to retry, or does not serve the kind yet.
+func retryable(err error) bool {
+	e, ok := err.(*kube.Error)
+	if !ok {
+		return false
+	}
+	return e.Code == 0 || e.Code >= 500 || kube.IsTooManyRequests(err) || kube.IsNotFound(err)
+}
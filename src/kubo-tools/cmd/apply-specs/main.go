@@ -0,0 +1,72 @@
+// Command apply-specs brings the cluster addons to the state in the
+// errand's configuration: it applies the enabled addons of the registry in
+// dependency order with server-side apply, each labelled with the addon
+// name and release version and waited for until ready, and prunes the
+// labelled objects that no addon has any more. Each object is logged to
+// stdout as created, updated, unchanged or deleted, followed by a summary.
+// It exits 1 if any addon failed, listing the objects that did not become
+// ready, or if any object could not be pruned.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"kubo-tools/addons"
+	"kubo-tools/kube"
+)
+
+func main() {
+	var (
+		kubeconfig     string
+		config         string
+		readyTimeout   time.Duration
+		fieldManager   string
+		forceConflicts bool
+	)
+	flag.StringVar(&kubeconfig, "kubeconfig", "/var/vcap/jobs/apply-specs/config/kubeconfig", "kubeconfig with admin credentials")
+	flag.StringVar(&config, "config", "/var/vcap/jobs/apply-specs/config/addons.yml", "addons to apply")
+	flag.DurationVar(&readyTimeout, "ready-timeout", 5*time.Minute, "how long to wait for each object to become ready")
+	flag.StringVar(&fieldManager, "field-manager", "apply-specs", "field manager to apply the objects as")
+	flag.BoolVar(&forceConflicts, "force-conflicts", false, "take over fields other field managers own instead of failing")
+	flag.Parse()
+
+	fail := func(err error) {
+		fmt.Fprintf(os.Stderr, "applying addons failed: %v\n", err)
+		os.Exit(1)
+	}
+	c, err := addons.LoadConfig(config)
+	if err != nil {
+		fail(err)
+	}
+	registry, err := addons.LoadRegistry(c.Registry)
+	if err != nil {
+		fail(err)
+	}
+	enabled, err := registry.Resolve(c.Addons)
+	if err != nil {
+		fail(err)
+	}
+	client, err := kube.NewClient(kubeconfig)
+	if err != nil {
+		fail(err)
+	}
+
+	logger := log.New(os.Stdout, "", log.LstdFlags|log.LUTC)
+	m := &addons.Manager{
+		Client:         client,
+		Log:            logger,
+		ReleaseVersion: c.ReleaseVersion,
+		ReadyTimeout:   readyTimeout,
+		FieldManager:   fieldManager,
+		ForceConflicts: forceConflicts,
+	}
+	changes, err := m.Apply(enabled)
+	logger.Print(addons.Summary(changes))
+	if err != nil {
+		fail(err)
+	}
+}
@@ -0,0 +1,190 @@
+// Command drain is the BOSH drain script of the kubelet job. It cordons the
+// worker, evicts its pods, waits for its persistent volumes to detach and
+// deletes the node. Progress is written to stdout as JSON lines; following
+// the BOSH drain contract, "0" is written to file descriptor 3 on success.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"kubo-tools/drain"
+	"kubo-tools/kube"
+)
+
+// config holds the flags.
+type config struct {
+	kubeconfig    string
+	nodeSelector  string
+	pidfile       string
+	rootDir       string
+	forceNode     bool
+	workers       int
+	auditDir      string
+	detachTimeout time.Duration
+	dryRun        bool
+	opts          drain.Options
+}
+
+func main() {
+	var c config
+	flag.StringVar(&c.kubeconfig, "kubeconfig", "/var/vcap/jobs/kubelet/config/kubeconfig-drain", "kubeconfig of the drain user")
+	flag.StringVar(&c.nodeSelector, "node-selector", "", "label selector of the node to drain, e.g. bosh.id=<id>")
+	flag.StringVar(&c.pidfile, "pidfile", "/var/vcap/sys/run/kubernetes/kubelet.pid", "kubelet pidfile; nothing is drained unless the kubelet is running")
+	flag.StringVar(&c.rootDir, "kubelet-root-dir", "/var/lib/kubelet", "kubelet root directory, under which persistent volumes are mounted")
+	flag.BoolVar(&c.forceNode, "force-node", false, "force delete the pods if they cannot be evicted")
+	flag.IntVar(&c.workers, "force-node-workers", 10, "how many pods to force delete at a time")
+	flag.StringVar(&c.auditDir, "audit-dir", "/var/vcap/sys/log/kubelet", "directory to write the audit of force deleted pods to")
+	flag.DurationVar(&c.detachTimeout, "volume-detach-timeout", 0, "time to wait for persistent volumes to detach; zero means forever")
+	flag.IntVar(&c.opts.GracePeriod, "grace-period", 10, "seconds given to each pod to terminate; if negative, the pod's own grace period")
+	flag.BoolVar(&c.dryRun, "dry-run", false, "print what the drain would do, without changing anything")
+	flag.DurationVar(&c.opts.Timeout, "timeout", 0, "time to wait for evictions before giving up; zero means forever")
+	flag.BoolVar(&c.opts.Force, "force", false, "evict pods that are not managed by a controller")
+	flag.BoolVar(&c.opts.IgnoreDaemonSets, "ignore-daemonsets", false, "ignore DaemonSet-managed pods")
+	flag.BoolVar(&c.opts.DeleteLocalData, "delete-local-data", false, "evict pods using emptyDir volumes")
+	flag.Parse()
+
+	if c.dryRun {
+		if err := dryRun(c, drain.NewProgress(os.Stderr)); err != nil {
+			fmt.Fprintf(os.Stderr, "drain dry run failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	progress := drain.NewProgress(os.Stdout)
+	if err := run(c, progress); err != nil {
+		progress.Failed("done", "", "kubelet drain failed", err)
+		fmt.Fprintf(os.Stderr, "kubelet drain failed: %v\n", err)
+		os.Exit(1)
+	}
+	progress.Log(drain.Event{Phase: "done", Message: "kubelet drained"})
+
+	// BOSH reads how long to wait before calling drain again from fd 3;
+	// 0 means the drain is complete.
+	fmt.Fprintln(os.NewFile(3, "bosh-drain"), 0)
+}
+
+func run(c config, progress *drain.Progress) error {
+	if running, why := kubeletRunning(c.pidfile); !running {
+		progress.Log(drain.Event{Phase: "check", Message: why + ", so not attempting to drain"})
+		return nil
+	}
+
+	client, err := kube.NewClient(c.kubeconfig)
+	if err != nil {
+		return err
+	}
+	d := drain.New(client, c.opts, progress)
+	d.Workers = c.workers
+
+	var nodes []string
+	if err := d.Retry("find-node", "", func() (err error) {
+		nodes, err = d.Nodes(c.nodeSelector)
+		return err
+	}); err != nil {
+		return err
+	}
+	if len(nodes) == 0 {
+		progress.Log(drain.Event{Phase: "check", Message: "no node matches " + c.nodeSelector + ", so not attempting to drain"})
+		return nil
+	}
+
+	for _, node := range nodes {
+		if err := d.Retry("cordon", node, func() error { return d.Cordon(node) }); err != nil {
+			return err
+		}
+
+		volumes, err := d.FindVolumes(node, c.rootDir)
+		if err != nil {
+			return err
+		}
+
+		if err := d.Drain(node); err != nil {
+			if !c.forceNode {
+				return err
+			}
+			progress.Failed("evict", node, "eviction failed, force deleting pods", err)
+			if err := forceKill(d, node, err, c.auditDir, progress); err != nil {
+				return err
+			}
+		}
+
+		if err := d.WaitForDetach(node, volumes, c.detachTimeout); err != nil {
+			return err
+		}
+
+		if err := d.Retry("delete-node", node, func() error { return d.DeleteNode(node) }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dryRun prints the plan for each node to stdout. Unlike a drain, it runs
+// whether or not the kubelet is.
+func dryRun(c config, progress *drain.Progress) error {
+	client, err := kube.NewClient(c.kubeconfig)
+	if err != nil {
+		return err
+	}
+	d := drain.New(client, c.opts, progress)
+
+	nodes, err := d.Nodes(c.nodeSelector)
+	if err != nil {
+		return err
+	}
+	if len(nodes) == 0 {
+		fmt.Printf("No node matches %s; the drain would do nothing.\n", c.nodeSelector)
+		return nil
+	}
+	for i, node := range nodes {
+		if i > 0 {
+			fmt.Println()
+		}
+		plan, err := d.Simulate(node, c.forceNode)
+		if err != nil {
+			return err
+		}
+		if err := plan.Write(os.Stdout); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// forceKill force deletes the pods on the node and writes the audit, even
+// if some deletions failed.
+func forceKill(d *drain.Drainer, node string, cause error, auditDir string, progress *drain.Progress) error {
+	audit, err := d.ForceKill(node, cause)
+	path, writeErr := audit.WriteFile(auditDir)
+	if writeErr != nil {
+		progress.Failed("force-kill", node, "cannot write audit", writeErr)
+	} else {
+		progress.Log(drain.Event{Phase: "force-kill", Node: node, Message: fmt.Sprintf("force deleted %d pods, audit written to %s", len(audit.Pods), path)})
+	}
+	return err
+}
+
+// kubeletRunning reports whether the process in the pidfile is alive, and
+// if not, why not.
+func kubeletRunning(pidfile string) (bool, string) {
+	raw, err := ioutil.ReadFile(pidfile)
+	if err != nil {
+		return false, "pidfile not found"
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(strings.SplitN(string(raw), "\n", 2)[0]))
+	if err != nil {
+		return false, "pidfile is invalid"
+	}
+	if err := syscall.Kill(pid, 0); err != nil && err != syscall.EPERM {
+		return false, "process from pidfile not running"
+	}
+	return true, ""
+}
@@ -0,0 +1,57 @@
+// Command etcd-backup is the BBR backup script of the bbr-kube-apiserver
+// job. It streams an etcd v3 snapshot of the cluster state into the
+// artifact directory, checks it, and records the Kubernetes and etcd
+// versions, the revision, the number of keys and the checksum of the
+// snapshot next to it.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"kubo-tools/backup"
+	"kubo-tools/etcd"
+)
+
+func main() {
+	var (
+		endpoints     string
+		cfg           etcd.Config
+		kubeAPIServer string
+		dir           string
+	)
+	flag.StringVar(&endpoints, "endpoints", "", "comma-separated etcd client URLs (required)")
+	flag.StringVar(&cfg.CACert, "cacert", "/var/vcap/jobs/kube-apiserver/config/etcd-ca.crt", "CA of the etcd certificates")
+	flag.StringVar(&cfg.Cert, "cert", "/var/vcap/jobs/kube-apiserver/config/etcd-client.crt", "etcd client certificate")
+	flag.StringVar(&cfg.Key, "key", "/var/vcap/jobs/kube-apiserver/config/etcd-client.key", "etcd client key")
+	flag.StringVar(&kubeAPIServer, "kube-apiserver", "/var/vcap/packages/kubernetes/bin/kube-apiserver", "kube-apiserver binary, to record its version")
+	flag.StringVar(&dir, "artifact-dir", os.Getenv("BBR_ARTIFACT_DIRECTORY"), "directory to write the snapshot to")
+	flag.Parse()
+	if endpoints == "" || dir == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	cfg.Endpoints = strings.Split(endpoints, ",")
+
+	fail := func(err error) {
+		fmt.Fprintf(os.Stderr, "backing up etcd failed: %v\n", err)
+		os.Exit(1)
+	}
+	version, err := backup.BinaryVersion(kubeAPIServer)
+	if err != nil {
+		fail(err)
+	}
+	client, err := etcd.NewClient(cfg)
+	if err != nil {
+		fail(err)
+	}
+	m, err := backup.Backup(client, version, dir)
+	if err != nil {
+		fail(err)
+	}
+	logger := log.New(os.Stdout, "", log.LstdFlags|log.LUTC)
+	logger.Printf("backed up %d keys at revision %d of etcd %s, Kubernetes %s: %d bytes, sha256 %s", m.Keys, m.Revision, m.EtcdVersion, m.KubernetesVersion, m.Size, m.SHA256)
+}
@@ -0,0 +1,70 @@
+// Command etcd-restore is the BBR restore script of the bbr-kube-apiserver
+// job. It verifies the snapshot in the artifact directory against its
+// metadata and the Kubernetes version deployed, then replaces every key in
+// etcd with the keys of the snapshot. It runs while the API servers are
+// stopped. With -verify-only it only verifies, so that every instance
+// checks the artifact while one restores it.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"kubo-tools/backup"
+	"kubo-tools/etcd"
+)
+
+func main() {
+	var (
+		endpoints     string
+		cfg           etcd.Config
+		kubeAPIServer string
+		dir           string
+		verifyOnly    bool
+	)
+	flag.StringVar(&endpoints, "endpoints", "", "comma-separated etcd client URLs (required unless -verify-only)")
+	flag.StringVar(&cfg.CACert, "cacert", "/var/vcap/jobs/kube-apiserver/config/etcd-ca.crt", "CA of the etcd certificates")
+	flag.StringVar(&cfg.Cert, "cert", "/var/vcap/jobs/kube-apiserver/config/etcd-client.crt", "etcd client certificate")
+	flag.StringVar(&cfg.Key, "key", "/var/vcap/jobs/kube-apiserver/config/etcd-client.key", "etcd client key")
+	flag.StringVar(&kubeAPIServer, "kube-apiserver", "/var/vcap/packages/kubernetes/bin/kube-apiserver", "kube-apiserver binary, whose minor version the snapshot must match")
+	flag.StringVar(&dir, "artifact-dir", os.Getenv("BBR_ARTIFACT_DIRECTORY"), "directory holding the snapshot")
+	flag.BoolVar(&verifyOnly, "verify-only", false, "verify the snapshot without restoring it")
+	flag.Parse()
+	if (endpoints == "" && !verifyOnly) || dir == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	fail := func(err error) {
+		fmt.Fprintf(os.Stderr, "restoring etcd failed: %v\n", err)
+		os.Exit(1)
+	}
+	logger := log.New(os.Stdout, "", log.LstdFlags|log.LUTC)
+	version, err := backup.BinaryVersion(kubeAPIServer)
+	if err != nil {
+		fail(err)
+	}
+	m, s, err := backup.Verify(dir, version)
+	if err != nil {
+		fail(err)
+	}
+	logger.Printf("verified the snapshot of %d keys at revision %d, taken %s of Kubernetes %s", m.Keys, m.Revision, m.Time.Format("2006-01-02T15:04:05Z"), m.KubernetesVersion)
+	if verifyOnly {
+		return
+	}
+
+	cfg.Endpoints = strings.Split(endpoints, ",")
+	client, err := etcd.NewClient(cfg)
+	if err != nil {
+		fail(err)
+	}
+	restored, skipped, err := backup.Restore(client, s)
+	if err != nil {
+		logger.Printf("wrote %d keys of the snapshot before failing", restored)
+		fail(err)
+	}
+	logger.Printf("restored %d keys, leaving out %d keys attached to leases", restored, skipped)
+}
@@ -0,0 +1,116 @@
+// Command etcd-snapshot inspects etcd snapshots offline, such as those the
+// bbr-kube-apiserver job backs up. It checks a snapshot's integrity hash
+// and lists the Kubernetes objects in it by group, kind and namespace, or
+// with -diff, lists the objects added, removed and changed between two
+// snapshots. A snapshot is a file or a BBR artifact directory, whose
+// metadata is checked as well.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"kubo-tools/backup"
+	"kubo-tools/snapshot"
+)
+
+func main() {
+	var (
+		prefix  string
+		filter  snapshot.Filter
+		objects bool
+		diff    bool
+	)
+	flag.StringVar(&prefix, "prefix", snapshot.DefaultPrefix, "prefix of the keys the API server stores objects under, its --etcd-prefix")
+	flag.StringVar(&filter.Group, "group", "", "only objects of this API group, core for the core group")
+	flag.StringVar(&filter.Kind, "kind", "", "only objects of this kind, e.g. Secret, or resource, e.g. secrets")
+	flag.StringVar(&filter.Namespace, "namespace", "", "only objects in this namespace")
+	flag.BoolVar(&objects, "objects", false, "list every object rather than how many there are")
+	flag.BoolVar(&diff, "diff", false, "list the objects that differ between two snapshots")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] SNAPSHOT\n       %s -diff [flags] OLD NEW\n", os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if (!diff && flag.NArg() != 1) || (diff && flag.NArg() != 2) {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	fail := func(err error) {
+		fmt.Fprintf(os.Stderr, "reading snapshot failed: %v\n", err)
+		os.Exit(1)
+	}
+	var selected [][]snapshot.Object
+	for _, path := range flag.Args() {
+		s, err := read(path)
+		if err != nil {
+			fail(err)
+		}
+		selected = append(selected, filter.Select(snapshot.Objects(s.KeyValues, prefix)))
+	}
+
+	if diff {
+		changes := snapshot.Diff(selected[0], selected[1])
+		added, removed := 0, 0
+		for _, c := range changes {
+			fmt.Println(c)
+			switch {
+			case c.Old == nil:
+				added++
+			case c.New == nil:
+				removed++
+			}
+		}
+		fmt.Printf("%d added, %d removed, %d changed\n", added, removed, len(changes)-added-removed)
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	if objects {
+		fmt.Fprintln(w, "GROUP\tKIND\tNAMESPACE\tNAME\tREVISION")
+		for _, o := range selected[0] {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\n", orDash(o.APIGroup()), o.Type(), o.Namespace, o.Name, o.ModRevision)
+		}
+	} else {
+		fmt.Fprintln(w, "GROUP\tKIND\tNAMESPACE\tOBJECTS")
+		for _, c := range snapshot.Counts(selected[0]) {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\n", orDash(c.Group), c.Kind, c.Namespace, c.Objects)
+		}
+	}
+	w.Flush()
+}
+
+// read reads and checks the snapshot at path, against its metadata if path
+// is an artifact directory, and describes it on standard error so that the
+// listing can be piped.
+func read(path string) (*snapshot.Snapshot, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		s, err := snapshot.Read(path)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(os.Stderr, "%s: integrity hash verified, %d keys at revision %d\n", path, len(s.KeyValues), s.Revision)
+		return s, nil
+	}
+	m, s, err := backup.Verify(path, "")
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(os.Stderr, "%s: checksum and integrity hash verified, %d keys at revision %d, taken %s of Kubernetes %s\n", path, m.Keys, m.Revision, m.Time.Format("2006-01-02T15:04:05Z"), m.KubernetesVersion)
+	return s, nil
+}
+
+// orDash shows an unknown group as a dash.
+func orDash(group string) string {
+	if group == "" {
+		return "-"
+	}
+	return group
+}
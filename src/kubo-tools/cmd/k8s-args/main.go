@@ -0,0 +1,78 @@
+// Command k8s-args starts a Kubernetes binary with the flags set by the
+// k8s-args property of its job. It reads the job's properties as JSON,
+// renders the property's flags and checks each against the flags the
+// binary lists in its --help. If a flag is unknown, deprecated or lacks a
+// value it needs, it exits 1 naming the property that set it; with
+// -allow-deprecated, deprecated flags are only warned about. Otherwise it
+// replaces itself with the binary, given the rendered flags followed by
+// the arguments after --. With -print it prints the flags, one per line,
+// instead of starting the binary.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"syscall"
+
+	"kubo-tools/k8sargs"
+)
+
+func main() {
+	var (
+		binary          string
+		properties      string
+		property        string
+		allowDeprecated bool
+		printOnly       bool
+	)
+	flag.StringVar(&binary, "binary", "", "Kubernetes binary to start (required)")
+	flag.StringVar(&properties, "properties", "", "JSON file of the job properties (required)")
+	flag.StringVar(&property, "property", "k8s-args", "property that sets the flags")
+	flag.BoolVar(&allowDeprecated, "allow-deprecated", false, "pass deprecated flags, with a warning, rather than refuse them")
+	flag.BoolVar(&printOnly, "print", false, "print the flags rather than start the binary")
+	flag.Parse()
+	if binary == "" || properties == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	fail := func(err error) {
+		fmt.Fprintf(os.Stderr, "starting %s failed: %v\n", binary, err)
+		os.Exit(1)
+	}
+	raw, err := ioutil.ReadFile(properties)
+	if err != nil {
+		fail(err)
+	}
+	args, err := k8sargs.Parse(raw, property)
+	if err != nil {
+		fail(fmt.Errorf("%s: %v", properties, err))
+	}
+	fs, err := k8sargs.Load(binary)
+	if err != nil {
+		fail(err)
+	}
+	var reasons []string
+	for _, p := range fs.Check(args) {
+		if p.Deprecated && allowDeprecated {
+			fmt.Fprintf(os.Stderr, "warning: %s\n", p)
+			continue
+		}
+		reasons = append(reasons, p.String())
+	}
+	if len(reasons) > 0 {
+		fail(fmt.Errorf("%d of the flags set by %s cannot be passed to it: %s", len(reasons), property, strings.Join(reasons, "; ")))
+	}
+
+	flags := append(k8sargs.Render(args), flag.Args()...)
+	if printOnly {
+		for _, f := range flags {
+			fmt.Println(f)
+		}
+		return
+	}
+	fail(syscall.Exec(binary, append([]string{binary}, flags...), os.Environ()))
+}
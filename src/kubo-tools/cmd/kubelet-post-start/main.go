@@ -0,0 +1,48 @@
+// Command kubelet-post-start is run by the post-start script of the kubelet
+// job once the kubelet is up. It finds the node by its bosh.id label, waits
+// for it to become Ready, uncordons it and marks its network available.
+// Progress is logged to stdout; a failure is written to stderr and exits 1,
+// which fails the BOSH deploy.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"kubo-tools/kube"
+	"kubo-tools/poststart"
+)
+
+func main() {
+	var (
+		kubeconfig   string
+		nodeSelector string
+		timeout      time.Duration
+	)
+	flag.StringVar(&kubeconfig, "kubeconfig", "/var/vcap/jobs/kubelet/config/kubeconfig", "kubeconfig of the kubelet")
+	flag.StringVar(&nodeSelector, "node-selector", "", "label selector of the node, e.g. bosh.id=<id>")
+	flag.DurationVar(&timeout, "timeout", 120*time.Second, "time to wait for the node to register and become Ready")
+	flag.Parse()
+
+	if nodeSelector == "" {
+		fmt.Fprintln(os.Stderr, "kubelet post-start failed: -node-selector is required")
+		os.Exit(1)
+	}
+
+	client, err := kube.NewClient(kubeconfig)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kubelet post-start failed: %v\n", err)
+		os.Exit(1)
+	}
+
+	s := poststart.New(client, log.New(os.Stdout, "", log.LstdFlags|log.LUTC), timeout)
+	node, err := s.Start(nodeSelector)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kubelet post-start failed: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("kubelet post-start checks succeeded for node %s\n", node)
+}
@@ -0,0 +1,61 @@
+// Command load-images loads the container images cached in the kubernetes
+// package into the container runtime, Docker or containerd, skipping those
+// it already has. It is run by the post-start script of the kubelet job.
+// Each archive is logged to stdout as loaded, already present or failed; if
+// any failed, the summary is written to stderr and it exits 1.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"kubo-tools/images"
+)
+
+func main() {
+	var (
+		dir         string
+		runtime     string
+		docker      string
+		address     string
+		namespace   string
+		snapshotter string
+	)
+	flag.StringVar(&dir, "dir", "/var/vcap/packages/kubernetes/container-images", "directory of image archives written by docker save")
+	flag.StringVar(&runtime, "runtime", "docker", "container runtime to load the images into: docker or containerd")
+	flag.StringVar(&docker, "docker-socket", "unix:///var/vcap/sys/run/docker/docker.sock", "socket of the Docker daemon")
+	flag.StringVar(&address, "containerd-address", "unix:///run/containerd/containerd.sock", "socket of containerd, which also serves CRI")
+	flag.StringVar(&namespace, "containerd-namespace", "k8s.io", "containerd namespace of the kubelet's images")
+	flag.StringVar(&snapshotter, "containerd-snapshotter", "overlayfs", "snapshotter the CRI plugin of containerd runs containers with")
+	flag.Parse()
+
+	var r images.Runtime
+	switch runtime {
+	case "docker":
+		r = images.NewDocker(docker)
+	case "containerd":
+		r = &images.Containerd{Address: address, Namespace: namespace, Snapshotter: snapshotter}
+	default:
+		fmt.Fprintf(os.Stderr, "loading cached images failed: unknown runtime %q, expected docker or containerd\n", runtime)
+		os.Exit(1)
+	}
+
+	logger := log.New(os.Stdout, "", log.LstdFlags|log.LUTC)
+	loader := &images.Loader{Runtime: r, Log: logger}
+	results, err := loader.LoadDir(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "loading cached images failed: %v\n", err)
+		os.Exit(1)
+	}
+
+	summary := images.Summary(results)
+	logger.Print(summary)
+	for _, result := range results {
+		if result.Status == images.Failed {
+			fmt.Fprintf(os.Stderr, "loading cached images failed: %s\n", summary)
+			os.Exit(1)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+// Command reconcile-policies compares the objects in manifest files and
+// directories, the policies of the kubernetes-roles job, with the live
+// objects and logs each one that is missing or differs, field by field.
+// With -reapply it applies the drifted policies again, with server-side
+// apply under -field-manager.
+//
+// With -interval 0 it checks once, as the job's errand, and exits 1 if any
+// object could not be read or drifted and was not applied again. Otherwise
+// it checks at every interval until stopped, as the job's monit process,
+// and serves Prometheus metrics on -metrics-address.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"kubo-tools/apply"
+	"kubo-tools/kube"
+	"kubo-tools/policies"
+)
+
+func main() {
+	var (
+		kubeconfig     string
+		fieldManager   string
+		forceConflicts bool
+		reapply        bool
+		interval       time.Duration
+		metricsAddress string
+	)
+	flag.StringVar(&kubeconfig, "kubeconfig", "", "kubeconfig with credentials to read and apply the objects (required)")
+	flag.StringVar(&fieldManager, "field-manager", "kubernetes-roles", "field manager to apply the objects as")
+	flag.BoolVar(&forceConflicts, "force-conflicts", false, "take over fields other field managers own instead of failing")
+	flag.BoolVar(&reapply, "reapply", false, "apply the drifted objects again instead of only reporting them")
+	flag.DurationVar(&interval, "interval", 0, "time between checks; 0 checks once and exits")
+	flag.StringVar(&metricsAddress, "metrics-address", "", "address to serve Prometheus metrics on, e.g. :9841, while checking at every interval")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s -kubeconfig FILE [flags] PATH...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if kubeconfig == "" || flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	fail := func(err error) {
+		fmt.Fprintf(os.Stderr, "reconciling policies failed: %v\n", err)
+		os.Exit(1)
+	}
+	objects, err := kube.ReadManifests(flag.Args()...)
+	if err != nil {
+		fail(err)
+	}
+	client, err := kube.NewClient(kubeconfig)
+	if err != nil {
+		fail(err)
+	}
+
+	logger := log.New(os.Stdout, "", log.LstdFlags|log.LUTC)
+	r := &policies.Reconciler{
+		Applier:  &apply.Applier{Client: client, FieldManager: fieldManager, Force: forceConflicts},
+		Policies: objects,
+		Reapply:  reapply,
+		Log:      logger,
+	}
+
+	if interval == 0 {
+		drifts, err := r.Check()
+		if err != nil {
+			fail(err)
+		}
+		if remaining := policies.Remaining(drifts); len(remaining) > 0 {
+			fail(fmt.Errorf("%d objects differ from their policies", len(remaining)))
+		}
+		return
+	}
+
+	r.Metrics = policies.NewMetrics()
+	if metricsAddress != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", r.Metrics)
+		go func() {
+			fail(http.ListenAndServe(metricsAddress, mux))
+		}()
+	}
+	for {
+		if _, err := r.Check(); err != nil {
+			logger.Print(err)
+		}
+		// Kinds may be served again, e.g. after the API server was upgraded.
+		client.ForgetDiscovery()
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,303 @@
+// Package containerd is a small client for the containerd API, enough to
+// import an image as `ctr images import` does: it writes content, unpacks
+// layers into snapshots and names images. It calls the containerd socket
+// with package grpc, so that the tools need neither ctr nor the containerd
+// module.
+package containerd
+
+import (
+	"io"
+	"strings"
+	"time"
+
+	"kubo-tools/grpc"
+)
+
+const (
+	contentService   = "/containerd.services.content.v1.Content/"
+	imagesService    = "/containerd.services.images.v1.Images/"
+	snapshotsService = "/containerd.services.snapshots.v1.Snapshots/"
+	diffService      = "/containerd.services.diff.v1.Diff/"
+	leasesService    = "/containerd.services.leases.v1.Leases/"
+)
+
+// Client makes calls in one containerd namespace.
+type Client struct {
+	conn      *grpc.Conn
+	namespace string
+	lease     string
+}
+
+// New connects to containerd at address, a path or a unix:// URL, for the
+// namespace, e.g. k8s.io, the one the CRI plugin uses.
+func New(address, namespace string) (*Client, error) {
+	conn, err := grpc.Dial(address)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{conn: conn, namespace: namespace}, nil
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+func IsNotFound(err error) bool {
+	return grpc.IsNotFound(err)
+}
+
+func IsAlreadyExists(err error) bool {
+	return grpc.IsAlreadyExists(err)
+}
+
+// metadata names the namespace of a call and the lease, if any, that
+// protects what it creates from garbage collection.
+func (c *Client) metadata() map[string]string {
+	md := map[string]string{"containerd-namespace": c.namespace}
+	if c.lease != "" {
+		md["containerd-lease"] = c.lease
+	}
+	return md
+}
+
+func (c *Client) call(method string, req grpc.Message) ([]byte, error) {
+	return c.conn.Invoke(method, c.metadata(), req)
+}
+
+// Descriptor describes content: a blob and its media type.
+type Descriptor struct {
+	MediaType string
+	Digest    string
+	Size      int64
+}
+
+func (d Descriptor) message() grpc.Message {
+	return grpc.Message(nil).Text(1, d.MediaType).Text(2, d.Digest).Varint(3, uint64(d.Size))
+}
+
+func readDescriptor(raw []byte) (Descriptor, error) {
+	var d Descriptor
+	err := grpc.EachField(raw, func(n int, v uint64, b []byte) {
+		switch n {
+		case 1:
+			d.MediaType = string(b)
+		case 2:
+			d.Digest = string(b)
+		case 3:
+			d.Size = int64(v)
+		}
+	})
+	return d, err
+}
+
+// WithLease creates a lease that expires after expiry and makes the calls
+// of fn under it, so that the content and snapshots they create are kept
+// until they are referenced. The lease is deleted once fn returns.
+func (c *Client) WithLease(id string, expiry time.Duration, fn func() error) error {
+	labels := map[string]string{"containerd.io/gc.expire": time.Now().Add(expiry).UTC().Format(time.RFC3339)}
+	if _, err := c.call(leasesService+"Create", grpc.Message(nil).Text(1, id).Labels(3, labels)); err != nil {
+		return err
+	}
+	c.lease = id
+	err := fn()
+	c.lease = ""
+	if _, deleteErr := c.call(leasesService+"Delete", grpc.Message(nil).Text(1, id)); err == nil {
+		err = deleteErr
+	}
+	return err
+}
+
+// Labels returns the labels of the content with digest.
+func (c *Client) Labels(digest string) (map[string]string, error) {
+	resp, err := c.call(contentService+"Info", grpc.Message(nil).Text(1, digest))
+	if err != nil {
+		return nil, err
+	}
+	labels := map[string]string{}
+	err = grpc.EachField(resp, func(n int, _ uint64, info []byte) {
+		if n != 1 {
+			return
+		}
+		grpc.EachField(info, func(n int, _ uint64, b []byte) {
+			if n == 5 {
+				grpc.ReadLabels(b, labels)
+			}
+		})
+	})
+	return labels, err
+}
+
+// SetLabels sets labels on the content with digest, keeping its others.
+func (c *Client) SetLabels(digest string, labels map[string]string) error {
+	var mask grpc.Message
+	for k := range labels {
+		mask = mask.Text(1, "labels."+k)
+	}
+	info := grpc.Message(nil).Text(1, digest).Labels(5, labels)
+	_, err := c.call(contentService+"Update", grpc.Message(nil).Embed(1, info).Embed(2, mask))
+	return err
+}
+
+// Write actions of content.v1.WriteContentRequest.
+const (
+	writeData   = 1
+	writeCommit = 2
+)
+
+// writeChunk is how much is sent in each message, well below the 16 MiB
+// containerd accepts.
+const writeChunk = 1 << 20
+
+// Write writes the content desc describes from r under ref, and commits
+// it with labels. containerd checks it against the size and digest.
+// Content that containerd has already is not an error.
+func (c *Client) Write(ref string, desc Descriptor, r io.Reader, labels map[string]string) error {
+	s, err := c.conn.NewStream(contentService+"Write", c.metadata())
+	if err != nil {
+		return err
+	}
+	request := func(action uint64, offset int64, data []byte) grpc.Message {
+		return grpc.Message(nil).
+			Varint(1, action).
+			Text(2, ref).
+			Varint(3, uint64(desc.Size)).
+			Text(4, desc.Digest).
+			Varint(5, uint64(offset)).
+			Bytes(6, data)
+	}
+
+	buf := make([]byte, writeChunk)
+	var offset int64
+	for {
+		n, readErr := io.ReadFull(r, buf)
+		if n > 0 {
+			if err := s.Send(request(writeData, offset, buf[:n])); err != nil {
+				return written(err)
+			}
+			offset += int64(n)
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+	if err := s.Send(request(writeCommit, offset, nil).Labels(7, labels)); err != nil {
+		return written(err)
+	}
+	if err := s.CloseSend(); err != nil {
+		return written(err)
+	}
+	for {
+		_, err := s.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return written(err)
+		}
+	}
+}
+
+// written ignores the error of a write of content that containerd already
+// has, which another writer may have committed meanwhile.
+func written(err error) error {
+	if IsAlreadyExists(err) {
+		return nil
+	}
+	return err
+}
+
+// Image returns the target of the image name.
+func (c *Client) Image(name string) (Descriptor, error) {
+	resp, err := c.call(imagesService+"Get", grpc.Message(nil).Text(1, name))
+	if err != nil {
+		return Descriptor{}, err
+	}
+	var target Descriptor
+	err = grpc.EachField(resp, func(n int, _ uint64, image []byte) {
+		if n != 1 {
+			return
+		}
+		grpc.EachField(image, func(n int, _ uint64, b []byte) {
+			if n == 3 {
+				target, _ = readDescriptor(b)
+			}
+		})
+	})
+	return target, err
+}
+
+// PutImage names target name, creating the image or pointing it at target.
+func (c *Client) PutImage(name string, target Descriptor) error {
+	image := grpc.Message(nil).Text(1, name).Embed(3, target.message())
+	_, err := c.call(imagesService+"Create", grpc.Message(nil).Embed(1, image))
+	if !IsAlreadyExists(err) {
+		return err
+	}
+	mask := grpc.Message(nil).Text(1, "target")
+	_, err = c.call(imagesService+"Update", grpc.Message(nil).Embed(1, image).Embed(2, mask))
+	return err
+}
+
+// Mount is a mount of a snapshot, as containerd returns it.
+type Mount grpc.Message
+
+// Snapshot checks that the snapshotter has the snapshot key.
+func (c *Client) Snapshot(snapshotter, key string) error {
+	_, err := c.call(snapshotsService+"Stat", grpc.Message(nil).Text(1, snapshotter).Text(2, key))
+	return err
+}
+
+// Prepare creates the active snapshot key on top of parent, or of nothing
+// if parent is empty, and returns its mounts.
+func (c *Client) Prepare(snapshotter, key, parent string) ([]Mount, error) {
+	resp, err := c.call(snapshotsService+"Prepare", grpc.Message(nil).Text(1, snapshotter).Text(2, key).Text(3, parent))
+	if err != nil {
+		return nil, err
+	}
+	var mounts []Mount
+	err = grpc.EachField(resp, func(n int, _ uint64, b []byte) {
+		if n == 1 {
+			mounts = append(mounts, Mount(b))
+		}
+	})
+	return mounts, err
+}
+
+// Commit commits the active snapshot key as name.
+func (c *Client) Commit(snapshotter, name, key string) error {
+	_, err := c.call(snapshotsService+"Commit", grpc.Message(nil).Text(1, snapshotter).Text(2, name).Text(3, key))
+	return err
+}
+
+// Remove removes the snapshot key.
+func (c *Client) Remove(snapshotter, key string) error {
+	_, err := c.call(snapshotsService+"Remove", grpc.Message(nil).Text(1, snapshotter).Text(2, key))
+	return err
+}
+
+// Apply extracts the layer desc, which containerd has, into the mounts of
+// an active snapshot.
+func (c *Client) Apply(desc Descriptor, mounts []Mount) error {
+	req := grpc.Message(nil).Embed(1, desc.message())
+	for _, m := range mounts {
+		req = req.Embed(2, grpc.Message(m))
+	}
+	_, err := c.call(diffService+"Apply", req)
+	return err
+}
+
+// Ref returns the name containerd gives an image reference, e.g.
+// docker.io/coredns/coredns:1.4.0 for coredns/coredns:1.4.0.
+func Ref(ref string) string {
+	domain := strings.SplitN(ref, "/", 2)[0]
+	if strings.Contains(ref, "/") && (strings.ContainsAny(domain, ".:") || domain == "localhost") {
+		return ref
+	}
+	if !strings.Contains(ref, "/") {
+		ref = "library/" + ref
+	}
+	return "docker.io/" + ref
+}
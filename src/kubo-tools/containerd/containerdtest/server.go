@@ -0,0 +1,508 @@
+// Package containerdtest provides an in-memory stand-in for the containerd
+// API, for testing code that uses package containerd.
+package containerdtest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"kubo-tools/containerd"
+	"kubo-tools/grpc"
+)
+
+// Server serves the content, images, snapshots, diff and leases calls that
+// package containerd makes, on a unix socket. Every call must name a
+// namespace; the namespaces share one store. Applying a layer only records
+// that it was applied.
+type Server struct {
+	// Socket is the path of the socket.
+	Socket string
+	srv    *grpc.Server
+
+	mu        sync.Mutex
+	content   map[string]blob
+	images    map[string]containerd.Descriptor
+	active    map[string]string
+	committed map[string]string
+	leases    map[string]bool
+	// writes counts the content written, applied the layers applied.
+	writes     int
+	applied    []string
+	namespaces map[string]bool
+	failApply  string
+	dropImages bool
+}
+
+type blob struct {
+	data   []byte
+	labels map[string]string
+}
+
+// NewServer serves on containerd.sock in dir.
+func NewServer(dir string) (*Server, error) {
+	s := &Server{
+		Socket:     filepath.Join(dir, "containerd.sock"),
+		srv:        grpc.NewServer(),
+		content:    map[string]blob{},
+		images:     map[string]containerd.Descriptor{},
+		active:     map[string]string{},
+		committed:  map[string]string{},
+		leases:     map[string]bool{},
+		namespaces: map[string]bool{},
+	}
+	l, err := net.Listen("unix", s.Socket)
+	if err != nil {
+		return nil, err
+	}
+	handlers := map[string]func(req []byte) (grpc.Message, error){
+		"/containerd.services.content.v1.Content/Info":        s.info,
+		"/containerd.services.content.v1.Content/Update":      s.update,
+		"/containerd.services.images.v1.Images/Get":           s.getImage,
+		"/containerd.services.images.v1.Images/Create":        s.createImage,
+		"/containerd.services.images.v1.Images/Update":        s.updateImage,
+		"/containerd.services.snapshots.v1.Snapshots/Stat":    s.stat,
+		"/containerd.services.snapshots.v1.Snapshots/Prepare": s.prepare,
+		"/containerd.services.snapshots.v1.Snapshots/Commit":  s.commit,
+		"/containerd.services.snapshots.v1.Snapshots/Remove":  s.remove,
+		"/containerd.services.diff.v1.Diff/Apply":             s.apply,
+		"/containerd.services.leases.v1.Leases/Create":        s.createLease,
+		"/containerd.services.leases.v1.Leases/Delete":        s.deleteLease,
+	}
+	for method, h := range handlers {
+		s.srv.Handle(method, unary(s, h))
+	}
+	s.srv.Handle("/containerd.services.content.v1.Content/Write", func(stream *grpc.ServerStream) error {
+		if err := s.namespace(stream); err != nil {
+			return err
+		}
+		return s.write(stream)
+	})
+	go s.srv.Serve(l)
+	return s, nil
+}
+
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// unary serves a call of one request and one response with h, under the
+// lock.
+func unary(s *Server, h func(req []byte) (grpc.Message, error)) grpc.Handler {
+	return func(stream *grpc.ServerStream) error {
+		if err := s.namespace(stream); err != nil {
+			return err
+		}
+		req, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		s.mu.Lock()
+		resp, err := h(req)
+		s.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		return stream.Send(resp)
+	}
+}
+
+func (s *Server) namespace(stream *grpc.ServerStream) error {
+	ns := stream.Metadata["containerd-namespace"]
+	if ns == "" {
+		return grpc.Errorf(grpc.FailedPrecondition, "namespace is required")
+	}
+	s.mu.Lock()
+	s.namespaces[ns] = true
+	s.mu.Unlock()
+	return nil
+}
+
+// fields reads the string and integer fields of a message; the last of a
+// repeated field wins.
+func fields(req []byte) (map[int]string, map[int]uint64, error) {
+	strs, ints := map[int]string{}, map[int]uint64{}
+	err := grpc.EachField(req, func(n int, v uint64, b []byte) {
+		if b != nil {
+			strs[n] = string(b)
+		} else {
+			ints[n] = v
+		}
+	})
+	return strs, ints, err
+}
+
+func digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func (s *Server) info(req []byte) (grpc.Message, error) {
+	strs, _, err := fields(req)
+	if err != nil {
+		return nil, err
+	}
+	b, ok := s.content[strs[1]]
+	if !ok {
+		return nil, grpc.Errorf(grpc.NotFound, "content digest %s: not found", strs[1])
+	}
+	info := grpc.Message(nil).Text(1, strs[1]).Varint(2, uint64(len(b.data))).Labels(5, b.labels)
+	return grpc.Message(nil).Embed(1, info), nil
+}
+
+func (s *Server) update(req []byte) (grpc.Message, error) {
+	var (
+		dgst   string
+		labels = map[string]string{}
+		paths  []string
+	)
+	err := grpc.EachField(req, func(n int, _ uint64, b []byte) {
+		switch n {
+		case 1:
+			grpc.EachField(b, func(n int, _ uint64, b []byte) {
+				switch n {
+				case 1:
+					dgst = string(b)
+				case 5:
+					grpc.ReadLabels(b, labels)
+				}
+			})
+		case 2:
+			grpc.EachField(b, func(_ int, _ uint64, b []byte) { paths = append(paths, string(b)) })
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	b, ok := s.content[dgst]
+	if !ok {
+		return nil, grpc.Errorf(grpc.NotFound, "content digest %s: not found", dgst)
+	}
+	for _, p := range paths {
+		k := p[len("labels."):]
+		b.labels[k] = labels[k]
+	}
+	return grpc.Message(nil).Embed(1, grpc.Message(nil).Text(1, dgst).Labels(5, b.labels)), nil
+}
+
+// write serves a stream of writes: the data of each is appended, and the
+// commit checks the size and digest. Every request is answered.
+func (s *Server) write(stream *grpc.ServerStream) error {
+	var (
+		data   []byte
+		labels = map[string]string{}
+	)
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		strs, ints, err := fields(req)
+		if err != nil {
+			return err
+		}
+		grpc.EachField(req, func(n int, _ uint64, b []byte) {
+			if n == 7 {
+				grpc.ReadLabels(b, labels)
+			}
+		})
+		expected, total := strs[4], int64(ints[3])
+
+		s.mu.Lock()
+		_, exists := s.content[expected]
+		s.mu.Unlock()
+		if exists {
+			return grpc.Errorf(grpc.AlreadyExists, "content %s: already exists", expected)
+		}
+		if int64(ints[5]) != int64(len(data)) {
+			return grpc.Errorf(grpc.OutOfRange, "write @%d must occur at current offset %d", ints[5], len(data))
+		}
+		data = append(data, strs[6]...)
+
+		resp := grpc.Message(nil).Varint(1, ints[1]).Varint(4, uint64(len(data))).Varint(5, uint64(total))
+		if ints[1] == 2 {
+			if int64(len(data)) != total {
+				return grpc.Errorf(grpc.FailedPrecondition, "unexpected commit size %d, expected %d", len(data), total)
+			}
+			if got := digest(data); got != expected {
+				return grpc.Errorf(grpc.FailedPrecondition, "unexpected commit digest %s, expected %s", got, expected)
+			}
+			s.mu.Lock()
+			s.content[expected] = blob{data: data, labels: labels}
+			s.writes++
+			s.mu.Unlock()
+			resp = resp.Text(6, expected)
+		}
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+}
+
+func readImage(req []byte) (string, containerd.Descriptor, error) {
+	var (
+		name   string
+		target containerd.Descriptor
+	)
+	err := grpc.EachField(req, func(n int, _ uint64, image []byte) {
+		if n != 1 {
+			return
+		}
+		grpc.EachField(image, func(n int, _ uint64, b []byte) {
+			switch n {
+			case 1:
+				name = string(b)
+			case 3:
+				strs, ints, _ := fields(b)
+				target = containerd.Descriptor{MediaType: strs[1], Digest: strs[2], Size: int64(ints[3])}
+			}
+		})
+	})
+	return name, target, err
+}
+
+func imageMessage(name string, target containerd.Descriptor) grpc.Message {
+	desc := grpc.Message(nil).Text(1, target.MediaType).Text(2, target.Digest).Varint(3, uint64(target.Size))
+	return grpc.Message(nil).Embed(1, grpc.Message(nil).Text(1, name).Embed(3, desc))
+}
+
+func (s *Server) getImage(req []byte) (grpc.Message, error) {
+	strs, _, err := fields(req)
+	if err != nil {
+		return nil, err
+	}
+	target, ok := s.images[strs[1]]
+	if !ok {
+		return nil, grpc.Errorf(grpc.NotFound, "image %q: not found", strs[1])
+	}
+	return imageMessage(strs[1], target), nil
+}
+
+func (s *Server) createImage(req []byte) (grpc.Message, error) {
+	name, target, err := readImage(req)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := s.images[name]; ok {
+		return nil, grpc.Errorf(grpc.AlreadyExists, "image %q: already exists", name)
+	}
+	if _, ok := s.content[target.Digest]; !ok {
+		return nil, grpc.Errorf(grpc.NotFound, "image %q: content digest %s: not found", name, target.Digest)
+	}
+	if !s.dropImages {
+		s.images[name] = target
+	}
+	return imageMessage(name, target), nil
+}
+
+func (s *Server) updateImage(req []byte) (grpc.Message, error) {
+	name, target, err := readImage(req)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := s.images[name]; !ok {
+		return nil, grpc.Errorf(grpc.NotFound, "image %q: not found", name)
+	}
+	s.images[name] = target
+	return imageMessage(name, target), nil
+}
+
+func (s *Server) stat(req []byte) (grpc.Message, error) {
+	strs, _, err := fields(req)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := s.committed[strs[1]+"/"+strs[2]]; !ok {
+		return nil, grpc.Errorf(grpc.NotFound, "snapshot %s: not found", strs[2])
+	}
+	return grpc.Message(nil).Embed(1, grpc.Message(nil).Text(1, strs[2])), nil
+}
+
+func (s *Server) prepare(req []byte) (grpc.Message, error) {
+	strs, _, err := fields(req)
+	if err != nil {
+		return nil, err
+	}
+	key, parent := strs[1]+"/"+strs[2], strs[3]
+	if _, ok := s.active[key]; ok {
+		return nil, grpc.Errorf(grpc.AlreadyExists, "snapshot %s: already exists", strs[2])
+	}
+	if _, ok := s.committed[strs[1]+"/"+parent]; parent != "" && !ok {
+		return nil, grpc.Errorf(grpc.NotFound, "parent snapshot %s: not found", parent)
+	}
+	s.active[key] = parent
+	mount := grpc.Message(nil).Text(1, "overlay").Text(2, "overlay").Text(4, "upperdir="+strs[2])
+	return grpc.Message(nil).Embed(1, mount), nil
+}
+
+func (s *Server) commit(req []byte) (grpc.Message, error) {
+	strs, _, err := fields(req)
+	if err != nil {
+		return nil, err
+	}
+	key, name := strs[1]+"/"+strs[3], strs[1]+"/"+strs[2]
+	parent, ok := s.active[key]
+	if !ok {
+		return nil, grpc.Errorf(grpc.NotFound, "snapshot %s: not found", strs[3])
+	}
+	if _, ok := s.committed[name]; ok {
+		return nil, grpc.Errorf(grpc.AlreadyExists, "snapshot %s: already exists", strs[2])
+	}
+	delete(s.active, key)
+	s.committed[name] = parent
+	return nil, nil
+}
+
+func (s *Server) remove(req []byte) (grpc.Message, error) {
+	strs, _, err := fields(req)
+	if err != nil {
+		return nil, err
+	}
+	delete(s.active, strs[1]+"/"+strs[2])
+	return nil, nil
+}
+
+func (s *Server) apply(req []byte) (grpc.Message, error) {
+	var (
+		desc   containerd.Descriptor
+		mounts int
+	)
+	err := grpc.EachField(req, func(n int, _ uint64, b []byte) {
+		switch n {
+		case 1:
+			strs, ints, _ := fields(b)
+			desc = containerd.Descriptor{MediaType: strs[1], Digest: strs[2], Size: int64(ints[3])}
+		case 2:
+			mounts++
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if mounts == 0 {
+		return nil, grpc.Errorf(grpc.InvalidArgument, "no mounts")
+	}
+	if _, ok := s.content[desc.Digest]; !ok {
+		return nil, grpc.Errorf(grpc.NotFound, "content digest %s: not found", desc.Digest)
+	}
+	if s.failApply != "" {
+		return nil, grpc.Errorf(grpc.Unknown, "%s", s.failApply)
+	}
+	s.applied = append(s.applied, desc.Digest)
+	return grpc.Message(nil).Embed(1, grpc.Message(nil).Text(1, "application/vnd.oci.image.layer.v1.tar").Text(2, desc.Digest)), nil
+}
+
+func (s *Server) createLease(req []byte) (grpc.Message, error) {
+	strs, _, err := fields(req)
+	if err != nil {
+		return nil, err
+	}
+	s.leases[strs[1]] = true
+	return grpc.Message(nil).Embed(1, grpc.Message(nil).Text(1, strs[1])), nil
+}
+
+func (s *Server) deleteLease(req []byte) (grpc.Message, error) {
+	strs, _, err := fields(req)
+	if err != nil {
+		return nil, err
+	}
+	if !s.leases[strs[1]] {
+		return nil, grpc.Errorf(grpc.NotFound, "lease %s: not found", strs[1])
+	}
+	delete(s.leases, strs[1])
+	return nil, nil
+}
+
+// Image returns the target of the image name.
+func (s *Server) Image(name string) (containerd.Descriptor, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	target, ok := s.images[name]
+	return target, ok
+}
+
+// Content returns the content with digest and its labels.
+func (s *Server) Content(digest string) ([]byte, map[string]string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.content[digest]
+	return b.data, b.labels, ok
+}
+
+// Snapshots returns the committed snapshots, as snapshotter/name, with
+// their parents.
+func (s *Server) Snapshots() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snapshots := map[string]string{}
+	for k, v := range s.committed {
+		snapshots[k] = v
+	}
+	return snapshots
+}
+
+// Active returns the keys of the active snapshots.
+func (s *Server) Active() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []string
+	for k := range s.active {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Writes returns how many blobs were written, and Applied the layers
+// applied, in order.
+func (s *Server) Writes() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.writes
+}
+
+func (s *Server) Applied() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.applied...)
+}
+
+// Namespaces returns the namespaces named by the calls, and Leases the
+// leases not deleted.
+func (s *Server) Namespaces() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var namespaces []string
+	for ns := range s.namespaces {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+	return namespaces
+}
+
+func (s *Server) Leases() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.leases)
+}
+
+// FailApply makes layers fail to apply with msg.
+func (s *Server) FailApply(msg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failApply = msg
+}
+
+// DropImages makes images created succeed without being kept.
+func (s *Server) DropImages() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dropImages = true
+}
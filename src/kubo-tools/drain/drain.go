@@ -0,0 +1,246 @@
+// Package drain takes a Kubernetes worker out of service before BOSH stops
+// its kubelet: it cordons the node, evicts its pods through the Eviction API
+// so that PodDisruptionBudgets are honoured, and deletes the node.
+package drain
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"kubo-tools/kube"
+)
+
+// Drainer drains nodes. The backoff and poll intervals default to values
+// suitable for a real cluster; tests shorten them.
+type Drainer struct {
+	Client   *kube.Client
+	Options  Options
+	Progress *Progress
+	Host     Host
+
+	// MinBackoff and MaxBackoff bound the wait between evictions that a
+	// PodDisruptionBudget refused. The wait doubles after each refusal.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// PollInterval is the wait between checks that evicted pods are gone
+	// and that volumes have detached.
+	PollInterval time.Duration
+	// RetryInterval is the wait between attempts of a failed API call.
+	RetryInterval time.Duration
+	// Workers is how many pods ForceKill deletes at a time.
+	Workers int
+}
+
+func New(client *kube.Client, opts Options, progress *Progress) *Drainer {
+	return &Drainer{
+		Client:        client,
+		Options:       opts,
+		Progress:      progress,
+		Host:          DefaultHost,
+		MinBackoff:    time.Second,
+		MaxBackoff:    32 * time.Second,
+		PollInterval:  2 * time.Second,
+		RetryInterval: time.Second,
+		Workers:       10,
+	}
+}
+
+// retryAttempts is how often Retry calls a failing function before giving
+// up, as the drain script did.
+const retryAttempts = 10
+
+// Retry calls f until it succeeds, at most retryAttempts times, logging
+// every failure.
+func (d *Drainer) Retry(phase, node string, f func() error) error {
+	var err error
+	for attempt := 1; attempt <= retryAttempts; attempt++ {
+		if err = f(); err == nil {
+			return nil
+		}
+		d.Progress.Log(Event{Phase: phase, Node: node, Attempt: attempt, Message: "failed, retrying", Error: err.Error()})
+		if attempt < retryAttempts {
+			time.Sleep(d.RetryInterval)
+		}
+	}
+	return fmt.Errorf("%s: failed %d attempts: %v", phase, retryAttempts, err)
+}
+
+// Nodes returns the names of the nodes matching the label selector.
+func (d *Drainer) Nodes(selector string) ([]string, error) {
+	nodes, err := d.Client.ListNodes(selector)
+	names := make([]string, len(nodes))
+	for i, n := range nodes {
+		names[i] = n.Name
+	}
+	return names, err
+}
+
+// Cordon marks the node unschedulable.
+func (d *Drainer) Cordon(node string) error {
+	err := d.Client.PatchNode(node, map[string]interface{}{
+		"spec": map[string]interface{}{"unschedulable": true},
+	})
+	if err == nil {
+		d.Progress.Log(Event{Phase: "cordon", Node: node, Message: "cordoned"})
+	}
+	return err
+}
+
+// decisions lists the pods on the node and decides what to do with each.
+func (d *Drainer) decisions(node string) ([]Decision, error) {
+	pods, err := d.Client.ListPods("", "spec.nodeName="+node)
+	if err != nil {
+		return nil, err
+	}
+	return Decide(pods, d.Options), nil
+}
+
+// Drain evicts the pods on the node and waits until they are gone, or
+// until the timeout in the options expires. Nothing is evicted if a pod is
+// refused by the options.
+func (d *Drainer) Drain(node string) error {
+	decisions, err := d.decisions(node)
+	if err != nil {
+		return err
+	}
+	if err := refusal(decisions); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	if d.Options.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.Options.Timeout)
+		defer cancel()
+	}
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed []string
+	)
+	for _, decision := range decisions {
+		if decision.Action == Skip {
+			d.Progress.Log(Event{Phase: "evict", Node: node, Pod: decision.Pod.Key(), Message: "skipped: " + decision.Reason})
+			continue
+		}
+		wg.Add(1)
+		go func(decision Decision) {
+			defer wg.Done()
+			pod := decision.Pod
+			message := "evicting"
+			if decision.Reason != "" {
+				message += ": " + decision.Reason
+			}
+			d.Progress.Log(Event{Phase: "evict", Node: node, Pod: pod.Key(), Message: message})
+
+			err := d.evict(ctx, node, pod)
+			if err == nil {
+				err = d.waitForDeletion(ctx, node, pod)
+			}
+			if err != nil {
+				d.Progress.Log(Event{Phase: "evict", Node: node, Pod: pod.Key(), Message: "not evicted", Error: err.Error()})
+				mu.Lock()
+				failed = append(failed, pod.Key())
+				mu.Unlock()
+			}
+		}(decision)
+	}
+	wg.Wait()
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to evict %d pods: %s", len(failed), strings.Join(failed, ", "))
+	}
+	d.Progress.Log(Event{Phase: "evict", Node: node, Message: "all pods evicted"})
+	return nil
+}
+
+// evict asks for the pod to be evicted until the API server agrees. While a
+// PodDisruptionBudget refuses, it backs off exponentially and logs the
+// budgets that block the eviction.
+func (d *Drainer) evict(ctx context.Context, node string, pod kube.Pod) error {
+	backoff := d.MinBackoff
+	for attempt := 1; ; attempt++ {
+		err := d.Client.EvictPod(pod.Namespace, pod.Name, d.Options.gracePeriod())
+		switch {
+		case err == nil, kube.IsNotFound(err):
+			return nil
+		case !kube.IsTooManyRequests(err):
+			return err
+		}
+
+		d.Progress.Log(Event{
+			Phase:   "evict",
+			Node:    node,
+			Pod:     pod.Key(),
+			Attempt: attempt,
+			Budgets: d.blockingBudgets(pod),
+			Message: fmt.Sprintf("eviction refused, retrying in %s", backoff),
+			Error:   err.Error(),
+		})
+		if err := sleep(ctx, backoff); err != nil {
+			return fmt.Errorf("giving up after %d attempts: %v", attempt, err)
+		}
+		if backoff *= 2; backoff > d.MaxBackoff {
+			backoff = d.MaxBackoff
+		}
+	}
+}
+
+// blockingBudgets names the budgets that block the pod's eviction. Listing
+// them is only for the log, so a failure is not an error.
+func (d *Drainer) blockingBudgets(pod kube.Pod) []string {
+	budgets, err := d.Client.ListPodDisruptionBudgets()
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, b := range BlockingBudgets(pod, budgets) {
+		names = append(names, b.Namespace+"/"+b.Name)
+	}
+	return names
+}
+
+// waitForDeletion polls until the pod is gone, or has been replaced by a
+// pod of the same name. The pod is looked up by listing, as the drain user
+// may not get pods.
+func (d *Drainer) waitForDeletion(ctx context.Context, node string, pod kube.Pod) error {
+	for {
+		pods, err := d.Client.ListPods(pod.Namespace, "metadata.name="+pod.Name)
+		if err != nil {
+			return err
+		}
+		if len(pods) == 0 || pods[0].UID != pod.UID {
+			d.Progress.Log(Event{Phase: "evict", Node: node, Pod: pod.Key(), Message: "evicted"})
+			return nil
+		}
+		if err := sleep(ctx, d.PollInterval); err != nil {
+			return fmt.Errorf("waiting for deletion: %v", err)
+		}
+	}
+}
+
+// DeleteNode removes the node object. A node that is already gone counts as
+// deleted.
+func (d *Drainer) DeleteNode(node string) error {
+	err := d.Client.DeleteNode(node)
+	if err != nil && !kube.IsNotFound(err) {
+		return err
+	}
+	d.Progress.Log(Event{Phase: "delete-node", Node: node, Message: "deleted"})
+	return nil
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,182 @@
+package drain_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"kubo-tools/drain"
+	"kubo-tools/kube"
+	"kubo-tools/kube/kubetest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// events decodes the progress log.
+func events(log *bytes.Buffer) []drain.Event {
+	var events []drain.Event
+	scanner := bufio.NewScanner(bytes.NewReader(log.Bytes()))
+	for scanner.Scan() {
+		var e drain.Event
+		ExpectWithOffset(1, json.Unmarshal(scanner.Bytes(), &e)).To(Succeed(), "progress line %q", scanner.Text())
+		events = append(events, e)
+	}
+	return events
+}
+
+var _ = Describe("Drainer", func() {
+	var (
+		server *kubetest.Server
+		tmpDir string
+		log    *bytes.Buffer
+		d      *drain.Drainer
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "drain")
+		Expect(err).NotTo(HaveOccurred())
+
+		server = kubetest.NewServer()
+		client, err := kube.NewClient(server.WriteKubeconfig(tmpDir, "drain-token"))
+		Expect(err).NotTo(HaveOccurred())
+
+		log = &bytes.Buffer{}
+		d = drain.New(client, drain.Options{GracePeriod: 10, IgnoreDaemonSets: true}, drain.NewProgress(log))
+		d.MinBackoff, d.MaxBackoff = time.Millisecond, 4*time.Millisecond
+		d.PollInterval, d.RetryInterval = time.Millisecond, time.Millisecond
+
+		server.Put(kube.Nodes, kube.Node{ObjectMeta: kube.ObjectMeta{Name: "worker-0", Labels: map[string]string{"bosh.id": "abc"}}})
+	})
+
+	AfterEach(func() {
+		server.Close()
+		os.RemoveAll(tmpDir)
+	})
+
+	It("finds the node by label", func() {
+		Expect(d.Nodes("bosh.id=abc")).To(Equal([]string{"worker-0"}))
+		Expect(d.Nodes("bosh.id=other")).To(BeEmpty())
+	})
+
+	It("cordons the node", func() {
+		Expect(d.Cordon("worker-0")).To(Succeed())
+		Expect(server.Get(kube.Nodes, "", "worker-0")).To(HaveKeyWithValue("spec", HaveKeyWithValue("unschedulable", true)))
+	})
+
+	It("evicts the pods on the node and leaves DaemonSet pods and other nodes alone", func() {
+		server.Put(kube.Pods, pod("one", "web", ownedBy("ReplicaSet"), onNode("worker-0")))
+		server.Put(kube.Pods, pod("two", "db", ownedBy("StatefulSet"), onNode("worker-0")))
+		server.Put(kube.Pods, pod("kube-system", "agent", ownedBy("DaemonSet"), onNode("worker-0")))
+		server.Put(kube.Pods, pod("one", "elsewhere", ownedBy("ReplicaSet"), onNode("worker-1")))
+
+		Expect(d.Drain("worker-0")).To(Succeed())
+
+		Expect(server.Get(kube.Pods, "one", "web")).To(BeNil())
+		Expect(server.Get(kube.Pods, "two", "db")).To(BeNil())
+		Expect(server.Get(kube.Pods, "kube-system", "agent")).NotTo(BeNil())
+		Expect(server.Get(kube.Pods, "one", "elsewhere")).NotTo(BeNil())
+		Expect(server.RequestLines()).To(ContainElement("POST /api/v1/namespaces/one/pods/web/eviction"))
+		Expect(server.RequestLines()).NotTo(ContainElement(ContainSubstring("DELETE")))
+
+		Expect(events(log)).To(ContainElement(And(
+			WithTransform(func(e drain.Event) string { return e.Pod }, Equal("kube-system/agent")),
+			WithTransform(func(e drain.Event) string { return e.Message }, HavePrefix("skipped: managed by DaemonSet")),
+		)))
+		Expect(events(log)[len(events(log))-1].Message).To(Equal("all pods evicted"))
+	})
+
+	It("evicts nothing when a pod is refused", func() {
+		server.Put(kube.Pods, pod("one", "web", ownedBy("ReplicaSet"), onNode("worker-0")))
+		server.Put(kube.Pods, pod("one", "bare", onNode("worker-0")))
+
+		err := d.Drain("worker-0")
+		Expect(err).To(MatchError(ContainSubstring("one/bare: not managed by a controller (use kubelet-drain-force)")))
+		Expect(server.Get(kube.Pods, "one", "web")).NotTo(BeNil())
+	})
+
+	Context("when a PodDisruptionBudget blocks an eviction", func() {
+		BeforeEach(func() {
+			server.Put(kube.Pods, pod("one", "web", ownedBy("ReplicaSet"), onNode("worker-0")))
+			pdb := kube.PodDisruptionBudget{ObjectMeta: kube.ObjectMeta{Name: "web-pdb", Namespace: "one"}}
+			pdb.Spec.Selector = &kube.LabelSelector{MatchLabels: map[string]string{"app": "web"}}
+			server.Put(kube.PodDisruptionBudgets, pdb)
+			server.BlockEvictions("one", "web", 3)
+		})
+
+		It("backs off until the budget allows it and logs the budget", func() {
+			Expect(d.Drain("worker-0")).To(Succeed())
+			Expect(server.Get(kube.Pods, "one", "web")).To(BeNil())
+
+			var refused []drain.Event
+			for _, e := range events(log) {
+				if e.Error != "" {
+					refused = append(refused, e)
+				}
+			}
+			Expect(refused).To(HaveLen(3))
+			for i, e := range refused {
+				Expect(e.Attempt).To(Equal(i + 1))
+				Expect(e.Budgets).To(Equal([]string{"one/web-pdb"}))
+				Expect(e.Error).To(ContainSubstring("TooManyRequests"))
+			}
+			Expect(refused[0].Message).To(Equal("eviction refused, retrying in 1ms"))
+			Expect(refused[2].Message).To(Equal("eviction refused, retrying in 4ms"))
+		})
+
+		It("gives up when the timeout expires", func() {
+			server.BlockEvictions("one", "web", 1000000)
+			d.Options.Timeout = 50 * time.Millisecond
+
+			err := d.Drain("worker-0")
+			Expect(err).To(MatchError("failed to evict 1 pods: one/web"))
+			Expect(server.Get(kube.Pods, "one", "web")).NotTo(BeNil())
+		})
+	})
+
+	It("waits until evicted pods are gone", func() {
+		server.KeepEvictedPods()
+		server.Put(kube.Pods, pod("one", "web", ownedBy("ReplicaSet"), onNode("worker-0")))
+
+		done := make(chan error)
+		go func() { done <- d.Drain("worker-0") }()
+
+		Consistently(done, 50*time.Millisecond).ShouldNot(Receive())
+		server.Remove(kube.Pods, "one", "web")
+		Eventually(done).Should(Receive(BeNil()))
+	})
+
+	It("deletes the node, ignoring a node that is already gone", func() {
+		Expect(d.DeleteNode("worker-0")).To(Succeed())
+		Expect(server.Get(kube.Nodes, "", "worker-0")).To(BeNil())
+		Expect(d.DeleteNode("worker-0")).To(Succeed())
+	})
+
+	It("retries failed calls and logs each failure", func() {
+		server.FailRequests("PATCH", "/api/v1/nodes/worker-0", 2)
+
+		Expect(d.Retry("cordon", "worker-0", func() error { return d.Cordon("worker-0") })).To(Succeed())
+		attempts := 0
+		for _, e := range events(log) {
+			if e.Phase == "cordon" && e.Error != "" {
+				attempts++
+			}
+		}
+		Expect(attempts).To(Equal(2))
+	})
+
+	It("gives up retrying after ten attempts", func() {
+		calls := 0
+		err := d.Retry("cordon", "worker-0", func() error {
+			calls++
+			return errors.New("nope")
+		})
+		Expect(err).To(MatchError("cordon: failed 10 attempts: nope"))
+		Expect(calls).To(Equal(10))
+	})
+})
@@ -0,0 +1,151 @@
+package drain
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"kubo-tools/kube"
+)
+
+// Audit records a force kill, so that a post-mortem can tell which pods the
+// drain deleted without waiting for them to terminate.
+type Audit struct {
+	Node     string       `json:"node"`
+	Cause    string       `json:"cause"`
+	Started  time.Time    `json:"started"`
+	Finished time.Time    `json:"finished"`
+	Pods     []AuditedPod `json:"pods"`
+}
+
+// AuditedPod is a pod the drain tried to force delete.
+type AuditedPod struct {
+	Namespace  string    `json:"namespace"`
+	Name       string    `json:"name"`
+	UID        string    `json:"uid"`
+	Controller string    `json:"controller,omitempty"`
+	Phase      string    `json:"phase,omitempty"`
+	Time       time.Time `json:"time"`
+	Deleted    bool      `json:"deleted"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// WriteFile writes the audit as JSON into dir, named after the node and the
+// start time so that earlier audits are kept. It returns the path.
+func (a *Audit) WriteFile(dir string) (string, error) {
+	raw, err := json.MarshalIndent(a, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("drain-force-kill-%s-%s.json", a.Node, a.Started.UTC().Format("20060102T150405Z")))
+	return path, ioutil.WriteFile(path, append(raw, '\n'), 0644)
+}
+
+// ForceKill deletes every pod on the node without a grace period, for when
+// eviction failed and kubelet-drain-force-node is set. Up to Workers pods
+// are deleted at a time. Each deleted pod gets a Warning event, and the
+// returned audit lists every pod whether or not its deletion succeeded.
+func (d *Drainer) ForceKill(node string, cause error) (*Audit, error) {
+	audit := &Audit{Node: node, Cause: cause.Error(), Started: time.Now().UTC()}
+	pods, err := d.Client.ListPods("", "spec.nodeName="+node)
+	if err != nil {
+		return audit, err
+	}
+
+	workers := d.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	queue := make(chan kube.Pod)
+	results := make(chan AuditedPod)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for pod := range queue {
+				results <- d.forceDelete(node, pod)
+			}
+		}()
+	}
+	go func() {
+		for _, pod := range pods {
+			queue <- pod
+		}
+		close(queue)
+		wg.Wait()
+		close(results)
+	}()
+
+	var failed []string
+	for result := range results {
+		audit.Pods = append(audit.Pods, result)
+		if !result.Deleted {
+			failed = append(failed, result.Namespace+"/"+result.Name)
+		}
+	}
+	sort.Slice(audit.Pods, func(i, j int) bool {
+		a, b := audit.Pods[i], audit.Pods[j]
+		return a.Namespace < b.Namespace || a.Namespace == b.Namespace && a.Name < b.Name
+	})
+	audit.Finished = time.Now().UTC()
+
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return audit, fmt.Errorf("failed to force delete %d pods: %s", len(failed), strings.Join(failed, ", "))
+	}
+	return audit, nil
+}
+
+func (d *Drainer) forceDelete(node string, pod kube.Pod) AuditedPod {
+	result := AuditedPod{
+		Namespace: pod.Namespace,
+		Name:      pod.Name,
+		UID:       pod.UID,
+		Phase:     pod.Status.Phase,
+		Time:      time.Now().UTC(),
+	}
+	if controller := kube.ControllerOf(pod.ObjectMeta); controller != nil {
+		result.Controller = controller.Kind + "/" + controller.Name
+	}
+
+	var zero int64
+	err := d.Client.DeletePod(pod.Namespace, pod.Name, &zero)
+	if err != nil && !kube.IsNotFound(err) {
+		result.Error = err.Error()
+		d.Progress.Log(Event{Phase: "force-kill", Node: node, Pod: pod.Key(), Message: "not deleted", Error: err.Error()})
+		return result
+	}
+	result.Deleted = true
+	d.Progress.Log(Event{Phase: "force-kill", Node: node, Pod: pod.Key(), Message: "force deleted"})
+
+	// The event tells the pod's owners why it vanished. It is best effort;
+	// the audit file is the record.
+	now := result.Time.Format(time.RFC3339)
+	event := &kube.Event{
+		ObjectMeta: kube.ObjectMeta{GenerateName: pod.Name + ".", Namespace: pod.Namespace},
+		InvolvedObject: kube.ObjectReference{
+			APIVersion: "v1",
+			Kind:       "Pod",
+			Namespace:  pod.Namespace,
+			Name:       pod.Name,
+			UID:        pod.UID,
+		},
+		Reason:         "ForceDeleted",
+		Message:        fmt.Sprintf("Deleted without a grace period by the drain of node %s, as eviction failed", node),
+		Source:         kube.EventSource{Component: "kubelet-drain", Host: node},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+		Type:           "Warning",
+	}
+	if err := d.Client.CreateEvent(event); err != nil {
+		d.Progress.Log(Event{Phase: "force-kill", Node: node, Pod: pod.Key(), Message: "cannot record event", Error: err.Error()})
+	}
+	return result
+}
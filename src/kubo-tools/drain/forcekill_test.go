@@ -0,0 +1,129 @@
+package drain_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"kubo-tools/drain"
+	"kubo-tools/kube"
+	"kubo-tools/kube/kubetest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ForceKill", func() {
+	var (
+		server *kubetest.Server
+		tmpDir string
+		d      *drain.Drainer
+		cause  = errors.New("failed to evict 1 pods: one/web-0")
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "forcekill")
+		Expect(err).NotTo(HaveOccurred())
+
+		server = kubetest.NewServer()
+		client, err := kube.NewClient(server.WriteKubeconfig(tmpDir, "drain-token"))
+		Expect(err).NotTo(HaveOccurred())
+
+		d = drain.New(client, drain.Options{}, drain.NewProgress(&bytes.Buffer{}))
+		d.Workers = 3
+		for i := 0; i < 8; i++ {
+			server.Put(kube.Pods, pod("one", fmt.Sprintf("web-%d", i), ownedBy("ReplicaSet"), onNode("worker-0")))
+		}
+		server.Put(kube.Pods, pod("kube-system", "agent", ownedBy("DaemonSet"), onNode("worker-0")))
+		server.Put(kube.Pods, pod("one", "elsewhere", onNode("worker-1")))
+	})
+
+	AfterEach(func() {
+		server.Close()
+		os.RemoveAll(tmpDir)
+	})
+
+	It("deletes every pod on the node without a grace period, a few at a time", func() {
+		server.SetLatency(10 * time.Millisecond)
+
+		audit, err := d.ForceKill("worker-0", cause)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(server.List(kube.Pods, "")).To(HaveLen(1))
+		Expect(server.MaxInFlight()).To(BeNumerically(">", 1))
+		Expect(server.MaxInFlight()).To(BeNumerically("<=", 3))
+
+		for _, r := range server.Requests {
+			if r.Method == "DELETE" {
+				Expect(r.Body).To(ContainSubstring(`"gracePeriodSeconds":0`))
+			}
+		}
+
+		Expect(audit.Node).To(Equal("worker-0"))
+		Expect(audit.Cause).To(Equal(cause.Error()))
+		Expect(audit.Pods).To(HaveLen(9))
+		Expect(audit.Pods[0].Namespace + "/" + audit.Pods[0].Name).To(Equal("kube-system/agent"))
+		Expect(audit.Pods[0].Controller).To(Equal("DaemonSet/agent-owner"))
+		Expect(audit.Pods[0].UID).NotTo(BeEmpty())
+		for _, p := range audit.Pods {
+			Expect(p.Deleted).To(BeTrue())
+		}
+	})
+
+	It("records a Warning event for each deleted pod", func() {
+		_, err := d.ForceKill("worker-0", cause)
+		Expect(err).NotTo(HaveOccurred())
+
+		events := server.List(kube.Events, "one")
+		Expect(events).To(HaveLen(8))
+		raw, _ := json.Marshal(events[0])
+		var event kube.Event
+		Expect(json.Unmarshal(raw, &event)).To(Succeed())
+		Expect(event.Type).To(Equal("Warning"))
+		Expect(event.Reason).To(Equal("ForceDeleted"))
+		Expect(event.InvolvedObject.Kind).To(Equal("Pod"))
+		Expect(event.InvolvedObject.Name).To(HavePrefix("web-"))
+		Expect(event.Name).To(HavePrefix(event.InvolvedObject.Name + "."))
+		Expect(event.Source.Component).To(Equal("kubelet-drain"))
+		Expect(event.Message).To(ContainSubstring("drain of node worker-0"))
+	})
+
+	It("audits pods it failed to delete and carries on with the rest", func() {
+		server.FailRequests("DELETE", "/api/v1/namespaces/one/pods/web-3", 1)
+
+		audit, err := d.ForceKill("worker-0", cause)
+		Expect(err).To(MatchError("failed to force delete 1 pods: one/web-3"))
+		Expect(server.List(kube.Pods, "")).To(HaveLen(2))
+
+		var failed []drain.AuditedPod
+		for _, p := range audit.Pods {
+			if !p.Deleted {
+				failed = append(failed, p)
+			}
+		}
+		Expect(failed).To(HaveLen(1))
+		Expect(failed[0].Name).To(Equal("web-3"))
+		Expect(failed[0].Error).To(ContainSubstring("injected failure"))
+	})
+
+	It("writes the audit as JSON named after the node and start time", func() {
+		audit, err := d.ForceKill("worker-0", cause)
+		Expect(err).NotTo(HaveOccurred())
+
+		path, err := audit.WriteFile(tmpDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(filepath.Base(path)).To(MatchRegexp(`^drain-force-kill-worker-0-\d{8}T\d{6}Z\.json$`))
+
+		raw, err := ioutil.ReadFile(path)
+		Expect(err).NotTo(HaveOccurred())
+		var written drain.Audit
+		Expect(json.Unmarshal(raw, &written)).To(Succeed())
+		Expect(written.Pods).To(HaveLen(9))
+		Expect(written.Finished).NotTo(BeTemporally("<", written.Started))
+	})
+})
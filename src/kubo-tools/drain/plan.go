@@ -0,0 +1,141 @@
+package drain
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"kubo-tools/kube"
+)
+
+// Options mirror the kubelet-drain-* job properties, which in turn mirror
+// the flags of kubectl drain.
+type Options struct {
+	// GracePeriod is given to each pod to terminate. If negative, the
+	// pod's own terminationGracePeriodSeconds is used.
+	GracePeriod int
+	// Timeout bounds the whole eviction phase; zero means forever.
+	Timeout time.Duration
+	// Force allows evicting pods that no controller will recreate.
+	Force bool
+	// IgnoreDaemonSets skips DaemonSet pods rather than refusing to drain.
+	IgnoreDaemonSets bool
+	// DeleteLocalData allows evicting pods with emptyDir volumes.
+	DeleteLocalData bool
+}
+
+func (o Options) gracePeriod() *int64 {
+	if o.GracePeriod < 0 {
+		return nil
+	}
+	seconds := int64(o.GracePeriod)
+	return &seconds
+}
+
+// Action is what the drain does with a pod.
+type Action string
+
+const (
+	// Evict pods through the Eviction API.
+	Evict Action = "evict"
+	// Skip pods that draining leaves alone: mirror pods, and DaemonSet
+	// pods when DaemonSets are ignored.
+	Skip Action = "skip"
+	// Refuse to drain because of the pod; the options do not allow
+	// evicting it.
+	Refuse Action = "refuse"
+)
+
+// Decision records what happens to a pod and why.
+type Decision struct {
+	Pod    kube.Pod
+	Action Action
+	Reason string
+}
+
+const mirrorPodAnnotation = "kubernetes.io/config.mirror"
+
+// Decide sorts the pods on a node the way kubectl drain does. Pods that
+// have already finished are always evicted; deleting them loses nothing.
+func Decide(pods []kube.Pod, opts Options) []Decision {
+	decisions := make([]Decision, 0, len(pods))
+	for _, pod := range pods {
+		decisions = append(decisions, decide(pod, opts))
+	}
+	return decisions
+}
+
+func decide(pod kube.Pod, opts Options) Decision {
+	d := Decision{Pod: pod, Action: Evict}
+	if _, ok := pod.Annotations[mirrorPodAnnotation]; ok {
+		d.Action, d.Reason = Skip, "mirror pod"
+		return d
+	}
+	if pod.Status.Phase == "Succeeded" || pod.Status.Phase == "Failed" {
+		d.Reason = "pod has finished"
+		return d
+	}
+
+	controller := kube.ControllerOf(pod.ObjectMeta)
+	var reasons []string
+	switch {
+	case controller != nil && controller.Kind == "DaemonSet":
+		if !opts.IgnoreDaemonSets {
+			d.Action, d.Reason = Refuse, "managed by DaemonSet "+controller.Name+" (use kubelet-drain-ignore-daemonsets)"
+			return d
+		}
+		d.Action, d.Reason = Skip, "managed by DaemonSet "+controller.Name
+		return d
+	case controller == nil && !opts.Force:
+		d.Action, d.Reason = Refuse, "not managed by a controller (use kubelet-drain-force)"
+		return d
+	case controller == nil:
+		reasons = append(reasons, "not managed by a controller")
+	}
+
+	if hasLocalData(pod) {
+		if !opts.DeleteLocalData {
+			d.Action, d.Reason = Refuse, "uses emptyDir local data (use kubelet-drain-delete-local-data)"
+			return d
+		}
+		reasons = append(reasons, "deleting emptyDir local data")
+	}
+	d.Reason = strings.Join(reasons, ", ")
+	return d
+}
+
+func hasLocalData(pod kube.Pod) bool {
+	for _, v := range pod.Spec.Volumes {
+		if v.EmptyDir != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// refusal describes the pods that prevent a drain, one per line, as kubectl
+// drain does before giving up without evicting anything.
+func refusal(decisions []Decision) error {
+	var lines []string
+	for _, d := range decisions {
+		if d.Action == Refuse {
+			lines = append(lines, d.Pod.Key()+": "+d.Reason)
+		}
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return fmt.Errorf("cannot drain %d pods:\n%s", len(lines), strings.Join(lines, "\n"))
+}
+
+// BlockingBudgets returns the budgets that cover pod and currently allow no
+// disruptions, and so would make its eviction fail.
+func BlockingBudgets(pod kube.Pod, budgets []kube.PodDisruptionBudget) []kube.PodDisruptionBudget {
+	var blocking []kube.PodDisruptionBudget
+	for _, b := range budgets {
+		if b.Namespace == pod.Namespace && b.Spec.Selector.Matches(pod.Labels) && b.Status.DisruptionsAllowed < 1 {
+			blocking = append(blocking, b)
+		}
+	}
+	return blocking
+}
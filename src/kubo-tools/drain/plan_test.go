@@ -0,0 +1,95 @@
+package drain_test
+
+import (
+	"kubo-tools/drain"
+	"kubo-tools/kube"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func pod(namespace, name string, mutate ...func(*kube.Pod)) kube.Pod {
+	p := kube.Pod{ObjectMeta: kube.ObjectMeta{Name: name, Namespace: namespace, Labels: map[string]string{"app": name}}}
+	for _, m := range mutate {
+		m(&p)
+	}
+	return p
+}
+
+func ownedBy(kind string) func(*kube.Pod) {
+	yes := true
+	return func(p *kube.Pod) {
+		p.OwnerReferences = []kube.OwnerReference{{Kind: kind, Name: p.Name + "-owner", Controller: &yes}}
+	}
+}
+
+func onNode(node string) func(*kube.Pod) {
+	return func(p *kube.Pod) { p.Spec.NodeName = node }
+}
+
+func withEmptyDir(p *kube.Pod) {
+	p.Spec.Volumes = []kube.Volume{{Name: "scratch", EmptyDir: &struct{}{}}}
+}
+
+func mirror(p *kube.Pod) {
+	p.Annotations = map[string]string{"kubernetes.io/config.mirror": "hash"}
+}
+
+func finished(p *kube.Pod) {
+	p.Status.Phase = "Succeeded"
+}
+
+var _ = Describe("Decide", func() {
+	all := drain.Options{Force: true, IgnoreDaemonSets: true, DeleteLocalData: true}
+	none := drain.Options{}
+
+	cases := []struct {
+		description string
+		pod         kube.Pod
+		opts        drain.Options
+		action      drain.Action
+		reason      string
+	}{
+		{"evicts a ReplicaSet pod", pod("ns", "web", ownedBy("ReplicaSet")), none, drain.Evict, ""},
+		{"skips a mirror pod", pod("kube-system", "static", mirror), none, drain.Skip, "mirror pod"},
+		{"evicts a finished unmanaged pod", pod("ns", "job", finished), none, drain.Evict, "finished"},
+		{"refuses a DaemonSet pod", pod("ns", "agent", ownedBy("DaemonSet")), none, drain.Refuse, "kubelet-drain-ignore-daemonsets"},
+		{"skips a DaemonSet pod when ignoring DaemonSets", pod("ns", "agent", ownedBy("DaemonSet")), all, drain.Skip, "DaemonSet agent-owner"},
+		{"refuses an unmanaged pod", pod("ns", "bare"), none, drain.Refuse, "kubelet-drain-force"},
+		{"evicts an unmanaged pod when forced", pod("ns", "bare"), all, drain.Evict, "not managed by a controller"},
+		{"refuses a pod with local data", pod("ns", "cache", ownedBy("ReplicaSet"), withEmptyDir), none, drain.Refuse, "kubelet-drain-delete-local-data"},
+		{"evicts a pod with local data when allowed", pod("ns", "cache", ownedBy("ReplicaSet"), withEmptyDir), all, drain.Evict, "deleting emptyDir local data"},
+	}
+
+	for _, c := range cases {
+		c := c
+		It(c.description, func() {
+			decisions := drain.Decide([]kube.Pod{c.pod}, c.opts)
+			Expect(decisions).To(HaveLen(1))
+			Expect(decisions[0].Action).To(Equal(c.action))
+			Expect(decisions[0].Reason).To(ContainSubstring(c.reason))
+		})
+	}
+})
+
+var _ = Describe("BlockingBudgets", func() {
+	budget := func(namespace, name, app string, allowed int32) kube.PodDisruptionBudget {
+		b := kube.PodDisruptionBudget{ObjectMeta: kube.ObjectMeta{Name: name, Namespace: namespace}}
+		b.Spec.Selector = &kube.LabelSelector{MatchLabels: map[string]string{"app": app}}
+		b.Status.DisruptionsAllowed = allowed
+		return b
+	}
+
+	It("returns the matching budgets in the pod's namespace that allow no disruption", func() {
+		budgets := []kube.PodDisruptionBudget{
+			budget("ns", "web-pdb", "web", 0),
+			budget("ns", "web-loose", "web", 1),
+			budget("other", "web-pdb", "web", 0),
+			budget("ns", "db-pdb", "db", 0),
+		}
+
+		blocking := drain.BlockingBudgets(pod("ns", "web"), budgets)
+		Expect(blocking).To(HaveLen(1))
+		Expect(blocking[0].Namespace + "/" + blocking[0].Name).To(Equal("ns/web-pdb"))
+	})
+})
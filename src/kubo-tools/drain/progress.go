@@ -0,0 +1,50 @@
+package drain
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// Event is one line of drain progress. Events are written as JSON, one per
+// line, so that drain.stdout.log can be searched and parsed.
+type Event struct {
+	Time    time.Time `json:"time"`
+	Phase   string    `json:"phase"`
+	Node    string    `json:"node,omitempty"`
+	Pod     string    `json:"pod,omitempty"`
+	Attempt int       `json:"attempt,omitempty"`
+	// Budgets names the PodDisruptionBudgets blocking an eviction.
+	Budgets []string `json:"budgets,omitempty"`
+	// Device, Volume and Pods describe a volume that is attached.
+	Device  string   `json:"device,omitempty"`
+	Volume  string   `json:"volume,omitempty"`
+	Pods    []string `json:"pods,omitempty"`
+	Message string   `json:"message"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// Progress writes events to a log. It is safe for concurrent use.
+type Progress struct {
+	mu  sync.Mutex
+	out io.Writer
+	now func() time.Time
+}
+
+func NewProgress(out io.Writer) *Progress {
+	return &Progress{out: out, now: time.Now}
+}
+
+// Log writes e, stamped with the current time.
+func (p *Progress) Log(e Event) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e.Time = p.now().UTC()
+	json.NewEncoder(p.out).Encode(e)
+}
+
+// Failed logs err for phase.
+func (p *Progress) Failed(phase, node, message string, err error) {
+	p.Log(Event{Phase: phase, Node: node, Message: message, Error: err.Error()})
+}
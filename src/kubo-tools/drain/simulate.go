@@ -0,0 +1,189 @@
+package drain
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"kubo-tools/kube"
+)
+
+// defaultGracePeriod is the terminationGracePeriodSeconds of pods that do
+// not set one.
+const defaultGracePeriod = 30 * time.Second
+
+// Plan is what a drain of a node would do, worked out without changing
+// anything.
+type Plan struct {
+	Node      string
+	Options   Options
+	ForceNode bool
+	Decisions []Decision
+	Budgets   []BudgetImpact
+}
+
+// BudgetImpact is a PodDisruptionBudget that covers pods the drain would
+// evict.
+type BudgetImpact struct {
+	Budget kube.PodDisruptionBudget
+	Pods   []string
+}
+
+// Blocking reports whether the budget allows no disruption at all, so that
+// evicting its pods waits until other pods become healthy.
+func (b BudgetImpact) Blocking() bool {
+	return b.Budget.Status.DisruptionsAllowed < 1
+}
+
+// rounds is how many rounds of evictions the budget lets through, as it
+// allows only so many of its pods to be disrupted at a time.
+func (b BudgetImpact) rounds() int {
+	allowed := int(b.Budget.Status.DisruptionsAllowed)
+	return (len(b.Pods) + allowed - 1) / allowed
+}
+
+// Simulate works out what draining the node would do: which pods are
+// evicted, which are left alone, and which budgets hold up the evictions.
+// It only reads from the API server.
+func (d *Drainer) Simulate(node string, forceNode bool) (*Plan, error) {
+	decisions, err := d.decisions(node)
+	if err != nil {
+		return nil, err
+	}
+	budgets, err := d.Client.ListPodDisruptionBudgets()
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &Plan{Node: node, Options: d.Options, ForceNode: forceNode, Decisions: decisions}
+	sort.Slice(plan.Decisions, func(i, j int) bool { return plan.Decisions[i].Pod.Key() < plan.Decisions[j].Pod.Key() })
+	for _, b := range budgets {
+		impact := BudgetImpact{Budget: b}
+		for _, decision := range plan.pods(Evict) {
+			if b.Namespace == decision.Pod.Namespace && b.Spec.Selector.Matches(decision.Pod.Labels) {
+				impact.Pods = append(impact.Pods, decision.Pod.Key())
+			}
+		}
+		if len(impact.Pods) > 0 {
+			plan.Budgets = append(plan.Budgets, impact)
+		}
+	}
+	sort.Slice(plan.Budgets, func(i, j int) bool {
+		return plan.Budgets[i].Budget.Namespace+"/"+plan.Budgets[i].Budget.Name < plan.Budgets[j].Budget.Namespace+"/"+plan.Budgets[j].Budget.Name
+	})
+	return plan, nil
+}
+
+func (p *Plan) pods(action Action) []Decision {
+	var matching []Decision
+	for _, d := range p.Decisions {
+		if d.Action == action {
+			matching = append(matching, d)
+		}
+	}
+	return matching
+}
+
+// gracePeriod is how long an evicted pod may take to terminate.
+func (p *Plan) gracePeriod(pod kube.Pod) time.Duration {
+	if p.Options.GracePeriod >= 0 {
+		return time.Duration(p.Options.GracePeriod) * time.Second
+	}
+	if pod.Spec.TerminationGracePeriodSeconds != nil {
+		return time.Duration(*pod.Spec.TerminationGracePeriodSeconds) * time.Second
+	}
+	return defaultGracePeriod
+}
+
+// Estimate describes how long the evictions would take. Pods are evicted
+// at the same time, so the wait is at least the longest grace period. A
+// budget that lets n of its pods go at a time makes its pods go in rounds.
+// A budget that allows no disruption blocks until its pods are healthy
+// elsewhere, which no plan can predict; the wait is then bounded only by
+// the timeout.
+func (p *Plan) Estimate() string {
+	if len(p.pods(Refuse)) > 0 {
+		if p.ForceNode {
+			return "none, the pods would be force deleted without a grace period"
+		}
+		return "none, the drain would fail without evicting anything"
+	}
+	if len(p.pods(Evict)) == 0 {
+		return "none, there are no pods to evict"
+	}
+
+	var longest time.Duration
+	for _, d := range p.pods(Evict) {
+		if g := p.gracePeriod(d.Pod); g > longest {
+			longest = g
+		}
+	}
+	estimate := longest
+	because := "the longest grace period"
+	var blocking []string
+	for _, b := range p.Budgets {
+		name := b.Budget.Namespace + "/" + b.Budget.Name
+		if b.Blocking() {
+			blocking = append(blocking, name)
+			continue
+		}
+		if rounds := b.rounds(); rounds > 1 && time.Duration(rounds)*longest > estimate {
+			estimate = time.Duration(rounds) * longest
+			because = fmt.Sprintf("%d rounds of evictions allowed by %s", rounds, name)
+		}
+	}
+
+	summary := fmt.Sprintf("at least %s (%s)", estimate, because)
+	if len(blocking) > 0 {
+		limit := "indefinitely"
+		if p.Options.Timeout > 0 {
+			limit = "up to the " + p.Options.Timeout.String() + " timeout"
+		}
+		summary = fmt.Sprintf("blocked until %s allow a disruption, %s", strings.Join(blocking, ", "), limit)
+	}
+	return summary
+}
+
+// Write prints the plan for a person to read.
+func (p *Plan) Write(out io.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Drain plan for node %s (dry run, nothing was changed)\n", p.Node)
+
+	section := func(title string, decisions []Decision) {
+		if len(decisions) == 0 {
+			return
+		}
+		fmt.Fprintf(w, "\n%s (%d):\n", title, len(decisions))
+		for _, d := range decisions {
+			fmt.Fprintf(w, "  %s\t%s\n", d.Pod.Key(), d.Reason)
+		}
+	}
+	section("Pods that stop the drain", p.pods(Refuse))
+	section("Pods to evict", p.pods(Evict))
+	section("Pods left alone", p.pods(Skip))
+
+	if len(p.Budgets) > 0 {
+		fmt.Fprintf(w, "\nPodDisruptionBudgets (%d):\n", len(p.Budgets))
+		for _, b := range p.Budgets {
+			state := fmt.Sprintf("%d disruptions allowed", b.Budget.Status.DisruptionsAllowed)
+			if b.Blocking() {
+				state = "BLOCKING, " + state
+			}
+			fmt.Fprintf(w, "  %s/%s\t%s\tcovers %s\n", b.Budget.Namespace, b.Budget.Name, state, strings.Join(b.Pods, ", "))
+		}
+	}
+
+	fmt.Fprintln(w)
+	if refused := p.pods(Refuse); len(refused) > 0 {
+		if p.ForceNode {
+			fmt.Fprintf(w, "The eviction would fail, then all %d pods on the node would be force deleted (kubelet-drain-force-node).\n", len(p.Decisions))
+		} else {
+			fmt.Fprintf(w, "The drain would fail: %d pods are not allowed to be evicted.\n", len(refused))
+		}
+	}
+	fmt.Fprintf(w, "Estimated wait: %s\n", p.Estimate())
+	return w.Flush()
+}
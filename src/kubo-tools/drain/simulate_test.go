@@ -0,0 +1,147 @@
+package drain_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"kubo-tools/drain"
+	"kubo-tools/kube"
+	"kubo-tools/kube/kubetest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Simulate", func() {
+	var (
+		server *kubetest.Server
+		tmpDir string
+		d      *drain.Drainer
+	)
+
+	budget := func(namespace, name, app string, allowed int32) kube.PodDisruptionBudget {
+		b := kube.PodDisruptionBudget{ObjectMeta: kube.ObjectMeta{Name: name, Namespace: namespace}}
+		b.Spec.Selector = &kube.LabelSelector{MatchLabels: map[string]string{"app": app}}
+		b.Status.DisruptionsAllowed = allowed
+		return b
+	}
+
+	withGracePeriod := func(seconds int64) func(*kube.Pod) {
+		return func(p *kube.Pod) { p.Spec.TerminationGracePeriodSeconds = &seconds }
+	}
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "simulate")
+		Expect(err).NotTo(HaveOccurred())
+
+		server = kubetest.NewServer()
+		client, err := kube.NewClient(server.WriteKubeconfig(tmpDir, "drain-token"))
+		Expect(err).NotTo(HaveOccurred())
+		d = drain.New(client, drain.Options{GracePeriod: -1, IgnoreDaemonSets: true}, drain.NewProgress(&bytes.Buffer{}))
+
+		server.Put(kube.Pods, pod("web", "api-1", ownedBy("ReplicaSet"), onNode("worker-0"), withGracePeriod(45)))
+		server.Put(kube.Pods, pod("db", "postgres-0", ownedBy("StatefulSet"), onNode("worker-0")))
+		server.Put(kube.Pods, pod("kube-system", "fluentd", ownedBy("DaemonSet"), onNode("worker-0")))
+		server.Put(kube.Pods, pod("web", "api-2", ownedBy("ReplicaSet"), onNode("worker-1")))
+	})
+
+	AfterEach(func() {
+		server.Close()
+		os.RemoveAll(tmpDir)
+	})
+
+	It("prints the pods to evict and the pods left alone without changing anything", func() {
+		plan, err := d.Simulate("worker-0", false)
+		Expect(err).NotTo(HaveOccurred())
+
+		out := &bytes.Buffer{}
+		Expect(plan.Write(out)).To(Succeed())
+		Expect(out.String()).To(ContainSubstring("Drain plan for node worker-0 (dry run, nothing was changed)"))
+		Expect(out.String()).To(MatchRegexp(`Pods to evict \(2\):\n  db/postgres-0\s*\n  web/api-1\s*\n`))
+		Expect(out.String()).To(MatchRegexp(`Pods left alone \(1\):\n  kube-system/fluentd  managed by DaemonSet fluentd-owner\n`))
+		Expect(out.String()).NotTo(ContainSubstring("stop the drain"))
+		Expect(out.String()).NotTo(ContainSubstring("api-2"))
+		Expect(out.String()).To(ContainSubstring("Estimated wait: at least 45s (the longest grace period)\n"))
+
+		for _, line := range server.RequestLines() {
+			Expect(line).To(HavePrefix("GET "))
+		}
+	})
+
+	It("uses the grace period of the options over that of the pods", func() {
+		d.Options.GracePeriod = 10
+		plan, err := d.Simulate("worker-0", false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(plan.Estimate()).To(Equal("at least 10s (the longest grace period)"))
+	})
+
+	It("lists the budgets covering evicted pods and the rounds they allow", func() {
+		server.Put(kube.Pods, pod("web", "api-3", ownedBy("ReplicaSet"), onNode("worker-0"), func(p *kube.Pod) { p.Labels["app"] = "api-1" }))
+		server.Put(kube.PodDisruptionBudgets, budget("web", "api", "api-1", 1))
+		server.Put(kube.PodDisruptionBudgets, budget("kube-system", "fluentd", "fluentd", 0))
+
+		plan, err := d.Simulate("worker-0", false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(plan.Budgets).To(HaveLen(1))
+		Expect(plan.Budgets[0].Pods).To(Equal([]string{"web/api-1", "web/api-3"}))
+		Expect(plan.Budgets[0].Blocking()).To(BeFalse())
+		Expect(plan.Estimate()).To(Equal("at least 1m30s (2 rounds of evictions allowed by web/api)"))
+
+		out := &bytes.Buffer{}
+		Expect(plan.Write(out)).To(Succeed())
+		Expect(out.String()).To(ContainSubstring("PodDisruptionBudgets (1):\n  web/api  1 disruptions allowed  covers web/api-1, web/api-3\n"))
+	})
+
+	Context("when a budget allows no disruption", func() {
+		BeforeEach(func() {
+			server.Put(kube.PodDisruptionBudgets, budget("db", "postgres", "postgres-0", 0))
+		})
+
+		It("marks it as blocking and waits indefinitely", func() {
+			plan, err := d.Simulate("worker-0", false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(plan.Estimate()).To(Equal("blocked until db/postgres allow a disruption, indefinitely"))
+
+			out := &bytes.Buffer{}
+			Expect(plan.Write(out)).To(Succeed())
+			Expect(out.String()).To(ContainSubstring("db/postgres  BLOCKING, 0 disruptions allowed  covers db/postgres-0"))
+		})
+
+		It("waits up to the timeout", func() {
+			d.Options.Timeout = 5 * time.Minute
+			plan, err := d.Simulate("worker-0", false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(plan.Estimate()).To(Equal("blocked until db/postgres allow a disruption, up to the 5m0s timeout"))
+		})
+	})
+
+	Context("when a pod would be refused", func() {
+		BeforeEach(func() {
+			server.Put(kube.Pods, pod("web", "bare", onNode("worker-0")))
+		})
+
+		It("says the drain would fail", func() {
+			plan, err := d.Simulate("worker-0", false)
+			Expect(err).NotTo(HaveOccurred())
+
+			out := &bytes.Buffer{}
+			Expect(plan.Write(out)).To(Succeed())
+			Expect(out.String()).To(ContainSubstring("Pods that stop the drain (1):\n  web/bare  not managed by a controller (use kubelet-drain-force)\n"))
+			Expect(out.String()).To(ContainSubstring("The drain would fail: 1 pods are not allowed to be evicted.\n"))
+			Expect(out.String()).To(ContainSubstring("Estimated wait: none, the drain would fail without evicting anything\n"))
+		})
+
+		It("says every pod would be force deleted with force-node", func() {
+			plan, err := d.Simulate("worker-0", true)
+			Expect(err).NotTo(HaveOccurred())
+
+			out := &bytes.Buffer{}
+			Expect(plan.Write(out)).To(Succeed())
+			Expect(out.String()).To(ContainSubstring("then all 4 pods on the node would be force deleted (kubelet-drain-force-node)"))
+			Expect(out.String()).To(ContainSubstring("Estimated wait: none, the pods would be force deleted without a grace period\n"))
+		})
+	})
+})
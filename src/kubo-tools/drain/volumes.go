@@ -0,0 +1,283 @@
+package drain
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"kubo-tools/kube"
+)
+
+// Host locates the proc and sys filesystems. Tests point it at a fake tree.
+type Host struct {
+	Proc string
+	Sys  string
+}
+
+var DefaultHost = Host{Proc: "/proc", Sys: "/sys"}
+
+// AttachedVolume is a block device mounted under the kubelet root directory,
+// with what is known about the volume on it and the pods using it.
+type AttachedVolume struct {
+	// Device is the disk, e.g. sdb, even if a partition of it is mounted.
+	Device string
+	// Volume is the name of the PersistentVolume, if a mount path shows it.
+	Volume string
+	// Pods are the pods that mount the volume, as namespace/name, or as
+	// their UID if they were not found on the node.
+	Pods []string
+	// Attachment is the VolumeAttachment for the volume, if there is one.
+	Attachment string
+}
+
+func (v AttachedVolume) String() string {
+	if v.Volume == "" {
+		return v.Device
+	}
+	return v.Device + " (" + v.Volume + ")"
+}
+
+// mount is a line of /proc/<pid>/mountinfo.
+type mount struct {
+	device     string // major:minor
+	mountPoint string
+}
+
+// mounts reads the mount table of init, which is the host's mount
+// namespace.
+func (h Host) mounts() ([]mount, error) {
+	f, err := os.Open(filepath.Join(h.Proc, "1", "mountinfo"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var mounts []mount
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		mounts = append(mounts, mount{device: fields[2], mountPoint: unescapeOctal(fields[4])})
+	}
+	return mounts, scanner.Err()
+}
+
+// unescapeOctal decodes the \ooo escapes mountinfo uses for spaces, tabs,
+// newlines and backslashes in paths.
+func unescapeOctal(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// disk resolves a major:minor device number to the name of its disk, via
+// /sys/dev/block. Partitions resolve to the disk that holds them. Devices
+// that are not block devices, such as the anonymous ones of tmpfs, resolve
+// to "".
+func (h Host) disk(device string) string {
+	path, err := filepath.EvalSymlinks(filepath.Join(h.Sys, "dev", "block", device))
+	if err != nil {
+		return ""
+	}
+	if _, err := os.Stat(filepath.Join(path, "partition")); err == nil {
+		path = filepath.Dir(path)
+	}
+	return filepath.Base(path)
+}
+
+// Attached reports whether the disk is still present in sysfs.
+func (h Host) Attached(disk string) bool {
+	_, err := os.Lstat(filepath.Join(h.Sys, "block", disk))
+	return err == nil
+}
+
+// MountedDisks returns the disks that hold volumes mounted below rootDir,
+// with the mount points of each relative to rootDir. Only the mounts of
+// volume plugins count: other mounts, such as the bind mounts of
+// volume-subpaths, are of disks the node keeps. Disks that back /,
+// /var/vcap/data or rootDir itself, such as those of local volumes, never
+// detach and are left out.
+func (h Host) MountedDisks(rootDir string) (map[string][]string, error) {
+	mounts, err := h.mounts()
+	if err != nil {
+		return nil, err
+	}
+	rootDir = filepath.Clean(rootDir)
+	system := map[string]bool{}
+	for _, m := range mounts {
+		switch m.mountPoint {
+		case "/", "/var/vcap/data", rootDir:
+			if disk := h.disk(m.device); disk != "" {
+				system[disk] = true
+			}
+		}
+	}
+	disks := map[string][]string{}
+	for _, m := range mounts {
+		if !strings.HasPrefix(m.mountPoint, rootDir+"/") {
+			continue
+		}
+		mountPoint := strings.TrimPrefix(m.mountPoint, rootDir+"/")
+		if _, _, ok := volumeOf(mountPoint); !ok {
+			continue
+		}
+		if disk := h.disk(m.device); disk != "" && !system[disk] {
+			disks[disk] = append(disks[disk], mountPoint)
+		}
+	}
+	return disks, nil
+}
+
+// volumeOf reads the PersistentVolume name and pod UID from a mount path
+// relative to the kubelet root directory, and reports whether the path is
+// that of a volume. Pods mount their volumes at
+// pods/<uid>/volumes/kubernetes.io~<plugin>/<pv>, in-tree plugins mount
+// devices at plugins/kubernetes.io/<plugin>/mounts/<device>, and CSI
+// volumes are staged at plugins/kubernetes.io/csi/pv/<pv>/globalmount.
+func volumeOf(mountPoint string) (volume, podUID string, ok bool) {
+	parts := strings.Split(mountPoint, "/")
+	switch {
+	case len(parts) >= 5 && parts[0] == "pods" && parts[2] == "volumes" && strings.HasPrefix(parts[3], "kubernetes.io~"):
+		return parts[4], parts[1], true
+	case len(parts) >= 6 && parts[0] == "plugins" && parts[1] == "kubernetes.io" && parts[2] == "csi" && parts[3] == "pv" && parts[5] == "globalmount":
+		return parts[4], "", true
+	case len(parts) >= 5 && parts[0] == "plugins" && parts[1] == "kubernetes.io" && parts[3] == "mounts":
+		return "", "", true
+	}
+	return "", "", false
+}
+
+// FindVolumes returns the volumes attached to the node: the disks mounted
+// under the kubelet root directory, cross-referenced with the pods on the
+// node and its VolumeAttachments. Call it before evicting the pods, so that
+// pods can be named.
+func (d *Drainer) FindVolumes(node, rootDir string) ([]AttachedVolume, error) {
+	disks, err := d.Host.MountedDisks(rootDir)
+	if err != nil {
+		return nil, err
+	}
+	pods, err := d.Client.ListPods("", "spec.nodeName="+node)
+	if err != nil {
+		return nil, err
+	}
+	podNames := map[string]string{}
+	for _, p := range pods {
+		podNames[p.UID] = p.Key()
+	}
+
+	var volumes []AttachedVolume
+	for disk, mountPoints := range disks {
+		v := AttachedVolume{Device: disk}
+		seen := map[string]bool{}
+		for _, mp := range mountPoints {
+			volume, uid, _ := volumeOf(mp)
+			if volume != "" {
+				v.Volume = volume
+			}
+			if uid != "" && !seen[uid] {
+				seen[uid] = true
+				name, ok := podNames[uid]
+				if !ok {
+					name = uid
+				}
+				v.Pods = append(v.Pods, name)
+			}
+		}
+		sort.Strings(v.Pods)
+		volumes = append(volumes, v)
+	}
+	sort.Slice(volumes, func(i, j int) bool { return volumes[i].Device < volumes[j].Device })
+
+	attachments := d.attachments(node)
+	for i := range volumes {
+		if a, ok := attachments[volumes[i].Volume]; ok && volumes[i].Volume != "" {
+			volumes[i].Attachment = a.Name
+		}
+		d.Progress.Log(volumeEvent(node, volumes[i], "mounted"))
+	}
+	return volumes, nil
+}
+
+// attachments returns the node's VolumeAttachments by PersistentVolume
+// name. They only add detail to the log, so failing to list them is not an
+// error.
+func (d *Drainer) attachments(node string) map[string]kube.VolumeAttachment {
+	list, err := d.Client.ListVolumeAttachments()
+	if err != nil {
+		d.Progress.Failed("volumes", node, "cannot list VolumeAttachments", err)
+		return nil
+	}
+	byVolume := map[string]kube.VolumeAttachment{}
+	for _, a := range list {
+		if a.Spec.NodeName == node && a.Spec.Source.PersistentVolumeName != nil {
+			byVolume[*a.Spec.Source.PersistentVolumeName] = a
+		}
+	}
+	return byVolume
+}
+
+func volumeEvent(node string, v AttachedVolume, message string) Event {
+	return Event{Phase: "volumes", Node: node, Device: v.Device, Volume: v.Volume, Pods: v.Pods, Message: message}
+}
+
+// WaitForDetach polls until every volume's disk has left sysfs. Each round
+// it logs the volumes still attached, with the pods holding them and the
+// state of their VolumeAttachment. It gives up after timeout; zero means
+// wait forever.
+func (d *Drainer) WaitForDetach(node string, volumes []AttachedVolume, timeout time.Duration) error {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	for {
+		var attached []AttachedVolume
+		for _, v := range volumes {
+			if d.Host.Attached(v.Device) {
+				attached = append(attached, v)
+			}
+		}
+		if len(attached) == 0 {
+			d.Progress.Log(Event{Phase: "volumes", Node: node, Message: "all volumes detached"})
+			return nil
+		}
+
+		attachments := d.attachments(node)
+		for _, v := range attached {
+			message := "still attached"
+			if a, ok := attachments[v.Volume]; ok && v.Volume != "" {
+				message += fmt.Sprintf(", VolumeAttachment %s attached=%t", a.Name, a.Status.Attached)
+			} else if v.Attachment != "" {
+				message += ", VolumeAttachment " + v.Attachment + " deleted"
+			}
+			d.Progress.Log(volumeEvent(node, v, message))
+		}
+
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			names := make([]string, len(attached))
+			for i, v := range attached {
+				names[i] = v.String()
+			}
+			return fmt.Errorf("%d volumes still attached after %s: %s", len(attached), timeout, strings.Join(names, ", "))
+		}
+		time.Sleep(d.PollInterval)
+	}
+}
@@ -0,0 +1,194 @@
+package drain_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"kubo-tools/drain"
+	"kubo-tools/kube"
+	"kubo-tools/kube/kubetest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakeHost builds a proc and sys tree with the mount table and block
+// devices of a worker.
+type fakeHost struct {
+	drain.Host
+	mountinfo []string
+}
+
+func newFakeHost(dir string) *fakeHost {
+	h := &fakeHost{Host: drain.Host{Proc: filepath.Join(dir, "proc"), Sys: filepath.Join(dir, "sys")}}
+	for _, d := range []string{"proc/1", "sys/dev/block", "sys/block", "sys/devices/virtual/block"} {
+		Expect(os.MkdirAll(filepath.Join(dir, d), 0755)).To(Succeed())
+	}
+	// The root filesystem and a tmpfs, which is not a block device.
+	h.mount("8:1", "/")
+	h.mount("0:45", "/var/vcap/data/kubelet/pods/uid-web/volumes/kubernetes.io~secret/token")
+	h.addDisk("sda", "8:0")
+	h.addPartition("sda", "sda1", "8:1")
+	return h
+}
+
+func (h *fakeHost) mount(device, mountPoint string) {
+	h.mountinfo = append(h.mountinfo, "36 25 "+device+" / "+strings.Replace(mountPoint, " ", `\040`, -1)+" rw,relatime shared:1 - ext4 /dev/x rw")
+	Expect(ioutil.WriteFile(filepath.Join(h.Proc, "1", "mountinfo"), []byte(strings.Join(h.mountinfo, "\n")+"\n"), 0644)).To(Succeed())
+}
+
+func (h *fakeHost) addDisk(name, device string) {
+	target := filepath.Join(h.Sys, "devices/virtual/block", name)
+	Expect(os.MkdirAll(target, 0755)).To(Succeed())
+	Expect(os.Symlink(target, filepath.Join(h.Sys, "block", name))).To(Succeed())
+	Expect(os.Symlink(target, filepath.Join(h.Sys, "dev/block", device))).To(Succeed())
+}
+
+func (h *fakeHost) addPartition(disk, name, device string) {
+	target := filepath.Join(h.Sys, "devices/virtual/block", disk, name)
+	Expect(os.MkdirAll(target, 0755)).To(Succeed())
+	Expect(ioutil.WriteFile(filepath.Join(target, "partition"), []byte("1\n"), 0644)).To(Succeed())
+	Expect(os.Symlink(target, filepath.Join(h.Sys, "dev/block", device))).To(Succeed())
+}
+
+// detach removes the disk from sysfs, as the kernel does when the cloud
+// detaches it.
+func (h *fakeHost) detach(name string) {
+	Expect(os.Remove(filepath.Join(h.Sys, "block", name))).To(Succeed())
+}
+
+var _ = Describe("volume detach", func() {
+	const rootDir = "/var/vcap/data/kubelet"
+
+	var (
+		server *kubetest.Server
+		tmpDir string
+		log    *bytes.Buffer
+		host   *fakeHost
+		d      *drain.Drainer
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "volumes")
+		Expect(err).NotTo(HaveOccurred())
+
+		server = kubetest.NewServer()
+		client, err := kube.NewClient(server.WriteKubeconfig(tmpDir, "drain-token"))
+		Expect(err).NotTo(HaveOccurred())
+
+		host = newFakeHost(tmpDir)
+		log = &bytes.Buffer{}
+		d = drain.New(client, drain.Options{}, drain.NewProgress(log))
+		d.Host = host.Host
+		d.PollInterval = time.Millisecond
+
+		web := pod("db", "web-0", ownedBy("StatefulSet"), onNode("worker-0"))
+		web.UID = "uid-web"
+		server.Put(kube.Pods, web)
+
+		pvName := "pvc-web"
+		va := kube.VolumeAttachment{ObjectMeta: kube.ObjectMeta{Name: "csi-123"}}
+		va.Spec.NodeName, va.Spec.Source.PersistentVolumeName, va.Status.Attached = "worker-0", &pvName, true
+		server.Put(kube.VolumeAttachments, va)
+
+		// A CSI volume on sdb, staged globally and mounted into web-0.
+		host.addDisk("sdb", "8:16")
+		host.mount("8:16", rootDir+"/plugins/kubernetes.io/csi/pv/pvc-web/globalmount")
+		host.mount("8:16", rootDir+"/pods/uid-web/volumes/kubernetes.io~csi/pvc-web/mount")
+		// An in-tree volume on a partition of sdc, mounted into a pod
+		// that is no longer on the node.
+		host.addDisk("sdc", "8:32")
+		host.addPartition("sdc", "sdc1", "8:33")
+		host.mount("8:33", rootDir+"/plugins/kubernetes.io/vsphere-volume/mounts/[datastore] kubevols/disk.vmdk")
+		host.mount("8:33", rootDir+"/pods/uid-gone/volumes/kubernetes.io~vsphere-volume/pv-logs")
+	})
+
+	AfterEach(func() {
+		server.Close()
+		os.RemoveAll(tmpDir)
+	})
+
+	It("finds the disks mounted under the kubelet root dir with their volumes and pods", func() {
+		volumes, err := d.FindVolumes("worker-0", rootDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(volumes).To(Equal([]drain.AttachedVolume{
+			{Device: "sdb", Volume: "pvc-web", Pods: []string{"db/web-0"}, Attachment: "csi-123"},
+			{Device: "sdc", Volume: "pv-logs", Pods: []string{"uid-gone"}},
+		}))
+	})
+
+	It("ignores a disk mounted at the kubelet root dir itself", func() {
+		host.addDisk("sdd", "8:48")
+		host.mount("8:48", rootDir)
+
+		volumes, err := d.FindVolumes("worker-0", rootDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(volumes).To(HaveLen(2))
+	})
+
+	It("ignores the bind mounts of volume-subpaths from the root disk", func() {
+		host.mount("8:1", rootDir+"/pods/uid-web/volume-subpaths/config/web/0")
+
+		volumes, err := d.FindVolumes("worker-0", rootDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(volumes).To(HaveLen(2))
+	})
+
+	It("ignores local volumes on the ephemeral disk", func() {
+		host.addDisk("sde", "8:64")
+		host.addPartition("sde", "sde1", "8:65")
+		host.mount("8:65", "/var/vcap/data")
+		host.mount("8:65", rootDir+"/pods/uid-web/volumes/kubernetes.io~local-volume/local-pv")
+
+		volumes, err := d.FindVolumes("worker-0", rootDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(volumes).To(HaveLen(2))
+		for _, v := range volumes {
+			Expect(v.Device).NotTo(Equal("sde"))
+		}
+	})
+
+	It("waits until every disk has detached, logging who holds them", func() {
+		volumes, err := d.FindVolumes("worker-0", rootDir)
+		Expect(err).NotTo(HaveOccurred())
+		host.detach("sdc")
+
+		done := make(chan error)
+		go func() { done <- d.WaitForDetach("worker-0", volumes, 0) }()
+		Consistently(done, 50*time.Millisecond).ShouldNot(Receive())
+
+		server.Remove(kube.VolumeAttachments, "", "csi-123")
+		host.detach("sdb")
+		Eventually(done).Should(Receive(BeNil()))
+
+		var waiting []drain.Event
+		for _, e := range events(log) {
+			if strings.HasPrefix(e.Message, "still attached") {
+				waiting = append(waiting, e)
+			}
+		}
+		Expect(waiting).NotTo(BeEmpty())
+		Expect(waiting[0].Device).To(Equal("sdb"))
+		Expect(waiting[0].Volume).To(Equal("pvc-web"))
+		Expect(waiting[0].Pods).To(Equal([]string{"db/web-0"}))
+		Expect(waiting[0].Message).To(Equal("still attached, VolumeAttachment csi-123 attached=true"))
+		for _, e := range waiting {
+			Expect(e.Device).NotTo(Equal("sdc"))
+		}
+		all := events(log)
+		Expect(all[len(all)-1].Message).To(Equal("all volumes detached"))
+	})
+
+	It("gives up after the deadline", func() {
+		volumes, err := d.FindVolumes("worker-0", rootDir)
+		Expect(err).NotTo(HaveOccurred())
+
+		err = d.WaitForDetach("worker-0", volumes, 20*time.Millisecond)
+		Expect(err).To(MatchError("2 volumes still attached after 20ms: sdb (pvc-web), sdc (pv-logs)"))
+	})
+})
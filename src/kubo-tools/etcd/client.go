@@ -0,0 +1,296 @@
+// Package etcd is a small client for the etcd v3 API, through the JSON
+// gateway etcd serves on its client port, so that the tools need neither
+// etcdctl nor the gRPC client. It authenticates with a client certificate,
+// as the API server does.
+package etcd
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is how to reach the cluster.
+type Config struct {
+	Endpoints []string
+	// CACert is the CA of the members' certificates; Cert and Key are the
+	// client certificate, if the members ask for one.
+	CACert string
+	Cert   string
+	Key    string
+}
+
+// Client sends requests to the first endpoint that answers.
+type Client struct {
+	endpoints []string
+	client    *http.Client
+	// prefix is the path of the gateway, which depends on the etcd
+	// version.
+	prefix string
+}
+
+func NewClient(cfg Config) (*Client, error) {
+	if len(cfg.Endpoints) == 0 {
+		return nil, errors.New("no etcd endpoints given")
+	}
+	tlsConfig := &tls.Config{}
+	if cfg.CACert != "" {
+		ca, err := ioutil.ReadFile(cfg.CACert)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CACert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.Cert != "" || cfg.Key != "" {
+		pair, err := tls.LoadX509KeyPair(cfg.Cert, cfg.Key)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{pair}
+	}
+	var endpoints []string
+	for _, e := range cfg.Endpoints {
+		endpoints = append(endpoints, strings.TrimSuffix(e, "/"))
+	}
+	return &Client{
+		endpoints: endpoints,
+		// Snapshots take as long as the database takes to stream, so only
+		// connecting has a timeout.
+		client: &http.Client{Transport: &http.Transport{
+			TLSClientConfig: tlsConfig,
+			DialContext:     (&net.Dialer{Timeout: 10 * time.Second}).DialContext,
+		}},
+	}, nil
+}
+
+// Error is an error etcd answered with.
+type Error struct {
+	Path    string
+	Code    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("etcd %s: %s", e.Path, e.Message)
+}
+
+// Version returns the version of the member that answers, e.g. 3.4.3, and
+// picks the gateway path for it: /v3 from 3.4, /v3beta in 3.3.
+func (c *Client) Version() (string, error) {
+	var v struct {
+		Server string `json:"etcdserver"`
+	}
+	if err := c.do(http.MethodGet, "/version", nil, &v); err != nil {
+		return "", err
+	}
+	c.prefix = "/v3"
+	parts := strings.SplitN(v.Server, ".", 3)
+	if len(parts) >= 2 {
+		minor, _ := strconv.Atoi(parts[1])
+		switch {
+		case parts[0] == "3" && minor == 3:
+			c.prefix = "/v3beta"
+		case parts[0] == "3" && minor < 3:
+			c.prefix = "/v3alpha"
+		}
+	}
+	return v.Server, nil
+}
+
+// Status is the status of the member that answers.
+type Status struct {
+	Version  string
+	Revision int64
+	DBSize   int64
+}
+
+func (c *Client) Status() (*Status, error) {
+	var s struct {
+		Header struct {
+			Revision int64 `json:"revision,string"`
+		} `json:"header"`
+		Version string `json:"version"`
+		DBSize  int64  `json:"db_size,string"`
+	}
+	if err := c.post("/maintenance/status", struct{}{}, &s); err != nil {
+		return nil, err
+	}
+	return &Status{Version: s.Version, Revision: s.Header.Revision, DBSize: s.DBSize}, nil
+}
+
+// Snapshot streams a snapshot of the member's database, ending with its
+// SHA-256, to w and returns its size.
+func (c *Client) Snapshot(w io.Writer) (int64, error) {
+	if err := c.gateway(); err != nil {
+		return 0, err
+	}
+	body, err := c.send(http.MethodPost, c.prefix+"/maintenance/snapshot", struct{}{})
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+	dec := json.NewDecoder(body)
+	var size int64
+	for {
+		var chunk struct {
+			Result *struct {
+				Blob []byte `json:"blob"`
+			} `json:"result"`
+			Error *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		err := dec.Decode(&chunk)
+		if err == io.EOF {
+			return size, nil
+		}
+		if err != nil {
+			return size, fmt.Errorf("reading snapshot: %v", err)
+		}
+		if chunk.Error != nil {
+			return size, &Error{Path: c.prefix + "/maintenance/snapshot", Message: chunk.Error.Message}
+		}
+		if chunk.Result != nil {
+			n, err := w.Write(chunk.Result.Blob)
+			size += int64(n)
+			if err != nil {
+				return size, err
+			}
+		}
+	}
+}
+
+// DeleteAll deletes every key and returns how many there were.
+func (c *Client) DeleteAll() (int64, error) {
+	var r struct {
+		Deleted int64 `json:"deleted,string"`
+	}
+	// A range from the zero byte to the zero byte is every key.
+	err := c.post("/kv/deleterange", map[string]interface{}{"key": []byte{0}, "range_end": []byte{0}}, &r)
+	return r.Deleted, err
+}
+
+// Put is a key to write.
+type Put struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// Maximum operations and bytes of a transaction, within etcd's defaults
+// of 128 operations and 1.5 MiB requests.
+const (
+	maxTxnOps   = 128
+	maxTxnBytes = 1 << 20
+)
+
+// PutAll writes the keys in transactions of as many as etcd takes. Each
+// transaction is atomic but the whole is not: it returns how many keys were
+// written, which are those before the transaction that failed.
+func (c *Client) PutAll(puts []Put) (int, error) {
+	written := 0
+	for len(puts) > 0 {
+		n, size := 0, 0
+		for n < len(puts) && n < maxTxnOps && (n == 0 || size+len(puts[n].Key)+len(puts[n].Value) <= maxTxnBytes) {
+			size += len(puts[n].Key) + len(puts[n].Value)
+			n++
+		}
+		var ops []interface{}
+		for _, p := range puts[:n] {
+			ops = append(ops, map[string]interface{}{"request_put": p})
+		}
+		if err := c.post("/kv/txn", map[string]interface{}{"success": ops}, nil); err != nil {
+			return written, err
+		}
+		written += n
+		puts = puts[n:]
+	}
+	return written, nil
+}
+
+// post sends a request to the gateway.
+func (c *Client) post(path string, req, into interface{}) error {
+	if err := c.gateway(); err != nil {
+		return err
+	}
+	return c.do(http.MethodPost, c.prefix+path, req, into)
+}
+
+// gateway finds the path of the gateway, unless Version already did.
+func (c *Client) gateway() error {
+	if c.prefix != "" {
+		return nil
+	}
+	_, err := c.Version()
+	return err
+}
+
+func (c *Client) do(method, path string, req, into interface{}) error {
+	body, err := c.send(method, path, req)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	if into == nil {
+		return nil
+	}
+	if err := json.NewDecoder(body).Decode(into); err != nil {
+		return fmt.Errorf("etcd %s: decoding response: %v", path, err)
+	}
+	return nil
+}
+
+// send tries each endpoint in turn until one answers, and returns the body
+// of the answer.
+func (c *Client) send(method, path string, req interface{}) (io.ReadCloser, error) {
+	var raw []byte
+	if req != nil {
+		var err error
+		if raw, err = json.Marshal(req); err != nil {
+			return nil, err
+		}
+	}
+	var errs []string
+	for _, endpoint := range c.endpoints {
+		httpReq, err := http.NewRequest(method, endpoint+path, bytes.NewReader(raw))
+		if err != nil {
+			return nil, err
+		}
+		httpReq.Header.Set("Content-Type", "application/json")
+		resp, err := c.client.Do(httpReq)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			defer resp.Body.Close()
+			var status struct {
+				Message string `json:"message"`
+				Error   string `json:"error"`
+			}
+			body, _ := ioutil.ReadAll(resp.Body)
+			if json.Unmarshal(body, &status) != nil || (status.Message == "" && status.Error == "") {
+				status.Message = strings.TrimSpace(string(body))
+			}
+			if status.Message == "" {
+				status.Message = status.Error
+			}
+			return nil, &Error{Path: path, Code: resp.StatusCode, Message: status.Message}
+		}
+		return resp.Body, nil
+	}
+	return nil, fmt.Errorf("etcd %s: no endpoint answered: %s", path, strings.Join(errs, "; "))
+}
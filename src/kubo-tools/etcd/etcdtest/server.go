@@ -0,0 +1,191 @@
+// Package etcdtest provides an in-memory stand-in for the JSON gateway of
+// an etcd member, for testing code that uses package etcd.
+package etcdtest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"kubo-tools/snapshot/snapshottest"
+)
+
+// Server serves the version, status, snapshot, delete range and put
+// transactions, over TLS. Its snapshots are built with snapshottest from
+// the keys it holds.
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	version  string
+	keys     map[string]Value
+	revision int64
+	// Txns counts the transactions received.
+	Txns int
+	// CorruptSnapshots flips a byte of the snapshots served.
+	CorruptSnapshots bool
+	// failTxnsAfter is how many transactions succeed before the rest fail,
+	// if it is not negative.
+	failTxnsAfter int
+}
+
+// Value is the value of a key and the lease it is attached to.
+type Value struct {
+	Value string
+	Lease int64
+}
+
+func NewServer() *Server {
+	s := &Server{version: "3.4.3", keys: map[string]Value{}, revision: 1, failTxnsAfter: -1}
+	s.Server = httptest.NewTLSServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// SetVersion sets the version the member reports, e.g. 3.3.10, which
+// decides the path of the gateway.
+func (s *Server) SetVersion(version string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version = version
+}
+
+// Put stores a key, attached to lease if it is not 0.
+func (s *Server) Put(key, value string, lease int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[key] = Value{Value: value, Lease: lease}
+	s.revision++
+}
+
+// FailTxnsAfter makes the transactions after the next n fail without
+// writing anything, as they would if the member lost its quorum.
+func (s *Server) FailTxnsAfter(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failTxnsAfter = s.Txns + n
+}
+
+// Keys returns a copy of the keys held.
+func (s *Server) Keys() map[string]Value {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := map[string]Value{}
+	for k, v := range s.keys {
+		keys[k] = v
+	}
+	return keys
+}
+
+// WriteCA writes the CA of the server's certificate into dir and returns
+// its path.
+func (s *Server) WriteCA(dir string) string {
+	path := filepath.Join(dir, "etcd-ca.crt")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.Certificate().Raw})
+	if err := ioutil.WriteFile(path, ca, 0600); err != nil {
+		panic(err)
+	}
+	return path
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.URL.Path == "/version" {
+		respond(w, map[string]string{"etcdserver": s.version, "etcdcluster": "3.4.0"})
+		return
+	}
+	prefix := "/v3"
+	switch {
+	case strings.HasPrefix(s.version, "3.3."):
+		prefix = "/v3beta"
+	case strings.HasPrefix(s.version, "3.2."):
+		prefix = "/v3alpha"
+	}
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.URL.Path, prefix+"/") {
+		w.WriteHeader(http.StatusNotFound)
+		respond(w, map[string]interface{}{"error": "Not Found", "code": 5, "message": "Not Found"})
+		return
+	}
+	var req map[string]interface{}
+	json.NewDecoder(r.Body).Decode(&req)
+	header := map[string]string{"revision": itoa(s.revision)}
+
+	switch strings.TrimPrefix(r.URL.Path, prefix) {
+	case "/maintenance/status":
+		respond(w, map[string]interface{}{"header": header, "version": s.version, "db_size": "16384"})
+	case "/maintenance/snapshot":
+		var names []string
+		for k := range s.keys {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		var ops []snapshottest.Op
+		for _, k := range names {
+			ops = append(ops, snapshottest.Op{Key: k, Value: s.keys[k].Value, Lease: s.keys[k].Lease})
+		}
+		raw := snapshottest.Build(ops...)
+		if s.CorruptSnapshots {
+			raw[len(raw)/2] ^= 0xff
+		}
+		// The gateway streams the snapshot in chunks, one JSON object each.
+		for len(raw) > 0 {
+			n := 32 * 1024
+			if n > len(raw) {
+				n = len(raw)
+			}
+			respond(w, map[string]interface{}{"result": map[string]interface{}{"header": header, "remaining_bytes": itoa(int64(len(raw) - n)), "blob": raw[:n]}})
+			raw = raw[n:]
+		}
+	case "/kv/deleterange":
+		key, end := decode(req["key"]), decode(req["range_end"])
+		if key != "\x00" || end != "\x00" {
+			w.WriteHeader(http.StatusBadRequest)
+			respond(w, map[string]interface{}{"error": "only deleting every key is supported", "code": 3, "message": "only deleting every key is supported"})
+			return
+		}
+		deleted := len(s.keys)
+		s.keys = map[string]Value{}
+		s.revision++
+		respond(w, map[string]interface{}{"header": header, "deleted": itoa(int64(deleted))})
+	case "/kv/txn":
+		s.Txns++
+		if s.failTxnsAfter >= 0 && s.Txns > s.failTxnsAfter {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			respond(w, map[string]interface{}{"error": "etcdserver: request timed out", "code": 14, "message": "etcdserver: request timed out"})
+			return
+		}
+		ops, _ := req["success"].([]interface{})
+		for _, op := range ops {
+			put := op.(map[string]interface{})["request_put"].(map[string]interface{})
+			s.keys[decode(put["key"])] = Value{Value: decode(put["value"])}
+		}
+		s.revision++
+		respond(w, map[string]interface{}{"header": header, "succeeded": true})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		respond(w, map[string]interface{}{"error": "Not Found", "code": 5, "message": "Not Found"})
+	}
+}
+
+func decode(v interface{}) string {
+	s, _ := v.(string)
+	raw, _ := base64.StdEncoding.DecodeString(s)
+	return string(raw)
+}
+
+func itoa(i int64) string {
+	return strconv.FormatInt(i, 10)
+}
+
+func respond(w http.ResponseWriter, body interface{}) {
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,203 @@
+// Package grpc is a small gRPC client over cleartext HTTP/2 on a unix
+// socket, enough to call containerd's API without the gRPC module, which
+// the tools do not vendor. Messages are encoded with Message and read with
+// EachField. It also serves methods, for the fakes of the services it
+// calls.
+package grpc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Conn is a connection to a gRPC server. It makes one call at a time, and
+// is not safe for concurrent use.
+type Conn struct {
+	t      *transport
+	nextID uint32
+	// Timeout bounds each call, from the start of the call to its last
+	// message.
+	Timeout time.Duration
+}
+
+// Dial connects to the server listening on socket, given as a path or a
+// unix:// URL.
+func Dial(socket string) (*Conn, error) {
+	conn, err := net.DialTimeout("unix", strings.TrimPrefix(socket, "unix://"), 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	t := newTransport(conn, false)
+	t.w.WriteString(preface)
+	if err := t.writeSettings(settingEnablePush, 0); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &Conn{t: t, nextID: 1, Timeout: 10 * time.Minute}, nil
+}
+
+func (c *Conn) Close() error {
+	return c.t.conn.Close()
+}
+
+// Invoke calls method, e.g. /containerd.services.content.v1.Content/Info,
+// with the request req and the metadata md, and returns the response.
+func (c *Conn) Invoke(method string, md map[string]string, req Message) ([]byte, error) {
+	s, err := c.NewStream(method, md)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Send(req); err != nil {
+		return nil, err
+	}
+	if err := s.CloseSend(); err != nil {
+		return nil, err
+	}
+	resp, err := s.Recv()
+	if err == io.EOF {
+		return nil, &Error{Method: method, Code: Internal, Message: "no response message"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.Recv(); err != io.EOF {
+		if err == nil {
+			err = &Error{Method: method, Code: Internal, Message: "more than one response message"}
+		}
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Stream is a call whose requests and responses are streamed.
+type Stream struct {
+	conn   *Conn
+	method string
+	s      *stream
+}
+
+// NewStream starts a call of method. Only one call may be open on the
+// connection at a time.
+func (c *Conn) NewStream(method string, md map[string]string) (*Stream, error) {
+	if c.Timeout > 0 {
+		c.t.conn.SetDeadline(time.Now().Add(c.Timeout))
+	}
+	fields := []field{
+		{":method", "POST"},
+		{":scheme", "http"},
+		{":path", method},
+		{":authority", "localhost"},
+		{"content-type", "application/grpc"},
+		{"te", "trailers"},
+	}
+	var keys []string
+	for k := range md {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fields = append(fields, field{strings.ToLower(k), md[k]})
+	}
+
+	s := c.t.open(c.nextID)
+	c.nextID += 2
+	if err := c.t.writeHeaders(s.id, fields, false); err != nil {
+		return nil, c.wrap(method, err)
+	}
+	return &Stream{conn: c, method: method, s: s}, nil
+}
+
+// Send sends a request message.
+func (s *Stream) Send(msg Message) error {
+	if err := s.check(); err != nil {
+		return err
+	}
+	return s.sent(s.conn.t.writeData(frameMessage(msg), false))
+}
+
+// CloseSend tells the server that no more requests follow.
+func (s *Stream) CloseSend() error {
+	if err := s.check(); err != nil {
+		return err
+	}
+	return s.sent(s.conn.t.writeData(nil, true))
+}
+
+// sent returns the error of a send, or the status the server ended the
+// call with if it did so before reading every request.
+func (s *Stream) sent(err error) error {
+	if err != nil && s.s.ended {
+		if end := s.end(); end != io.EOF {
+			return end
+		}
+	}
+	return s.conn.wrap(s.method, err)
+}
+
+// Recv returns the next response message, or io.EOF once the call has
+// ended with an OK status. A failed call returns an *Error.
+func (s *Stream) Recv() ([]byte, error) {
+	if err := s.check(); err != nil {
+		return nil, err
+	}
+	for {
+		msg, ok, err := s.s.nextMessage()
+		if err != nil {
+			return nil, &Error{Method: s.method, Code: Internal, Message: err.Error()}
+		}
+		if ok {
+			return msg, nil
+		}
+		if s.s.ended || s.s.reset != nil {
+			return nil, s.end()
+		}
+		if err := s.conn.t.readFrame(); err != nil {
+			return nil, s.conn.wrap(s.method, err)
+		}
+	}
+}
+
+// end returns the status of a call that has ended, io.EOF if it is OK.
+func (s *Stream) end() error {
+	if !s.s.ended {
+		return &Error{Method: s.method, Code: Unavailable, Message: s.s.reset.Error()}
+	}
+	if code := header(s.s.headers, ":status"); code != "200" {
+		return &Error{Method: s.method, Code: Unknown, Message: "HTTP status " + code}
+	}
+	fields := s.s.trailers
+	if fields == nil {
+		fields = s.s.headers
+	}
+	if err := status(s.method, fields); err != nil {
+		return err
+	}
+	if len(s.s.data) > 0 {
+		return &Error{Method: s.method, Code: Internal, Message: "the response ends in a partial message"}
+	}
+	return io.EOF
+}
+
+// check fails if another call was started on the connection since.
+func (s *Stream) check() error {
+	if s.conn.t.stream != s.s {
+		return errors.New(s.method + ": the call is no longer open")
+	}
+	return nil
+}
+
+// wrap names the method a transport error happened in.
+func (c *Conn) wrap(method string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*Error); ok {
+		return err
+	}
+	return &Error{Method: method, Code: Unavailable, Message: fmt.Sprintf("connection failed: %v", err)}
+}
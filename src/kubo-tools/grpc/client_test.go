@@ -0,0 +1,258 @@
+package grpc_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"kubo-tools/grpc"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// echo serves the test methods: Unary answers with the namespace metadata
+// and the request, Stream with the length of each request, Big with a
+// message of the requested size, and Fail and Refuse fail, Refuse without
+// reading the requests.
+func echo(srv *grpc.Server) {
+	srv.Handle("/test.Echo/Unary", func(s *grpc.ServerStream) error {
+		req, err := s.Recv()
+		if err != nil {
+			return err
+		}
+		return s.Send(grpc.Message(nil).Text(1, s.Metadata["containerd-namespace"]).Bytes(2, req))
+	})
+	srv.Handle("/test.Echo/Stream", func(s *grpc.ServerStream) error {
+		for {
+			req, err := s.Recv()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := s.Send(grpc.Message(nil).Varint(1, uint64(len(req)))); err != nil {
+				return err
+			}
+		}
+	})
+	srv.Handle("/test.Echo/Big", func(s *grpc.ServerStream) error {
+		req, err := s.Recv()
+		if err != nil {
+			return err
+		}
+		var size uint64
+		grpc.EachField(req, func(n int, v uint64, _ []byte) { size = v })
+		return s.Send(grpc.Message(nil).Bytes(1, bytes.Repeat([]byte("x"), int(size))))
+	})
+	srv.Handle("/test.Echo/Fail", func(s *grpc.ServerStream) error {
+		return grpc.Errorf(grpc.NotFound, "image %q: 100%% gone", "docker.io/library/x:1")
+	})
+	srv.Handle("/test.Echo/Refuse", func(s *grpc.ServerStream) error {
+		return grpc.Errorf(grpc.ResourceExhausted, "no space left on device")
+	})
+}
+
+var _ = Describe("Conn", func() {
+	var (
+		tmpDir string
+		socket string
+		srv    *grpc.Server
+		conn   *grpc.Conn
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "grpc")
+		Expect(err).NotTo(HaveOccurred())
+		socket = filepath.Join(tmpDir, "grpc.sock")
+		l, err := net.Listen("unix", socket)
+		Expect(err).NotTo(HaveOccurred())
+		srv = grpc.NewServer()
+		echo(srv)
+		go srv.Serve(l)
+
+		conn, err = grpc.Dial("unix://" + socket)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		conn.Close()
+		srv.Close()
+		os.RemoveAll(tmpDir)
+	})
+
+	It("calls a method with metadata", func() {
+		resp, err := conn.Invoke("/test.Echo/Unary", map[string]string{"containerd-namespace": "k8s.io"}, grpc.Message(nil).Text(1, "hello"))
+		Expect(err).NotTo(HaveOccurred())
+
+		fields := map[int]string{}
+		Expect(grpc.EachField(resp, func(n int, _ uint64, b []byte) { fields[n] = string(b) })).To(Succeed())
+		Expect(fields).To(Equal(map[int]string{1: "k8s.io", 2: string(grpc.Message(nil).Text(1, "hello"))}))
+	})
+
+	It("returns the status of a failed call", func() {
+		_, err := conn.Invoke("/test.Echo/Fail", nil, nil)
+		Expect(err).To(MatchError(`/test.Echo/Fail: NotFound: image "docker.io/library/x:1": 100% gone`))
+		Expect(grpc.IsNotFound(err)).To(BeTrue())
+		Expect(grpc.IsAlreadyExists(err)).To(BeFalse())
+	})
+
+	It("says a method is unimplemented", func() {
+		_, err := conn.Invoke("/test.Echo/Missing", nil, nil)
+		Expect(err).To(MatchError("/test.Echo/Missing: Unimplemented: unknown method /test.Echo/Missing"))
+	})
+
+	It("streams messages larger than a frame and the flow control windows", func() {
+		s, err := conn.NewStream("/test.Echo/Stream", nil)
+		Expect(err).NotTo(HaveOccurred())
+		for _, size := range []int{1, 200000, 1 << 20} {
+			Expect(s.Send(grpc.Message(bytes.Repeat([]byte("y"), size)))).To(Succeed())
+		}
+		Expect(s.CloseSend()).To(Succeed())
+
+		var sizes []uint64
+		for {
+			resp, err := s.Recv()
+			if err == io.EOF {
+				break
+			}
+			Expect(err).NotTo(HaveOccurred())
+			grpc.EachField(resp, func(_ int, v uint64, _ []byte) { sizes = append(sizes, v) })
+		}
+		Expect(sizes).To(Equal([]uint64{1, 200000, 1 << 20}))
+	})
+
+	It("receives a message larger than the flow control windows", func() {
+		resp, err := conn.Invoke("/test.Echo/Big", nil, grpc.Message(nil).Varint(1, 3<<20))
+		Expect(err).NotTo(HaveOccurred())
+		var body []byte
+		grpc.EachField(resp, func(_ int, _ uint64, b []byte) { body = b })
+		Expect(body).To(HaveLen(3 << 20))
+	})
+
+	It("returns the status when the server fails before reading the requests, and carries on", func() {
+		s, err := conn.NewStream("/test.Echo/Refuse", nil)
+		Expect(err).NotTo(HaveOccurred())
+		err = s.Send(grpc.Message(bytes.Repeat([]byte("z"), 1<<20)))
+		Expect(err).To(MatchError("/test.Echo/Refuse: ResourceExhausted: no space left on device"))
+
+		_, err = conn.Invoke("/test.Echo/Unary", nil, grpc.Message(nil).Text(1, "again"))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("fails a call whose connection is gone", func() {
+		_, err := conn.Invoke("/test.Echo/Unary", nil, nil)
+		Expect(err).NotTo(HaveOccurred())
+		srv.Close()
+		_, err = conn.Invoke("/test.Echo/Unary", nil, nil)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(HavePrefix("/test.Echo/Unary: Unavailable: connection failed: "))
+	})
+})
+
+var _ = Describe("Conn against a server that compresses its headers", func() {
+	// Responses of RFC 7541, Appendix C.6: Huffman coded, the second
+	// indexing fields the first added to the dynamic table.
+	blocks := []string{
+		"488264025885aec3771a4b6196d07abe941054d444a8200595040b8166e082a62d1bff6e919d29ad171863c78f0b97c8e9ae82ae43d3",
+		"4883640effc1c0bf",
+	}
+
+	It("decodes the headers of each response", func() {
+		tmpDir, err := ioutil.TempDir("", "grpc")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(tmpDir)
+		socket := filepath.Join(tmpDir, "h2.sock")
+		l, err := net.Listen("unix", socket)
+		Expect(err).NotTo(HaveOccurred())
+		defer l.Close()
+
+		go func() {
+			defer GinkgoRecover()
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+			preface := make([]byte, 24)
+			io.ReadFull(c, preface)
+			for _, block := range blocks {
+				// Answer the next HEADERS frame with the block, ending
+				// the stream.
+				for {
+					header := make([]byte, 9)
+					if _, err := io.ReadFull(c, header); err != nil {
+						return
+					}
+					length := int(header[0])<<16 | int(header[1])<<8 | int(header[2])
+					io.CopyN(ioutil.Discard, c, int64(length))
+					if header[3] != 0x1 {
+						continue
+					}
+					payload, _ := hex.DecodeString(block)
+					frame := []byte{byte(len(payload) >> 16), byte(len(payload) >> 8), byte(len(payload)), 0x1, 0x5, 0, 0, 0, 0}
+					binary.BigEndian.PutUint32(frame[5:], binary.BigEndian.Uint32(header[5:]))
+					c.Write(append(frame, payload...))
+					break
+				}
+			}
+			io.Copy(ioutil.Discard, c)
+		}()
+
+		conn, err := grpc.Dial(socket)
+		Expect(err).NotTo(HaveOccurred())
+		defer conn.Close()
+		for _, status := range []string{"302", "307"} {
+			_, err := conn.Invoke("/test.Echo/Unary", nil, nil)
+			Expect(err).To(MatchError("/test.Echo/Unary: Unknown: HTTP status " + status))
+		}
+	})
+})
+
+var _ = Describe("Message", func() {
+	It("encodes fields that EachField reads back", func() {
+		m := grpc.Message(nil).
+			Text(1, "sha256:abc").
+			Varint(2, 300).
+			Varint(3, 0).
+			Embed(4, grpc.Message(nil)).
+			Labels(5, map[string]string{"b": "2", "a": "1"}).
+			Bytes(6, []byte(strings.Repeat("l", 200)))
+
+		var (
+			seen   []int
+			labels = map[string]string{}
+		)
+		err := grpc.EachField(m, func(n int, v uint64, b []byte) {
+			seen = append(seen, n)
+			switch n {
+			case 1:
+				Expect(string(b)).To(Equal("sha256:abc"))
+			case 2:
+				Expect(v).To(Equal(uint64(300)))
+			case 4:
+				Expect(b).To(BeEmpty())
+			case 5:
+				Expect(grpc.ReadLabels(b, labels)).To(Succeed())
+			case 6:
+				Expect(b).To(HaveLen(200))
+			}
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(seen).To(Equal([]int{1, 2, 4, 5, 5, 6}))
+		Expect(labels).To(Equal(map[string]string{"a": "1", "b": "2"}))
+	})
+
+	It("rejects a truncated message", func() {
+		m := grpc.Message(nil).Text(1, "sha256:abc")
+		Expect(grpc.EachField(m[:len(m)-1], func(int, uint64, []byte) {})).To(MatchError("malformed protobuf"))
+	})
+})
@@ -0,0 +1,107 @@
+package grpc
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// Code is a gRPC status code.
+type Code int
+
+const (
+	OK                 Code = 0
+	Canceled           Code = 1
+	Unknown            Code = 2
+	InvalidArgument    Code = 3
+	DeadlineExceeded   Code = 4
+	NotFound           Code = 5
+	AlreadyExists      Code = 6
+	PermissionDenied   Code = 7
+	ResourceExhausted  Code = 8
+	FailedPrecondition Code = 9
+	Aborted            Code = 10
+	OutOfRange         Code = 11
+	Unimplemented      Code = 12
+	Internal           Code = 13
+	Unavailable        Code = 14
+	DataLoss           Code = 15
+	Unauthenticated    Code = 16
+)
+
+var codeNames = []string{
+	"OK", "Canceled", "Unknown", "InvalidArgument", "DeadlineExceeded", "NotFound",
+	"AlreadyExists", "PermissionDenied", "ResourceExhausted", "FailedPrecondition",
+	"Aborted", "OutOfRange", "Unimplemented", "Internal", "Unavailable", "DataLoss",
+	"Unauthenticated",
+}
+
+func (c Code) String() string {
+	if c >= 0 && int(c) < len(codeNames) {
+		return codeNames[c]
+	}
+	return "Code(" + strconv.Itoa(int(c)) + ")"
+}
+
+// Error is the status of a call that failed.
+type Error struct {
+	// Method is the full name of the method called, e.g.
+	// /containerd.services.content.v1.Content/Info.
+	Method  string
+	Code    Code
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s: %s", e.Method, e.Code, e.Message)
+}
+
+// Errorf returns the status a handler fails with.
+func Errorf(code Code, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func IsNotFound(err error) bool {
+	return codeOf(err) == NotFound
+}
+
+func IsAlreadyExists(err error) bool {
+	return codeOf(err) == AlreadyExists
+}
+
+func codeOf(err error) Code {
+	if e, ok := err.(*Error); ok {
+		return e.Code
+	}
+	return Unknown
+}
+
+// status reads the status of a call from its trailers, or from its
+// headers if the response had only those.
+func status(method string, fields []field) error {
+	code, err := strconv.Atoi(header(fields, "grpc-status"))
+	if err != nil {
+		return &Error{Method: method, Code: Unknown, Message: "the response has no grpc-status"}
+	}
+	if code == 0 {
+		return nil
+	}
+	// The message is percent-encoded.
+	msg := header(fields, "grpc-message")
+	if unescaped, err := url.PathUnescape(msg); err == nil {
+		msg = unescaped
+	}
+	return &Error{Method: method, Code: Code(code), Message: msg}
+}
+
+// statusFields are the trailers that report err.
+func statusFields(err error) []field {
+	if err == nil {
+		return []field{{"grpc-status", "0"}}
+	}
+	e, ok := err.(*Error)
+	if !ok {
+		e = &Error{Code: Unknown, Message: err.Error()}
+	}
+	return []field{{"grpc-status", strconv.Itoa(int(e.Code))}, {"grpc-message", url.PathEscape(e.Message)}}
+}
@@ -0,0 +1,263 @@
+package grpc
+
+import (
+	"errors"
+	"fmt"
+)
+
+// field is a header field.
+type field struct {
+	name, value string
+}
+
+// staticTable is the static table of RFC 7541, Appendix A, from index 1.
+var staticTable = []field{
+	{":authority", ""}, {":method", "GET"}, {":method", "POST"}, {":path", "/"},
+	{":path", "/index.html"}, {":scheme", "http"}, {":scheme", "https"}, {":status", "200"},
+	{":status", "204"}, {":status", "206"}, {":status", "304"}, {":status", "400"},
+	{":status", "404"}, {":status", "500"}, {"accept-charset", ""}, {"accept-encoding", "gzip, deflate"},
+	{"accept-language", ""}, {"accept-ranges", ""}, {"accept", ""}, {"access-control-allow-origin", ""},
+	{"age", ""}, {"allow", ""}, {"authorization", ""}, {"cache-control", ""},
+	{"content-disposition", ""}, {"content-encoding", ""}, {"content-language", ""}, {"content-length", ""},
+	{"content-location", ""}, {"content-range", ""}, {"content-type", ""}, {"cookie", ""},
+	{"date", ""}, {"etag", ""}, {"expect", ""}, {"expires", ""},
+	{"from", ""}, {"host", ""}, {"if-match", ""}, {"if-modified-since", ""},
+	{"if-none-match", ""}, {"if-range", ""}, {"if-unmodified-since", ""}, {"last-modified", ""},
+	{"link", ""}, {"location", ""}, {"max-forwards", ""}, {"proxy-authenticate", ""},
+	{"proxy-authorization", ""}, {"range", ""}, {"referer", ""}, {"refresh", ""},
+	{"retry-after", ""}, {"server", ""}, {"set-cookie", ""}, {"strict-transport-security", ""},
+	{"transfer-encoding", ""}, {"user-agent", ""}, {"via", ""}, {"www-authenticate", ""},
+}
+
+// encodeHeaders encodes fields as literals that are not indexed, without
+// Huffman coding, which every decoder accepts and which leaves the peer's
+// dynamic table alone.
+func encodeHeaders(fields []field) []byte {
+	var b []byte
+	for _, f := range fields {
+		b = append(b, 0)
+		b = appendString(b, f.name)
+		b = appendString(b, f.value)
+	}
+	return b
+}
+
+func appendString(b []byte, s string) []byte {
+	b = appendInt(b, 7, 0, uint64(len(s)))
+	return append(b, s...)
+}
+
+// appendInt appends i with an n-bit prefix, the other bits of the first
+// byte being flags.
+func appendInt(b []byte, n uint, flags byte, i uint64) []byte {
+	max := uint64(1)<<n - 1
+	if i < max {
+		return append(b, flags|byte(i))
+	}
+	b = append(b, flags|byte(max))
+	i -= max
+	for i >= 128 {
+		b = append(b, byte(i%128)|0x80)
+		i /= 128
+	}
+	return append(b, byte(i))
+}
+
+// decoder decodes header blocks, keeping the dynamic table between them as
+// the peer's encoder does.
+type decoder struct {
+	dynamic []field // newest first
+	size    int
+	maxSize int
+}
+
+func newDecoder() *decoder {
+	return &decoder{maxSize: 4096}
+}
+
+var errHeaders = errors.New("malformed header block")
+
+func (d *decoder) decode(b []byte) ([]field, error) {
+	var fields []field
+	for len(b) > 0 {
+		var (
+			f     field
+			err   error
+			index = b[0]&0x80 != 0
+			add   = b[0]&0xc0 == 0x40
+		)
+		switch {
+		case index:
+			var i uint64
+			if i, b, err = readInt(b, 7); err != nil {
+				return nil, err
+			}
+			if f, err = d.at(i); err != nil {
+				return nil, err
+			}
+			fields = append(fields, f)
+			continue
+		case b[0]&0xe0 == 0x20:
+			// A dynamic table size update.
+			var size uint64
+			if size, b, err = readInt(b, 5); err != nil {
+				return nil, err
+			}
+			if size > 4096 {
+				return nil, fmt.Errorf("dynamic table size %d is over the 4096 allowed", size)
+			}
+			d.maxSize = int(size)
+			d.evict()
+			continue
+		}
+
+		// A literal, with a 6-bit index if it is to be added to the table,
+		// a 4-bit one otherwise.
+		n := uint(4)
+		if add {
+			n = 6
+		}
+		var i uint64
+		if i, b, err = readInt(b, n); err != nil {
+			return nil, err
+		}
+		if i > 0 {
+			var named field
+			if named, err = d.at(i); err != nil {
+				return nil, err
+			}
+			f.name = named.name
+		} else if f.name, b, err = readString(b); err != nil {
+			return nil, err
+		}
+		if f.value, b, err = readString(b); err != nil {
+			return nil, err
+		}
+		if add {
+			d.add(f)
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+func (d *decoder) at(i uint64) (field, error) {
+	switch {
+	case i == 0:
+		return field{}, errHeaders
+	case i <= uint64(len(staticTable)):
+		return staticTable[i-1], nil
+	case i-uint64(len(staticTable)) <= uint64(len(d.dynamic)):
+		return d.dynamic[i-uint64(len(staticTable))-1], nil
+	}
+	return field{}, fmt.Errorf("header index %d is not in the table", i)
+}
+
+// add adds f to the dynamic table, whose entries count their length and 32
+// bytes towards its size.
+func (d *decoder) add(f field) {
+	d.dynamic = append([]field{f}, d.dynamic...)
+	d.size += len(f.name) + len(f.value) + 32
+	d.evict()
+}
+
+func (d *decoder) evict() {
+	for d.size > d.maxSize && len(d.dynamic) > 0 {
+		f := d.dynamic[len(d.dynamic)-1]
+		d.dynamic = d.dynamic[:len(d.dynamic)-1]
+		d.size -= len(f.name) + len(f.value) + 32
+	}
+}
+
+func readInt(b []byte, n uint) (uint64, []byte, error) {
+	if len(b) == 0 {
+		return 0, nil, errHeaders
+	}
+	max := uint64(1)<<n - 1
+	i := uint64(b[0]) & max
+	b = b[1:]
+	if i < max {
+		return i, b, nil
+	}
+	for shift := uint(0); len(b) > 0; shift += 7 {
+		if shift > 56 {
+			return 0, nil, errHeaders
+		}
+		c := b[0]
+		b = b[1:]
+		i += uint64(c&0x7f) << shift
+		if c&0x80 == 0 {
+			return i, b, nil
+		}
+	}
+	return 0, nil, errHeaders
+}
+
+func readString(b []byte) (string, []byte, error) {
+	if len(b) == 0 {
+		return "", nil, errHeaders
+	}
+	huffman := b[0]&0x80 != 0
+	n, b, err := readInt(b, 7)
+	if err != nil {
+		return "", nil, err
+	}
+	if uint64(len(b)) < n {
+		return "", nil, errHeaders
+	}
+	s, b := b[:n], b[n:]
+	if !huffman {
+		return string(s), b, nil
+	}
+	decoded, err := huffmanDecode(s)
+	return decoded, b, err
+}
+
+// huffmanNode is a node of the tree of the Huffman code; a leaf has no
+// children.
+type huffmanNode struct {
+	children [2]*huffmanNode
+	sym      byte
+}
+
+var huffmanRoot = func() *huffmanNode {
+	root := &huffmanNode{}
+	for sym, code := range huffmanCodes {
+		n := root
+		for bit := int(huffmanCodeLens[sym]) - 1; bit >= 0; bit-- {
+			b := code >> uint(bit) & 1
+			if n.children[b] == nil {
+				n.children[b] = &huffmanNode{}
+			}
+			n = n.children[b]
+		}
+		n.sym = byte(sym)
+	}
+	return root
+}()
+
+// huffmanDecode decodes s, which ends in up to seven bits of the EOS code,
+// all ones.
+func huffmanDecode(s []byte) (string, error) {
+	var out []byte
+	n, depth, ones := huffmanRoot, 0, true
+	for _, c := range s {
+		for bit := 7; bit >= 0; bit-- {
+			b := c >> uint(bit) & 1
+			n = n.children[b]
+			if n == nil {
+				return "", errHeaders
+			}
+			depth++
+			ones = ones && b == 1
+			if n.children[0] == nil && n.children[1] == nil {
+				out = append(out, n.sym)
+				n, depth, ones = huffmanRoot, 0, true
+			}
+		}
+	}
+	if depth > 7 || !ones {
+		return "", errHeaders
+	}
+	return string(out), nil
+}
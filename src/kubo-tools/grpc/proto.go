@@ -0,0 +1,118 @@
+package grpc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Message is an encoded protobuf message, built by appending its fields.
+// As in proto3, fields left at their zero value are not written.
+type Message []byte
+
+func (m Message) tag(n int, wireType uint64) Message {
+	return appendUvarint(m, uint64(n)<<3|wireType)
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+// Varint appends field n, an integer, enum or boolean.
+func (m Message) Varint(n int, v uint64) Message {
+	if v == 0 {
+		return m
+	}
+	return appendUvarint(m.tag(n, 0), v)
+}
+
+// Bytes appends field n, a string or bytes.
+func (m Message) Bytes(n int, b []byte) Message {
+	if len(b) == 0 {
+		return m
+	}
+	return m.Embed(n, b)
+}
+
+// Text appends field n, a string.
+func (m Message) Text(n int, s string) Message {
+	return m.Bytes(n, []byte(s))
+}
+
+// Embed appends field n, a message, even if it is empty.
+func (m Message) Embed(n int, sub Message) Message {
+	m = appendUvarint(m.tag(n, 2), uint64(len(sub)))
+	return append(m, sub...)
+}
+
+// Labels appends field n, a map<string, string>, in key order.
+func (m Message) Labels(n int, labels map[string]string) Message {
+	var keys []string
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		m = m.Embed(n, Message(nil).Text(1, k).Text(2, labels[k]))
+	}
+	return m
+}
+
+// EachField calls fn with every field of the encoded message raw: its
+// number and, for a varint its value, for a length-delimited field its
+// bytes. Fixed-size fields are skipped.
+func EachField(raw []byte, fn func(n int, v uint64, b []byte)) error {
+	for len(raw) > 0 {
+		tag, n := binary.Uvarint(raw)
+		if n <= 0 {
+			return errors.New("malformed protobuf")
+		}
+		raw = raw[n:]
+		field, wireType := int(tag>>3), tag&7
+		switch wireType {
+		case 0:
+			v, n := binary.Uvarint(raw)
+			if n <= 0 {
+				return errors.New("malformed protobuf")
+			}
+			raw = raw[n:]
+			fn(field, v, nil)
+		case 1, 5:
+			size := 8
+			if wireType == 5 {
+				size = 4
+			}
+			if len(raw) < size {
+				return errors.New("malformed protobuf")
+			}
+			raw = raw[size:]
+		case 2:
+			l, n := binary.Uvarint(raw)
+			if n <= 0 || uint64(len(raw)-n) < l {
+				return errors.New("malformed protobuf")
+			}
+			fn(field, 0, raw[n:n+int(l)])
+			raw = raw[n+int(l):]
+		default:
+			return fmt.Errorf("unexpected protobuf wire type %d", wireType)
+		}
+	}
+	return nil
+}
+
+// ReadLabels reads an entry of a map<string, string> into labels.
+func ReadLabels(entry []byte, labels map[string]string) error {
+	var k, v string
+	err := EachField(entry, func(n int, _ uint64, b []byte) {
+		switch n {
+		case 1:
+			k = string(b)
+		case 2:
+			v = string(b)
+		}
+	})
+	labels[k] = v
+	return err
+}
@@ -0,0 +1,169 @@
+package grpc
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+)
+
+// Handler serves a call. It returns nil to end the call with an OK status,
+// an *Error for another, or any other error for Unknown.
+type Handler func(s *ServerStream) error
+
+// Server serves calls on the connections of a listener, one call at a time
+// on each.
+type Server struct {
+	mu       sync.Mutex
+	handlers map[string]Handler
+	listener net.Listener
+	conns    map[net.Conn]bool
+	closed   bool
+}
+
+func NewServer() *Server {
+	return &Server{handlers: map[string]Handler{}, conns: map[net.Conn]bool{}}
+}
+
+// Handle serves method, e.g. /containerd.services.content.v1.Content/Info,
+// with h. Methods without a handler are Unimplemented.
+func (srv *Server) Handle(method string, h Handler) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.handlers[method] = h
+}
+
+// Serve accepts connections on l until it is closed.
+func (srv *Server) Serve(l net.Listener) error {
+	srv.mu.Lock()
+	srv.listener = l
+	srv.mu.Unlock()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		srv.mu.Lock()
+		if srv.closed {
+			srv.mu.Unlock()
+			conn.Close()
+			return errors.New("server closed")
+		}
+		srv.conns[conn] = true
+		srv.mu.Unlock()
+		go srv.serveConn(conn)
+	}
+}
+
+// Close closes the listener and every connection.
+func (srv *Server) Close() error {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.closed = true
+	for conn := range srv.conns {
+		conn.Close()
+	}
+	if srv.listener == nil {
+		return nil
+	}
+	return srv.listener.Close()
+}
+
+func (srv *Server) serveConn(conn net.Conn) {
+	defer func() {
+		srv.mu.Lock()
+		delete(srv.conns, conn)
+		srv.mu.Unlock()
+		conn.Close()
+	}()
+	t := newTransport(conn, true)
+	var p [len(preface)]byte
+	if _, err := io.ReadFull(t.r, p[:]); err != nil || string(p[:]) != preface {
+		return
+	}
+	if t.writeSettings() != nil {
+		return
+	}
+	for {
+		// Read until a call has started.
+		for t.stream == nil || t.stream.headers == nil {
+			if t.readFrame() != nil {
+				return
+			}
+		}
+		s := &ServerStream{t: t, s: t.stream, Metadata: map[string]string{}}
+		for _, f := range t.stream.headers {
+			if f.name != "" && f.name[0] != ':' {
+				s.Metadata[f.name] = f.value
+			}
+		}
+		method := header(t.stream.headers, ":path")
+		srv.mu.Lock()
+		h, ok := srv.handlers[method]
+		srv.mu.Unlock()
+		var err error = Errorf(Unimplemented, "unknown method %s", method)
+		if ok {
+			err = h(s)
+		}
+		if s.respond() != nil {
+			return
+		}
+		if t.writeHeaders(s.s.id, statusFields(err), true) != nil {
+			return
+		}
+		t.stream = nil
+	}
+}
+
+// ServerStream is a call being served.
+type ServerStream struct {
+	t *transport
+	s *stream
+	// Metadata are the headers of the call that are not pseudo-headers,
+	// e.g. containerd-namespace.
+	Metadata  map[string]string
+	responded bool
+}
+
+// Recv returns the next request message, or io.EOF once the client has
+// sent them all.
+func (s *ServerStream) Recv() ([]byte, error) {
+	for {
+		msg, ok, err := s.s.nextMessage()
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return msg, nil
+		}
+		if s.s.ended {
+			return nil, io.EOF
+		}
+		if s.s.reset != nil {
+			return nil, s.s.reset
+		}
+		if err := s.t.readFrame(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Send sends a response message.
+func (s *ServerStream) Send(msg Message) error {
+	if err := s.respond(); err != nil {
+		return err
+	}
+	if s.s.reset != nil {
+		return errors.New("the client reset the call")
+	}
+	return s.t.writeData(frameMessage(msg), false)
+}
+
+// respond writes the response headers, once.
+func (s *ServerStream) respond() error {
+	if s.responded {
+		return nil
+	}
+	s.responded = true
+	return s.t.writeHeaders(s.s.id, []field{{":status", "200"}, {"content-type", "application/grpc"}}, false)
+}
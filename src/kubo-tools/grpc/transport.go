@@ -0,0 +1,429 @@
+package grpc
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+)
+
+// HTTP/2 frame types and flags, from RFC 7540.
+const (
+	frameData         = 0x0
+	frameHeaders      = 0x1
+	frameRSTStream    = 0x3
+	frameSettings     = 0x4
+	framePing         = 0x6
+	frameGoAway       = 0x7
+	frameWindowUpdate = 0x8
+	frameContinuation = 0x9
+
+	flagEndStream  = 0x1
+	flagAck        = 0x1
+	flagEndHeaders = 0x4
+	flagPadded     = 0x8
+	flagPriority   = 0x20
+
+	settingEnablePush        = 0x2
+	settingInitialWindowSize = 0x4
+	settingMaxFrameSize      = 0x5
+)
+
+const (
+	preface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+	// defaultWindow and defaultFrameSize are the initial flow control
+	// window and the largest frame, until the peer's settings say
+	// otherwise.
+	defaultWindow    = 65535
+	defaultFrameSize = 16384
+	// maxFrameSize bounds the frames read, which are never larger than
+	// the defaultFrameSize this end advertises.
+	maxFrameSize = 1 << 24
+)
+
+// stream is the one open stream of a transport.
+type stream struct {
+	id uint32
+	// headers are the first header block received, trailers the second.
+	headers  []field
+	trailers []field
+	// data is what was received and not read yet.
+	data []byte
+	// ended is whether the peer ended the stream, reset whether it reset
+	// it.
+	ended bool
+	reset error
+	// sendWindow is the flow control window of the stream for data sent.
+	sendWindow int64
+}
+
+// transport reads and writes the frames of an HTTP/2 connection on which
+// one stream is open at a time. Frames of other streams are dropped, so a
+// stream that is given up on does not disturb the next.
+type transport struct {
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+	dec  *decoder
+	// server is whether the transport accepts the streams the peer opens.
+	server bool
+	stream *stream
+	// sendWindow is the flow control window of the connection for data
+	// sent; initialWindow and frameSize are the peer's settings.
+	sendWindow    int64
+	initialWindow int64
+	frameSize     int
+	err           error
+}
+
+func newTransport(conn net.Conn, server bool) *transport {
+	return &transport{
+		conn:          conn,
+		r:             bufio.NewReader(conn),
+		w:             bufio.NewWriter(conn),
+		dec:           newDecoder(),
+		server:        server,
+		sendWindow:    defaultWindow,
+		initialWindow: defaultWindow,
+		frameSize:     defaultFrameSize,
+	}
+}
+
+// open opens the stream id, which frames are then read for.
+func (t *transport) open(id uint32) *stream {
+	t.stream = &stream{id: id, sendWindow: t.initialWindow}
+	return t.stream
+}
+
+func (t *transport) writeFrame(typ, flags byte, id uint32, payload []byte) error {
+	if t.err != nil {
+		return t.err
+	}
+	var header [9]byte
+	header[0], header[1], header[2] = byte(len(payload)>>16), byte(len(payload)>>8), byte(len(payload))
+	header[3], header[4] = typ, flags
+	binary.BigEndian.PutUint32(header[5:], id&0x7fffffff)
+	t.w.Write(header[:])
+	t.w.Write(payload)
+	if err := t.w.Flush(); err != nil {
+		t.err = err
+	}
+	return t.err
+}
+
+func (t *transport) writeSettings(settings ...uint32) error {
+	var payload []byte
+	for i := 0; i+1 < len(settings); i += 2 {
+		var s [6]byte
+		binary.BigEndian.PutUint16(s[:], uint16(settings[i]))
+		binary.BigEndian.PutUint32(s[2:], settings[i+1])
+		payload = append(payload, s[:]...)
+	}
+	return t.writeFrame(frameSettings, 0, 0, payload)
+}
+
+func (t *transport) writeWindowUpdate(id uint32, n int) error {
+	var payload [4]byte
+	binary.BigEndian.PutUint32(payload[:], uint32(n))
+	return t.writeFrame(frameWindowUpdate, 0, id, payload[:])
+}
+
+// writeHeaders writes a header block, in CONTINUATION frames after the
+// first if it is larger than a frame.
+func (t *transport) writeHeaders(id uint32, fields []field, endStream bool) error {
+	block := encodeHeaders(fields)
+	typ, flags := byte(frameHeaders), byte(0)
+	if endStream {
+		flags |= flagEndStream
+	}
+	for {
+		chunk := block
+		if len(chunk) > t.frameSize {
+			chunk = chunk[:t.frameSize]
+		}
+		block = block[len(chunk):]
+		if len(block) == 0 {
+			flags |= flagEndHeaders
+		}
+		if err := t.writeFrame(typ, flags, id, chunk); err != nil {
+			return err
+		}
+		if len(block) == 0 {
+			return nil
+		}
+		typ, flags = frameContinuation, 0
+	}
+}
+
+// writeData writes data on the open stream as flow control allows, reading
+// frames while the windows are closed.
+func (t *transport) writeData(data []byte, endStream bool) error {
+	s := t.stream
+	for {
+		n := int64(len(data))
+		for _, limit := range []int64{int64(t.frameSize), t.sendWindow, s.sendWindow} {
+			if n > limit {
+				n = limit
+			}
+		}
+		if n <= 0 && len(data) > 0 {
+			if s.reset != nil {
+				return s.reset
+			}
+			// A server still answers a client that has sent everything,
+			// but a server that has ended the call reads nothing more.
+			if s.ended && !t.server {
+				return errors.New("the peer ended the stream")
+			}
+			if err := t.readFrame(); err != nil {
+				return err
+			}
+			continue
+		}
+		flags := byte(0)
+		if endStream && n == int64(len(data)) {
+			flags = flagEndStream
+		}
+		if err := t.writeFrame(frameData, flags, s.id, data[:n]); err != nil {
+			return err
+		}
+		t.sendWindow -= n
+		s.sendWindow -= n
+		data = data[n:]
+		if len(data) == 0 {
+			return nil
+		}
+	}
+}
+
+// readFrame reads a frame and acts on it: settings, pings and window
+// updates are handled here, and the headers and data of the open stream
+// are kept on it.
+func (t *transport) readFrame() error {
+	if t.err != nil {
+		return t.err
+	}
+	typ, flags, id, payload, err := t.read()
+	if err != nil {
+		t.err = err
+		return err
+	}
+	s := t.stream
+	if s != nil && s.id != id {
+		s = nil
+	}
+
+	switch typ {
+	case frameSettings:
+		if flags&flagAck != 0 {
+			return nil
+		}
+		if err := t.applySettings(payload); err != nil {
+			return err
+		}
+		return t.writeFrame(frameSettings, flagAck, 0, nil)
+
+	case framePing:
+		if flags&flagAck != 0 {
+			return nil
+		}
+		return t.writeFrame(framePing, flagAck, 0, payload)
+
+	case frameWindowUpdate:
+		if len(payload) != 4 {
+			return t.fail("malformed WINDOW_UPDATE frame")
+		}
+		n := int64(binary.BigEndian.Uint32(payload) & 0x7fffffff)
+		switch {
+		case id == 0:
+			t.sendWindow += n
+		case s != nil:
+			s.sendWindow += n
+		}
+		return nil
+
+	case frameGoAway:
+		msg := "the peer closed the connection"
+		if len(payload) > 8 {
+			msg += ": " + string(payload[8:])
+		}
+		return t.fail(msg)
+
+	case frameRSTStream:
+		if s != nil && len(payload) == 4 {
+			s.reset = fmt.Errorf("stream reset by the peer with error code %d", binary.BigEndian.Uint32(payload))
+		}
+		return nil
+
+	case frameHeaders:
+		block, err := t.headerBlock(flags, payload)
+		if err != nil {
+			return err
+		}
+		fields, err := t.dec.decode(block)
+		if err != nil {
+			return t.fail(err.Error())
+		}
+		if s == nil && t.server && (t.stream == nil || id > t.stream.id) {
+			s = t.open(id)
+		}
+		switch {
+		case s == nil:
+		case s.headers == nil:
+			s.headers = fields
+		default:
+			s.trailers = fields
+		}
+		if s != nil && flags&flagEndStream != 0 {
+			s.ended = true
+		}
+		return nil
+
+	case frameData:
+		n := len(payload)
+		if flags&flagPadded != 0 {
+			if len(payload) == 0 || int(payload[0]) >= len(payload) {
+				return t.fail("malformed DATA frame")
+			}
+			payload = payload[1 : len(payload)-int(payload[0])]
+		}
+		if n > 0 {
+			// What is received is buffered until it is read, so the
+			// windows are opened again at once.
+			if err := t.writeWindowUpdate(0, n); err != nil {
+				return err
+			}
+			if s != nil && flags&flagEndStream == 0 {
+				if err := t.writeWindowUpdate(id, n); err != nil {
+					return err
+				}
+			}
+		}
+		if s != nil {
+			s.data = append(s.data, payload...)
+			if flags&flagEndStream != 0 {
+				s.ended = true
+			}
+		}
+		return nil
+	}
+	// PRIORITY, and frames this end has no use for.
+	return nil
+}
+
+// headerBlock returns the header block of a HEADERS frame and the
+// CONTINUATION frames that follow it.
+func (t *transport) headerBlock(flags byte, payload []byte) ([]byte, error) {
+	if flags&flagPadded != 0 {
+		if len(payload) == 0 || int(payload[0]) >= len(payload) {
+			return nil, t.fail("malformed HEADERS frame")
+		}
+		payload = payload[1 : len(payload)-int(payload[0])]
+	}
+	if flags&flagPriority != 0 {
+		if len(payload) < 5 {
+			return nil, t.fail("malformed HEADERS frame")
+		}
+		payload = payload[5:]
+	}
+	block := append([]byte(nil), payload...)
+	for flags&flagEndHeaders == 0 {
+		var typ byte
+		var err error
+		typ, flags, _, payload, err = t.read()
+		if err != nil {
+			t.err = err
+			return nil, err
+		}
+		if typ != frameContinuation {
+			return nil, t.fail("header block not followed by CONTINUATION")
+		}
+		block = append(block, payload...)
+	}
+	return block, nil
+}
+
+func (t *transport) applySettings(payload []byte) error {
+	if len(payload)%6 != 0 {
+		return t.fail("malformed SETTINGS frame")
+	}
+	for ; len(payload) > 0; payload = payload[6:] {
+		v := binary.BigEndian.Uint32(payload[2:])
+		switch binary.BigEndian.Uint16(payload) {
+		case settingInitialWindowSize:
+			// The change applies to the open stream too.
+			if t.stream != nil {
+				t.stream.sendWindow += int64(v) - t.initialWindow
+			}
+			t.initialWindow = int64(v)
+		case settingMaxFrameSize:
+			t.frameSize = int(v)
+		}
+	}
+	return nil
+}
+
+func (t *transport) read() (typ, flags byte, id uint32, payload []byte, err error) {
+	var header [9]byte
+	if _, err = io.ReadFull(t.r, header[:]); err != nil {
+		return 0, 0, 0, nil, err
+	}
+	length := int(header[0])<<16 | int(header[1])<<8 | int(header[2])
+	if length > maxFrameSize {
+		return 0, 0, 0, nil, errors.New("frame too large")
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(t.r, payload); err != nil {
+		return 0, 0, 0, nil, err
+	}
+	return header[3], header[4], binary.BigEndian.Uint32(header[5:]) & 0x7fffffff, payload, nil
+}
+
+// fail ends the connection, telling the peer why.
+func (t *transport) fail(msg string) error {
+	if t.err == nil {
+		payload := make([]byte, 8, 8+len(msg))
+		binary.BigEndian.PutUint32(payload[4:], 1) // PROTOCOL_ERROR
+		t.writeFrame(frameGoAway, 0, 0, append(payload, msg...))
+		t.err = errors.New(msg)
+	}
+	return t.err
+}
+
+// nextMessage returns the next gRPC message received on the stream, if a
+// whole one was: a compression flag, which is never set, the length and
+// the message.
+func (s *stream) nextMessage() ([]byte, bool, error) {
+	if len(s.data) < 5 {
+		return nil, false, nil
+	}
+	if s.data[0] != 0 {
+		return nil, false, errors.New("received a compressed message")
+	}
+	n := int(binary.BigEndian.Uint32(s.data[1:5]))
+	if len(s.data) < 5+n {
+		return nil, false, nil
+	}
+	msg := s.data[5 : 5+n]
+	s.data = s.data[5+n:]
+	return msg, true, nil
+}
+
+// frameMessage prefixes msg as nextMessage reads it.
+func frameMessage(msg []byte) []byte {
+	b := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(b[1:], uint32(len(msg)))
+	return append(b, msg...)
+}
+
+// header returns the value of the field name, or "".
+func header(fields []field, name string) string {
+	for _, f := range fields {
+		if f.name == name {
+			return f.value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,193 @@
+// Package health probes the health endpoints of an API server, so that the
+// post-start script of the kube-apiserver job and the BBR scripts can wait
+// for it to serve and tell which of its checks, such as etcd, a post-start
+// hook or the informer sync, keep it from being ready.
+package health
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"kubo-tools/kube"
+)
+
+// Endpoints are the endpoints probed by default: whether the API server
+// is alive, whether it is ready to serve, and both, as older servers
+// report it.
+var Endpoints = []string{"/livez", "/readyz", "/healthz"}
+
+// Check is one of the checks an endpoint lists, e.g. etcd.
+type Check struct {
+	Name string
+	OK   bool
+	// Reason is why it failed. The API server withholds it from most
+	// users and logs it instead.
+	Reason string
+}
+
+// Status is the answer of one endpoint.
+type Status struct {
+	Endpoint string
+	// NotServed is set if the API server is older than the endpoint,
+	// e.g. /livez before 1.16. The endpoint does not count.
+	NotServed bool
+	OK        bool
+	Checks    []Check
+	// Err is set if the endpoint could not be read, e.g. the API server
+	// is not listening yet or refused the credentials.
+	Err error
+}
+
+// Failing returns the checks that failed.
+func (s *Status) Failing() []Check {
+	var failing []Check
+	for _, c := range s.Checks {
+		if !c.OK {
+			failing = append(failing, c)
+		}
+	}
+	return failing
+}
+
+func (s *Status) String() string {
+	switch {
+	case s.Err != nil:
+		return fmt.Sprintf("%s: %v", s.Endpoint, s.Err)
+	case s.NotServed:
+		return s.Endpoint + " is not served"
+	case s.OK:
+		return s.Endpoint + " ok"
+	}
+	var failing []string
+	for _, c := range s.Failing() {
+		if c.Reason == "" {
+			failing = append(failing, c.Name+" failed")
+		} else {
+			failing = append(failing, c.Name+" failed: "+c.Reason)
+		}
+	}
+	if len(failing) == 0 {
+		return s.Endpoint + " failed"
+	}
+	return fmt.Sprintf("%s: %s", s.Endpoint, strings.Join(failing, ", "))
+}
+
+// Healthy reports whether every endpoint that is served is ok.
+func Healthy(statuses []*Status) bool {
+	for _, s := range statuses {
+		if !s.OK && !s.NotServed {
+			return false
+		}
+	}
+	return true
+}
+
+// Describe joins the statuses, e.g. "/livez ok; /readyz: etcd failed:
+// reason withheld".
+func Describe(statuses []*Status) string {
+	parts := make([]string, len(statuses))
+	for i, s := range statuses {
+		parts[i] = s.String()
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Prober reads the health endpoints of the API server of its client.
+type Prober struct {
+	Client *kube.Client
+	// Endpoints defaults to Endpoints.
+	Endpoints []string
+	Log       *log.Logger
+	// Interval is the time between probes while waiting; it defaults to
+	// two seconds.
+	Interval time.Duration
+}
+
+// Probe reads each endpoint once, verbosely, so that every check is
+// listed.
+func (p *Prober) Probe() []*Status {
+	endpoints := p.Endpoints
+	if len(endpoints) == 0 {
+		endpoints = Endpoints
+	}
+	statuses := make([]*Status, len(endpoints))
+	for i, endpoint := range endpoints {
+		statuses[i] = p.probe(endpoint)
+	}
+	return statuses
+}
+
+func (p *Prober) probe(endpoint string) *Status {
+	s := &Status{Endpoint: endpoint}
+	var body []byte
+	err := p.Client.Get(endpoint+"?verbose", &body)
+	e, ok := err.(*kube.Error)
+	switch {
+	case err == nil:
+		s.OK = true
+	case ok && e.Code == 404:
+		s.NotServed = true
+		return s
+	case ok && e.Code == 500:
+		// A failed check makes the answer a 500 listing the checks.
+		body = []byte(e.Err.Error())
+	default:
+		s.Err = err
+		return s
+	}
+	s.Checks = parseChecks(string(body))
+	return s
+}
+
+// parseChecks reads the lines of a verbose answer, e.g.
+//
+//	[+]ping ok
+//	[-]etcd failed: reason withheld
+func parseChecks(body string) []Check {
+	var checks []Check
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "[+]"):
+			checks = append(checks, Check{Name: strings.TrimSuffix(line[3:], " ok"), OK: true})
+		case strings.HasPrefix(line, "[-]"):
+			c := Check{Name: line[3:]}
+			if i := strings.Index(c.Name, " failed"); i >= 0 {
+				c.Name, c.Reason = c.Name[:i], strings.TrimPrefix(strings.TrimPrefix(c.Name[i:], " failed"), ": ")
+			}
+			checks = append(checks, c)
+		}
+	}
+	return checks
+}
+
+// Wait probes until every endpoint is ok, logging the failing checks
+// whenever they change. It returns the last statuses, and an error if the
+// API server was not healthy within timeout.
+func (p *Prober) Wait(timeout time.Duration) ([]*Status, error) {
+	interval := p.Interval
+	if interval == 0 {
+		interval = 2 * time.Second
+	}
+	start := time.Now()
+	last := ""
+	for {
+		statuses := p.Probe()
+		description := Describe(statuses)
+		if Healthy(statuses) {
+			p.Log.Printf("the API server is healthy: %s", description)
+			return statuses, nil
+		}
+		waited := time.Since(start)
+		if waited >= timeout {
+			return statuses, fmt.Errorf("the API server is not healthy after %s: %s", timeout, description)
+		}
+		if description != last {
+			p.Log.Printf("waiting for the API server (%s): %s", waited.Round(time.Second), description)
+			last = description
+		}
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,100 @@
+package health_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+
+	"kubo-tools/health"
+	"kubo-tools/kube"
+	"kubo-tools/kube/kubetest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Prober", func() {
+	var (
+		server *kubetest.Server
+		tmpDir string
+		logs   *bytes.Buffer
+		p      *health.Prober
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "health")
+		Expect(err).NotTo(HaveOccurred())
+
+		server = kubetest.NewServer()
+		client, err := kube.NewClient(server.WriteKubeconfig(tmpDir, ""))
+		Expect(err).NotTo(HaveOccurred())
+		logs = &bytes.Buffer{}
+		p = &health.Prober{Client: client, Log: log.New(logs, "", 0), Interval: 10 * time.Millisecond}
+	})
+
+	AfterEach(func() {
+		server.Close()
+		os.RemoveAll(tmpDir)
+	})
+
+	It("reads every endpoint verbosely", func() {
+		statuses := p.Probe()
+		Expect(health.Healthy(statuses)).To(BeTrue())
+		Expect(health.Describe(statuses)).To(Equal("/livez ok; /readyz ok; /healthz ok"))
+		Expect(statuses[1].Checks).To(ContainElement(health.Check{Name: "informer-sync", OK: true}))
+		Expect(server.Requests[0].Query).To(Equal("verbose"))
+	})
+
+	It("names the failing checks of each endpoint", func() {
+		server.FailHealthCheck("etcd", "reason withheld")
+		server.FailHealthCheck("informer-sync", "reason withheld")
+
+		statuses := p.Probe()
+		Expect(health.Healthy(statuses)).To(BeFalse())
+		Expect(statuses[1].Failing()).To(Equal([]health.Check{
+			{Name: "etcd", Reason: "reason withheld"},
+			{Name: "informer-sync", Reason: "reason withheld"},
+		}))
+		Expect(statuses[0].String()).To(Equal("/livez: etcd failed: reason withheld"))
+	})
+
+	It("skips the endpoints an older API server does not serve", func() {
+		server.SetVersion("v1.15.3")
+		statuses := p.Probe()
+		Expect(health.Healthy(statuses)).To(BeTrue())
+		Expect(health.Describe(statuses)).To(Equal("/livez is not served; /readyz is not served; /healthz ok"))
+	})
+
+	It("waits until the checks pass, logging them when they change", func() {
+		server.FailHealthCheck("poststarthook/rbac/bootstrap-roles", "not finished")
+		go func() {
+			defer GinkgoRecover()
+			time.Sleep(50 * time.Millisecond)
+			server.FailHealthCheck("poststarthook/rbac/bootstrap-roles", "")
+		}()
+
+		_, err := p.Wait(time.Minute)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(logs.String()).To(Equal("waiting for the API server (0s): " +
+			"/livez: poststarthook/rbac/bootstrap-roles failed: not finished; " +
+			"/readyz: poststarthook/rbac/bootstrap-roles failed: not finished; " +
+			"/healthz: poststarthook/rbac/bootstrap-roles failed: not finished\n" +
+			"the API server is healthy: /livez ok; /readyz ok; /healthz ok\n"))
+	})
+
+	It("fails with the failing checks after the timeout", func() {
+		server.FailHealthCheck("etcd", "reason withheld")
+		_, err := p.Wait(30 * time.Millisecond)
+		Expect(err).To(MatchError(HavePrefix("the API server is not healthy after 30ms: /livez: etcd failed: reason withheld;")))
+	})
+
+	It("reports an API server that does not answer", func() {
+		server.Close()
+		statuses := p.Probe()
+		Expect(health.Healthy(statuses)).To(BeFalse())
+		Expect(statuses[0].Err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,225 @@
+// Package images loads the container images cached in the kubernetes
+// package into the container runtime of a worker, so that system pods start
+// without pulling from a registry. Each archive is verified against the
+// digests recorded inside it before it is loaded, and images the runtime
+// already has are skipped.
+package images
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+// Image is an image archive as written by `docker save`, optionally
+// gzipped.
+type Image struct {
+	// Path is the archive on disk.
+	Path string
+	// ID is the digest of the image config, e.g. sha256:da86e6ba6ca1….
+	ID string
+	// Tags are the references the image is loaded under.
+	Tags []string
+	// Layers are the digests of the uncompressed layers, in order.
+	Layers []string
+	// config and layerFiles are the files of the config and the layers in
+	// the archive, links followed, and sizes the size of each file.
+	config     string
+	layerFiles []string
+	sizes      map[string]int64
+}
+
+func (i *Image) String() string {
+	if len(i.Tags) == 0 {
+		return i.ID
+	}
+	return strings.Join(i.Tags, ", ")
+}
+
+// manifest is an entry of the manifest.json of a `docker save` archive.
+type manifest struct {
+	Config   string
+	RepoTags []string
+	Layers   []string
+}
+
+// config is the part of an image config that lists the layers.
+type config struct {
+	RootFS struct {
+		DiffIDs []string `json:"diff_ids"`
+	} `json:"rootfs"`
+}
+
+// ReadArchive reads the archive at path and verifies it: the config must
+// match the digest it is named after, and each layer must match the digest
+// the config records for it. A truncated or corrupted archive is an error.
+// The archive must hold exactly one image.
+func ReadArchive(path string) (*Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	stream, err := uncompressed(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	contents, err := scan(stream)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	img, err := contents.verify()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	img.Path = path
+	return img, nil
+}
+
+// contents are the digests of the files in an archive, and the bodies of
+// the small JSON files that describe the image.
+type contents struct {
+	digests  map[string]string
+	sizes    map[string]int64
+	links    map[string]string
+	json     map[string][]byte
+	manifest []manifest
+}
+
+// maxJSON bounds the JSON files kept in memory; image configs are a few
+// kilobytes.
+const maxJSON = 1 << 20
+
+// uncompressed returns the tar stream of an archive that may be gzipped.
+func uncompressed(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		return gzip.NewReader(br)
+	}
+	return br, nil
+}
+
+func scan(stream io.Reader) (*contents, error) {
+	c := &contents{digests: map[string]string{}, sizes: map[string]int64{}, links: map[string]string{}, json: map[string][]byte{}}
+	tr := tar.NewReader(stream)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading archive: %v", err)
+		}
+		name := path.Clean(hdr.Name)
+		switch hdr.Typeflag {
+		case tar.TypeSymlink:
+			// docker save links a layer shared by two images to one
+			// copy.
+			c.links[name] = path.Join(path.Dir(name), hdr.Linkname)
+			continue
+		case tar.TypeReg, tar.TypeRegA:
+		default:
+			continue
+		}
+
+		h := sha256.New()
+		var body strings.Builder
+		w := io.Writer(h)
+		// Configs are named <hex>.json, or blobs/sha256/<hex> in OCI
+		// layouts.
+		if (strings.HasSuffix(name, ".json") || strings.HasPrefix(name, "blobs/")) && hdr.Size <= maxJSON {
+			w = io.MultiWriter(h, &body)
+		}
+		if _, err := io.Copy(w, tr); err != nil {
+			return nil, fmt.Errorf("reading %s: %v", name, err)
+		}
+		c.digests[name] = "sha256:" + hex.EncodeToString(h.Sum(nil))
+		c.sizes[name] = hdr.Size
+		if body.Len() > 0 {
+			c.json[name] = []byte(body.String())
+		}
+	}
+	// Reading to the end makes gzip check its CRC.
+	if _, err := io.Copy(ioutil.Discard, stream); err != nil {
+		return nil, fmt.Errorf("reading archive: %v", err)
+	}
+
+	raw, ok := c.json["manifest.json"]
+	if !ok {
+		return nil, fmt.Errorf("no manifest.json, not an image archive")
+	}
+	if err := json.Unmarshal(raw, &c.manifest); err != nil {
+		return nil, fmt.Errorf("manifest.json: %v", err)
+	}
+	return c, nil
+}
+
+// resolve follows links to the file they point at.
+func (c *contents) resolve(name string) string {
+	name = path.Clean(name)
+	for i := 0; i < 8; i++ {
+		target, ok := c.links[name]
+		if !ok {
+			break
+		}
+		name = target
+	}
+	return name
+}
+
+// digest returns the digest of the file, following links.
+func (c *contents) digest(name string) (string, bool) {
+	d, ok := c.digests[c.resolve(name)]
+	return d, ok
+}
+
+func (c *contents) verify() (*Image, error) {
+	if len(c.manifest) != 1 {
+		return nil, fmt.Errorf("manifest.json describes %d images, expected one", len(c.manifest))
+	}
+	m := c.manifest[0]
+
+	id, ok := c.digest(m.Config)
+	if !ok {
+		return nil, fmt.Errorf("config %s is missing", m.Config)
+	}
+	if want := strings.TrimSuffix(path.Base(m.Config), ".json"); len(want) == 64 && "sha256:"+want != id {
+		return nil, fmt.Errorf("config %s has digest %s", m.Config, id)
+	}
+
+	var cfg config
+	raw, ok := c.json[c.resolve(m.Config)]
+	if !ok {
+		return nil, fmt.Errorf("config %s is not readable", m.Config)
+	}
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		return nil, fmt.Errorf("config %s: %v", m.Config, err)
+	}
+	if len(cfg.RootFS.DiffIDs) != len(m.Layers) {
+		return nil, fmt.Errorf("config lists %d layers, manifest.json %d", len(cfg.RootFS.DiffIDs), len(m.Layers))
+	}
+
+	img := &Image{ID: id, Tags: m.RepoTags, config: c.resolve(m.Config), sizes: c.sizes}
+	for i, layer := range m.Layers {
+		got, ok := c.digest(layer)
+		if !ok {
+			return nil, fmt.Errorf("layer %s is missing", layer)
+		}
+		if want := cfg.RootFS.DiffIDs[i]; got != want {
+			return nil, fmt.Errorf("layer %s has digest %s, expected %s", layer, got, want)
+		}
+		img.Layers = append(img.Layers, got)
+		img.layerFiles = append(img.layerFiles, c.resolve(layer))
+	}
+	return img, nil
+}
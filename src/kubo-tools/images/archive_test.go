@@ -0,0 +1,165 @@
+package images_test
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"kubo-tools/images"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func digest(b []byte) string {
+	sum := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// archive describes an image archive as docker save writes it.
+type archive struct {
+	tags   []string
+	layers []string
+	// tamper changes the first layer after its digest was recorded.
+	tamper bool
+	// share writes the second layer as a link to the first.
+	share bool
+	plain bool
+}
+
+// write writes the archive to path and returns the image ID.
+func (a archive) write(path string) string {
+	var diffIDs []string
+	for _, l := range a.layers {
+		diffIDs = append(diffIDs, digest([]byte(l)))
+	}
+	cfg, err := json.Marshal(map[string]interface{}{
+		"architecture": "amd64",
+		"rootfs":       map[string]interface{}{"type": "layers", "diff_ids": diffIDs},
+	})
+	Expect(err).NotTo(HaveOccurred())
+	id := digest(cfg)
+	configName := id[len("sha256:"):] + ".json"
+
+	var layerNames []string
+	for i := range a.layers {
+		layerNames = append(layerNames, diffIDs[i][len("sha256:"):len("sha256:")+12]+"/layer.tar")
+	}
+	manifest, err := json.Marshal([]map[string]interface{}{{"Config": configName, "RepoTags": a.tags, "Layers": layerNames}})
+	Expect(err).NotTo(HaveOccurred())
+
+	f, err := os.Create(path)
+	Expect(err).NotTo(HaveOccurred())
+	defer f.Close()
+	var w io.Writer = f
+	if !a.plain {
+		gz := gzip.NewWriter(f)
+		defer gz.Close()
+		w = gz
+	}
+	tw := tar.NewWriter(w)
+	defer tw.Close()
+
+	file := func(name string, body []byte) {
+		Expect(tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(body)), Typeflag: tar.TypeReg})).To(Succeed())
+		_, err := tw.Write(body)
+		Expect(err).NotTo(HaveOccurred())
+	}
+	for i, l := range a.layers {
+		if i == 1 && a.share {
+			Expect(tw.WriteHeader(&tar.Header{Name: layerNames[1], Linkname: "../" + layerNames[0], Typeflag: tar.TypeSymlink})).To(Succeed())
+			continue
+		}
+		if i == 0 && a.tamper {
+			l += "!"
+		}
+		file(layerNames[i], []byte(l))
+	}
+	file(configName, cfg)
+	file("manifest.json", manifest)
+	return id
+}
+
+var _ = Describe("ReadArchive", func() {
+	var tmpDir string
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "images")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	It("reads the ID, tags and layers of a gzipped archive", func() {
+		path := filepath.Join(tmpDir, "pause.tgz")
+		id := archive{tags: []string{"k8s.gcr.io/pause:3.1"}, layers: []string{"base", "app"}}.write(path)
+
+		img, err := images.ReadArchive(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(img.Path).To(Equal(path))
+		Expect(img.ID).To(Equal(id))
+		Expect(img.Tags).To(Equal([]string{"k8s.gcr.io/pause:3.1"}))
+		Expect(img.Layers).To(Equal([]string{digest([]byte("base")), digest([]byte("app"))}))
+	})
+
+	It("reads a plain tar archive", func() {
+		path := filepath.Join(tmpDir, "pause.tar")
+		id := archive{tags: []string{"k8s.gcr.io/pause:3.1"}, layers: []string{"base"}, plain: true}.write(path)
+
+		img, err := images.ReadArchive(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(img.ID).To(Equal(id))
+	})
+
+	It("follows links between shared layers", func() {
+		path := filepath.Join(tmpDir, "shared.tgz")
+		archive{layers: []string{"same", "same"}, share: true}.write(path)
+
+		img, err := images.ReadArchive(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(img.Layers).To(HaveLen(2))
+	})
+
+	It("rejects a layer that does not match its digest", func() {
+		path := filepath.Join(tmpDir, "tampered.tgz")
+		archive{layers: []string{"base", "app"}, tamper: true}.write(path)
+
+		_, err := images.ReadArchive(path)
+		Expect(err).To(MatchError(MatchRegexp(`tampered.tgz: layer \w+/layer.tar has digest sha256:\w+, expected ` + digest([]byte("base")))))
+	})
+
+	It("rejects a truncated archive", func() {
+		path := filepath.Join(tmpDir, "truncated.tgz")
+		archive{layers: []string{"base"}}.write(path)
+		raw, err := ioutil.ReadFile(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ioutil.WriteFile(path, raw[:len(raw)-10], 0644)).To(Succeed())
+
+		_, err = images.ReadArchive(path)
+		Expect(err).To(MatchError(ContainSubstring("truncated.tgz: ")))
+	})
+
+	It("rejects a file that is not an image archive", func() {
+		path := filepath.Join(tmpDir, "notes.tar")
+		f, err := os.Create(path)
+		Expect(err).NotTo(HaveOccurred())
+		tw := tar.NewWriter(f)
+		Expect(tw.WriteHeader(&tar.Header{Name: "notes.txt", Mode: 0644, Size: 2, Typeflag: tar.TypeReg})).To(Succeed())
+		_, err = tw.Write([]byte("hi"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tw.Close()).To(Succeed())
+		Expect(f.Close()).To(Succeed())
+
+		_, err = images.ReadArchive(path)
+		Expect(err).To(MatchError(HaveSuffix("notes.tar: no manifest.json, not an image archive")))
+	})
+})
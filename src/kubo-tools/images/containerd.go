@@ -0,0 +1,266 @@
+package images
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"time"
+
+	"kubo-tools/containerd"
+)
+
+// Media types of the manifest written for an archive, those containerd
+// gives a `docker save` archive it imports.
+const (
+	mediaTypeManifest = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaTypeConfig   = "application/vnd.docker.container.image.v1+json"
+	mediaTypeLayer    = "application/vnd.docker.image.rootfs.diff.tar"
+)
+
+// Containerd imports images through the containerd API, as `ctr images
+// import` does, into the namespace the CRI plugin uses: the CRI API has no
+// call to import an image. The config and layers are written as content
+// next to a manifest, the layers are unpacked into snapshots, and each tag
+// names the manifest.
+type Containerd struct {
+	// Address is the containerd socket, which also serves CRI.
+	Address string
+	// Namespace is the containerd namespace of the kubelet's images, and
+	// Snapshotter the snapshotter the CRI plugin runs containers with.
+	Namespace   string
+	Snapshotter string
+}
+
+// Has checks that every tag names the manifest of the image, and that its
+// layers are unpacked. An image without tags is never had.
+func (c *Containerd) Has(img *Image) (bool, error) {
+	client, err := containerd.New(c.Address, c.Namespace)
+	if err != nil {
+		return false, err
+	}
+	defer client.Close()
+
+	manifest, _, err := img.manifest()
+	if err != nil || len(img.Tags) == 0 {
+		return false, err
+	}
+	for _, tag := range img.Tags {
+		target, err := client.Image(containerd.Ref(tag))
+		if containerd.IsNotFound(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if target.Digest != manifest.Digest {
+			return false, nil
+		}
+	}
+	if len(img.Layers) == 0 {
+		return true, nil
+	}
+	err = client.Snapshot(c.Snapshotter, chainID(img.Layers))
+	if containerd.IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (c *Containerd) Load(img *Image) error {
+	if len(img.Tags) == 0 {
+		return fmt.Errorf("the image has no tags, which containerd names images by")
+	}
+	client, err := containerd.New(c.Address, c.Namespace)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	manifest, raw, err := img.manifest()
+	if err != nil {
+		return err
+	}
+	// The lease keeps what is written until the tags refer to it.
+	lease := "load-images-" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	return client.WithLease(lease, time.Hour, func() error {
+		if err := c.writeBlobs(client, img); err != nil {
+			return err
+		}
+		top, err := c.unpack(client, img)
+		if err != nil {
+			return err
+		}
+		// The config refers to the snapshots and the manifest to the
+		// config and layers, so that none are garbage collected.
+		if top != "" {
+			if err := client.SetLabels(img.ID, map[string]string{"containerd.io/gc.ref.snapshot." + c.Snapshotter: top}); err != nil {
+				return fmt.Errorf("labelling config %s: %v", img.ID, err)
+			}
+		}
+		labels := map[string]string{"containerd.io/gc.ref.content.config": img.ID}
+		for i, layer := range img.Layers {
+			labels["containerd.io/gc.ref.content.l."+strconv.Itoa(i)] = layer
+		}
+		if err := c.write(client, manifest, bytes.NewReader(raw), labels); err != nil {
+			return fmt.Errorf("writing manifest %s: %v", manifest.Digest, err)
+		}
+		for _, tag := range img.Tags {
+			if err := client.PutImage(containerd.Ref(tag), manifest); err != nil {
+				return fmt.Errorf("tagging %s: %v", tag, err)
+			}
+		}
+		return nil
+	})
+}
+
+// writeBlobs writes the config and the layers containerd does not have
+// from the archive, uncompressed.
+func (c *Containerd) writeBlobs(client *containerd.Client, img *Image) error {
+	wanted := map[string]containerd.Descriptor{img.config: {MediaType: mediaTypeConfig, Digest: img.ID, Size: img.sizes[img.config]}}
+	for i, file := range img.layerFiles {
+		wanted[file] = containerd.Descriptor{MediaType: mediaTypeLayer, Digest: img.Layers[i], Size: img.sizes[file]}
+	}
+
+	f, err := os.Open(img.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	stream, err := uncompressed(f)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(stream)
+	for len(wanted) > 0 {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("reading archive: %v", err)
+		}
+		name := path.Clean(hdr.Name)
+		desc, ok := wanted[name]
+		if !ok || (hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA) {
+			continue
+		}
+		delete(wanted, name)
+		if err := c.write(client, desc, tr, nil); err != nil {
+			return fmt.Errorf("writing %s: %v", name, err)
+		}
+	}
+	if len(wanted) > 0 {
+		return fmt.Errorf("%d files of the image are missing from the archive", len(wanted))
+	}
+	return nil
+}
+
+// write writes content containerd does not have.
+func (c *Containerd) write(client *containerd.Client, desc containerd.Descriptor, r io.Reader, labels map[string]string) error {
+	_, err := client.Labels(desc.Digest)
+	if err == nil {
+		if len(labels) == 0 {
+			return nil
+		}
+		return client.SetLabels(desc.Digest, labels)
+	}
+	if !containerd.IsNotFound(err) {
+		return err
+	}
+	return client.Write("load-images-"+desc.Digest, desc, r, labels)
+}
+
+// unpack applies the layers the snapshotter does not have, each onto the
+// snapshot of the layers below it, and returns the snapshot of the top
+// layer. Snapshots are named by the chain ID of their layers, as
+// containerd names them.
+func (c *Containerd) unpack(client *containerd.Client, img *Image) (string, error) {
+	var parent string
+	for i, layer := range img.Layers {
+		chain := chainID(img.Layers[:i+1])
+		err := client.Snapshot(c.Snapshotter, chain)
+		if err == nil {
+			parent = chain
+			continue
+		}
+		if !containerd.IsNotFound(err) {
+			return "", err
+		}
+
+		key := fmt.Sprintf("load-images-%d-%s", time.Now().UnixNano(), chain)
+		desc := containerd.Descriptor{MediaType: mediaTypeLayer, Digest: layer, Size: img.sizes[img.layerFiles[i]]}
+		mounts, err := client.Prepare(c.Snapshotter, key, parent)
+		if err != nil {
+			return "", fmt.Errorf("unpacking layer %s: %v", layer, err)
+		}
+		if err := client.Apply(desc, mounts); err != nil {
+			client.Remove(c.Snapshotter, key)
+			return "", fmt.Errorf("unpacking layer %s: %v", layer, err)
+		}
+		err = client.Commit(c.Snapshotter, chain, key)
+		if containerd.IsAlreadyExists(err) {
+			// Unpacked meanwhile by someone else.
+			err = client.Remove(c.Snapshotter, key)
+		}
+		if err != nil {
+			client.Remove(c.Snapshotter, key)
+			return "", fmt.Errorf("unpacking layer %s: %v", layer, err)
+		}
+		parent = chain
+	}
+	return parent, nil
+}
+
+// chainID identifies a stack of layers: the digest of the first, then the
+// digest of the chain ID below and the next layer, joined by a space.
+func chainID(layers []string) string {
+	var chain string
+	for _, layer := range layers {
+		if chain == "" {
+			chain = layer
+			continue
+		}
+		sum := sha256.Sum256([]byte(chain + " " + layer))
+		chain = "sha256:" + hex.EncodeToString(sum[:])
+	}
+	return chain
+}
+
+// manifest returns the manifest containerd is given for the image, and its
+// descriptor. It depends only on the image, so its digest tells whether a
+// tag names the image.
+func (img *Image) manifest() (containerd.Descriptor, []byte, error) {
+	type descriptor struct {
+		MediaType string `json:"mediaType"`
+		Size      int64  `json:"size"`
+		Digest    string `json:"digest"`
+	}
+	m := struct {
+		SchemaVersion int          `json:"schemaVersion"`
+		MediaType     string       `json:"mediaType"`
+		Config        descriptor   `json:"config"`
+		Layers        []descriptor `json:"layers"`
+	}{
+		SchemaVersion: 2,
+		MediaType:     mediaTypeManifest,
+		Config:        descriptor{mediaTypeConfig, img.sizes[img.config], img.ID},
+		Layers:        []descriptor{},
+	}
+	for i, layer := range img.Layers {
+		m.Layers = append(m.Layers, descriptor{mediaTypeLayer, img.sizes[img.layerFiles[i]], layer})
+	}
+	raw, err := json.Marshal(m)
+	if err != nil {
+		return containerd.Descriptor{}, nil, err
+	}
+	sum := sha256.Sum256(raw)
+	desc := containerd.Descriptor{MediaType: mediaTypeManifest, Digest: "sha256:" + hex.EncodeToString(sum[:]), Size: int64(len(raw))}
+	return desc, raw, nil
+}
@@ -0,0 +1,124 @@
+package images
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Status is the outcome of loading one archive.
+type Status string
+
+const (
+	Loaded  Status = "loaded"
+	Present Status = "already present"
+	Failed  Status = "failed"
+)
+
+// Result reports what happened to one archive.
+type Result struct {
+	Archive string
+	Image   *Image
+	Status  Status
+	Err     error
+}
+
+func (r Result) String() string {
+	name := filepath.Base(r.Archive)
+	if r.Image != nil {
+		name = fmt.Sprintf("%s (%s, %s)", r.Image, r.Image.ID, filepath.Base(r.Archive))
+	}
+	if r.Err != nil {
+		return fmt.Sprintf("%s %s: %v", r.Status, name, r.Err)
+	}
+	return fmt.Sprintf("%s %s", r.Status, name)
+}
+
+// Loader loads image archives into a runtime.
+type Loader struct {
+	Runtime Runtime
+	Log     *log.Logger
+}
+
+// LoadDir loads every *.tgz and *.tar archive in dir, in name order, and
+// returns one result per archive. A failed archive does not stop the
+// others; the error is only for a dir that cannot be read.
+func (l *Loader) LoadDir(dir string) ([]Result, error) {
+	var archives []string
+	for _, pattern := range []string{"*.tgz", "*.tar"} {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		archives = append(archives, matches...)
+	}
+	sort.Strings(archives)
+
+	results := make([]Result, len(archives))
+	for i, archive := range archives {
+		results[i] = l.Load(archive)
+		l.Log.Print(results[i])
+	}
+	return results, nil
+}
+
+// Load verifies the archive and loads it unless the runtime already has the
+// image. A load counts only once the runtime reports the image by its ID
+// and tags.
+func (l *Loader) Load(archive string) Result {
+	result := Result{Archive: archive, Status: Failed}
+	img, err := ReadArchive(archive)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	result.Image = img
+
+	has, err := l.Runtime.Has(img)
+	switch {
+	case err != nil:
+		result.Err = err
+		return result
+	case has:
+		result.Status = Present
+		return result
+	}
+
+	if err := l.Runtime.Load(img); err != nil {
+		result.Err = err
+		return result
+	}
+	if has, err := l.Runtime.Has(img); err != nil || !has {
+		result.Err = fmt.Errorf("the runtime does not have the image after loading it")
+		if err != nil {
+			result.Err = fmt.Errorf("checking the image after loading it: %v", err)
+		}
+		return result
+	}
+	result.Status = Loaded
+	return result
+}
+
+// Summary counts the results by status, e.g. "2 loaded, 3 already present,
+// 1 failed: pause.tgz".
+func Summary(results []Result) string {
+	var loaded, present int
+	var failed []string
+	for _, r := range results {
+		switch r.Status {
+		case Loaded:
+			loaded++
+		case Present:
+			present++
+		default:
+			failed = append(failed, filepath.Base(r.Archive))
+		}
+	}
+	summary := fmt.Sprintf("%d loaded, %d already present, %d failed", loaded, present, len(failed))
+	if len(failed) > 0 {
+		summary += ": " + strings.Join(failed, ", ")
+	}
+	return summary
+}
@@ -0,0 +1,278 @@
+package images_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"kubo-tools/containerd/containerdtest"
+	"kubo-tools/images"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakeDocker serves the image calls of the Docker Engine API on a unix
+// socket.
+type fakeDocker struct {
+	mu     sync.Mutex
+	images map[string][]string
+	loads  int
+	// failLoad makes loads report an error in the message stream.
+	failLoad string
+	// dropLoads makes loads succeed without keeping the image.
+	dropLoads bool
+	server    *http.Server
+}
+
+func newFakeDocker(socket string) *fakeDocker {
+	d := &fakeDocker{images: map[string][]string{}}
+	l, err := net.Listen("unix", socket)
+	Expect(err).NotTo(HaveOccurred())
+	d.server = &http.Server{Handler: http.HandlerFunc(d.serve)}
+	go d.server.Serve(l)
+	return d
+}
+
+func (d *fakeDocker) serve(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	switch {
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/images/"):
+		id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/images/"), "/json")
+		tags, ok := d.images[id]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"message": "No such image: " + id})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"Id": id, "RepoTags": tags})
+
+	case r.Method == http.MethodPost && r.URL.Path == "/images/load":
+		d.loads++
+		if d.failLoad != "" {
+			json.NewEncoder(w).Encode(map[string]interface{}{"errorDetail": map[string]string{"message": d.failLoad}, "error": d.failLoad})
+			return
+		}
+		if d.dropLoads {
+			json.NewEncoder(w).Encode(map[string]string{"stream": "Loaded image\n"})
+			return
+		}
+		tr := tar.NewReader(r.Body)
+		for {
+			hdr, err := tr.Next()
+			if err != nil {
+				break
+			}
+			if hdr.Name != "manifest.json" {
+				continue
+			}
+			var manifest []struct {
+				Config   string
+				RepoTags []string
+			}
+			Expect(json.NewDecoder(tr).Decode(&manifest)).To(Succeed())
+			d.images["sha256:"+strings.TrimSuffix(manifest[0].Config, ".json")] = manifest[0].RepoTags
+		}
+		json.NewEncoder(w).Encode(map[string]string{"stream": "Loaded image\n"})
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+var _ = Describe("Loader", func() {
+	var (
+		tmpDir  string
+		archDir string
+		logs    *bytes.Buffer
+		pauseID string
+		dnsID   string
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "loader")
+		Expect(err).NotTo(HaveOccurred())
+		archDir = filepath.Join(tmpDir, "container-images")
+		Expect(os.Mkdir(archDir, 0755)).To(Succeed())
+		logs = &bytes.Buffer{}
+
+		pauseID = archive{tags: []string{"k8s.gcr.io/pause:3.1"}, layers: []string{"pause"}}.write(filepath.Join(archDir, "k8s.gcr.io_pause:3.1.tgz"))
+		dnsID = archive{tags: []string{"coredns/coredns:1.4.0"}, layers: []string{"base", "coredns"}}.write(filepath.Join(archDir, "coredns_coredns:1.4.0.tgz"))
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	Context("with Docker", func() {
+		var (
+			docker *fakeDocker
+			loader *images.Loader
+		)
+
+		BeforeEach(func() {
+			socket := filepath.Join(tmpDir, "docker.sock")
+			docker = newFakeDocker(socket)
+			loader = &images.Loader{Runtime: images.NewDocker("unix://" + socket), Log: log.New(logs, "", 0)}
+		})
+
+		AfterEach(func() {
+			docker.server.Close()
+		})
+
+		It("loads the images the daemon does not have and skips the rest", func() {
+			docker.images[pauseID] = []string{"k8s.gcr.io/pause:3.1"}
+
+			results, err := loader.LoadDir(archDir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(results).To(HaveLen(2))
+			Expect(results[0].Status).To(Equal(images.Loaded))
+			Expect(results[0].Image.ID).To(Equal(dnsID))
+			Expect(results[1].Status).To(Equal(images.Present))
+			Expect(docker.loads).To(Equal(1))
+			Expect(docker.images).To(HaveKeyWithValue(dnsID, []string{"coredns/coredns:1.4.0"}))
+
+			Expect(images.Summary(results)).To(Equal("1 loaded, 1 already present, 0 failed"))
+			Expect(logs.String()).To(ContainSubstring("loaded coredns/coredns:1.4.0 (" + dnsID + ", coredns_coredns:1.4.0.tgz)\n"))
+			Expect(logs.String()).To(ContainSubstring("already present k8s.gcr.io/pause:3.1 (" + pauseID + ", k8s.gcr.io_pause:3.1.tgz)\n"))
+		})
+
+		It("loads an image that is present under other tags", func() {
+			docker.images[pauseID] = []string{"example.com/pause:latest"}
+
+			results, err := loader.LoadDir(archDir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(results[1].Status).To(Equal(images.Loaded))
+		})
+
+		It("reports the error the daemon streams back", func() {
+			docker.failLoad = "no space left on device"
+
+			results, err := loader.LoadDir(archDir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(results[0].Status).To(Equal(images.Failed))
+			Expect(results[0].Err).To(MatchError("docker load: no space left on device"))
+			Expect(images.Summary(results)).To(Equal("0 loaded, 0 already present, 2 failed: coredns_coredns:1.4.0.tgz, k8s.gcr.io_pause:3.1.tgz"))
+		})
+
+		It("fails when the image is missing after the load", func() {
+			docker.dropLoads = true
+
+			result := loader.Load(filepath.Join(archDir, "coredns_coredns:1.4.0.tgz"))
+			Expect(result.Status).To(Equal(images.Failed))
+			Expect(result.Err).To(MatchError("the runtime does not have the image after loading it"))
+		})
+
+		It("does not load an archive that fails verification, and carries on", func() {
+			archive{tags: []string{"evil:1"}, layers: []string{"x"}, tamper: true}.write(filepath.Join(archDir, "evil.tgz"))
+
+			results, err := loader.LoadDir(archDir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(results).To(HaveLen(3))
+			Expect(results[1].Archive).To(HaveSuffix("evil.tgz"))
+			Expect(results[1].Status).To(Equal(images.Failed))
+			Expect(results[1].Err).To(MatchError(ContainSubstring("has digest")))
+			Expect(docker.loads).To(Equal(2))
+		})
+	})
+
+	Context("with containerd", func() {
+		var (
+			server *containerdtest.Server
+			loader *images.Loader
+		)
+
+		BeforeEach(func() {
+			var err error
+			server, err = containerdtest.NewServer(tmpDir)
+			Expect(err).NotTo(HaveOccurred())
+			runtime := &images.Containerd{Address: "unix://" + server.Socket, Namespace: "k8s.io", Snapshotter: "overlayfs"}
+			loader = &images.Loader{Runtime: runtime, Log: log.New(logs, "", 0)}
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("imports the image into the kubelet's namespace and unpacks its layers", func() {
+			result := loader.Load(filepath.Join(archDir, "coredns_coredns:1.4.0.tgz"))
+			Expect(result.Err).NotTo(HaveOccurred())
+			Expect(result.Status).To(Equal(images.Loaded))
+			Expect(server.Namespaces()).To(Equal([]string{"k8s.io"}))
+
+			manifest, ok := server.Image("docker.io/coredns/coredns:1.4.0")
+			Expect(ok).To(BeTrue())
+			raw, labels, ok := server.Content(manifest.Digest)
+			Expect(ok).To(BeTrue())
+			Expect(digest(raw)).To(Equal(manifest.Digest))
+			layers := result.Image.Layers
+			Expect(labels).To(Equal(map[string]string{
+				"containerd.io/gc.ref.content.config": dnsID,
+				"containerd.io/gc.ref.content.l.0":    layers[0],
+				"containerd.io/gc.ref.content.l.1":    layers[1],
+			}))
+
+			// Each layer is unpacked onto the one below it.
+			Expect(server.Applied()).To(Equal(layers))
+			top := digest([]byte(layers[0] + " " + layers[1]))
+			Expect(server.Snapshots()).To(Equal(map[string]string{"overlayfs/" + layers[0]: "", "overlayfs/" + top: layers[0]}))
+			_, labels, _ = server.Content(dnsID)
+			Expect(labels).To(HaveKeyWithValue("containerd.io/gc.ref.snapshot.overlayfs", top))
+			Expect(server.Active()).To(BeEmpty())
+			Expect(server.Leases()).To(BeZero())
+		})
+
+		It("skips an image containerd has under its normalized tags", func() {
+			results, err := loader.LoadDir(archDir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(images.Summary(results)).To(Equal("2 loaded, 0 already present, 0 failed"))
+			writes := server.Writes()
+
+			results, err = loader.LoadDir(archDir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(images.Summary(results)).To(Equal("0 loaded, 2 already present, 0 failed"))
+			Expect(server.Writes()).To(Equal(writes))
+		})
+
+		It("writes a layer shared by two images once", func() {
+			archive{tags: []string{"coredns/coredns:1.4.1"}, layers: []string{"base", "coredns 1.4.1"}}.write(filepath.Join(archDir, "coredns_coredns:1.4.1.tgz"))
+
+			results, err := loader.LoadDir(archDir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(images.Summary(results)).To(Equal("3 loaded, 0 already present, 0 failed"))
+			// Three configs, three manifests and four distinct layers.
+			Expect(server.Writes()).To(Equal(10))
+			Expect(server.Applied()).To(HaveLen(4))
+		})
+
+		It("fails when a layer does not unpack, leaving no snapshot behind", func() {
+			server.FailApply("failed to extract layer: no space left on device")
+
+			result := loader.Load(filepath.Join(archDir, "k8s.gcr.io_pause:3.1.tgz"))
+			Expect(result.Status).To(Equal(images.Failed))
+			Expect(result.Err).To(MatchError("unpacking layer " + result.Image.Layers[0] + ": /containerd.services.diff.v1.Diff/Apply: Unknown: failed to extract layer: no space left on device"))
+			Expect(server.Active()).To(BeEmpty())
+			Expect(server.Leases()).To(BeZero())
+			_, ok := server.Image("k8s.gcr.io/pause:3.1")
+			Expect(ok).To(BeFalse())
+		})
+
+		It("fails when the image is missing after the import", func() {
+			server.DropImages()
+
+			result := loader.Load(filepath.Join(archDir, "coredns_coredns:1.4.0.tgz"))
+			Expect(result.Status).To(Equal(images.Failed))
+			Expect(result.Err).To(MatchError("the runtime does not have the image after loading it"))
+		})
+	})
+})
@@ -0,0 +1,135 @@
+package images
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Runtime is a container runtime that images are loaded into.
+type Runtime interface {
+	// Has reports whether the runtime has the image under all of its tags.
+	Has(img *Image) (bool, error)
+	// Load imports the image archive.
+	Load(img *Image) error
+}
+
+// Docker talks to the Docker Engine API on a unix socket.
+type Docker struct {
+	client *http.Client
+}
+
+// NewDocker returns a runtime for the Docker daemon listening on socket,
+// given as a path or a unix:// URL.
+func NewDocker(socket string) *Docker {
+	socket = strings.TrimPrefix(socket, "unix://")
+	return &Docker{client: &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		},
+		// Loading a large image takes a while.
+		Timeout: 10 * time.Minute,
+	}}
+}
+
+func (d *Docker) Has(img *Image) (bool, error) {
+	resp, err := d.client.Get("http://docker/images/" + img.ID + "/json")
+	if err != nil {
+		return false, fmt.Errorf("inspecting %s: %v", img.ID, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("inspecting %s: %s", img.ID, dockerError(resp))
+	}
+
+	var inspect struct {
+		ID       string `json:"Id"`
+		RepoTags []string
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&inspect); err != nil {
+		return false, fmt.Errorf("inspecting %s: %v", img.ID, err)
+	}
+	return inspect.ID == img.ID && containsAll(inspect.RepoTags, img.Tags), nil
+}
+
+func (d *Docker) Load(img *Image) error {
+	f, err := os.Open(img.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	stream, err := uncompressed(f)
+	if err != nil {
+		return err
+	}
+
+	resp, err := d.client.Post("http://docker/images/load?quiet=1", "application/x-tar", stream)
+	if err != nil {
+		return fmt.Errorf("docker load: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("docker load: %s", dockerError(resp))
+	}
+
+	// The response is a stream of JSON messages, which report a failure
+	// even though the status is 200.
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var message struct {
+			Error       string
+			ErrorDetail struct{ Message string }
+		}
+		err := decoder.Decode(&message)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("docker load: %v", err)
+		}
+		if message.ErrorDetail.Message != "" {
+			return fmt.Errorf("docker load: %s", message.ErrorDetail.Message)
+		}
+		if message.Error != "" {
+			return fmt.Errorf("docker load: %s", message.Error)
+		}
+	}
+}
+
+func dockerError(resp *http.Response) string {
+	raw, _ := ioutil.ReadAll(resp.Body)
+	var body struct{ Message string }
+	if json.Unmarshal(raw, &body) == nil && body.Message != "" {
+		return body.Message
+	}
+	if s := strings.TrimSpace(string(raw)); s != "" {
+		return s
+	}
+	return resp.Status
+}
+
+func containsAll(have, want []string) bool {
+	set := map[string]bool{}
+	for _, h := range have {
+		set[h] = true
+	}
+	for _, w := range want {
+		if !set[w] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,156 @@
+// Package k8sargs renders the k8s-args property of the Kubernetes jobs as
+// command-line flags, and checks them against the flags of the binary they
+// are passed to. A misspelt or removed flag then stops the job before the
+// process starts, naming the property that set it, rather than leaving the
+// process to crash-loop.
+package k8sargs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Arg is a flag set by a property.
+type Arg struct {
+	// Property is the property that sets the flag, e.g.
+	// k8s-args.feature-gates.
+	Property string
+	Name     string
+	Value    string
+	// Bare is whether the flag is given without a value, as it is when the
+	// property is null.
+	Bare bool
+}
+
+// String is the flag as it is passed to the binary.
+func (a Arg) String() string {
+	if a.Bare {
+		return "--" + a.Name
+	}
+	return "--" + a.Name + "=" + a.Value
+}
+
+// Parse reads the flags set by property in raw, the JSON of the job's
+// properties, in the order they are set. A null property is a flag without
+// a value, a list is joined with commas and a map is joined as key=value
+// pairs, e.g. feature-gates: {CSIMigration: true} is
+// --feature-gates=CSIMigration=true.
+func Parse(raw []byte, property string) ([]Arg, error) {
+	var properties map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &properties); err != nil {
+		return nil, fmt.Errorf("reading properties: %v", err)
+	}
+	value, ok := properties[property]
+	if !ok || isNull(value) {
+		return nil, nil
+	}
+	var args []Arg
+	err := eachMember(value, func(name string, value json.RawMessage) error {
+		arg := Arg{Property: property + "." + name, Name: name}
+		var err error
+		switch {
+		case isNull(value):
+			arg.Bare = true
+		case value[0] == '[':
+			arg.Value, err = list(value)
+		case value[0] == '{':
+			arg.Value, err = pairs(value)
+		default:
+			arg.Value, err = scalar(value)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", arg.Property, err)
+		}
+		args = append(args, arg)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return args, nil
+}
+
+// Render returns the flags as they are passed to the binary.
+func Render(args []Arg) []string {
+	var flags []string
+	for _, a := range args {
+		flags = append(flags, a.String())
+	}
+	return flags
+}
+
+func list(raw json.RawMessage) (string, error) {
+	var items []json.RawMessage
+	if err := json.Unmarshal(raw, &items); err != nil {
+		return "", err
+	}
+	var values []string
+	for _, item := range items {
+		v, err := scalar(item)
+		if err != nil {
+			return "", err
+		}
+		values = append(values, v)
+	}
+	return strings.Join(values, ","), nil
+}
+
+func pairs(raw json.RawMessage) (string, error) {
+	var values []string
+	err := eachMember(raw, func(key string, value json.RawMessage) error {
+		v, err := scalar(value)
+		if err != nil {
+			return fmt.Errorf("%s: %v", key, err)
+		}
+		values = append(values, key+"="+v)
+		return nil
+	})
+	return strings.Join(values, ","), err
+}
+
+// scalar renders a string, number, boolean or null as the templates did:
+// strings unquoted, numbers as written and null as nothing.
+func scalar(raw json.RawMessage) (string, error) {
+	raw = bytes.TrimSpace(raw)
+	switch {
+	case isNull(raw):
+		return "", nil
+	case raw[0] == '"':
+		var s string
+		err := json.Unmarshal(raw, &s)
+		return s, err
+	case raw[0] == '[' || raw[0] == '{':
+		return "", fmt.Errorf("%s cannot be rendered as a flag value; only lists and maps of strings, numbers and booleans can", raw)
+	default:
+		return string(raw), nil
+	}
+}
+
+// eachMember calls fn with every member of the JSON object raw, in the
+// order they are written.
+func eachMember(raw json.RawMessage, fn func(string, json.RawMessage) error) error {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return fmt.Errorf("%s is not a map", bytes.TrimSpace(raw))
+	}
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return err
+		}
+		if err := fn(t.(string), value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isNull(raw json.RawMessage) bool {
+	return len(raw) == 0 || string(bytes.TrimSpace(raw)) == "null"
+}
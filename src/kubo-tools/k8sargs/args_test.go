@@ -0,0 +1,60 @@
+package k8sargs_test
+
+import (
+	"kubo-tools/k8sargs"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Parse", func() {
+	It("renders the flags in the order the properties set them", func() {
+		args, err := k8sargs.Parse([]byte(`{
+			"tls.kubernetes": {"ca": "x"},
+			"k8s-args": {
+				"string": "value",
+				"colon-suffix": "value:",
+				"true": true,
+				"false": false,
+				"number": 30,
+				"float": 0.2,
+				"bare": null,
+				"array": ["value1", "value2", 3],
+				"hash": {"key2": "value2", "key1": true, "key3": null}
+			}
+		}`), "k8s-args")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(k8sargs.Render(args)).To(Equal([]string{
+			"--string=value",
+			"--colon-suffix=value:",
+			"--true=true",
+			"--false=false",
+			"--number=30",
+			"--float=0.2",
+			"--bare",
+			"--array=value1,value2,3",
+			"--hash=key2=value2,key1=true,key3=",
+		}))
+		Expect(args[0].Property).To(Equal("k8s-args.string"))
+		Expect(args[6]).To(Equal(k8sargs.Arg{Property: "k8s-args.bare", Name: "bare", Bare: true}))
+	})
+
+	It("renders nothing when the property is not set", func() {
+		for _, raw := range []string{`{}`, `{"k8s-args": null}`, `{"k8s-args": {}}`} {
+			args, err := k8sargs.Parse([]byte(raw), "k8s-args")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(args).To(BeEmpty())
+		}
+	})
+
+	It("refuses values that cannot be flags", func() {
+		_, err := k8sargs.Parse([]byte(`{"k8s-args": {"feature-gates": {"CSIMigration": {"enabled": true}}}}`), "k8s-args")
+		Expect(err).To(MatchError(ContainSubstring("k8s-args.feature-gates: CSIMigration: ")))
+
+		_, err = k8sargs.Parse([]byte(`{"k8s-args": ["v=2"]}`), "k8s-args")
+		Expect(err).To(MatchError(ContainSubstring("is not a map")))
+
+		_, err = k8sargs.Parse([]byte(`{"k8s-args": `), "k8s-args")
+		Expect(err).To(MatchError(ContainSubstring("reading properties")))
+	})
+})
@@ -0,0 +1,185 @@
+package k8sargs
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Flag is a flag of a binary.
+type Flag struct {
+	Name string
+	// Bare is whether the flag may be given without a value, as booleans
+	// can.
+	Bare bool
+	// Deprecated is the deprecation notice of the flag, if it has one.
+	Deprecated string
+}
+
+// FlagSet is the flags of a Kubernetes binary.
+type FlagSet struct {
+	// Binary is the name of the binary, e.g. kube-apiserver, and Version
+	// its version, e.g. v1.17.9.
+	Binary  string
+	Version string
+	Flags   map[string]Flag
+}
+
+// Load reads the flags of the binary at path from its --help, and its
+// version from its --version.
+func Load(path string) (*FlagSet, error) {
+	// The help goes to standard output or standard error, depending on
+	// the binary.
+	help, _ := exec.Command(path, "--help").CombinedOutput()
+	flags := ParseHelp(string(help))
+	if len(flags) == 0 {
+		return nil, fmt.Errorf("%s --help listed no flags: %s", path, strings.TrimSpace(string(help)))
+	}
+	out, err := exec.Command(path, "--version").Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s --version: %v", path, err)
+	}
+	// The binaries print "Kubernetes v1.17.9".
+	fields := strings.Fields(string(out))
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("%s --version printed nothing", path)
+	}
+	return &FlagSet{Binary: filepath.Base(path), Version: fields[len(fields)-1], Flags: flags}, nil
+}
+
+// flagLine is a flag as pflag lists it: an optional shorthand, the name,
+// the default when given without a value, the type unless it is a
+// boolean, and the usage after at least two spaces.
+var flagLine = regexp.MustCompile(`^  (?:-\w, |    )--([\w][\w.-]*)(\[=[^\]]*\])?( \S+)?(?:  +(.*))?$`)
+
+// ParseHelp reads the flags listed in the --help of a binary. Usage that
+// continues on the following lines belongs to the flag above it.
+func ParseHelp(help string) map[string]Flag {
+	flags := map[string]Flag{}
+	var name string
+	usage := map[string]string{}
+	for _, line := range strings.Split(help, "\n") {
+		if m := flagLine.FindStringSubmatch(line); m != nil {
+			name = m[1]
+			flags[name] = Flag{Name: name, Bare: m[2] != "" || m[3] == ""}
+			usage[name] = m[4]
+			continue
+		}
+		if name != "" && strings.HasPrefix(line, " ") {
+			usage[name] += " " + strings.TrimSpace(line)
+		} else {
+			name = ""
+		}
+	}
+	for name, f := range flags {
+		f.Deprecated = deprecation(usage[name])
+		flags[name] = f
+	}
+	return flags
+}
+
+// deprecation returns the notice in the usage of a deprecated flag, which
+// Kubernetes marks with DEPRECATED, often in parentheses.
+func deprecation(usage string) string {
+	i := strings.Index(usage, "DEPRECATED")
+	if i < 0 {
+		return ""
+	}
+	notice := strings.TrimLeft(usage[i+len("DEPRECATED"):], ": ")
+	if i > 0 && usage[i-1] == '(' {
+		if j := strings.LastIndex(notice, ")"); j >= 0 {
+			notice = notice[:j]
+		}
+	}
+	if notice = strings.TrimSpace(notice); notice == "" {
+		return "deprecated"
+	}
+	return notice
+}
+
+// Problem is an arg that cannot be passed to the binary, or that is
+// deprecated.
+type Problem struct {
+	Arg    Arg
+	Reason string
+	// Deprecated is whether the binary has the flag but marks it
+	// deprecated, in which case it can still be passed.
+	Deprecated bool
+}
+
+func (p Problem) String() string {
+	return p.Arg.Property + ": " + p.Reason
+}
+
+// Check returns the args that are not flags of the binary, that are
+// deprecated, or that are given without a value the flag needs.
+func (fs *FlagSet) Check(args []Arg) []Problem {
+	var problems []Problem
+	for _, a := range args {
+		f, ok := fs.Flags[a.Name]
+		switch {
+		case !ok:
+			reason := fmt.Sprintf("%s %s has no flag --%s", fs.Binary, fs.Version, a.Name)
+			if similar := fs.similar(a.Name); similar != "" {
+				reason += "; did you mean --" + similar + "?"
+			}
+			problems = append(problems, Problem{Arg: a, Reason: reason})
+		case f.Deprecated != "":
+			problems = append(problems, Problem{Arg: a, Reason: fmt.Sprintf("--%s is deprecated in %s %s: %s", a.Name, fs.Binary, fs.Version, f.Deprecated), Deprecated: true})
+		case a.Bare && !f.Bare:
+			problems = append(problems, Problem{Arg: a, Reason: fmt.Sprintf("--%s needs a value", a.Name)})
+		}
+	}
+	return problems
+}
+
+// similar returns the flag whose name is closest to name, if it is at most
+// two edits away, as a misspelling would be.
+func (fs *FlagSet) similar(name string) string {
+	var names []string
+	for n := range fs.Flags {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	best, bestDistance := "", 3
+	for _, n := range names {
+		if d := distance(name, n); d < bestDistance {
+			best, bestDistance = n, d
+		}
+	}
+	return best
+}
+
+// distance is the Levenshtein distance between a and b.
+func distance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func min(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
@@ -0,0 +1,184 @@
+package k8sargs_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"kubo-tools/k8sargs"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// help is an excerpt of the --help of kube-apiserver v1.17.
+const help = `The Kubernetes API server validates and configures data
+for the api objects which include pods, services, replicationcontrollers, and
+others.
+
+Usage:
+  kube-apiserver [flags]
+
+Generic flags:
+
+      --advertise-address ip                  The IP address on which to advertise the apiserver to members of the cluster.
+      --feature-gates mapStringBool           A set of key=value pairs that describe feature gates for alpha/experimental features. Options are:
+                                              APIListChunking=true|false (BETA - default=true)
+                                              CSIMigration=true|false (BETA - default=true)
+
+Insecure serving flags:
+
+      --address ip                            The IP address on which to serve the insecure --port (set to 0.0.0.0 for all IPv4 interfaces and :: for all IPv6 interfaces). (default 127.0.0.1) (DEPRECATED: see --bind-address instead.)
+      --insecure-port int                     The port on which to serve unsecured, unauthenticated access. (default 8080) (DEPRECATED: This flag will be removed in a future version.)
+
+Admission flags:
+
+      --enable-admission-plugins strings      admission plugins that should be enabled in addition to default enabled ones.
+      --allow-privileged                      If true, allow privileged containers. [default=false]
+      --profiling                             Enable profiling via web interface host:port/debug/pprof/ (default true)
+
+Global flags:
+
+  -h, --help                     help for kube-apiserver
+      --log-flush-frequency duration   Maximum number of seconds between log flushes (default 5s)
+  -v, --v Level                  number for the log level verbosity
+      --vmodule moduleSpec       comma-separated list of pattern=N settings for file-filtered logging
+      --logtostderr[=true]       log to standard error instead of files
+`
+
+// kubeletHelp is an excerpt of the --help of kubelet v1.17, which marks
+// the flags its config file can set as deprecated.
+const kubeletHelp = `The kubelet is the primary "node agent" that runs on each
+node.
+
+Usage:
+  kubelet [flags]
+
+Flags:
+      --address ip                                   The IP address for the Kubelet to serve on (set to '0.0.0.0' for all IPv4 interfaces and '::' for all IPv6 interfaces) (default 0.0.0.0) (DEPRECATED: This parameter should be set via the config file specified by the Kubelet's --config flag. See https://kubernetes.io/docs/tasks/administer-cluster/kubelet-config-file/ for more information.)
+      --config string                                The Kubelet will load its initial configuration from this file. The path may be absolute or relative; relative paths start at the Kubelet's current working directory. Omit this flag to use the built-in default configuration values. Command-line flags override configuration from this file.
+      --container-runtime string                     The container runtime to use. Possible values: 'docker', 'remote'. (default "docker")
+      --docker-only                                  Only report docker containers in addition to root stats (DEPRECATED: This is a cadvisor flag that was mistakenly registered with the Kubelet. Due to legacy concerns, it will follow the standard CLI deprecation timeline before being removed.)
+      --eviction-hard mapStringString                A set of eviction thresholds (e.g. memory.available<1Gi) that if met would trigger a pod eviction. (default imagefs.available<15%,memory.available<100Mi,nodefs.available<10%,nodefs.inodesFree<5%) (DEPRECATED: This parameter should be set via the config file specified by the Kubelet's --config flag. See https://kubernetes.io/docs/tasks/administer-cluster/kubelet-config-file/ for more information.)
+      --exit-on-lock-contention                      Whether kubelet should exit upon lock-file contention.
+      --feature-gates mapStringBool                  A set of key=value pairs that describe feature gates for alpha/experimental features. Options are:
+                                                 APIListChunking=true|false (BETA - default=true)
+                                                 CSIMigration=true|false (BETA - default=true) (DEPRECATED: This parameter should be set via the config file specified by the Kubelet's --config flag. See https://kubernetes.io/docs/tasks/administer-cluster/kubelet-config-file/ for more information.)
+      --max-pods int32                               Number of Pods that can run on this Kubelet. (default 110) (DEPRECATED: This parameter should be set via the config file specified by the Kubelet's --config flag. See https://kubernetes.io/docs/tasks/administer-cluster/kubelet-config-file/ for more information.)
+      --node-ip string                               IP address of the node. If set, kubelet will use this IP address for the node
+      --register-with-taints []api.Taint             Register the node with the given list of taints (comma separated "<key>=<value>:<effect>"). No-op if register-node is false.
+      --root-dir string                              Directory path for managing kubelet files (volume mounts,etc). (default "/var/lib/kubelet")
+  -v, --v Level                                      number for the log level verbosity
+`
+
+var _ = Describe("ParseHelp", func() {
+	It("reads the flags, whether they need a value and their deprecation", func() {
+		flags := k8sargs.ParseHelp(help)
+		Expect(flags).To(HaveLen(12))
+		Expect(flags["advertise-address"]).To(Equal(k8sargs.Flag{Name: "advertise-address"}))
+		Expect(flags["allow-privileged"]).To(Equal(k8sargs.Flag{Name: "allow-privileged", Bare: true}))
+		Expect(flags["logtostderr"].Bare).To(BeTrue())
+		Expect(flags["v"].Bare).To(BeFalse())
+		Expect(flags["help"].Bare).To(BeTrue())
+		Expect(flags["address"].Deprecated).To(Equal("see --bind-address instead."))
+		Expect(flags["insecure-port"].Deprecated).To(Equal("This flag will be removed in a future version."))
+		Expect(flags["feature-gates"].Deprecated).To(BeEmpty())
+	})
+})
+
+var _ = Describe("FlagSet", func() {
+	var fs *k8sargs.FlagSet
+
+	BeforeEach(func() {
+		fs = &k8sargs.FlagSet{Binary: "kube-apiserver", Version: "v1.17.9", Flags: k8sargs.ParseHelp(help)}
+	})
+
+	It("accepts the flags of the binary", func() {
+		args, err := k8sargs.Parse([]byte(`{"k8s-args": {"feature-gates": {"CSIMigration": true}, "profiling": null, "v": 2, "enable-admission-plugins": ["NodeRestriction"]}}`), "k8s-args")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fs.Check(args)).To(BeEmpty())
+	})
+
+	It("names the property of each flag that cannot be passed", func() {
+		args, err := k8sargs.Parse([]byte(`{"k8s-args": {"enable-admision-plugins": ["NodeRestriction"], "insecure-port": 0, "advertise-address": null, "no-such-flag": true}}`), "k8s-args")
+		Expect(err).NotTo(HaveOccurred())
+		var problems []string
+		for _, p := range fs.Check(args) {
+			problems = append(problems, p.String())
+		}
+		Expect(problems).To(Equal([]string{
+			"k8s-args.enable-admision-plugins: kube-apiserver v1.17.9 has no flag --enable-admision-plugins; did you mean --enable-admission-plugins?",
+			"k8s-args.insecure-port: --insecure-port is deprecated in kube-apiserver v1.17.9: This flag will be removed in a future version.",
+			"k8s-args.advertise-address: --advertise-address needs a value",
+			"k8s-args.no-such-flag: kube-apiserver v1.17.9 has no flag --no-such-flag",
+		}))
+	})
+})
+
+var _ = Describe("FlagSet of kubelet", func() {
+	var fs *k8sargs.FlagSet
+
+	BeforeEach(func() {
+		fs = &k8sargs.FlagSet{Binary: "kubelet", Version: "v1.17.9", Flags: k8sargs.ParseHelp(kubeletHelp)}
+	})
+
+	It("accepts the flags only the command line can set", func() {
+		args, err := k8sargs.Parse([]byte(`{"k8s-args": {"node-ip": "10.0.0.1", "register-with-taints": ["dedicated=gpu:NoSchedule"], "exit-on-lock-contention": null, "v": 2}}`), "k8s-args")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fs.Check(args)).To(BeEmpty())
+	})
+
+	It("tells the deprecated flags from those that cannot be passed", func() {
+		args, err := k8sargs.Parse([]byte(`{"k8s-args": {"address": "10.0.0.1", "docker-only": null, "max-pods": 200, "feature-gates": {"CSIMigration": true}, "node-ipp": "10.0.0.1"}}`), "k8s-args")
+		Expect(err).NotTo(HaveOccurred())
+		var deprecated, refused []string
+		for _, p := range fs.Check(args) {
+			if p.Deprecated {
+				deprecated = append(deprecated, p.Arg.Property)
+			} else {
+				refused = append(refused, p.String())
+			}
+		}
+		Expect(deprecated).To(Equal([]string{"k8s-args.address", "k8s-args.docker-only", "k8s-args.max-pods", "k8s-args.feature-gates"}))
+		Expect(refused).To(Equal([]string{"k8s-args.node-ipp: kubelet v1.17.9 has no flag --node-ipp; did you mean --node-ip?"}))
+	})
+
+	It("reads the deprecation notice after the options of a flag", func() {
+		Expect(fs.Flags["feature-gates"].Deprecated).To(HavePrefix("This parameter should be set via the config file"))
+		Expect(fs.Flags["docker-only"].Bare).To(BeTrue())
+	})
+})
+
+var _ = Describe("Load", func() {
+	var tmpDir string
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "k8sargs")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	It("reads the flags and version of the binary", func() {
+		path := filepath.Join(tmpDir, "kube-apiserver")
+		script := "#!/bin/sh\nif [ \"$1\" = --version ]; then echo Kubernetes v1.17.9; exit 0; fi\ncat <<'EOF' >&2\n" + help + "EOF\n"
+		Expect(ioutil.WriteFile(path, []byte(script), 0700)).To(Succeed())
+
+		fs, err := k8sargs.Load(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fs.Binary).To(Equal("kube-apiserver"))
+		Expect(fs.Version).To(Equal("v1.17.9"))
+		Expect(fs.Flags).To(HaveKey("feature-gates"))
+	})
+
+	It("fails if the binary lists no flags", func() {
+		path := filepath.Join(tmpDir, "kubelet")
+		Expect(ioutil.WriteFile(path, []byte("#!/bin/sh\necho unknown flag: $1\nexit 1\n"), 0700)).To(Succeed())
+
+		_, err := k8sargs.Load(path)
+		Expect(err).To(MatchError(ContainSubstring("--help listed no flags: unknown flag: --help")))
+	})
+})
@@ -0,0 +1,13 @@
+package k8sargs_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestK8sArgs(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "K8sArgs Suite")
+}
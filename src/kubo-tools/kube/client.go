@@ -0,0 +1,368 @@
+// Package kube is a small client for the Kubernetes REST API, used by the
+// tools that run on the VMs and by the native runner of the smoke tests.
+// It speaks JSON over HTTPS with the credentials from a kubeconfig file, so
+// the tools need neither kubectl nor client-go.
+package kube
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Client sends requests to a single API server.
+type Client struct {
+	// Trace, if set, receives the method and URL of every request.
+	Trace io.Writer
+
+	server string
+	token  string
+	client *http.Client
+
+	mu         sync.Mutex
+	discovered map[string]*APIResourceList
+}
+
+func NewClient(kubeconfigPath string) (*Client, error) {
+	cfg, err := loadKubeconfig(kubeconfigPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		server: strings.TrimSuffix(cfg.Server, "/"),
+		token:  cfg.Token,
+		client: &http.Client{
+			Timeout:   60 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: cfg.TLS, Proxy: http.ProxyFromEnvironment},
+		},
+	}, nil
+}
+
+// Resource identifies a REST collection.
+type Resource struct {
+	GroupVersion string
+	Name         string
+	Namespaced   bool
+}
+
+var (
+	Nodes                = Resource{"v1", "nodes", false}
+	Pods                 = Resource{"v1", "pods", true}
+	Events               = Resource{"v1", "events", true}
+	Namespaces           = Resource{"v1", "namespaces", false}
+	ConfigMaps           = Resource{"v1", "configmaps", true}
+	Services             = Resource{"v1", "services", true}
+	ServiceAccounts      = Resource{"v1", "serviceaccounts", true}
+	Secrets              = Resource{"v1", "secrets", true}
+	Deployments          = Resource{"apps/v1", "deployments", true}
+	DaemonSets           = Resource{"apps/v1", "daemonsets", true}
+	StatefulSets         = Resource{"apps/v1", "statefulsets", true}
+	Jobs                 = Resource{"batch/v1", "jobs", true}
+	StorageClasses       = Resource{"storage.k8s.io/v1", "storageclasses", false}
+	ClusterRoles         = Resource{"rbac.authorization.k8s.io/v1", "clusterroles", false}
+	ClusterRoleBindings  = Resource{"rbac.authorization.k8s.io/v1", "clusterrolebindings", false}
+	Roles                = Resource{"rbac.authorization.k8s.io/v1", "roles", true}
+	RoleBindings         = Resource{"rbac.authorization.k8s.io/v1", "rolebindings", true}
+	APIServices          = Resource{"apiregistration.k8s.io/v1", "apiservices", false}
+	CRDs                 = Resource{"apiextensions.k8s.io/v1", "customresourcedefinitions", false}
+	PodDisruptionBudgets = Resource{"policy/v1beta1", "poddisruptionbudgets", true}
+	PodSecurityPolicies  = Resource{"policy/v1beta1", "podsecuritypolicies", false}
+	VolumeAttachments    = Resource{"storage.k8s.io/v1", "volumeattachments", false}
+)
+
+func (r Resource) path(namespace, name, subresource string) string {
+	p := "/apis/" + r.GroupVersion
+	if r.GroupVersion == "v1" {
+		p = "/api/v1"
+	}
+	if r.Namespaced && namespace != "" {
+		p += "/namespaces/" + namespace
+	}
+	p += "/" + r.Name
+	if name != "" {
+		p += "/" + name
+	}
+	if subresource != "" {
+		p += "/" + subresource
+	}
+	return p
+}
+
+// QualifiedName returns the resource name as kubectl prints it, e.g.
+// poddisruptionbudgets.policy, so that errors are unambiguous across API
+// groups.
+func (r Resource) QualifiedName() string {
+	if i := strings.Index(r.GroupVersion, "/"); i > 0 {
+		return r.Name + "." + r.GroupVersion[:i]
+	}
+	return r.Name
+}
+
+// Request describes a single API call. An empty Namespace on a list of a
+// namespaced resource lists across all namespaces.
+type Request struct {
+	Verb        string
+	Resource    Resource
+	Namespace   string
+	Name        string
+	Subresource string
+	Query       url.Values
+	Body        interface{}
+	// As is the user to impersonate, if any.
+	As string
+	// PatchType is the content type of a patch; it defaults to
+	// MergePatch.
+	PatchType string
+}
+
+// Patch content types. A strategic merge patch merges lists such as node
+// conditions by key, where a merge patch replaces them whole. An apply
+// patch is a server-side apply of the whole object; its request needs a
+// fieldManager in the query.
+const (
+	MergePatch          = "application/merge-patch+json"
+	StrategicMergePatch = "application/strategic-merge-patch+json"
+	ApplyPatch          = "application/apply-patch+yaml"
+)
+
+var methods = map[string]string{
+	"get":    http.MethodGet,
+	"list":   http.MethodGet,
+	"create": http.MethodPost,
+	"update": http.MethodPut,
+	"patch":  http.MethodPatch,
+	"delete": http.MethodDelete,
+}
+
+// Do sends req to the API server. If into is a *[]byte it receives the raw
+// response body, otherwise the body is decoded as JSON. Patches are sent as
+// JSON merge patches unless the request says otherwise. Failures are
+// returned as *Error.
+func (c *Client) Do(req Request, into interface{}) error {
+	resourceName := req.Resource.QualifiedName()
+	if req.Subresource != "" {
+		resourceName += "/" + req.Subresource
+	}
+	fail := func(code int, reason string, err error) error {
+		return &Error{Verb: req.Verb, Resource: resourceName, Namespace: req.Namespace, Name: req.Name, Code: code, Reason: reason, Err: err}
+	}
+
+	method, ok := methods[req.Verb]
+	if !ok {
+		return fail(0, "", fmt.Errorf("unsupported verb %q", req.Verb))
+	}
+	name := req.Name
+	if req.Verb == "create" && req.Subresource == "" {
+		name = ""
+	}
+	u := c.server + req.Resource.path(req.Namespace, name, req.Subresource)
+	if len(req.Query) > 0 {
+		u += "?" + req.Query.Encode()
+	}
+
+	if req.Verb == "delete" && req.Body == nil {
+		req.Body = DeleteOptions{PropagationPolicy: "Background"}
+	}
+
+	var body io.Reader
+	contentType := "application/json"
+	if req.Body != nil {
+		raw, err := json.Marshal(req.Body)
+		if err != nil {
+			return fail(0, "", err)
+		}
+		body = bytes.NewReader(raw)
+	}
+	if req.Verb == "patch" {
+		contentType = MergePatch
+		if req.PatchType != "" {
+			contentType = req.PatchType
+		}
+	}
+	return c.send(method, u, body, contentType, req.As, into, fail)
+}
+
+// Get decodes the JSON at path, for the calls that are not about a
+// resource, such as discovery and /readyz. As with Do, a *[]byte receives
+// the raw body.
+func (c *Client) Get(path string, into interface{}) error {
+	fail := func(code int, reason string, err error) error {
+		return &Error{Verb: "get", Resource: path, Code: code, Reason: reason, Err: err}
+	}
+	return c.send(http.MethodGet, c.server+path, nil, "", "", into, fail)
+}
+
+func (c *Client) send(method, u string, body io.Reader, contentType, as string, into interface{}, fail func(int, string, error) error) error {
+	if c.Trace != nil {
+		fmt.Fprintf(c.Trace, "%s %s\n", method, u)
+	}
+	httpReq, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return fail(0, "", err)
+	}
+	httpReq.Header.Set("Accept", "application/json")
+	if body != nil {
+		httpReq.Header.Set("Content-Type", contentType)
+	}
+	if c.token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if as != "" {
+		httpReq.Header.Set("Impersonate-User", as)
+	}
+
+	resp, err := c.client.Do(httpReq)
+	if err != nil {
+		return fail(0, "", err)
+	}
+	defer resp.Body.Close()
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fail(resp.StatusCode, "", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var status struct {
+			Reason  string `json:"reason"`
+			Message string `json:"message"`
+			Details struct {
+				Causes []StatusCause `json:"causes"`
+			} `json:"details"`
+		}
+		if json.Unmarshal(raw, &status) != nil || status.Message == "" {
+			status.Message = strings.TrimSpace(string(raw))
+		}
+		if status.Message == "" {
+			status.Message = resp.Status
+		}
+		err := fail(resp.StatusCode, status.Reason, errors.New(status.Message))
+		if e, ok := err.(*Error); ok {
+			e.Causes = status.Details.Causes
+		}
+		return err
+	}
+
+	switch into := into.(type) {
+	case nil:
+		return nil
+	case *[]byte:
+		*into = raw
+		return nil
+	default:
+		if err := json.Unmarshal(raw, into); err != nil {
+			return fail(resp.StatusCode, "", fmt.Errorf("decoding response: %v", err))
+		}
+		return nil
+	}
+}
+
+// ListNodes returns the nodes matching the label selector.
+func (c *Client) ListNodes(selector string) ([]Node, error) {
+	var list NodeList
+	err := c.Do(Request{Verb: "list", Resource: Nodes, Query: url.Values{"labelSelector": {selector}}}, &list)
+	return list.Items, err
+}
+
+func (c *Client) GetNode(name string) (*Node, error) {
+	var node Node
+	if err := c.Do(Request{Verb: "get", Resource: Nodes, Name: name}, &node); err != nil {
+		return nil, err
+	}
+	return &node, nil
+}
+
+// PatchNode applies a JSON merge patch to the node.
+func (c *Client) PatchNode(name string, patch interface{}) error {
+	return c.Do(Request{Verb: "patch", Resource: Nodes, Name: name, Body: patch}, nil)
+}
+
+// PatchNodeStatus applies a strategic merge patch to the node's status, so
+// that a patched condition leaves the others alone.
+func (c *Client) PatchNodeStatus(name string, patch interface{}) error {
+	return c.Do(Request{
+		Verb:        "patch",
+		Resource:    Nodes,
+		Name:        name,
+		Subresource: "status",
+		Body:        patch,
+		PatchType:   StrategicMergePatch,
+	}, nil)
+}
+
+func (c *Client) DeleteNode(name string) error {
+	return c.Do(Request{Verb: "delete", Resource: Nodes, Name: name}, nil)
+}
+
+// ListPods lists pods in namespace, or in all namespaces if it is empty,
+// that match the field selector.
+func (c *Client) ListPods(namespace, fieldSelector string) ([]Pod, error) {
+	var list PodList
+	err := c.Do(Request{
+		Verb:      "list",
+		Resource:  Pods,
+		Namespace: namespace,
+		Query:     url.Values{"fieldSelector": {fieldSelector}},
+	}, &list)
+	return list.Items, err
+}
+
+// DeletePod deletes a pod. A nil gracePeriod leaves the pod's own
+// terminationGracePeriodSeconds in effect.
+func (c *Client) DeletePod(namespace, name string, gracePeriod *int64) error {
+	return c.Do(Request{
+		Verb:      "delete",
+		Resource:  Pods,
+		Namespace: namespace,
+		Name:      name,
+		Body:      DeleteOptions{GracePeriodSeconds: gracePeriod, PropagationPolicy: "Background"},
+	}, nil)
+}
+
+// EvictPod asks the API server to evict a pod. The server answers with
+// TooManyRequests while a PodDisruptionBudget does not allow the disruption.
+func (c *Client) EvictPod(namespace, name string, gracePeriod *int64) error {
+	eviction := Eviction{
+		TypeMeta:   TypeMeta{APIVersion: "policy/v1beta1", Kind: "Eviction"},
+		ObjectMeta: ObjectMeta{Name: name, Namespace: namespace},
+	}
+	if gracePeriod != nil {
+		eviction.DeleteOptions = &DeleteOptions{GracePeriodSeconds: gracePeriod}
+	}
+	return c.Do(Request{
+		Verb:        "create",
+		Resource:    Pods,
+		Namespace:   namespace,
+		Name:        name,
+		Subresource: "eviction",
+		Body:        eviction,
+	}, nil)
+}
+
+// ListPodDisruptionBudgets lists the budgets in all namespaces.
+func (c *Client) ListPodDisruptionBudgets() ([]PodDisruptionBudget, error) {
+	var list PodDisruptionBudgetList
+	err := c.Do(Request{Verb: "list", Resource: PodDisruptionBudgets}, &list)
+	return list.Items, err
+}
+
+// ListVolumeAttachments lists the attachments to every node.
+func (c *Client) ListVolumeAttachments() ([]VolumeAttachment, error) {
+	var list VolumeAttachmentList
+	err := c.Do(Request{Verb: "list", Resource: VolumeAttachments}, &list)
+	return list.Items, err
+}
+
+// CreateEvent records an event in its namespace.
+func (c *Client) CreateEvent(e *Event) error {
+	e.APIVersion, e.Kind = "v1", "Event"
+	return c.Do(Request{Verb: "create", Resource: Events, Namespace: e.Namespace, Body: e}, nil)
+}
@@ -0,0 +1,145 @@
+package kube_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"kubo-tools/kube"
+	"kubo-tools/kube/kubetest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Client", func() {
+	var (
+		server *kubetest.Server
+		tmpDir string
+		client *kube.Client
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "kube")
+		Expect(err).NotTo(HaveOccurred())
+
+		server = kubetest.NewServer()
+		client, err = kube.NewClient(server.WriteKubeconfig(tmpDir, "s3cr3t"))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		server.Close()
+		os.RemoveAll(tmpDir)
+	})
+
+	It("fails to load a kubeconfig whose current-context does not exist", func() {
+		path := filepath.Join(tmpDir, "broken")
+		Expect(ioutil.WriteFile(path, []byte("current-context: nope\n"), 0600)).To(Succeed())
+
+		_, err := kube.NewClient(path)
+		Expect(err).To(MatchError(ContainSubstring(`current-context "nope" not found`)))
+	})
+
+	It("lists nodes by label and patches them", func() {
+		server.Put(kube.Nodes, kube.Node{ObjectMeta: kube.ObjectMeta{Name: "worker-0", Labels: map[string]string{"bosh.id": "abc"}}})
+		server.Put(kube.Nodes, kube.Node{ObjectMeta: kube.ObjectMeta{Name: "worker-1", Labels: map[string]string{"bosh.id": "def"}}})
+
+		nodes, err := client.ListNodes("bosh.id=abc")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(nodes).To(HaveLen(1))
+		Expect(nodes[0].Name).To(Equal("worker-0"))
+
+		Expect(client.PatchNode("worker-0", map[string]interface{}{"spec": map[string]interface{}{"unschedulable": true}})).To(Succeed())
+		Expect(server.Get(kube.Nodes, "", "worker-0")).To(HaveKeyWithValue("spec", HaveKeyWithValue("unschedulable", true)))
+	})
+
+	It("patches a node condition without replacing the others", func() {
+		node := kube.Node{ObjectMeta: kube.ObjectMeta{Name: "worker-0"}}
+		node.Status.Conditions = []kube.NodeCondition{{Type: "Ready", Status: "True"}, {Type: "NetworkUnavailable", Status: "True"}}
+		server.Put(kube.Nodes, node)
+
+		Expect(client.PatchNodeStatus("worker-0", map[string]interface{}{
+			"status": map[string]interface{}{"conditions": []kube.NodeCondition{{Type: "NetworkUnavailable", Status: "False"}}},
+		})).To(Succeed())
+		Expect(server.RequestLines()).To(ContainElement("PATCH /api/v1/nodes/worker-0/status"))
+
+		patched, err := client.GetNode("worker-0")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(patched.Condition("Ready").Status).To(Equal("True"))
+		Expect(patched.Condition("NetworkUnavailable").Status).To(Equal("False"))
+		Expect(patched.Condition("MemoryPressure")).To(BeNil())
+	})
+
+	It("lists pods across namespaces by field", func() {
+		server.Put(kube.Pods, kube.Pod{ObjectMeta: kube.ObjectMeta{Name: "a", Namespace: "one"}, Spec: kube.PodSpec{NodeName: "worker-0"}})
+		server.Put(kube.Pods, kube.Pod{ObjectMeta: kube.ObjectMeta{Name: "b", Namespace: "two"}, Spec: kube.PodSpec{NodeName: "worker-0"}})
+		server.Put(kube.Pods, kube.Pod{ObjectMeta: kube.ObjectMeta{Name: "c", Namespace: "two"}, Spec: kube.PodSpec{NodeName: "worker-1"}})
+
+		pods, err := client.ListPods("", "spec.nodeName=worker-0")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pods).To(HaveLen(2))
+		Expect([]string{pods[0].Key(), pods[1].Key()}).To(ConsistOf("one/a", "two/b"))
+	})
+
+	It("evicts a pod with the requested grace period", func() {
+		server.Put(kube.Pods, kube.Pod{ObjectMeta: kube.ObjectMeta{Name: "a", Namespace: "one"}})
+
+		grace := int64(10)
+		Expect(client.EvictPod("one", "a", &grace)).To(Succeed())
+		Expect(server.Get(kube.Pods, "one", "a")).To(BeNil())
+
+		last := server.Requests[len(server.Requests)-1]
+		Expect(last.String()).To(Equal("POST /api/v1/namespaces/one/pods/a/eviction"))
+		var eviction kube.Eviction
+		Expect(json.Unmarshal([]byte(last.Body), &eviction)).To(Succeed())
+		Expect(eviction.Kind).To(Equal("Eviction"))
+		Expect(*eviction.DeleteOptions.GracePeriodSeconds).To(Equal(int64(10)))
+	})
+
+	It("reports a blocked eviction as TooManyRequests", func() {
+		server.Put(kube.Pods, kube.Pod{ObjectMeta: kube.ObjectMeta{Name: "a", Namespace: "one"}})
+		server.BlockEvictions("one", "a", 1)
+
+		err := client.EvictPod("one", "a", nil)
+		Expect(kube.IsTooManyRequests(err)).To(BeTrue())
+		Expect(err).To(MatchError(ContainSubstring("create pods/eviction a in namespace one: TooManyRequests: Cannot evict pod")))
+	})
+
+	It("returns NotFound errors typed", func() {
+		err := client.DeleteNode("nope")
+		Expect(kube.IsNotFound(err)).To(BeTrue())
+		Expect(err.(*kube.Error).Code).To(Equal(404))
+	})
+
+	It("decodes budgets whose bounds are percentages", func() {
+		var pdb kube.PodDisruptionBudget
+		Expect(json.Unmarshal([]byte(`{"spec": {"minAvailable": "50%", "maxUnavailable": 1}}`), &pdb)).To(Succeed())
+		Expect(pdb.Spec.MinAvailable.String()).To(Equal("50%"))
+		Expect(pdb.Spec.MaxUnavailable.String()).To(Equal("1"))
+	})
+})
+
+var _ = Describe("LabelSelector", func() {
+	labels := map[string]string{"app": "web", "tier": "front"}
+
+	It("matches labels and expressions", func() {
+		Expect((&kube.LabelSelector{MatchLabels: map[string]string{"app": "web"}}).Matches(labels)).To(BeTrue())
+		Expect((&kube.LabelSelector{MatchLabels: map[string]string{"app": "db"}}).Matches(labels)).To(BeFalse())
+		Expect((&kube.LabelSelector{MatchExpressions: []kube.LabelSelectorRequirement{
+			{Key: "tier", Operator: "In", Values: []string{"front", "back"}},
+			{Key: "canary", Operator: "DoesNotExist"},
+		}}).Matches(labels)).To(BeTrue())
+		Expect((&kube.LabelSelector{MatchExpressions: []kube.LabelSelectorRequirement{
+			{Key: "tier", Operator: "NotIn", Values: []string{"front"}},
+		}}).Matches(labels)).To(BeFalse())
+	})
+
+	It("matches everything when empty and nothing when nil", func() {
+		Expect((&kube.LabelSelector{}).Matches(labels)).To(BeTrue())
+		var nilSelector *kube.LabelSelector
+		Expect(nilSelector.Matches(labels)).To(BeFalse())
+	})
+})
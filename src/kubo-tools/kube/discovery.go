@@ -0,0 +1,85 @@
+package kube
+
+import (
+	"fmt"
+	"strings"
+)
+
+// APIResourceList is what the API server serves at /api/v1 and
+// /apis/<group>/<version>: the resources of one group version.
+type APIResourceList struct {
+	TypeMeta
+	GroupVersion string        `json:"groupVersion"`
+	Resources    []APIResource `json:"resources"`
+}
+
+type APIResource struct {
+	Name       string   `json:"name"`
+	Namespaced bool     `json:"namespaced"`
+	Kind       string   `json:"kind"`
+	Verbs      []string `json:"verbs,omitempty"`
+}
+
+// ResourceFor returns the REST collection of objects of the kind, asking
+// the API server once per group version. The error for a kind the server
+// does not serve is NotFound, so that callers can tell it apart; a CRD may
+// not be established yet.
+func (c *Client) ResourceFor(apiVersion, kind string) (Resource, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	list, ok := c.discovered[apiVersion]
+	if !ok {
+		path := "/apis/" + apiVersion
+		if apiVersion == "v1" {
+			path = "/api/v1"
+		}
+		list = &APIResourceList{}
+		if err := c.Get(path, list); err != nil {
+			if !IsNotFound(err) {
+				return Resource{}, err
+			}
+		}
+		if c.discovered == nil {
+			c.discovered = map[string]*APIResourceList{}
+		}
+		c.discovered[apiVersion] = list
+	}
+
+	for _, r := range list.Resources {
+		if r.Kind == kind && !strings.Contains(r.Name, "/") {
+			return Resource{GroupVersion: apiVersion, Name: r.Name, Namespaced: r.Namespaced}, nil
+		}
+	}
+	return Resource{}, &Error{
+		Verb:     "discover",
+		Resource: apiVersion,
+		Reason:   "NotFound",
+		Code:     404,
+		Err:      fmt.Errorf("the server does not serve kind %s in %s", kind, apiVersion),
+	}
+}
+
+// ForgetDiscovery drops what ResourceFor learned, so that resources added
+// since, such as those of a new CRD, are found.
+func (c *Client) ForgetDiscovery() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.discovered = nil
+}
+
+// VersionInfo is what the API server serves at /version.
+type VersionInfo struct {
+	Major      string `json:"major"`
+	Minor      string `json:"minor"`
+	GitVersion string `json:"gitVersion"`
+}
+
+// ServerVersion returns the version of the API server.
+func (c *Client) ServerVersion() (*VersionInfo, error) {
+	var v VersionInfo
+	if err := c.Get("/version", &v); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
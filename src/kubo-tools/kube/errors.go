@@ -0,0 +1,82 @@
+package kube
+
+import "fmt"
+
+// Error reports a failed request to the API server. It records the verb and
+// resource so that callers can log what broke, not just that something did.
+type Error struct {
+	Verb      string
+	Resource  string
+	Namespace string
+	Name      string
+
+	// Reason is the machine readable Status reason returned by the API
+	// server, e.g. NotFound or TooManyRequests. It is empty if the request
+	// never got a response.
+	Reason string
+	// Code is the HTTP status code, or 0 when not known.
+	Code int
+	// Causes are the details the Status gives, such as the fields a
+	// server-side apply conflicts on.
+	Causes []StatusCause
+	Err    error
+}
+
+// StatusCause is one cause of a failure, as listed in Status.details.
+type StatusCause struct {
+	Type    string `json:"reason"`
+	Message string `json:"message"`
+	Field   string `json:"field"`
+}
+
+func (e *Error) Error() string {
+	target := e.Resource
+	if e.Name != "" {
+		target += " " + e.Name
+	}
+	if e.Namespace != "" {
+		target += " in namespace " + e.Namespace
+	}
+	if e.Reason != "" {
+		return fmt.Sprintf("%s %s: %s: %v", e.Verb, target, e.Reason, e.Err)
+	}
+	return fmt.Sprintf("%s %s: %v", e.Verb, target, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func IsNotFound(err error) bool {
+	return reasonOf(err) == "NotFound"
+}
+
+func IsAlreadyExists(err error) bool {
+	return reasonOf(err) == "AlreadyExists"
+}
+
+// IsForbidden reports whether the API server refused the request, either
+// because RBAC denied it or because an admission plugin rejected the object.
+func IsForbidden(err error) bool {
+	return reasonOf(err) == "Forbidden"
+}
+
+// IsConflict reports whether the object changed under the request, or, for
+// a server-side apply, whether another field manager owns the fields it
+// sets; the Causes then name the managers.
+func IsConflict(err error) bool {
+	return reasonOf(err) == "Conflict"
+}
+
+// IsTooManyRequests reports whether the API server asked to retry later. An
+// eviction gets this answer while a PodDisruptionBudget forbids it.
+func IsTooManyRequests(err error) bool {
+	return reasonOf(err) == "TooManyRequests"
+}
+
+func reasonOf(err error) string {
+	if e, ok := err.(*Error); ok {
+		return e.Reason
+	}
+	return ""
+}
@@ -0,0 +1,312 @@
+package kubetest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"kubo-tools/kube"
+)
+
+// The server tracks which field manager owns which fields of each object,
+// to mimic server-side apply. Fields are leaves of the object, shown as
+// dotted paths such as .spec.replicas; unlike the API server it does not
+// merge lists by key, so a list is a single field. Creates, updates and
+// merge patches give the fields they change to their fieldManager, or
+// "unknown"; an update also drops the managers of the fields it removes. A
+// get lists the managers in metadata.managedFields, by name only.
+
+// ignored are the fields no manager owns.
+var ignored = map[string]bool{
+	".apiVersion":                 true,
+	".kind":                       true,
+	".metadata.name":              true,
+	".metadata.namespace":         true,
+	".metadata.resourceVersion":   true,
+	".metadata.uid":               true,
+	".metadata.creationTimestamp": true,
+	".metadata.generation":        true,
+	".metadata.managedFields":     true,
+	".status":                     true,
+}
+
+// sep separates the keys of a path, which may themselves have dots, as
+// label keys do.
+const sep = "\x00"
+
+// dotted shows a path the way the API server does.
+func dotted(path string) string {
+	return "." + strings.Replace(path, sep, ".", -1)
+}
+
+// leaves returns the fields of obj with their values.
+func leaves(obj map[string]interface{}) map[string]interface{} {
+	fields := map[string]interface{}{}
+	var walk func(prefix string, m map[string]interface{})
+	walk = func(prefix string, m map[string]interface{}) {
+		for k, v := range m {
+			path := k
+			if prefix != "" {
+				path = prefix + sep + k
+			}
+			if ignored[dotted(path)] {
+				continue
+			}
+			if child, ok := v.(map[string]interface{}); ok && len(child) > 0 {
+				walk(path, child)
+				continue
+			}
+			fields[path] = v
+		}
+	}
+	walk("", obj)
+	return fields
+}
+
+// field returns the value at path in obj and whether it is there.
+func field(obj map[string]interface{}, path string) (interface{}, bool) {
+	var v interface{} = obj
+	for _, key := range strings.Split(path, sep) {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if v, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+func setField(obj map[string]interface{}, path string, value interface{}) {
+	keys := strings.Split(path, sep)
+	for _, key := range keys[:len(keys)-1] {
+		child, ok := obj[key].(map[string]interface{})
+		if !ok {
+			child = map[string]interface{}{}
+			obj[key] = child
+		}
+		obj = child
+	}
+	obj[keys[len(keys)-1]] = value
+}
+
+// removeField deletes the field at path, and the maps it leaves empty.
+func removeField(obj map[string]interface{}, path string) {
+	keys := strings.Split(path, sep)
+	if len(keys) == 1 {
+		delete(obj, keys[0])
+		return
+	}
+	child, ok := obj[keys[0]].(map[string]interface{})
+	if !ok {
+		return
+	}
+	removeField(child, strings.Join(keys[1:], sep))
+	if len(child) == 0 {
+		delete(obj, keys[0])
+	}
+}
+
+// owners returns the managers of the fields of the object, creating the
+// record if it is missing.
+func (s *Server) owners(collection, id string) map[string]map[string]bool {
+	key := collection + " " + id
+	if s.managed[key] == nil {
+		s.managed[key] = map[string]map[string]bool{}
+	}
+	return s.managed[key]
+}
+
+// update gives the fields of patch that differ from obj to the manager, as
+// an update does. obj is the object before the update, or nil.
+func (s *Server) update(collection, id, manager string, obj, patch map[string]interface{}) {
+	if manager == "" {
+		manager = "unknown"
+	}
+	owners := s.owners(collection, id)
+	for path, value := range leaves(patch) {
+		if value == nil {
+			delete(owners, path)
+			continue
+		}
+		if old, ok := field(obj, path); obj != nil && ok && reflect.DeepEqual(old, value) && len(owners[path]) > 0 {
+			owners[path][manager] = true
+			continue
+		}
+		owners[path] = map[string]bool{manager: true}
+	}
+}
+
+// replace records an update that replaces obj with the whole of updated:
+// the fields it drops lose their managers.
+func (s *Server) replace(collection, id, manager string, obj, updated map[string]interface{}) {
+	owners := s.owners(collection, id)
+	fields := leaves(updated)
+	for path := range owners {
+		if _, ok := fields[path]; !ok {
+			delete(owners, path)
+		}
+	}
+	s.update(collection, id, manager, obj, updated)
+}
+
+// withManagedFields returns a copy of obj whose metadata.managedFields has
+// an entry for each manager of its fields.
+func (s *Server) withManagedFields(collection, id string, obj map[string]interface{}) map[string]interface{} {
+	seen := map[string]bool{}
+	var names []string
+	for _, ms := range s.managed[collection+" "+id] {
+		for m := range ms {
+			if !seen[m] {
+				seen[m] = true
+				names = append(names, m)
+			}
+		}
+	}
+	if len(names) == 0 {
+		return obj
+	}
+	sort.Strings(names)
+	entries := make([]interface{}, len(names))
+	for i, m := range names {
+		entries[i] = map[string]interface{}{"manager": m}
+	}
+	copied := map[string]interface{}{}
+	for k, v := range obj {
+		copied[k] = v
+	}
+	metadata := map[string]interface{}{}
+	for k, v := range obj["metadata"].(map[string]interface{}) {
+		metadata[k] = v
+	}
+	metadata["managedFields"] = entries
+	copied["metadata"] = metadata
+	return copied
+}
+
+// FieldManagers returns the fields of the object that each manager owns.
+func (s *Server) FieldManagers(r kube.Resource, namespace, name string) map[string][]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	managers := map[string][]string{}
+	for path, ms := range s.managed[key(r)+" "+namespace+"/"+name] {
+		for m := range ms {
+			managers[m] = append(managers[m], dotted(path))
+		}
+	}
+	for _, paths := range managers {
+		sort.Strings(paths)
+	}
+	return managers
+}
+
+// serverSideApply handles a PATCH with an apply patch: it creates the object
+// or sets the fields in the patch, refusing to change fields other managers
+// own unless forced, and removes the fields the manager applied before but
+// no longer does, unless another manager owns them too.
+func (s *Server) serverSideApply(w http.ResponseWriter, r *http.Request, collection, namespace, resource, name string, raw []byte) {
+	id := namespace + "/" + name
+	manager := r.URL.Query().Get("fieldManager")
+	if manager == "" {
+		status(w, http.StatusBadRequest, "BadRequest", "PATCH requests with an apply patch must set fieldManager")
+		return
+	}
+	force := r.URL.Query().Get("force") == "true"
+	var applied map[string]interface{}
+	if err := json.Unmarshal(raw, &applied); err != nil {
+		status(w, http.StatusBadRequest, "BadRequest", err.Error())
+		return
+	}
+	fields := leaves(applied)
+
+	obj, exists := s.objects[collection][id]
+	owners := s.owners(collection, id)
+	if !exists {
+		for path := range owners {
+			delete(owners, path)
+		}
+		metadata, _ := applied["metadata"].(map[string]interface{})
+		if metadata == nil {
+			metadata = map[string]interface{}{"name": name}
+			applied["metadata"] = metadata
+		}
+		if namespace != "" {
+			metadata["namespace"] = namespace
+		}
+		for path := range fields {
+			owners[path] = map[string]bool{manager: true}
+		}
+		s.store(collection, applied)
+		s.reconcile(collection, applied)
+		respond(w, http.StatusCreated, applied)
+		return
+	}
+
+	var conflicts []string
+	var causes []interface{}
+	for path, value := range fields {
+		old, ok := field(obj, path)
+		if !ok || reflect.DeepEqual(old, value) {
+			continue
+		}
+		var others []string
+		for m := range owners[path] {
+			if m != manager {
+				others = append(others, m)
+			}
+		}
+		sort.Strings(others)
+		for _, m := range others {
+			if force {
+				delete(owners[path], m)
+				continue
+			}
+			conflicts = append(conflicts, fmt.Sprintf("conflict with %q: %s", m, dotted(path)))
+			causes = append(causes, map[string]interface{}{"reason": "FieldManagerConflict", "message": fmt.Sprintf("conflict with %q", m), "field": dotted(path)})
+		}
+	}
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		respond(w, http.StatusConflict, map[string]interface{}{
+			"kind":    "Status",
+			"status":  "Failure",
+			"reason":  "Conflict",
+			"message": fmt.Sprintf("Apply failed with %d conflicts: %s", len(conflicts), strings.Join(conflicts, "; ")),
+			"details": map[string]interface{}{"name": name, "kind": resource, "causes": causes},
+			"code":    http.StatusConflict,
+		})
+		return
+	}
+
+	changed := false
+	for path, ms := range owners {
+		if _, ok := fields[path]; ok || !ms[manager] {
+			continue
+		}
+		delete(ms, manager)
+		if len(ms) == 0 {
+			delete(owners, path)
+			removeField(obj, path)
+			changed = true
+		}
+	}
+	for path, value := range fields {
+		if old, ok := field(obj, path); !ok || !reflect.DeepEqual(old, value) {
+			setField(obj, path, value)
+			changed = true
+		}
+		if owners[path] == nil {
+			owners[path] = map[string]bool{}
+		}
+		owners[path][manager] = true
+	}
+	if changed {
+		s.store(collection, obj)
+		s.reconcile(collection, obj)
+	}
+	respond(w, http.StatusOK, obj)
+}
@@ -0,0 +1,716 @@
+// Package kubetest provides an in-memory stand-in for kube-apiserver, for
+// testing code that uses package kube.
+package kubetest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"kubo-tools/kube"
+)
+
+// Server understands enough of the REST conventions (discovery, collections
+// across namespaces, label and field selectors, updates, merge and strategic
+// merge patches, server-side apply, dry runs, Status errors, the eviction and
+// log subresources, access reviews and the health endpoints) to exercise the
+// tools. Evictions remove the pod unless they are blocked with
+// BlockEvictions.
+type Server struct {
+	*httptest.Server
+
+	mu          sync.Mutex
+	objects     map[string]map[string]map[string]interface{}
+	resources   map[string][]kube.APIResource
+	blocked     map[string]int
+	failures    map[string]int
+	keepEvicted bool
+	controllers map[string]func(map[string]interface{})
+	managed     map[string]map[string]map[string]bool
+	version     int
+	gitVersion  string
+	checks      map[string]string
+	logs        map[string]string
+	grants      map[string]bool
+	Requests    []Request
+
+	latency     time.Duration
+	inFlight    int
+	maxInFlight int
+}
+
+// Request records a call the server received.
+type Request struct {
+	Method string
+	Path   string
+	Query  string
+	Body   string
+	// Token is the bearer token the request authenticated with, and As
+	// the user it impersonated, if any.
+	Token string
+	As    string
+}
+
+func (r Request) String() string {
+	return r.Method + " " + r.Path
+}
+
+var (
+	apiPath       = regexp.MustCompile(`^/(api/v1|apis/[^/]+/[^/]+)(?:/namespaces/([^/]+))?/([^/]+)(?:/([^/]+))?(?:/([^/]+))?$`)
+	discoveryPath = regexp.MustCompile(`^/(api/v1|apis/[^/]+/[^/]+)$`)
+)
+
+// builtin are the resources the server serves from the start, with their
+// kinds.
+var builtin = map[kube.Resource]string{
+	kube.Nodes:                "Node",
+	kube.Pods:                 "Pod",
+	kube.Events:               "Event",
+	kube.Namespaces:           "Namespace",
+	kube.ConfigMaps:           "ConfigMap",
+	kube.Services:             "Service",
+	kube.ServiceAccounts:      "ServiceAccount",
+	kube.Secrets:              "Secret",
+	kube.PodDisruptionBudgets: "PodDisruptionBudget",
+	kube.PodSecurityPolicies:  "PodSecurityPolicy",
+	kube.VolumeAttachments:    "VolumeAttachment",
+	kube.Deployments:          "Deployment",
+	kube.DaemonSets:           "DaemonSet",
+	kube.StatefulSets:         "StatefulSet",
+	kube.Jobs:                 "Job",
+	kube.StorageClasses:       "StorageClass",
+	kube.ClusterRoles:         "ClusterRole",
+	kube.ClusterRoleBindings:  "ClusterRoleBinding",
+	kube.Roles:                "Role",
+	kube.RoleBindings:         "RoleBinding",
+	kube.APIServices:          "APIService",
+	kube.CRDs:                 "CustomResourceDefinition",
+}
+
+func NewServer() *Server {
+	s := &Server{
+		objects:     map[string]map[string]map[string]interface{}{},
+		resources:   map[string][]kube.APIResource{},
+		blocked:     map[string]int{},
+		failures:    map[string]int{},
+		controllers: map[string]func(map[string]interface{}){},
+		managed:     map[string]map[string]map[string]bool{},
+		gitVersion:  "v1.17.9",
+		checks:      map[string]string{},
+		logs:        map[string]string{},
+		grants:      map[string]bool{},
+	}
+	for r, kind := range builtin {
+		s.AddResource(r, kind)
+	}
+	s.Server = httptest.NewTLSServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// AddResource makes discovery list the resource, as if a CRD for it had
+// been established.
+func (s *Server) AddResource(r kube.Resource, kind string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.resources[r.GroupVersion] = append(s.resources[r.GroupVersion], kube.APIResource{Name: r.Name, Namespaced: r.Namespaced, Kind: kind})
+}
+
+// SetVersion sets the version /version reports, e.g. "v1.15.3".
+func (s *Server) SetVersion(gitVersion string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gitVersion = gitVersion
+}
+
+// healthChecks are the checks of each health endpoint. /livez and /readyz
+// are served from 1.16 on.
+var healthChecks = map[string][]string{
+	"/healthz": {"ping", "log", "etcd", "poststarthook/start-informers", "poststarthook/rbac/bootstrap-roles"},
+	"/livez":   {"ping", "log", "etcd", "poststarthook/start-informers", "poststarthook/rbac/bootstrap-roles"},
+	"/readyz":  {"ping", "log", "etcd", "informer-sync", "poststarthook/start-informers", "poststarthook/rbac/bootstrap-roles", "shutdown"},
+}
+
+// FailHealthCheck makes the named check, e.g. etcd, fail with reason on
+// every health endpoint that has it. An empty reason makes it pass again.
+func (s *Server) FailHealthCheck(name, reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if reason == "" {
+		delete(s.checks, name)
+		return
+	}
+	s.checks[name] = reason
+}
+
+// WriteKubeconfig writes a kubeconfig for the server into dir and returns
+// its path.
+func (s *Server) WriteKubeconfig(dir, token string) string {
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.Certificate().Raw})
+	config := fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- cluster:
+    certificate-authority-data: %s
+    server: %s
+  name: fake
+contexts:
+- context:
+    cluster: fake
+    user: test
+  name: fake
+current-context: fake
+users:
+- name: test
+  user:
+    token: %s
+`, base64.StdEncoding.EncodeToString(ca), s.URL, token)
+
+	path := filepath.Join(dir, "kubeconfig")
+	if err := ioutil.WriteFile(path, []byte(config), 0600); err != nil {
+		panic(err)
+	}
+	return path
+}
+
+// Put stores obj as if it had been created. Its namespace is taken from its
+// metadata.
+func (s *Server) Put(r kube.Resource, obj interface{}) {
+	raw, err := json.Marshal(obj)
+	if err != nil {
+		panic(err)
+	}
+	var m map[string]interface{}
+	json.Unmarshal(raw, &m)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.store(key(r), m)
+	metadata := m["metadata"].(map[string]interface{})
+	namespace, _ := metadata["namespace"].(string)
+	delete(s.managed, key(r)+" "+namespace+"/"+metadata["name"].(string))
+}
+
+// Get returns the stored object, or nil.
+func (s *Server) Get(r kube.Resource, namespace, name string) map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.objects[key(r)][namespace+"/"+name]
+}
+
+// List returns the stored objects of the resource in namespace, or in all
+// namespaces if it is empty, ordered by namespace and name.
+func (s *Server) List(r kube.Resource, namespace string) []map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ids []string
+	for id := range s.objects[key(r)] {
+		if namespace == "" || strings.HasPrefix(id, namespace+"/") {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	objects := make([]map[string]interface{}, len(ids))
+	for i, id := range ids {
+		objects[i] = s.objects[key(r)][id]
+	}
+	return objects
+}
+
+// Remove deletes a stored object, as the kubelet does once a terminating
+// pod is gone.
+func (s *Server) Remove(r kube.Resource, namespace, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects[key(r)], namespace+"/"+name)
+	delete(s.managed, key(r)+" "+namespace+"/"+name)
+}
+
+// BlockEvictions makes the next times evictions of the pod fail with
+// TooManyRequests, as if a PodDisruptionBudget forbade them.
+func (s *Server) BlockEvictions(namespace, pod string, times int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.blocked[namespace+"/"+pod] = times
+}
+
+// SetLogs sets what the log subresource of the pod returns.
+func (s *Server) SetLogs(namespace, pod, logs string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.logs[namespace+"/"+pod] = logs
+}
+
+// Allow makes subject access reviews for user report that verb is allowed
+// on resource, which may include a subresource as in pods/eviction. Every
+// other review is denied.
+func (s *Server) Allow(user, verb, resource string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.grants[user+" "+verb+" "+resource] = true
+}
+
+// KeepEvictedPods makes evictions mark pods as terminating rather than
+// remove them, so that they linger until Remove is called.
+func (s *Server) KeepEvictedPods() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keepEvicted = true
+}
+
+// SetController makes the server call reconcile on every object of the
+// resource that is created or patched, as a controller would, for example
+// to give it the status of a finished rollout.
+func (s *Server) SetController(r kube.Resource, reconcile func(obj map[string]interface{})) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.controllers[key(r)] = reconcile
+}
+
+// FailRequests makes the next times requests with method for path fail
+// with an InternalError.
+func (s *Server) FailRequests(method, path string, times int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[method+" "+path] = times
+}
+
+// SetLatency delays every response, so that concurrent requests overlap.
+func (s *Server) SetLatency(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency = d
+}
+
+// MaxInFlight returns the largest number of requests that were being served
+// at the same time.
+func (s *Server) MaxInFlight() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.maxInFlight
+}
+
+// RequestLines returns the method and path of every request received.
+func (s *Server) RequestLines() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lines := make([]string, len(s.Requests))
+	for i, r := range s.Requests {
+		lines[i] = r.String()
+	}
+	return lines
+}
+
+func key(r kube.Resource) string {
+	return r.GroupVersion + "/" + r.Name
+}
+
+func (s *Server) store(collection string, obj map[string]interface{}) {
+	s.version++
+	metadata := obj["metadata"].(map[string]interface{})
+	metadata["resourceVersion"] = strconv.Itoa(s.version)
+	if _, ok := metadata["creationTimestamp"]; !ok {
+		metadata["creationTimestamp"] = time.Now().UTC().Format(time.RFC3339)
+	}
+	if _, ok := metadata["uid"]; !ok {
+		metadata["uid"] = fmt.Sprintf("uid-%d", s.version)
+	}
+	if s.objects[collection] == nil {
+		s.objects[collection] = map[string]map[string]interface{}{}
+	}
+	namespace, _ := metadata["namespace"].(string)
+	s.objects[collection][namespace+"/"+metadata["name"].(string)] = obj
+}
+
+func (s *Server) reconcile(collection string, obj map[string]interface{}) {
+	if reconcile := s.controllers[collection]; reconcile != nil {
+		reconcile(obj)
+	}
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.inFlight++
+	if s.inFlight > s.maxInFlight {
+		s.maxInFlight = s.inFlight
+	}
+	latency := s.latency
+	s.mu.Unlock()
+	time.Sleep(latency)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer func() { s.inFlight-- }()
+	raw, _ := ioutil.ReadAll(r.Body)
+	s.Requests = append(s.Requests, Request{
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Query:  r.URL.RawQuery,
+		Body:   string(raw),
+		Token:  strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "),
+		As:     r.Header.Get("Impersonate-User"),
+	})
+
+	if n := s.failures[r.Method+" "+r.URL.Path]; n > 0 {
+		s.failures[r.Method+" "+r.URL.Path] = n - 1
+		status(w, http.StatusInternalServerError, "InternalError", "injected failure")
+		return
+	}
+
+	if r.URL.Path == "/version" && r.Method == http.MethodGet {
+		v := strings.SplitN(strings.TrimPrefix(s.gitVersion, "v"), ".", 3)
+		respond(w, http.StatusOK, kube.VersionInfo{Major: v[0], Minor: v[1], GitVersion: s.gitVersion})
+		return
+	}
+
+	if checks, ok := healthChecks[r.URL.Path]; ok && r.Method == http.MethodGet {
+		s.health(w, r, checks)
+		return
+	}
+
+	if m := discoveryPath.FindStringSubmatch(r.URL.Path); m != nil && r.Method == http.MethodGet {
+		groupVersion := strings.TrimPrefix(strings.TrimPrefix(m[1], "api/"), "apis/")
+		resources, ok := s.resources[groupVersion]
+		if !ok {
+			status(w, http.StatusNotFound, "NotFound", "the server could not find the requested resource")
+			return
+		}
+		respond(w, http.StatusOK, kube.APIResourceList{TypeMeta: kube.TypeMeta{Kind: "APIResourceList"}, GroupVersion: groupVersion, Resources: resources})
+		return
+	}
+
+	m := apiPath.FindStringSubmatch(r.URL.Path)
+	if m == nil {
+		status(w, http.StatusNotFound, "NotFound", "unknown path "+r.URL.Path)
+		return
+	}
+	groupVersion, namespace, resource, name, subresource := m[1], m[2], m[3], m[4], m[5]
+	if groupVersion == "api/v1" {
+		groupVersion = "v1"
+	}
+	collection := strings.TrimPrefix(groupVersion, "apis/") + "/" + resource
+	id := namespace + "/" + name
+
+	switch {
+	case subresource == "eviction" && r.Method == http.MethodPost:
+		pod, ok := s.objects[collection][id]
+		if !ok {
+			status(w, http.StatusNotFound, "NotFound", fmt.Sprintf("pods %q not found", name))
+			return
+		}
+		if n := s.blocked[id]; n > 0 {
+			s.blocked[id] = n - 1
+			status(w, http.StatusTooManyRequests, "TooManyRequests", "Cannot evict pod as it would violate the pod's disruption budget.")
+			return
+		}
+		if s.keepEvicted {
+			pod["metadata"].(map[string]interface{})["deletionTimestamp"] = time.Now().UTC().Format(time.RFC3339)
+		} else {
+			delete(s.objects[collection], id)
+		}
+		respond(w, http.StatusCreated, map[string]interface{}{"kind": "Status", "status": "Success"})
+
+	case subresource == "log" && r.Method == http.MethodGet:
+		if _, ok := s.objects[collection][id]; !ok {
+			status(w, http.StatusNotFound, "NotFound", fmt.Sprintf("pods %q not found", name))
+			return
+		}
+		w.Write([]byte(s.logs[id]))
+
+	case resource == "subjectaccessreviews" && r.Method == http.MethodPost:
+		var review struct {
+			Spec struct {
+				User               string
+				ResourceAttributes struct{ Verb, Resource, Subresource string }
+			}
+		}
+		if err := json.Unmarshal(raw, &review); err != nil {
+			status(w, http.StatusBadRequest, "BadRequest", err.Error())
+			return
+		}
+		attrs := review.Spec.ResourceAttributes
+		if attrs.Subresource != "" {
+			attrs.Resource += "/" + attrs.Subresource
+		}
+		respond(w, http.StatusCreated, map[string]interface{}{
+			"kind":   "SubjectAccessReview",
+			"status": map[string]interface{}{"allowed": s.grants[review.Spec.User+" "+attrs.Verb+" "+attrs.Resource]},
+		})
+
+	case name == "" && r.Method == http.MethodGet:
+		query := r.URL.Query()
+		var ids []string
+		for id := range s.objects[collection] {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		items := []interface{}{}
+		for _, id := range ids {
+			obj := s.objects[collection][id]
+			if namespace != "" && !strings.HasPrefix(id, namespace+"/") {
+				continue
+			}
+			labels, _ := obj["metadata"].(map[string]interface{})["labels"].(map[string]interface{})
+			if matches(selector(query.Get("labelSelector")), labels) && matchesFields(obj, query.Get("fieldSelector")) {
+				items = append(items, obj)
+			}
+		}
+		respond(w, http.StatusOK, map[string]interface{}{"kind": "List", "items": items})
+
+	case name == "" && r.Method == http.MethodPost:
+		var obj map[string]interface{}
+		if err := json.Unmarshal(raw, &obj); err != nil {
+			status(w, http.StatusBadRequest, "BadRequest", err.Error())
+			return
+		}
+		metadata := obj["metadata"].(map[string]interface{})
+		if namespace != "" {
+			metadata["namespace"] = namespace
+		}
+		if generateName, ok := metadata["generateName"].(string); ok && metadata["name"] == nil {
+			metadata["name"] = fmt.Sprintf("%s%d", generateName, s.version+1)
+		}
+		objName, _ := metadata["name"].(string)
+		if _, exists := s.objects[collection][namespace+"/"+objName]; exists {
+			status(w, http.StatusConflict, "AlreadyExists", fmt.Sprintf("%s %q already exists", resource, objName))
+			return
+		}
+		if r.URL.Query().Get("dryRun") == "All" {
+			respond(w, http.StatusCreated, obj)
+			return
+		}
+		s.update(collection, namespace+"/"+objName, r.URL.Query().Get("fieldManager"), nil, obj)
+		s.store(collection, obj)
+		s.reconcile(collection, obj)
+		respond(w, http.StatusCreated, obj)
+
+	case r.Method == http.MethodGet:
+		obj, ok := s.objects[collection][id]
+		if !ok {
+			status(w, http.StatusNotFound, "NotFound", fmt.Sprintf("%s %q not found", resource, name))
+			return
+		}
+		respond(w, http.StatusOK, s.withManagedFields(collection, id, obj))
+
+	case r.Method == http.MethodPut:
+		existing, ok := s.objects[collection][id]
+		if !ok {
+			status(w, http.StatusNotFound, "NotFound", fmt.Sprintf("%s %q not found", resource, name))
+			return
+		}
+		var obj map[string]interface{}
+		if err := json.Unmarshal(raw, &obj); err != nil {
+			status(w, http.StatusBadRequest, "BadRequest", err.Error())
+			return
+		}
+		metadata := obj["metadata"].(map[string]interface{})
+		old := existing["metadata"].(map[string]interface{})
+		if metadata["resourceVersion"] != old["resourceVersion"] {
+			status(w, http.StatusConflict, "Conflict", fmt.Sprintf("Operation cannot be fulfilled on %s %q: the object has been modified", resource, name))
+			return
+		}
+		if namespace != "" {
+			metadata["namespace"] = namespace
+		}
+		metadata["uid"], metadata["creationTimestamp"] = old["uid"], old["creationTimestamp"]
+		s.replace(collection, id, r.URL.Query().Get("fieldManager"), existing, obj)
+		s.store(collection, obj)
+		s.reconcile(collection, obj)
+		respond(w, http.StatusOK, obj)
+
+	case r.Method == http.MethodPatch && r.Header.Get("Content-Type") == kube.ApplyPatch:
+		s.serverSideApply(w, r, collection, namespace, resource, name, raw)
+
+	case r.Method == http.MethodPatch:
+		obj, ok := s.objects[collection][id]
+		if !ok {
+			status(w, http.StatusNotFound, "NotFound", fmt.Sprintf("%s %q not found", resource, name))
+			return
+		}
+		var patch map[string]interface{}
+		if err := json.Unmarshal(raw, &patch); err != nil {
+			status(w, http.StatusBadRequest, "BadRequest", err.Error())
+			return
+		}
+		s.update(collection, id, r.URL.Query().Get("fieldManager"), obj, patch)
+		mergePatch(obj, patch, r.Header.Get("Content-Type") == kube.StrategicMergePatch)
+		s.store(collection, obj)
+		s.reconcile(collection, obj)
+		respond(w, http.StatusOK, obj)
+
+	case r.Method == http.MethodDelete:
+		if _, ok := s.objects[collection][id]; !ok {
+			status(w, http.StatusNotFound, "NotFound", fmt.Sprintf("%s %q not found", resource, name))
+			return
+		}
+		delete(s.objects[collection], id)
+		delete(s.managed, collection+" "+id)
+		respond(w, http.StatusOK, map[string]interface{}{"kind": "Status", "status": "Success"})
+
+	default:
+		status(w, http.StatusMethodNotAllowed, "MethodNotAllowed", r.Method+" not supported")
+	}
+}
+
+// mergePatch applies a JSON merge patch (RFC 7386) to obj. A strategic
+// merge patch differs in merging lists of objects by their "type", as the
+// API server does for conditions; other list keys are not known here.
+// health answers as the API server does: the checks are listed if the
+// request is verbose or any check failed, which makes the answer a 500.
+func (s *Server) health(w http.ResponseWriter, r *http.Request, checks []string) {
+	endpoint := strings.TrimPrefix(r.URL.Path, "/")
+	v := strings.SplitN(strings.TrimPrefix(s.gitVersion, "v"), ".", 3)
+	if minor, _ := strconv.Atoi(v[1]); endpoint != "healthz" && v[0] == "1" && minor < 16 {
+		status(w, http.StatusNotFound, "NotFound", "the server could not find the requested resource")
+		return
+	}
+	var body strings.Builder
+	failed := false
+	for _, name := range checks {
+		if reason, ok := s.checks[name]; ok {
+			fmt.Fprintf(&body, "[-]%s failed: %s\n", name, reason)
+			failed = true
+		} else {
+			fmt.Fprintf(&body, "[+]%s ok\n", name)
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	switch _, verbose := r.URL.Query()["verbose"]; {
+	case failed:
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "%s%s check failed\n", body.String(), endpoint)
+	case verbose:
+		fmt.Fprintf(w, "%s%s check passed\n", body.String(), endpoint)
+	default:
+		fmt.Fprint(w, "ok")
+	}
+}
+
+func mergePatch(obj, patch map[string]interface{}, strategic bool) {
+	for k, v := range patch {
+		switch v := v.(type) {
+		case nil:
+			delete(obj, k)
+		case map[string]interface{}:
+			existing, ok := obj[k].(map[string]interface{})
+			if !ok {
+				existing = map[string]interface{}{}
+				obj[k] = existing
+			}
+			mergePatch(existing, v, strategic)
+		case []interface{}:
+			existing, ok := obj[k].([]interface{})
+			if !strategic || !ok {
+				obj[k] = v
+				continue
+			}
+			obj[k] = mergeByType(existing, v)
+		default:
+			obj[k] = v
+		}
+	}
+}
+
+func mergeByType(existing, patch []interface{}) []interface{} {
+	for _, p := range patch {
+		item, _ := p.(map[string]interface{})
+		merged := false
+		for _, e := range existing {
+			if e, ok := e.(map[string]interface{}); ok && item != nil && e["type"] != nil && e["type"] == item["type"] {
+				mergePatch(e, item, true)
+				merged = true
+			}
+		}
+		if !merged {
+			existing = append(existing, p)
+		}
+	}
+	return existing
+}
+
+type requirement struct {
+	key   string
+	value string
+	op    string
+}
+
+// selector parses equality-based selectors such as "a=b,c!=d,e", where a
+// bare key requires the key to exist.
+func selector(s string) []requirement {
+	var reqs []requirement
+	for _, term := range strings.Split(s, ",") {
+		switch {
+		case term == "":
+		case strings.Contains(term, "!="):
+			kv := strings.SplitN(term, "!=", 2)
+			reqs = append(reqs, requirement{kv[0], kv[1], "!="})
+		case strings.Contains(term, "="):
+			kv := strings.SplitN(strings.Replace(term, "==", "=", 1), "=", 2)
+			reqs = append(reqs, requirement{kv[0], kv[1], "="})
+		default:
+			reqs = append(reqs, requirement{key: term, op: "exists"})
+		}
+	}
+	return reqs
+}
+
+func matches(reqs []requirement, values map[string]interface{}) bool {
+	for _, r := range reqs {
+		value, ok := values[r.key]
+		switch {
+		case r.op == "exists" && !ok:
+			return false
+		case r.op == "=" && (!ok || fmt.Sprint(value) != r.value):
+			return false
+		case r.op == "!=" && ok && fmt.Sprint(value) == r.value:
+			return false
+		}
+	}
+	return true
+}
+
+// matchesFields applies a field selector such as spec.nodeName=node-1 by
+// looking up the dotted path in obj. Missing fields compare as empty.
+func matchesFields(obj map[string]interface{}, fieldSelector string) bool {
+	for _, r := range selector(fieldSelector) {
+		var value interface{} = obj
+		for _, part := range strings.Split(r.key, ".") {
+			m, _ := value.(map[string]interface{})
+			value = m[part]
+		}
+		s := ""
+		if value != nil {
+			s = fmt.Sprint(value)
+		}
+		if !matches([]requirement{r}, map[string]interface{}{r.key: s}) {
+			return false
+		}
+	}
+	return true
+}
+
+func respond(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
+
+func status(w http.ResponseWriter, code int, reason, message string) {
+	respond(w, code, map[string]interface{}{
+		"kind":    "Status",
+		"status":  "Failure",
+		"reason":  reason,
+		"message": message,
+		"code":    code,
+	})
+}
@@ -0,0 +1,122 @@
+package kube
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// ReadManifests reads the objects in the YAML or JSON files at paths. A
+// directory stands for the *.yml, *.yaml and *.json files in it, in name
+// order, as with kubectl apply -f. Files may hold several documents, and a
+// List is expanded into its items. Empty documents are skipped.
+func ReadManifests(paths ...string) ([]Object, error) {
+	var objects []Object
+	for _, path := range paths {
+		files, err := manifestFiles(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			raw, err := ioutil.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+			objs, err := DecodeManifest(raw)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", file, err)
+			}
+			objects = append(objects, objs...)
+		}
+	}
+	return objects, nil
+}
+
+func manifestFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+	entries, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, e := range entries {
+		switch filepath.Ext(e.Name()) {
+		case ".yml", ".yaml", ".json":
+			if !e.IsDir() {
+				files = append(files, filepath.Join(path, e.Name()))
+			}
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// DecodeManifest decodes the YAML or JSON documents in raw.
+func DecodeManifest(raw []byte) ([]Object, error) {
+	var objects []Object
+	decoder := yaml.NewDecoder(bytes.NewReader(raw))
+	for i := 1; ; i++ {
+		var doc interface{}
+		err := decoder.Decode(&doc)
+		if err == io.EOF {
+			return objects, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("document %d: %v", i, err)
+		}
+		if doc == nil {
+			continue
+		}
+		m, ok := jsonValue(doc).(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("document %d is not an object", i)
+		}
+		obj := Object(m)
+		if strings.HasSuffix(obj.Kind(), "List") && obj["items"] != nil {
+			items, _ := obj["items"].([]interface{})
+			for _, item := range items {
+				if m, ok := item.(map[string]interface{}); ok {
+					objects = append(objects, Object(m))
+				}
+			}
+			continue
+		}
+		if obj.APIVersion() == "" || obj.Kind() == "" || obj.Name() == "" {
+			return nil, fmt.Errorf("document %d needs apiVersion, kind and metadata.name", i)
+		}
+		objects = append(objects, obj)
+	}
+}
+
+// jsonValue turns the maps yaml.v2 decodes, which have interface{} keys,
+// into maps with string keys.
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			m[fmt.Sprint(k)] = jsonValue(val)
+		}
+		return m
+	case []interface{}:
+		for i := range v {
+			v[i] = jsonValue(v[i])
+		}
+		return v
+	default:
+		return v
+	}
+}
@@ -0,0 +1,111 @@
+package kube
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Object is an API object of any kind, as read from a manifest or returned
+// by the API server. Nested values are maps, slices and JSON scalars.
+type Object map[string]interface{}
+
+func (o Object) APIVersion() string { return o.str("apiVersion") }
+func (o Object) Kind() string       { return o.str("kind") }
+func (o Object) Name() string       { return o.metadata().str("name") }
+func (o Object) Namespace() string  { return o.metadata().str("namespace") }
+
+func (o Object) SetNamespace(namespace string) {
+	o.metadata()["namespace"] = namespace
+}
+
+func (o Object) Labels() map[string]string {
+	return o.metadata().strings("labels")
+}
+
+func (o Object) SetLabel(key, value string) {
+	o.metadata().child("labels")[key] = value
+}
+
+func (o Object) Annotations() map[string]string {
+	return o.metadata().strings("annotations")
+}
+
+func (o Object) SetAnnotation(key, value string) {
+	o.metadata().child("annotations")[key] = value
+}
+
+// Field returns the value at the dotted path, e.g. "status.replicas", or nil.
+func (o Object) Field(path string) interface{} {
+	var v interface{} = map[string]interface{}(o)
+	for _, key := range strings.Split(path, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[key]
+	}
+	return v
+}
+
+// String names the object as kind namespace/name, or kind name if it is not
+// namespaced.
+func (o Object) String() string {
+	if ns := o.Namespace(); ns != "" {
+		return fmt.Sprintf("%s %s/%s", o.Kind(), ns, o.Name())
+	}
+	return fmt.Sprintf("%s %s", o.Kind(), o.Name())
+}
+
+// DeepCopy copies the object through JSON, which also turns numbers into
+// float64 as in objects decoded from the API server.
+func (o Object) DeepCopy() Object {
+	raw, err := json.Marshal(o)
+	if err != nil {
+		panic(err)
+	}
+	var copied Object
+	if err := json.Unmarshal(raw, &copied); err != nil {
+		panic(err)
+	}
+	return copied
+}
+
+func (o Object) str(key string) string {
+	s, _ := o[key].(string)
+	return s
+}
+
+func (o Object) metadata() Object {
+	return o.child("metadata")
+}
+
+// child returns the map at key, creating it if it is missing.
+func (o Object) child(key string) Object {
+	switch m := o[key].(type) {
+	case map[string]interface{}:
+		return m
+	case Object:
+		return m
+	}
+	m := map[string]interface{}{}
+	o[key] = m
+	return m
+}
+
+func (o Object) strings(key string) map[string]string {
+	m, _ := o[key].(map[string]interface{})
+	if m == nil {
+		return nil
+	}
+	strs := make(map[string]string, len(m))
+	for k, v := range m {
+		strs[k], _ = v.(string)
+	}
+	return strs
+}
+
+// ObjectList is a list of objects of any kind.
+type ObjectList struct {
+	Items []Object `json:"items"`
+}
@@ -0,0 +1,284 @@
+package kube
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// The types below mirror the subset of the Kubernetes API objects that the
+// tools read and write. Field names and JSON tags follow the upstream API.
+
+type TypeMeta struct {
+	APIVersion string `json:"apiVersion,omitempty"`
+	Kind       string `json:"kind,omitempty"`
+}
+
+type ObjectMeta struct {
+	Name              string            `json:"name,omitempty"`
+	GenerateName      string            `json:"generateName,omitempty"`
+	Namespace         string            `json:"namespace,omitempty"`
+	UID               string            `json:"uid,omitempty"`
+	Labels            map[string]string `json:"labels,omitempty"`
+	Annotations       map[string]string `json:"annotations,omitempty"`
+	OwnerReferences   []OwnerReference  `json:"ownerReferences,omitempty"`
+	ResourceVersion   string            `json:"resourceVersion,omitempty"`
+	CreationTimestamp string            `json:"creationTimestamp,omitempty"`
+	DeletionTimestamp string            `json:"deletionTimestamp,omitempty"`
+}
+
+type OwnerReference struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+	UID        string `json:"uid,omitempty"`
+	Controller *bool  `json:"controller,omitempty"`
+}
+
+// ControllerOf returns the owner reference that manages the object, or nil.
+func ControllerOf(meta ObjectMeta) *OwnerReference {
+	for i, ref := range meta.OwnerReferences {
+		if ref.Controller != nil && *ref.Controller {
+			return &meta.OwnerReferences[i]
+		}
+	}
+	return nil
+}
+
+type Node struct {
+	TypeMeta
+	ObjectMeta `json:"metadata,omitempty"`
+	Spec       NodeSpec   `json:"spec,omitempty"`
+	Status     NodeStatus `json:"status,omitempty"`
+}
+
+type NodeSpec struct {
+	Unschedulable bool `json:"unschedulable,omitempty"`
+}
+
+type NodeStatus struct {
+	Conditions []NodeCondition `json:"conditions,omitempty"`
+}
+
+type NodeCondition struct {
+	Type               string `json:"type"`
+	Status             string `json:"status"`
+	Reason             string `json:"reason,omitempty"`
+	Message            string `json:"message,omitempty"`
+	LastTransitionTime string `json:"lastTransitionTime,omitempty"`
+}
+
+// Condition returns the node's condition of the given type, or nil.
+func (n Node) Condition(conditionType string) *NodeCondition {
+	for i := range n.Status.Conditions {
+		if n.Status.Conditions[i].Type == conditionType {
+			return &n.Status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+type NodeList struct {
+	Items []Node `json:"items"`
+}
+
+type Pod struct {
+	TypeMeta
+	ObjectMeta `json:"metadata,omitempty"`
+	Spec       PodSpec   `json:"spec,omitempty"`
+	Status     PodStatus `json:"status,omitempty"`
+}
+
+type PodSpec struct {
+	NodeName                      string   `json:"nodeName,omitempty"`
+	Volumes                       []Volume `json:"volumes,omitempty"`
+	TerminationGracePeriodSeconds *int64   `json:"terminationGracePeriodSeconds,omitempty"`
+}
+
+type Volume struct {
+	Name     string    `json:"name"`
+	EmptyDir *struct{} `json:"emptyDir,omitempty"`
+}
+
+type PodStatus struct {
+	Phase string `json:"phase,omitempty"`
+}
+
+type PodList struct {
+	Items []Pod `json:"items"`
+}
+
+// Key returns namespace/name, the way kubectl identifies a pod in logs.
+func (p Pod) Key() string {
+	return p.Namespace + "/" + p.Name
+}
+
+type DeleteOptions struct {
+	TypeMeta
+	GracePeriodSeconds *int64 `json:"gracePeriodSeconds,omitempty"`
+	PropagationPolicy  string `json:"propagationPolicy,omitempty"`
+}
+
+// Eviction asks the API server to delete a pod, unless that would violate a
+// PodDisruptionBudget.
+type Eviction struct {
+	TypeMeta
+	ObjectMeta    `json:"metadata,omitempty"`
+	DeleteOptions *DeleteOptions `json:"deleteOptions,omitempty"`
+}
+
+type PodDisruptionBudget struct {
+	TypeMeta
+	ObjectMeta `json:"metadata,omitempty"`
+	Spec       PodDisruptionBudgetSpec   `json:"spec,omitempty"`
+	Status     PodDisruptionBudgetStatus `json:"status,omitempty"`
+}
+
+type PodDisruptionBudgetSpec struct {
+	MinAvailable   *IntOrString   `json:"minAvailable,omitempty"`
+	MaxUnavailable *IntOrString   `json:"maxUnavailable,omitempty"`
+	Selector       *LabelSelector `json:"selector,omitempty"`
+}
+
+type PodDisruptionBudgetStatus struct {
+	DisruptionsAllowed int32 `json:"disruptionsAllowed"`
+	CurrentHealthy     int32 `json:"currentHealthy"`
+	DesiredHealthy     int32 `json:"desiredHealthy"`
+	ExpectedPods       int32 `json:"expectedPods"`
+}
+
+type PodDisruptionBudgetList struct {
+	Items []PodDisruptionBudget `json:"items"`
+}
+
+type LabelSelector struct {
+	MatchLabels      map[string]string          `json:"matchLabels,omitempty"`
+	MatchExpressions []LabelSelectorRequirement `json:"matchExpressions,omitempty"`
+}
+
+type LabelSelectorRequirement struct {
+	Key      string   `json:"key"`
+	Operator string   `json:"operator"`
+	Values   []string `json:"values,omitempty"`
+}
+
+// Matches reports whether labels satisfy the selector. A nil selector
+// matches nothing and an empty one everything, as for PodDisruptionBudgets.
+func (s *LabelSelector) Matches(labels map[string]string) bool {
+	if s == nil {
+		return false
+	}
+	for k, v := range s.MatchLabels {
+		if labels[k] != v {
+			return false
+		}
+	}
+	for _, r := range s.MatchExpressions {
+		value, exists := labels[r.Key]
+		in := false
+		for _, v := range r.Values {
+			in = in || exists && v == value
+		}
+		switch r.Operator {
+		case "In":
+			if !in {
+				return false
+			}
+		case "NotIn":
+			if in {
+				return false
+			}
+		case "Exists":
+			if !exists {
+				return false
+			}
+		case "DoesNotExist":
+			if exists {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// IntOrString holds a value that the API may express either as a number or
+// as a string, such as a percentage.
+type IntOrString struct {
+	IntVal int32
+	StrVal string
+}
+
+func (i IntOrString) MarshalJSON() ([]byte, error) {
+	if i.StrVal != "" {
+		return json.Marshal(i.StrVal)
+	}
+	return json.Marshal(i.IntVal)
+}
+
+func (i *IntOrString) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		return json.Unmarshal(data, &i.StrVal)
+	}
+	return json.Unmarshal(data, &i.IntVal)
+}
+
+func (i IntOrString) String() string {
+	if i.StrVal != "" {
+		return i.StrVal
+	}
+	return strconv.Itoa(int(i.IntVal))
+}
+
+// VolumeAttachment records that a volume is to be attached to a node. The
+// attach/detach controller creates them for CSI volumes.
+type VolumeAttachment struct {
+	TypeMeta
+	ObjectMeta `json:"metadata,omitempty"`
+	Spec       VolumeAttachmentSpec   `json:"spec,omitempty"`
+	Status     VolumeAttachmentStatus `json:"status,omitempty"`
+}
+
+type VolumeAttachmentSpec struct {
+	Attacher string `json:"attacher"`
+	NodeName string `json:"nodeName"`
+	Source   struct {
+		PersistentVolumeName *string `json:"persistentVolumeName,omitempty"`
+	} `json:"source"`
+}
+
+type VolumeAttachmentStatus struct {
+	Attached bool `json:"attached"`
+}
+
+type VolumeAttachmentList struct {
+	Items []VolumeAttachment `json:"items"`
+}
+
+// Event reports something that happened to an object, as shown by kubectl
+// describe and kubectl get events.
+type Event struct {
+	TypeMeta
+	ObjectMeta     `json:"metadata,omitempty"`
+	InvolvedObject ObjectReference `json:"involvedObject"`
+	Reason         string          `json:"reason,omitempty"`
+	Message        string          `json:"message,omitempty"`
+	Source         EventSource     `json:"source,omitempty"`
+	FirstTimestamp string          `json:"firstTimestamp,omitempty"`
+	LastTimestamp  string          `json:"lastTimestamp,omitempty"`
+	Count          int32           `json:"count,omitempty"`
+	Type           string          `json:"type,omitempty"`
+}
+
+type ObjectReference struct {
+	APIVersion string `json:"apiVersion,omitempty"`
+	Kind       string `json:"kind,omitempty"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name,omitempty"`
+	UID        string `json:"uid,omitempty"`
+}
+
+type EventSource struct {
+	Component string `json:"component,omitempty"`
+	Host      string `json:"host,omitempty"`
+}
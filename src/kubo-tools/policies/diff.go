@@ -0,0 +1,93 @@
+// Package policies keeps the RBAC and PodSecurityPolicy objects of the
+// kubernetes-roles job as the job renders them. The post-start script
+// applies them once; a reconciler then compares the live objects with the
+// rendered policies, reports the objects that drifted, such as a role an
+// operator edited or a policy that was deleted, and can apply them again.
+package policies
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"kubo-tools/kube"
+)
+
+// Difference is a field whose live value is not the one the policy sets.
+type Difference struct {
+	// Field is the path of the field, e.g. .rules[0].verbs.
+	Field string
+	// Live is the live value, or nil if the field is missing.
+	Live interface{}
+	Want interface{}
+}
+
+func (d Difference) String() string {
+	if d.Live == nil {
+		return fmt.Sprintf("%s is missing, want %s", d.Field, value(d.Want))
+	}
+	return fmt.Sprintf("%s is %s, want %s", d.Field, value(d.Live), value(d.Want))
+}
+
+func value(v interface{}) string {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(raw)
+}
+
+// Compare returns the fields of the policy that the live object does not
+// have, sorted by path. Only the fields the policy sets are compared, so
+// fields the API server defaults and labels others add are not drift. Of
+// the metadata, only labels and annotations are compared. Lists must have
+// as many items as the policy, each matching its item in the policy.
+func Compare(policy, live kube.Object) []Difference {
+	policy, live = policy.DeepCopy(), live.DeepCopy()
+	var diffs []Difference
+	for key, want := range policy {
+		switch key {
+		case "apiVersion", "kind", "status":
+		case "metadata":
+			wantMeta, _ := want.(map[string]interface{})
+			liveMeta, _ := live["metadata"].(map[string]interface{})
+			for _, k := range []string{"labels", "annotations"} {
+				if v, ok := wantMeta[k]; ok {
+					diffs = compare(diffs, ".metadata."+k, v, liveMeta[k])
+				}
+			}
+		default:
+			diffs = compare(diffs, "."+key, want, live[key])
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].Field < diffs[j].Field })
+	return diffs
+}
+
+func compare(diffs []Difference, path string, want, live interface{}) []Difference {
+	switch w := want.(type) {
+	case map[string]interface{}:
+		l, ok := live.(map[string]interface{})
+		if !ok {
+			return append(diffs, Difference{Field: path, Live: live, Want: want})
+		}
+		for k, v := range w {
+			diffs = compare(diffs, path+"."+k, v, l[k])
+		}
+		return diffs
+	case []interface{}:
+		l, ok := live.([]interface{})
+		if !ok || len(l) != len(w) {
+			return append(diffs, Difference{Field: path, Live: live, Want: want})
+		}
+		for i := range w {
+			diffs = compare(diffs, fmt.Sprintf("%s[%d]", path, i), w[i], l[i])
+		}
+		return diffs
+	}
+	if !reflect.DeepEqual(want, live) {
+		diffs = append(diffs, Difference{Field: path, Live: live, Want: want})
+	}
+	return diffs
+}
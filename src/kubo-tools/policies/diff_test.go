@@ -0,0 +1,68 @@
+package policies_test
+
+import (
+	"kubo-tools/kube"
+	"kubo-tools/policies"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Compare", func() {
+	policy := kube.Object{
+		"apiVersion": "rbac.authorization.k8s.io/v1",
+		"kind":       "ClusterRoleBinding",
+		"metadata":   map[string]interface{}{"name": "drain", "labels": map[string]interface{}{"team": "kubo"}},
+		"subjects":   []interface{}{map[string]interface{}{"kind": "User", "name": "kubelet-drain"}},
+		"roleRef":    map[string]interface{}{"kind": "ClusterRole", "name": "drain"},
+	}
+
+	cases := []struct {
+		name string
+		live kube.Object
+		want []string
+	}{{
+		name: "ignores metadata the server sets, labels others add and defaulted fields",
+		live: kube.Object{
+			"apiVersion": "rbac.authorization.k8s.io/v1",
+			"kind":       "ClusterRoleBinding",
+			"metadata":   map[string]interface{}{"name": "drain", "uid": "1", "resourceVersion": "7", "labels": map[string]interface{}{"team": "kubo", "owner": "ops"}},
+			"subjects":   []interface{}{map[string]interface{}{"kind": "User", "name": "kubelet-drain", "apiGroup": "rbac.authorization.k8s.io"}},
+			"roleRef":    map[string]interface{}{"kind": "ClusterRole", "name": "drain", "apiGroup": "rbac.authorization.k8s.io"},
+		},
+	}, {
+		name: "reports changed and missing fields by path",
+		live: kube.Object{
+			"metadata": map[string]interface{}{"name": "drain"},
+			"subjects": []interface{}{map[string]interface{}{"kind": "User", "name": "someone"}},
+			"roleRef":  map[string]interface{}{"kind": "ClusterRole", "name": "drain"},
+		},
+		want: []string{
+			`.metadata.labels is missing, want {"team":"kubo"}`,
+			`.subjects[0].name is "someone", want "kubelet-drain"`,
+		},
+	}, {
+		name: "reports a list with items added or removed as a whole",
+		live: kube.Object{
+			"metadata": map[string]interface{}{"name": "drain", "labels": map[string]interface{}{"team": "kubo"}},
+			"subjects": []interface{}{
+				map[string]interface{}{"kind": "User", "name": "kubelet-drain"},
+				map[string]interface{}{"kind": "Group", "name": "system:authenticated"},
+			},
+			"roleRef": map[string]interface{}{"kind": "ClusterRole", "name": "drain"},
+		},
+		want: []string{
+			`.subjects is [{"kind":"User","name":"kubelet-drain"},{"kind":"Group","name":"system:authenticated"}], want [{"kind":"User","name":"kubelet-drain"}]`,
+		},
+	}}
+	for _, c := range cases {
+		c := c
+		It(c.name, func() {
+			var got []string
+			for _, d := range policies.Compare(policy, c.live) {
+				got = append(got, d.String())
+			}
+			Expect(got).To(Equal(c.want))
+		})
+	}
+})
@@ -0,0 +1,130 @@
+package policies
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"kubo-tools/kube"
+)
+
+// Metrics keeps the outcome of the checks and serves it in the Prometheus
+// text format. It is safe for concurrent use.
+type Metrics struct {
+	mu sync.Mutex
+	// drifted is whether each policy differs from its live object after the
+	// last check that read it, by kind, namespace and name.
+	drifted  map[[3]string]bool
+	detected map[string]float64
+	// reapplied and failures count policies applied again, and policies
+	// that could not be.
+	reapplied float64
+	failures  float64
+	errors    float64
+	checks    float64
+	lastCheck time.Time
+	now       func() time.Time
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{drifted: map[[3]string]bool{}, detected: map[string]float64{}, now: time.Now}
+}
+
+// record updates the metrics after a check. The policies in unknown could
+// not be read and keep the state of the previous check.
+func (m *Metrics) record(policies []kube.Object, drifts []*Drift, unknown map[string]bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.checks++
+	m.lastCheck = m.now()
+	if len(unknown) > 0 {
+		m.errors++
+	}
+	for _, p := range policies {
+		if !unknown[p.String()] {
+			m.drifted[[3]string{p.Kind(), p.Namespace(), p.Name()}] = false
+		}
+	}
+	for _, d := range drifts {
+		m.detected[d.Policy.Kind()]++
+		switch {
+		case d.Reapplied:
+			m.reapplied++
+		case d.Err != nil:
+			m.failures++
+		}
+		m.drifted[[3]string{d.Policy.Kind(), d.Policy.Namespace(), d.Policy.Name()}] = !d.Reapplied
+	}
+}
+
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.Write(w)
+}
+
+// Write writes the metrics in the Prometheus text format.
+func (m *Metrics) Write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var ids [][3]string
+	drifted := 0
+	for id, d := range m.drifted {
+		ids = append(ids, id)
+		if d {
+			drifted++
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return strings.Join(ids[i][:], "/") < strings.Join(ids[j][:], "/")
+	})
+	header(w, "kubernetes_roles_policy_drifted", "gauge", "Whether the object differs from its policy, or is missing, after the last check.")
+	for _, id := range ids {
+		fmt.Fprintf(w, "kubernetes_roles_policy_drifted{kind=%s,namespace=%s,name=%s} %s\n", label(id[0]), label(id[1]), label(id[2]), bit(m.drifted[id]))
+	}
+	header(w, "kubernetes_roles_policies_drifted", "gauge", "Number of objects that differ from their policies after the last check.")
+	fmt.Fprintf(w, "kubernetes_roles_policies_drifted %d\n", drifted)
+
+	var kinds []string
+	for kind := range m.detected {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	header(w, "kubernetes_roles_drift_detected_total", "counter", "Drifted objects found, counted at every check that found them.")
+	for _, kind := range kinds {
+		fmt.Fprintf(w, "kubernetes_roles_drift_detected_total{kind=%s} %g\n", label(kind), m.detected[kind])
+	}
+	header(w, "kubernetes_roles_policies_reapplied_total", "counter", "Drifted policies that were applied again.")
+	fmt.Fprintf(w, "kubernetes_roles_policies_reapplied_total %g\n", m.reapplied)
+	header(w, "kubernetes_roles_reapply_failures_total", "counter", "Drifted policies that could not be applied again.")
+	fmt.Fprintf(w, "kubernetes_roles_reapply_failures_total %g\n", m.failures)
+	header(w, "kubernetes_roles_checks_total", "counter", "Checks of the policies.")
+	fmt.Fprintf(w, "kubernetes_roles_checks_total %g\n", m.checks)
+	header(w, "kubernetes_roles_check_errors_total", "counter", "Checks that could not read every object.")
+	fmt.Fprintf(w, "kubernetes_roles_check_errors_total %g\n", m.errors)
+	if !m.lastCheck.IsZero() {
+		header(w, "kubernetes_roles_last_check_timestamp_seconds", "gauge", "Time of the last check.")
+		fmt.Fprintf(w, "kubernetes_roles_last_check_timestamp_seconds %d\n", m.lastCheck.Unix())
+	}
+}
+
+func header(w io.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func label(v string) string {
+	return `"` + labelEscaper.Replace(v) + `"`
+}
+
+func bit(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
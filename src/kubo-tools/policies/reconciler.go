@@ -0,0 +1,134 @@
+package policies
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"kubo-tools/apply"
+	"kubo-tools/kube"
+)
+
+// Drift is a policy whose live object is missing or differs from it.
+type Drift struct {
+	// Policy is the object as the job renders it.
+	Policy      kube.Object
+	Missing     bool
+	Differences []Difference
+	// Reapplied is set once applying the policy again succeeded; Err is
+	// why it failed.
+	Reapplied bool
+	Err       error
+}
+
+func (d *Drift) String() string {
+	if d.Missing {
+		return fmt.Sprintf("%s is missing", d.Policy)
+	}
+	var parts []string
+	for _, diff := range d.Differences {
+		parts = append(parts, diff.String())
+	}
+	return fmt.Sprintf("%s differs: %s", d.Policy, strings.Join(parts, "; "))
+}
+
+// Reconciler compares the live objects with the policies.
+type Reconciler struct {
+	// Applier applies drifted policies again, as the job's field manager.
+	// Its client reads the live objects.
+	Applier  *apply.Applier
+	Policies []kube.Object
+	// Reapply applies the drifted policies again. Otherwise drift is only
+	// reported.
+	Reapply bool
+	Log     *log.Logger
+	// Metrics, if set, records each check.
+	Metrics *Metrics
+}
+
+// Check reads the live object of each policy and returns the policies
+// that drifted, applying them again if Reapply is set. The error lists the
+// objects that could not be read; the others are still checked.
+func (r *Reconciler) Check() ([]*Drift, error) {
+	var (
+		drifts  []*Drift
+		failed  []string
+		unknown = map[string]bool{}
+	)
+	for _, policy := range r.Policies {
+		drift, err := r.check(policy)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", policy, err))
+			unknown[policy.String()] = true
+			continue
+		}
+		if drift == nil {
+			continue
+		}
+		r.Log.Printf("drifted %s", drift)
+		if r.Reapply {
+			if _, err := r.Applier.Apply(policy); err != nil {
+				drift.Err = err
+				r.Log.Printf("cannot reapply %s: %v", policy, err)
+			} else {
+				drift.Reapplied = true
+				r.Log.Printf("reapplied %s", policy)
+			}
+		}
+		drifts = append(drifts, drift)
+	}
+	r.Log.Print(Summary(len(r.Policies), drifts))
+
+	var err error
+	if len(failed) > 0 {
+		err = fmt.Errorf("%d objects could not be read: %s", len(failed), strings.Join(failed, "; "))
+	}
+	if r.Metrics != nil {
+		r.Metrics.record(r.Policies, drifts, unknown)
+	}
+	return drifts, err
+}
+
+func (r *Reconciler) check(policy kube.Object) (*Drift, error) {
+	client := r.Applier.Client
+	resource, err := client.ResourceFor(policy.APIVersion(), policy.Kind())
+	if err != nil {
+		return nil, err
+	}
+	namespace := ""
+	if resource.Namespaced {
+		if namespace = policy.Namespace(); namespace == "" {
+			namespace = "default"
+		}
+	}
+	var live kube.Object
+	err = client.Do(kube.Request{Verb: "get", Resource: resource, Namespace: namespace, Name: policy.Name()}, &live)
+	if kube.IsNotFound(err) {
+		return &Drift{Policy: policy, Missing: true}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if diffs := Compare(policy, live); len(diffs) > 0 {
+		return &Drift{Policy: policy, Differences: diffs}, nil
+	}
+	return nil, nil
+}
+
+// Remaining returns the drifts that were not reapplied.
+func Remaining(drifts []*Drift) []*Drift {
+	var remaining []*Drift
+	for _, d := range drifts {
+		if !d.Reapplied {
+			remaining = append(remaining, d)
+		}
+	}
+	return remaining
+}
+
+// Summary describes a check of n policies, e.g. "checked 12 policies: 2
+// drifted, 1 reapplied".
+func Summary(n int, drifts []*Drift) string {
+	reapplied := len(drifts) - len(Remaining(drifts))
+	return fmt.Sprintf("checked %d policies: %d drifted, %d reapplied", n, len(drifts), reapplied)
+}
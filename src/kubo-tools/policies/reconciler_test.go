@@ -0,0 +1,144 @@
+package policies_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"kubo-tools/apply"
+	"kubo-tools/kube"
+	"kubo-tools/kube/kubetest"
+	"kubo-tools/policies"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func drainRole(verbs ...interface{}) kube.Object {
+	return kube.Object{
+		"apiVersion": "rbac.authorization.k8s.io/v1",
+		"kind":       "ClusterRole",
+		"metadata":   map[string]interface{}{"name": "system:kubelet-drain"},
+		"rules":      []interface{}{map[string]interface{}{"apiGroups": []interface{}{""}, "resources": []interface{}{"pods/eviction"}, "verbs": verbs}},
+	}
+}
+
+func psp() kube.Object {
+	return kube.Object{
+		"apiVersion": "policy/v1beta1",
+		"kind":       "PodSecurityPolicy",
+		"metadata":   map[string]interface{}{"name": "kube-system-psp"},
+		"spec":       map[string]interface{}{"privileged": false},
+	}
+}
+
+var _ = Describe("Reconciler", func() {
+	var (
+		server  *kubetest.Server
+		tmpDir  string
+		logs    *bytes.Buffer
+		metrics *policies.Metrics
+		r       *policies.Reconciler
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "policies")
+		Expect(err).NotTo(HaveOccurred())
+
+		server = kubetest.NewServer()
+		client, err := kube.NewClient(server.WriteKubeconfig(tmpDir, "admin-token"))
+		Expect(err).NotTo(HaveOccurred())
+		logs = &bytes.Buffer{}
+		metrics = policies.NewMetrics()
+		r = &policies.Reconciler{
+			Applier:  &apply.Applier{Client: client, FieldManager: "kubernetes-roles"},
+			Policies: []kube.Object{drainRole("create"), psp()},
+			Log:      log.New(logs, "", 0),
+			Metrics:  metrics,
+		}
+		for _, p := range r.Policies {
+			_, err := r.Applier.Apply(p)
+			Expect(err).NotTo(HaveOccurred())
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+		os.RemoveAll(tmpDir)
+	})
+
+	metricsText := func() string {
+		var out bytes.Buffer
+		metrics.Write(&out)
+		return out.String()
+	}
+
+	It("finds no drift in the objects as applied", func() {
+		drifts, err := r.Check()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(drifts).To(BeEmpty())
+		Expect(logs.String()).To(Equal("checked 2 policies: 0 drifted, 0 reapplied\n"))
+		Expect(metricsText()).To(ContainSubstring(`kubernetes_roles_policy_drifted{kind="ClusterRole",namespace="",name="system:kubelet-drain"} 0`))
+		Expect(metricsText()).To(ContainSubstring("kubernetes_roles_policies_drifted 0\n"))
+	})
+
+	Context("when an object was edited and another deleted", func() {
+		BeforeEach(func() {
+			edited := drainRole("create", "delete")
+			edited["metadata"].(map[string]interface{})["resourceVersion"] = "9"
+			server.Put(kube.ClusterRoles, edited)
+			server.Remove(kube.PodSecurityPolicies, "", "kube-system-psp")
+		})
+
+		It("reports them without changing them", func() {
+			drifts, err := r.Check()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(drifts).To(HaveLen(2))
+			Expect(drifts[0].String()).To(Equal(`ClusterRole system:kubelet-drain differs: .rules[0].verbs is ["create","delete"], want ["create"]`))
+			Expect(drifts[1].String()).To(Equal("PodSecurityPolicy kube-system-psp is missing"))
+			Expect(policies.Remaining(drifts)).To(HaveLen(2))
+			Expect(server.Get(kube.PodSecurityPolicies, "", "kube-system-psp")).To(BeNil())
+			Expect(logs.String()).To(HaveSuffix("checked 2 policies: 2 drifted, 0 reapplied\n"))
+
+			text := metricsText()
+			Expect(text).To(ContainSubstring(`kubernetes_roles_policy_drifted{kind="ClusterRole",namespace="",name="system:kubelet-drain"} 1`))
+			Expect(text).To(ContainSubstring(`kubernetes_roles_policy_drifted{kind="PodSecurityPolicy",namespace="",name="kube-system-psp"} 1`))
+			Expect(text).To(ContainSubstring("kubernetes_roles_policies_drifted 2\n"))
+			Expect(text).To(ContainSubstring(`kubernetes_roles_drift_detected_total{kind="PodSecurityPolicy"} 1`))
+		})
+
+		It("applies them again with Reapply", func() {
+			r.Reapply = true
+			drifts, err := r.Check()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(drifts).To(HaveLen(2))
+			Expect(policies.Remaining(drifts)).To(BeEmpty())
+			Expect(server.Get(kube.ClusterRoles, "", "system:kubelet-drain")["rules"]).To(Equal(drainRole("create")["rules"]))
+			Expect(server.Get(kube.PodSecurityPolicies, "", "kube-system-psp")).NotTo(BeNil())
+			Expect(logs.String()).To(ContainSubstring("reapplied PodSecurityPolicy kube-system-psp\n"))
+
+			text := metricsText()
+			Expect(text).To(ContainSubstring("kubernetes_roles_policies_drifted 0\n"))
+			Expect(text).To(ContainSubstring("kubernetes_roles_policies_reapplied_total 2\n"))
+
+			drifts, err = r.Check()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(drifts).To(BeEmpty())
+			Expect(metricsText()).To(ContainSubstring("kubernetes_roles_checks_total 2\n"))
+		})
+	})
+
+	It("checks the other objects when one cannot be read", func() {
+		server.Remove(kube.PodSecurityPolicies, "", "kube-system-psp")
+		server.FailRequests("GET", "/apis/rbac.authorization.k8s.io/v1/clusterroles/system:kubelet-drain", 1)
+
+		drifts, err := r.Check()
+		Expect(err).To(MatchError(ContainSubstring("1 objects could not be read: ClusterRole system:kubelet-drain: ")))
+		Expect(drifts).To(HaveLen(1))
+		Expect(drifts[0].Missing).To(BeTrue())
+		Expect(metricsText()).To(ContainSubstring("kubernetes_roles_check_errors_total 1\n"))
+		Expect(metricsText()).NotTo(ContainSubstring(`name="system:kubelet-drain"`))
+	})
+})
@@ -0,0 +1,178 @@
+// Package poststart brings a worker into service after BOSH has started its
+// kubelet: it finds the node the kubelet registered, waits for it to become
+// Ready, uncordons it and clears the NetworkUnavailable condition that some
+// cloud providers set until routes are configured.
+package poststart
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"kubo-tools/kube"
+)
+
+// Starter brings nodes into service. The poll interval defaults to a value
+// suitable for a real cluster; tests shorten it.
+type Starter struct {
+	Client *kube.Client
+	Log    *log.Logger
+
+	// Timeout bounds finding the node and waiting for it to become Ready.
+	Timeout time.Duration
+	// PollInterval is the wait between checks that the node has registered
+	// and is Ready.
+	PollInterval time.Duration
+}
+
+func New(client *kube.Client, logger *log.Logger, timeout time.Duration) *Starter {
+	return &Starter{
+		Client:       client,
+		Log:          logger,
+		Timeout:      timeout,
+		PollInterval: 2 * time.Second,
+	}
+}
+
+// Start finds the node matching the label selector, waits until it is
+// Ready, uncordons it and marks its network available. Finding the node and
+// waiting for it share the timeout. It returns the node name.
+func (s *Starter) Start(selector string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Timeout)
+	defer cancel()
+
+	node, err := s.find(ctx, selector)
+	if err != nil {
+		return "", err
+	}
+	if err := s.waitReady(ctx, node); err != nil {
+		return node, err
+	}
+	if err := s.Uncordon(node); err != nil {
+		return node, err
+	}
+	return node, s.MarkNetworkAvailable(node)
+}
+
+// find waits until exactly one node matches the label selector and returns
+// its name. The kubelet may not have registered yet, so an empty match is
+// polled until ctx expires; more than one match is an error at once, as
+// waiting will not resolve it.
+func (s *Starter) find(ctx context.Context, selector string) (string, error) {
+	var lastErr error
+	for {
+		nodes, err := s.Client.ListNodes(selector)
+		switch {
+		case err != nil:
+			lastErr = err
+			s.Log.Printf("cannot list nodes with %s, retrying: %v", selector, err)
+		case len(nodes) == 1:
+			s.Log.Printf("found node %s with %s", nodes[0].Name, selector)
+			return nodes[0].Name, nil
+		case len(nodes) > 1:
+			names := make([]string, len(nodes))
+			for i, n := range nodes {
+				names[i] = n.Name
+			}
+			return "", fmt.Errorf("%d nodes match %s, expected one: %s", len(nodes), selector, strings.Join(names, ", "))
+		default:
+			lastErr = nil
+			s.Log.Printf("no node matches %s yet", selector)
+		}
+
+		if err := sleep(ctx, s.PollInterval); err != nil {
+			if lastErr != nil {
+				return "", fmt.Errorf("cannot find the node with %s within %s: %v", selector, s.Timeout, lastErr)
+			}
+			return "", fmt.Errorf("no node matches %s within %s: the kubelet has not registered its node", selector, s.Timeout)
+		}
+	}
+}
+
+// waitReady polls the node until its Ready condition is True or ctx
+// expires. The error names the last reason the node gave for not being
+// Ready.
+func (s *Starter) waitReady(ctx context.Context, node string) error {
+	var notReady string
+	for {
+		n, err := s.Client.GetNode(node)
+		switch {
+		case err != nil:
+			notReady = err.Error()
+		case n.Condition("Ready") == nil:
+			notReady = "it has no Ready condition"
+		case n.Condition("Ready").Status == "True":
+			s.Log.Printf("node %s is Ready", node)
+			return nil
+		default:
+			ready := n.Condition("Ready")
+			notReady = fmt.Sprintf("Ready is %s", ready.Status)
+			if ready.Reason != "" {
+				notReady += ": " + ready.Reason
+			}
+			if ready.Message != "" {
+				notReady += ": " + ready.Message
+			}
+		}
+		s.Log.Printf("node %s is not Ready yet: %s", node, notReady)
+
+		if err := sleep(ctx, s.PollInterval); err != nil {
+			return fmt.Errorf("node %s did not become Ready within %s: %s", node, s.Timeout, notReady)
+		}
+	}
+}
+
+// Uncordon marks the node schedulable, undoing the cordon of the drain.
+func (s *Starter) Uncordon(node string) error {
+	err := s.Client.PatchNode(node, map[string]interface{}{
+		"spec": map[string]interface{}{"unschedulable": false},
+	})
+	if err != nil {
+		return fmt.Errorf("cannot uncordon node %s: %v", node, err)
+	}
+	s.Log.Printf("uncordoned node %s", node)
+	return nil
+}
+
+// MarkNetworkAvailable sets the node's NetworkUnavailable condition to
+// False if it is True. Flannel provides the pod network, but a cloud
+// provider that manages routes sets the condition and never clears it.
+func (s *Starter) MarkNetworkAvailable(node string) error {
+	n, err := s.Client.GetNode(node)
+	if err != nil {
+		return fmt.Errorf("cannot read the conditions of node %s: %v", node, err)
+	}
+	if c := n.Condition("NetworkUnavailable"); c == nil || c.Status != "True" {
+		return nil
+	}
+
+	err = s.Client.PatchNodeStatus(node, map[string]interface{}{
+		"status": map[string]interface{}{
+			"conditions": []kube.NodeCondition{{
+				Type:               "NetworkUnavailable",
+				Status:             "False",
+				Reason:             "NetworkProvidedByFlannel",
+				Message:            "Status manually modified by CFCR kubelet post-start",
+				LastTransitionTime: time.Now().UTC().Format(time.RFC3339),
+			}},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("cannot mark the network of node %s available: %v", node, err)
+	}
+	s.Log.Printf("marked the network of node %s available", node)
+	return nil
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
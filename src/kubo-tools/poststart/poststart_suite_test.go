@@ -0,0 +1,13 @@
+package poststart_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestPoststart(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Poststart Suite")
+}
@@ -0,0 +1,149 @@
+package poststart_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+
+	"kubo-tools/kube"
+	"kubo-tools/kube/kubetest"
+	"kubo-tools/poststart"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func node(name, boshID string, conditions ...kube.NodeCondition) kube.Node {
+	n := kube.Node{ObjectMeta: kube.ObjectMeta{Name: name, Labels: map[string]string{"bosh.id": boshID}}}
+	n.Spec.Unschedulable = true
+	n.Status.Conditions = conditions
+	return n
+}
+
+var (
+	ready    = kube.NodeCondition{Type: "Ready", Status: "True", Reason: "KubeletReady"}
+	notReady = kube.NodeCondition{Type: "Ready", Status: "False", Reason: "KubeletNotReady", Message: "runtime network not ready"}
+)
+
+var _ = Describe("Starter", func() {
+	var (
+		server *kubetest.Server
+		tmpDir string
+		logs   *bytes.Buffer
+		s      *poststart.Starter
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "poststart")
+		Expect(err).NotTo(HaveOccurred())
+
+		server = kubetest.NewServer()
+		client, err := kube.NewClient(server.WriteKubeconfig(tmpDir, "kubelet-token"))
+		Expect(err).NotTo(HaveOccurred())
+
+		logs = &bytes.Buffer{}
+		s = poststart.New(client, log.New(logs, "", 0), 50*time.Millisecond)
+		s.PollInterval = time.Millisecond
+	})
+
+	AfterEach(func() {
+		server.Close()
+		os.RemoveAll(tmpDir)
+	})
+
+	It("uncordons the Ready node with the bosh id", func() {
+		server.Put(kube.Nodes, node("worker-0", "abc", ready))
+		server.Put(kube.Nodes, node("worker-1", "def", ready))
+
+		Expect(s.Start("bosh.id=abc")).To(Equal("worker-0"))
+		Expect(server.Get(kube.Nodes, "", "worker-0")).To(HaveKeyWithValue("spec", HaveKeyWithValue("unschedulable", false)))
+		Expect(server.Get(kube.Nodes, "", "worker-1")).To(HaveKeyWithValue("spec", HaveKeyWithValue("unschedulable", true)))
+		Expect(server.RequestLines()).NotTo(ContainElement(ContainSubstring("/status")))
+	})
+
+	It("waits for the node to register and become Ready", func() {
+		s.Timeout = 5 * time.Second
+		done := make(chan error)
+		go func() {
+			_, err := s.Start("bosh.id=abc")
+			done <- err
+		}()
+
+		Consistently(done, 20*time.Millisecond).ShouldNot(Receive())
+		server.Put(kube.Nodes, node("worker-0", "abc", notReady))
+		Consistently(done, 20*time.Millisecond).ShouldNot(Receive())
+		server.Put(kube.Nodes, node("worker-0", "abc", ready))
+		Eventually(done).Should(Receive(BeNil()))
+
+		Expect(logs.String()).To(ContainSubstring("no node matches bosh.id=abc yet"))
+		Expect(logs.String()).To(ContainSubstring("node worker-0 is not Ready yet: Ready is False: KubeletNotReady: runtime network not ready"))
+	})
+
+	It("fails when no node registers in time", func() {
+		_, err := s.Start("bosh.id=abc")
+		Expect(err).To(MatchError("no node matches bosh.id=abc within 50ms: the kubelet has not registered its node"))
+	})
+
+	It("fails at once when several nodes have the bosh id", func() {
+		s.Timeout = time.Hour
+		server.Put(kube.Nodes, node("worker-0", "abc", ready))
+		server.Put(kube.Nodes, node("worker-9", "abc", ready))
+
+		_, err := s.Start("bosh.id=abc")
+		Expect(err).To(MatchError("2 nodes match bosh.id=abc, expected one: worker-0, worker-9"))
+	})
+
+	It("reports the last API error when the nodes cannot be listed", func() {
+		server.FailRequests("GET", "/api/v1/nodes", 1000)
+
+		_, err := s.Start("bosh.id=abc")
+		Expect(err).To(MatchError(ContainSubstring("cannot find the node with bosh.id=abc within 50ms: list nodes")))
+		Expect(err).To(MatchError(ContainSubstring("injected failure")))
+	})
+
+	It("fails with the reason the node is not Ready", func() {
+		server.Put(kube.Nodes, node("worker-0", "abc", notReady))
+
+		_, err := s.Start("bosh.id=abc")
+		Expect(err).To(MatchError("node worker-0 did not become Ready within 50ms: Ready is False: KubeletNotReady: runtime network not ready"))
+		Expect(server.Get(kube.Nodes, "", "worker-0")).To(HaveKeyWithValue("spec", HaveKeyWithValue("unschedulable", true)))
+	})
+
+	It("fails when the node cannot be uncordoned", func() {
+		server.Put(kube.Nodes, node("worker-0", "abc", ready))
+		server.FailRequests("PATCH", "/api/v1/nodes/worker-0", 1)
+
+		_, err := s.Start("bosh.id=abc")
+		Expect(err).To(MatchError(ContainSubstring("cannot uncordon node worker-0: patch nodes worker-0")))
+	})
+
+	Context("when the node's network is marked unavailable", func() {
+		BeforeEach(func() {
+			server.Put(kube.Nodes, node("worker-0", "abc", ready, kube.NodeCondition{Type: "NetworkUnavailable", Status: "True", Reason: "NoRouteCreated"}))
+		})
+
+		It("marks it available through the status subresource", func() {
+			Expect(s.Start("bosh.id=abc")).To(Equal("worker-0"))
+
+			conditions := server.Get(kube.Nodes, "", "worker-0")["status"].(map[string]interface{})["conditions"]
+			Expect(conditions).To(ConsistOf(
+				HaveKeyWithValue("type", "Ready"),
+				And(
+					HaveKeyWithValue("type", "NetworkUnavailable"),
+					HaveKeyWithValue("status", "False"),
+					HaveKeyWithValue("reason", "NetworkProvidedByFlannel"),
+				),
+			))
+		})
+
+		It("fails when the status cannot be patched", func() {
+			server.FailRequests("PATCH", "/api/v1/nodes/worker-0/status", 1)
+
+			_, err := s.Start("bosh.id=abc")
+			Expect(err).To(MatchError(ContainSubstring("cannot mark the network of node worker-0 available: patch nodes/status worker-0")))
+		})
+	})
+})
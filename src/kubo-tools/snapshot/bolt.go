@@ -0,0 +1,145 @@
+package snapshot
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+)
+
+// The parts of the bbolt file format needed to read every key of a
+// bucket. Integers are little endian, as bbolt writes them on amd64.
+const (
+	pageHeaderSize = 16
+	elementSize    = 16
+	metaSize       = 64
+	boltMagic      = 0xED0CDAED
+
+	branchPageFlag = 0x01
+	leafPageFlag   = 0x02
+	bucketLeafFlag = 0x01
+)
+
+// bolt is a bbolt database held in memory.
+type bolt struct {
+	data     []byte
+	pageSize uint64
+	// root is the page of the root bucket, which holds the top-level
+	// buckets.
+	root uint64
+}
+
+func open(data []byte) (*bolt, error) {
+	if len(data) < pageHeaderSize+metaSize {
+		return nil, errors.New("database is too small")
+	}
+	// Both meta pages record the page size; the first is at offset 0.
+	d := &bolt{data: data, pageSize: uint64(binary.LittleEndian.Uint32(data[pageHeaderSize+8:]))}
+	if d.pageSize < 512 || uint64(len(data)) < 2*d.pageSize {
+		return nil, fmt.Errorf("database has an invalid page size %d", d.pageSize)
+	}
+	// The meta page with the latest transaction wins, unless it was not
+	// written completely.
+	var txid uint64
+	valid := false
+	for id := uint64(0); id < 2; id++ {
+		m := data[id*d.pageSize+pageHeaderSize:][:metaSize]
+		if binary.LittleEndian.Uint32(m) != boltMagic {
+			continue
+		}
+		h := fnv.New64a()
+		h.Write(m[:56])
+		if h.Sum64() != binary.LittleEndian.Uint64(m[56:]) {
+			continue
+		}
+		if t := binary.LittleEndian.Uint64(m[48:]); !valid || t > txid {
+			txid, valid = t, true
+			d.root = binary.LittleEndian.Uint64(m[16:])
+		}
+	}
+	if !valid {
+		return nil, errors.New("database has no valid meta page; it is not a bbolt database")
+	}
+	return d, nil
+}
+
+// page returns the page with id, including its overflow pages.
+func (d *bolt) page(id uint64) ([]byte, error) {
+	start := id * d.pageSize
+	if id < 2 || start+pageHeaderSize > uint64(len(d.data)) {
+		return nil, fmt.Errorf("page %d is out of the database", id)
+	}
+	overflow := uint64(binary.LittleEndian.Uint32(d.data[start+12:]))
+	end := start + (overflow+1)*d.pageSize
+	if end > uint64(len(d.data)) {
+		return nil, fmt.Errorf("page %d is out of the database", id)
+	}
+	return d.data[start:end], nil
+}
+
+// each calls fn with the key, value and flags of every element of the
+// bucket whose pages start at root, in key order. A bucket stored in its
+// parent's value has root 0 and its page in inline.
+func (d *bolt) each(root uint64, inline []byte, fn func(k, v []byte, flags uint32) error) error {
+	p := inline
+	if root != 0 {
+		var err error
+		if p, err = d.page(root); err != nil {
+			return err
+		}
+	}
+	return d.eachInPage(p, fn, 0)
+}
+
+func (d *bolt) eachInPage(p []byte, fn func(k, v []byte, flags uint32) error, depth int) error {
+	if depth > 64 || len(p) < pageHeaderSize {
+		return errors.New("malformed page")
+	}
+	flags := binary.LittleEndian.Uint16(p[8:])
+	count := int(binary.LittleEndian.Uint16(p[10:]))
+	if pageHeaderSize+count*elementSize > len(p) {
+		return errors.New("malformed page")
+	}
+	for i := 0; i < count; i++ {
+		off := pageHeaderSize + i*elementSize
+		e := p[off : off+elementSize]
+		switch {
+		case flags&branchPageFlag != 0:
+			child, err := d.page(binary.LittleEndian.Uint64(e[8:]))
+			if err != nil {
+				return err
+			}
+			if err := d.eachInPage(child, fn, depth+1); err != nil {
+				return err
+			}
+		case flags&leafPageFlag != 0:
+			pos := off + int(binary.LittleEndian.Uint32(e[4:]))
+			ksize := int(binary.LittleEndian.Uint32(e[8:]))
+			vsize := int(binary.LittleEndian.Uint32(e[12:]))
+			if pos+ksize+vsize > len(p) {
+				return errors.New("malformed leaf element")
+			}
+			k, v := p[pos:pos+ksize], p[pos+ksize:pos+ksize+vsize]
+			if err := fn(k, v, binary.LittleEndian.Uint32(e)); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unexpected page flags %#x", flags)
+		}
+	}
+	return nil
+}
+
+// bucket reads the header of a bucket in the value of its parent's
+// element: the page it starts at, or 0 and the page that follows the
+// header if the bucket is inline.
+func bucket(v []byte) (uint64, []byte, error) {
+	if len(v) < 16 {
+		return 0, nil, errors.New("malformed bucket header")
+	}
+	root := binary.LittleEndian.Uint64(v)
+	if root != 0 {
+		return root, nil, nil
+	}
+	return 0, v[16:], nil
+}
@@ -0,0 +1,57 @@
+package snapshot
+
+import "bytes"
+
+// Change is an object that was added, removed or changed between two
+// snapshots.
+type Change struct {
+	// Old is the object in the older snapshot, nil if it was added; New
+	// the object in the newer one, nil if it was removed.
+	Old *Object
+	New *Object
+}
+
+// Object is the newer object, or the older if it was removed.
+func (c Change) Object() Object {
+	if c.New != nil {
+		return *c.New
+	}
+	return *c.Old
+}
+
+// String is the object, after + if it was added, - if it was removed and
+// ~ if it changed.
+func (c Change) String() string {
+	switch {
+	case c.Old == nil:
+		return "+ " + c.New.String()
+	case c.New == nil:
+		return "- " + c.Old.String()
+	default:
+		return "~ " + c.New.String()
+	}
+}
+
+// Diff returns the objects whose values differ between old and new,
+// sorted by key. Both must be sorted by key, as Objects returns them.
+func Diff(old, new []Object) []Change {
+	var changes []Change
+	i, j := 0, 0
+	for i < len(old) || j < len(new) {
+		switch {
+		case j == len(new) || (i < len(old) && old[i].Key < new[j].Key):
+			changes = append(changes, Change{Old: &old[i]})
+			i++
+		case i == len(old) || new[j].Key < old[i].Key:
+			changes = append(changes, Change{New: &new[j]})
+			j++
+		default:
+			if !bytes.Equal(old[i].Value, new[j].Value) {
+				changes = append(changes, Change{Old: &old[i], New: &new[j]})
+			}
+			i++
+			j++
+		}
+	}
+	return changes
+}
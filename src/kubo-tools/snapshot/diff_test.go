@@ -0,0 +1,44 @@
+package snapshot_test
+
+import (
+	"kubo-tools/snapshot"
+	"kubo-tools/snapshot/snapshottest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Diff", func() {
+	It("gives the objects added, removed and changed", func() {
+		old := objects(
+			snapshottest.Put("/registry/secrets/default/a", protobuf("v1", "Secret")+"1"),
+			snapshottest.Put("/registry/secrets/default/b", protobuf("v1", "Secret")),
+			snapshottest.Put("/registry/apiextensions.k8s.io/customresourcedefinitions/crontabs.stable.example.com", `{"apiVersion":"apiextensions.k8s.io/v1beta1","kind":"CustomResourceDefinition","spec":{}}`),
+			snapshottest.Put("/registry/namespaces/default", protobuf("v1", "Namespace")),
+		)
+		new := objects(
+			snapshottest.Put("/registry/namespaces/default", protobuf("v1", "Namespace")),
+			snapshottest.Put("/registry/secrets/default/a", protobuf("v1", "Secret")+"2"),
+			snapshottest.Put("/registry/secrets/default/c", protobuf("v1", "Secret")),
+		)
+		changes := snapshot.Diff(old, new)
+		var lines []string
+		for _, c := range changes {
+			lines = append(lines, c.String())
+		}
+		Expect(lines).To(Equal([]string{
+			"- CustomResourceDefinition crontabs.stable.example.com",
+			"~ Secret default/a",
+			"- Secret default/b",
+			"+ Secret default/c",
+		}))
+		Expect(string(changes[1].Old.Value)).To(HaveSuffix("1"))
+		Expect(string(changes[1].New.Value)).To(HaveSuffix("2"))
+		Expect(changes[0].Object().Kind).To(Equal("CustomResourceDefinition"))
+	})
+
+	It("gives nothing for the same objects", func() {
+		o := objects(snapshottest.Put("/registry/secrets/default/a", protobuf("v1", "Secret")))
+		Expect(snapshot.Diff(o, o)).To(BeEmpty())
+	})
+})
@@ -0,0 +1,218 @@
+package snapshot
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// DefaultPrefix is the prefix of the keys the API server stores objects
+// under, unless --etcd-prefix sets another.
+const DefaultPrefix = "/registry"
+
+// Object is a Kubernetes object stored in a key. The API server keeps an
+// object at <prefix>/<resource>/<namespace>/<name>, or without the
+// namespace if the kind is cluster-scoped. Resources outside the built-in
+// groups, such as CRDs and custom resources, have their group before the
+// resource.
+type Object struct {
+	Key string
+	// Resource is the resource in the key, e.g. secrets. Some are stored
+	// under a longer path, e.g. services/specs.
+	Resource  string
+	Namespace string
+	Name      string
+	// Group, Version and Kind are those the object was stored as, if its
+	// value could be read. Group is empty for the core group.
+	Group   string
+	Version string
+	Kind    string
+	// Encrypted is whether the value is encrypted at rest, in which case
+	// only the key tells what the object is.
+	Encrypted   bool
+	Value       []byte
+	ModRevision int64
+}
+
+// String is the type and the namespaced name of the object.
+func (o Object) String() string {
+	if o.Namespace == "" {
+		return o.Type() + " " + o.Name
+	}
+	return o.Type() + " " + o.Namespace + "/" + o.Name
+}
+
+// Type is the kind of the object or, if its kind is unknown, its resource.
+func (o Object) Type() string {
+	switch {
+	case o.Kind != "":
+		return o.Kind
+	case o.Encrypted:
+		return o.Resource + " (encrypted)"
+	default:
+		return o.Resource
+	}
+}
+
+// APIGroup is the group of the object, core for the core group, or empty
+// if it is unknown.
+func (o Object) APIGroup() string {
+	if o.Group == "" && o.Kind != "" {
+		return "core"
+	}
+	return o.Group
+}
+
+// Objects returns the objects stored under prefix, sorted by key. Other
+// keys are left out.
+func Objects(kvs []KeyValue, prefix string) []Object {
+	prefix = strings.TrimSuffix(prefix, "/") + "/"
+	var objects []Object
+	for _, kv := range kvs {
+		key := string(kv.Key)
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		o, ok := parseKey(strings.TrimPrefix(key, prefix))
+		if !ok {
+			continue
+		}
+		o.Key, o.Value, o.ModRevision = key, kv.Value, kv.ModRevision
+		o.decode()
+		objects = append(objects, o)
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects
+}
+
+// parseKey reads the resource, namespace and name of a key, without the
+// prefix.
+func parseKey(key string) (Object, bool) {
+	var o Object
+	parts := strings.Split(key, "/")
+	// Groups have dots, built-in resources do not.
+	if len(parts) > 2 && strings.Contains(parts[0], ".") {
+		o.Group, parts = parts[0], parts[1:]
+	}
+	if len(parts) < 2 {
+		return o, false
+	}
+	switch {
+	case len(parts) == 2:
+		o.Resource, o.Name = parts[0], parts[1]
+	default:
+		n := len(parts)
+		o.Resource, o.Namespace, o.Name = strings.Join(parts[:n-2], "/"), parts[n-2], parts[n-1]
+	}
+	return o, o.Name != ""
+}
+
+// Prefixes of the values the API server writes: encrypted by a provider of
+// its encryption config, or encoded as protobuf rather than JSON.
+var (
+	encryptedPrefix = []byte("k8s:enc:")
+	protobufPrefix  = []byte("k8s\x00")
+)
+
+// decode reads the group, version and kind of the object from its value.
+func (o *Object) decode() {
+	var apiVersion string
+	switch {
+	case bytes.HasPrefix(o.Value, encryptedPrefix):
+		o.Encrypted = true
+		return
+	case bytes.HasPrefix(o.Value, protobufPrefix):
+		// A runtime.Unknown, whose first field is the type meta.
+		eachField(o.Value[len(protobufPrefix):], func(field uint64, _ uint64, b []byte) {
+			if field != 1 {
+				return
+			}
+			eachField(b, func(field uint64, _ uint64, b []byte) {
+				switch field {
+				case 1:
+					apiVersion = string(b)
+				case 2:
+					o.Kind = string(b)
+				}
+			})
+		})
+	default:
+		var meta struct {
+			APIVersion string `json:"apiVersion"`
+			Kind       string `json:"kind"`
+		}
+		if json.Unmarshal(o.Value, &meta) != nil {
+			return
+		}
+		apiVersion, o.Kind = meta.APIVersion, meta.Kind
+	}
+	if i := strings.LastIndex(apiVersion, "/"); i >= 0 {
+		o.Group, o.Version = apiVersion[:i], apiVersion[i+1:]
+	} else {
+		o.Version = apiVersion
+	}
+}
+
+// Filter selects objects. Empty fields select every object.
+type Filter struct {
+	Group string
+	// Kind is a kind, e.g. Secret, or a resource, e.g. secrets, which
+	// selects encrypted objects as well.
+	Kind      string
+	Namespace string
+}
+
+func (f Filter) Match(o Object) bool {
+	if f.Group != "" && f.Group != o.APIGroup() {
+		return false
+	}
+	if f.Kind != "" && !strings.EqualFold(f.Kind, o.Kind) && !strings.EqualFold(f.Kind, o.Resource) {
+		return false
+	}
+	return f.Namespace == "" || f.Namespace == o.Namespace
+}
+
+// Select returns the objects f matches.
+func (f Filter) Select(objects []Object) []Object {
+	var selected []Object
+	for _, o := range objects {
+		if f.Match(o) {
+			selected = append(selected, o)
+		}
+	}
+	return selected
+}
+
+// Count is how many objects of a type there are in a namespace.
+type Count struct {
+	// Group and Kind are the APIGroup and Type of the objects.
+	Group     string
+	Kind      string
+	Namespace string
+	Objects   int
+}
+
+// Counts counts the objects by group, type and namespace, in that order.
+func Counts(objects []Object) []Count {
+	index := map[Count]int{}
+	for _, o := range objects {
+		index[Count{Group: o.APIGroup(), Kind: o.Type(), Namespace: o.Namespace}]++
+	}
+	var counts []Count
+	for c, n := range index {
+		c.Objects = n
+		counts = append(counts, c)
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		a, b := counts[i], counts[j]
+		if a.Group != b.Group {
+			return a.Group < b.Group
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Namespace < b.Namespace
+	})
+	return counts
+}
@@ -0,0 +1,131 @@
+package snapshot_test
+
+import (
+	"fmt"
+
+	"kubo-tools/snapshot"
+	"kubo-tools/snapshot/snapshottest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// protobuf encodes an object as the API server stores it in protobuf: a
+// runtime.Unknown with the type meta, followed by the raw object.
+func protobuf(apiVersion, kind string) string {
+	typeMeta := field(1, apiVersion) + field(2, kind)
+	return "k8s\x00" + field(1, typeMeta) + field(2, "raw")
+}
+
+func field(n int, value string) string {
+	return string([]byte{byte(n<<3 | 2), byte(len(value))}) + value
+}
+
+func objects(ops ...snapshottest.Op) []snapshot.Object {
+	s, err := snapshot.Parse(snapshottest.Build(ops...))
+	Expect(err).NotTo(HaveOccurred())
+	return snapshot.Objects(s.KeyValues, snapshot.DefaultPrefix)
+}
+
+func names(objects []snapshot.Object) []string {
+	var names []string
+	for _, o := range objects {
+		names = append(names, o.String())
+	}
+	return names
+}
+
+var _ = Describe("Objects", func() {
+	It("reads the objects from their keys and values", func() {
+		o := objects(
+			snapshottest.Put("/registry/deployments/kube-system/coredns", protobuf("apps/v1", "Deployment")),
+			snapshottest.Put("/registry/namespaces/default", protobuf("v1", "Namespace")),
+			snapshottest.Put("/registry/apiextensions.k8s.io/customresourcedefinitions/crontabs.stable.example.com", `{"apiVersion":"apiextensions.k8s.io/v1beta1","kind":"CustomResourceDefinition"}`),
+			snapshottest.Put("/registry/stable.example.com/crontabs/default/nightly", `{"apiVersion":"stable.example.com/v1","kind":"CronTab"}`),
+			snapshottest.Put("/registry/secrets/default/token", "k8s:enc:aescbc:v1:key1:\x01\x02"),
+			snapshottest.Put("/registry/services/specs/default/kubernetes", protobuf("v1", "Service")),
+			snapshottest.Put("/registry/ranges/serviceips", protobuf("v1", "RangeAllocation")),
+			snapshottest.Put("compact_rev_key", "x"),
+			snapshottest.Put("/registry/health", `{"health":"true"}`),
+		)
+		Expect(names(o)).To(Equal([]string{
+			"CustomResourceDefinition crontabs.stable.example.com",
+			"Deployment kube-system/coredns",
+			"Namespace default",
+			"RangeAllocation serviceips",
+			"secrets (encrypted) default/token",
+			"Service default/kubernetes",
+			"CronTab default/nightly",
+		}))
+		Expect(o[1].Group).To(Equal("apps"))
+		Expect(o[1].Version).To(Equal("v1"))
+		Expect(o[1].Resource).To(Equal("deployments"))
+		Expect(o[2].Group).To(Equal(""))
+		Expect(o[2].APIGroup()).To(Equal("core"))
+		Expect(o[4].APIGroup()).To(Equal(""))
+		Expect(o[4].Encrypted).To(BeTrue())
+		Expect(o[5].Resource).To(Equal("services/specs"))
+		Expect(o[6].Group).To(Equal("stable.example.com"))
+		Expect(o[6].Resource).To(Equal("crontabs"))
+	})
+
+	It("reads the objects under another prefix", func() {
+		s, err := snapshot.Parse(snapshottest.Build(
+			snapshottest.Put("/kubernetes/secrets/default/a", protobuf("v1", "Secret")),
+			snapshottest.Put("/registry/secrets/default/b", protobuf("v1", "Secret")),
+		))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(names(snapshot.Objects(s.KeyValues, "/kubernetes/"))).To(Equal([]string{"Secret default/a"}))
+	})
+})
+
+var _ = Describe("Filter", func() {
+	var o []snapshot.Object
+
+	BeforeEach(func() {
+		o = objects(
+			snapshottest.Put("/registry/deployments/kube-system/coredns", protobuf("apps/v1", "Deployment")),
+			snapshottest.Put("/registry/configmaps/kube-system/coredns", protobuf("v1", "ConfigMap")),
+			snapshottest.Put("/registry/secrets/default/a", protobuf("v1", "Secret")),
+			snapshottest.Put("/registry/secrets/kube-system/b", "k8s:enc:aescbc:v1:key1:\x01"),
+		)
+	})
+
+	cases := []struct {
+		filter snapshot.Filter
+		want   []string
+	}{
+		{snapshot.Filter{}, []string{"ConfigMap kube-system/coredns", "Deployment kube-system/coredns", "Secret default/a", "secrets (encrypted) kube-system/b"}},
+		{snapshot.Filter{Kind: "Secret"}, []string{"Secret default/a"}},
+		{snapshot.Filter{Kind: "secrets"}, []string{"Secret default/a", "secrets (encrypted) kube-system/b"}},
+		{snapshot.Filter{Group: "apps"}, []string{"Deployment kube-system/coredns"}},
+		{snapshot.Filter{Group: "core"}, []string{"ConfigMap kube-system/coredns", "Secret default/a"}},
+		{snapshot.Filter{Namespace: "kube-system", Kind: "configmap"}, []string{"ConfigMap kube-system/coredns"}},
+	}
+	for _, c := range cases {
+		c := c
+		It("selects "+fmt.Sprintf("%+v", c.filter), func() {
+			Expect(names(c.filter.Select(o))).To(Equal(c.want))
+		})
+	}
+})
+
+var _ = Describe("Counts", func() {
+	It("counts the objects by group, kind and namespace", func() {
+		o := objects(
+			snapshottest.Put("/registry/deployments/kube-system/coredns", protobuf("apps/v1", "Deployment")),
+			snapshottest.Put("/registry/secrets/default/a", protobuf("v1", "Secret")),
+			snapshottest.Put("/registry/secrets/default/b", protobuf("v1", "Secret")),
+			snapshottest.Put("/registry/secrets/kube-system/c", protobuf("v1", "Secret")),
+			snapshottest.Put("/registry/secrets/kube-system/d", "k8s:enc:aescbc:v1:key1:\x01"),
+			snapshottest.Put("/registry/namespaces/default", protobuf("v1", "Namespace")),
+		)
+		Expect(snapshot.Counts(o)).To(Equal([]snapshot.Count{
+			{Group: "", Kind: "secrets (encrypted)", Namespace: "kube-system", Objects: 1},
+			{Group: "apps", Kind: "Deployment", Namespace: "kube-system", Objects: 1},
+			{Group: "core", Kind: "Namespace", Namespace: "", Objects: 1},
+			{Group: "core", Kind: "Secret", Namespace: "default", Objects: 2},
+			{Group: "core", Kind: "Secret", Namespace: "kube-system", Objects: 1},
+		}))
+	})
+})
@@ -0,0 +1,210 @@
+// Package snapshot reads etcd v3 snapshots, as etcd streams them from its
+// maintenance API, without etcd: the bbolt database of the member followed
+// by the SHA-256 of the database. It checks the hash and gives the keys
+// that were live at the snapshot's revision, and the Kubernetes objects
+// stored in them.
+package snapshot
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+)
+
+// KeyValue is a key as etcd stores it, at its last revision.
+type KeyValue struct {
+	Key            []byte
+	Value          []byte
+	CreateRevision int64
+	ModRevision    int64
+	Version        int64
+	// Lease is the lease the key is attached to, or 0. Kubernetes
+	// attaches events to leases, so that they expire.
+	Lease int64
+}
+
+// Snapshot is the content of a snapshot.
+type Snapshot struct {
+	// Revision is the last revision in the snapshot.
+	Revision int64
+	// KeyValues are the keys live at Revision, sorted by key.
+	KeyValues []KeyValue
+	// Size is the size of the database, without the hash.
+	Size int64
+}
+
+// Read reads and checks the snapshot at path.
+func Read(path string) (*Snapshot, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s, err := Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return s, nil
+}
+
+// Parse checks the hash at the end of raw and reads the database before
+// it.
+func Parse(raw []byte) (*Snapshot, error) {
+	db, err := Verify(raw)
+	if err != nil {
+		return nil, err
+	}
+	s := &Snapshot{Size: int64(len(db))}
+	live := map[string]KeyValue{}
+	err = eachKeyRevision(db, func(rev revision, value []byte) error {
+		if rev.main > s.Revision {
+			s.Revision = rev.main
+		}
+		kv, err := decodeKeyValue(value)
+		if err != nil {
+			return fmt.Errorf("revision %d: %v", rev.main, err)
+		}
+		if rev.tombstone {
+			delete(live, string(kv.Key))
+		} else {
+			live[string(kv.Key)] = kv
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, kv := range live {
+		s.KeyValues = append(s.KeyValues, kv)
+	}
+	sort.Slice(s.KeyValues, func(i, j int) bool { return bytes.Compare(s.KeyValues[i].Key, s.KeyValues[j].Key) < 0 })
+	return s, nil
+}
+
+// Verify checks the SHA-256 etcd appends to a snapshot and returns the
+// database it covers. The database is a whole number of pages, so the
+// hash is there if the size is 32 bytes past a multiple of 512, as etcd
+// checks it on restore.
+func Verify(raw []byte) ([]byte, error) {
+	if len(raw)%512 != sha256.Size {
+		return nil, fmt.Errorf("snapshot of %d bytes has no integrity hash; it is truncated or not an etcd snapshot", len(raw))
+	}
+	db, sum := raw[:len(raw)-sha256.Size], raw[len(raw)-sha256.Size:]
+	if got := sha256.Sum256(db); !bytes.Equal(got[:], sum) {
+		return nil, fmt.Errorf("snapshot has hash %x, but its database has hash %x; it is corrupted", sum, got)
+	}
+	return db, nil
+}
+
+// revision is the key of a revision in etcd's key bucket: 8 bytes of main
+// revision, an underscore and 8 bytes of sub revision, followed by a t if
+// the revision deleted the key.
+type revision struct {
+	main, sub int64
+	tombstone bool
+}
+
+func parseRevision(key []byte) (revision, error) {
+	if (len(key) != 17 && len(key) != 18) || key[8] != '_' {
+		return revision{}, fmt.Errorf("malformed revision key %x", key)
+	}
+	return revision{
+		main:      int64(binary.BigEndian.Uint64(key[:8])),
+		sub:       int64(binary.BigEndian.Uint64(key[9:17])),
+		tombstone: len(key) == 18 && key[17] == 't',
+	}, nil
+}
+
+// eachKeyRevision calls fn with every revision in the key bucket, in
+// order.
+func eachKeyRevision(db []byte, fn func(revision, []byte) error) error {
+	d, err := open(db)
+	if err != nil {
+		return err
+	}
+	found := false
+	err = d.each(d.root, nil, func(k, v []byte, flags uint32) error {
+		if string(k) != "key" || flags&bucketLeafFlag == 0 {
+			return nil
+		}
+		found = true
+		root, inline, err := bucket(v)
+		if err != nil {
+			return fmt.Errorf("bucket key: %v", err)
+		}
+		return d.each(root, inline, func(k, v []byte, flags uint32) error {
+			rev, err := parseRevision(k)
+			if err != nil {
+				return err
+			}
+			return fn(rev, v)
+		})
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return errors.New("database has no key bucket; it is not an etcd v3 snapshot")
+	}
+	return nil
+}
+
+// decodeKeyValue decodes the protobuf of an mvccpb.KeyValue.
+func decodeKeyValue(raw []byte) (KeyValue, error) {
+	var kv KeyValue
+	err := eachField(raw, func(field uint64, v uint64, b []byte) {
+		switch field {
+		case 1:
+			kv.Key = b
+		case 2:
+			kv.CreateRevision = int64(v)
+		case 3:
+			kv.ModRevision = int64(v)
+		case 4:
+			kv.Version = int64(v)
+		case 5:
+			kv.Value = b
+		case 6:
+			kv.Lease = int64(v)
+		}
+	})
+	if err != nil {
+		return kv, fmt.Errorf("key value: %v", err)
+	}
+	return kv, nil
+}
+
+// eachField calls fn with every field of a protobuf message: with the
+// value of a varint, or the bytes of a length-delimited field.
+func eachField(raw []byte, fn func(field uint64, v uint64, b []byte)) error {
+	for len(raw) > 0 {
+		tag, n := binary.Uvarint(raw)
+		if n <= 0 {
+			return errors.New("malformed protobuf")
+		}
+		raw = raw[n:]
+		field, wireType := tag>>3, tag&7
+		switch wireType {
+		case 0:
+			v, n := binary.Uvarint(raw)
+			if n <= 0 {
+				return errors.New("malformed protobuf")
+			}
+			raw = raw[n:]
+			fn(field, v, nil)
+		case 2:
+			l, n := binary.Uvarint(raw)
+			if n <= 0 || uint64(len(raw)-n) < l {
+				return errors.New("malformed protobuf")
+			}
+			fn(field, 0, raw[n:n+int(l)])
+			raw = raw[n+int(l):]
+		default:
+			return fmt.Errorf("unexpected protobuf wire type %d", wireType)
+		}
+	}
+	return nil
+}
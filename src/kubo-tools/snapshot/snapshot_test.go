@@ -0,0 +1,79 @@
+package snapshot_test
+
+import (
+	"fmt"
+	"strings"
+
+	"kubo-tools/snapshot"
+	"kubo-tools/snapshot/snapshottest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func keys(s *snapshot.Snapshot) []string {
+	var keys []string
+	for _, kv := range s.KeyValues {
+		keys = append(keys, string(kv.Key))
+	}
+	return keys
+}
+
+var _ = Describe("Parse", func() {
+	It("gives the keys live at the last revision", func() {
+		s, err := snapshot.Parse(snapshottest.Build(
+			snapshottest.Put("/registry/secrets/default/a", "1"),
+			snapshottest.Put("/registry/secrets/default/b", "1"),
+			snapshottest.Put("/registry/secrets/default/a", "2"),
+			snapshottest.Delete("/registry/secrets/default/b"),
+			snapshottest.Op{Key: "/registry/events/default/e", Value: "x", Lease: 7},
+			snapshottest.Put("/registry/namespaces/default", "ns"),
+		))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(s.Revision).To(Equal(int64(7)))
+		Expect(keys(s)).To(Equal([]string{"/registry/events/default/e", "/registry/namespaces/default", "/registry/secrets/default/a"}))
+		Expect(s.KeyValues[2]).To(Equal(snapshot.KeyValue{
+			Key:            []byte("/registry/secrets/default/a"),
+			Value:          []byte("2"),
+			CreateRevision: 2,
+			ModRevision:    4,
+			Version:        2,
+		}))
+		Expect(s.KeyValues[0].Lease).To(Equal(int64(7)))
+	})
+
+	It("reads histories spread over many pages", func() {
+		var ops []snapshottest.Op
+		for i := 0; i < 200; i++ {
+			ops = append(ops, snapshottest.Put(fmt.Sprintf("/registry/configmaps/default/%03d", i), strings.Repeat("v", 300)))
+		}
+		s, err := snapshot.Parse(snapshottest.Build(ops...))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(s.KeyValues).To(HaveLen(200))
+		Expect(s.Revision).To(Equal(int64(201)))
+	})
+
+	It("reads an empty snapshot", func() {
+		s, err := snapshot.Parse(snapshottest.Build())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(s.KeyValues).To(BeEmpty())
+	})
+
+	It("refuses a corrupted snapshot", func() {
+		raw := snapshottest.Build(snapshottest.Put("/registry/a", "1"))
+		raw[4*4096+20] ^= 0xff
+		_, err := snapshot.Parse(raw)
+		Expect(err).To(MatchError(ContainSubstring("it is corrupted")))
+	})
+
+	It("refuses a truncated snapshot", func() {
+		raw := snapshottest.Build(snapshottest.Put("/registry/a", "1"))
+		_, err := snapshot.Parse(raw[:len(raw)-100])
+		Expect(err).To(MatchError(ContainSubstring("has no integrity hash")))
+	})
+
+	It("refuses a database that is not an etcd snapshot", func() {
+		_, err := snapshot.Parse(make([]byte, 4*4096+32))
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,199 @@
+// Package snapshottest builds etcd v3 snapshots, for testing code that
+// reads them with package snapshot. The snapshots hold a bbolt database
+// with a key bucket of revisions, spread over several pages, and end with
+// their SHA-256, as those etcd streams do.
+package snapshottest
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"hash/fnv"
+)
+
+const (
+	pageSize = 4096
+	// perLeaf keeps the leaves small, so that even short histories have a
+	// branch page.
+	perLeaf = 4
+)
+
+// Op is a revision: a put of Value to Key, or a delete of Key. A put with
+// a Lease attaches the key to it.
+type Op struct {
+	Key    string
+	Value  string
+	Delete bool
+	Lease  int64
+}
+
+// Put and Delete make Ops.
+func Put(key, value string) Op { return Op{Key: key, Value: value} }
+func Delete(key string) Op     { return Op{Key: key, Delete: true} }
+
+// Build returns a snapshot whose history is ops, the first at revision 2,
+// as in a new etcd cluster.
+func Build(ops ...Op) []byte {
+	type version struct{ create, version int64 }
+	versions := map[string]version{}
+	var keys, values [][]byte
+	for i, op := range ops {
+		rev := int64(i + 2)
+		key := make([]byte, 17, 18)
+		binary.BigEndian.PutUint64(key, uint64(rev))
+		key[8] = '_'
+		if op.Delete {
+			keys = append(keys, append(key, 't'))
+			values = append(values, keyValue(op.Key, "", 0, 0, 0, 0))
+			delete(versions, op.Key)
+			continue
+		}
+		v, ok := versions[op.Key]
+		if !ok {
+			v.create = rev
+		}
+		v.version++
+		versions[op.Key] = v
+		keys = append(keys, key)
+		values = append(values, keyValue(op.Key, op.Value, v.create, rev, v.version, op.Lease))
+	}
+
+	// Pages 0 and 1 are the meta pages, 2 the free list and 3 the root
+	// bucket; the key bucket follows.
+	var pages [][]byte
+	next := uint64(4)
+	var leaves []uint64
+	var firstKeys [][]byte
+	for i := 0; i < len(keys) || i == 0; i += perLeaf {
+		end := i + perLeaf
+		if end > len(keys) {
+			end = len(keys)
+		}
+		p := leaf(next, keys[i:end], values[i:end], nil)
+		leaves = append(leaves, next)
+		if i < len(keys) {
+			firstKeys = append(firstKeys, keys[i])
+		} else {
+			firstKeys = append(firstKeys, nil)
+		}
+		pages = append(pages, p)
+		next += uint64(len(p) / pageSize)
+	}
+	keyRoot := leaves[0]
+	if len(leaves) > 1 {
+		keyRoot = next
+		p := branch(next, firstKeys, leaves)
+		pages = append(pages, p)
+		next += uint64(len(p) / pageSize)
+	}
+
+	// The meta bucket is inline, as small buckets are.
+	inline := make([]byte, 32)
+	binary.LittleEndian.PutUint16(inline[16+8:], 0x02)
+	keyBucket := make([]byte, 16)
+	binary.LittleEndian.PutUint64(keyBucket, keyRoot)
+	root := leaf(3, [][]byte{[]byte("key"), []byte("meta")}, [][]byte{keyBucket, inline}, []uint32{1, 1})
+
+	freelist := make([]byte, pageSize)
+	binary.LittleEndian.PutUint64(freelist, 2)
+	binary.LittleEndian.PutUint16(freelist[8:], 0x10)
+
+	var db []byte
+	db = append(db, meta(0, 1, next)...)
+	db = append(db, meta(1, 2, next)...)
+	db = append(db, freelist...)
+	db = append(db, root...)
+	for _, p := range pages {
+		db = append(db, p...)
+	}
+	sum := sha256.Sum256(db)
+	return append(db, sum[:]...)
+}
+
+func meta(id, txid, pages uint64) []byte {
+	p := make([]byte, pageSize)
+	binary.LittleEndian.PutUint64(p, id)
+	binary.LittleEndian.PutUint16(p[8:], 0x04)
+	m := p[16:]
+	binary.LittleEndian.PutUint32(m, 0xED0CDAED)
+	binary.LittleEndian.PutUint32(m[4:], 2)
+	binary.LittleEndian.PutUint32(m[8:], pageSize)
+	binary.LittleEndian.PutUint64(m[16:], 3)
+	binary.LittleEndian.PutUint64(m[32:], 2)
+	binary.LittleEndian.PutUint64(m[40:], pages)
+	binary.LittleEndian.PutUint64(m[48:], txid)
+	h := fnv.New64a()
+	h.Write(m[:56])
+	binary.LittleEndian.PutUint64(m[56:], h.Sum64())
+	return p
+}
+
+func leaf(id uint64, keys, values [][]byte, flags []uint32) []byte {
+	p := make([]byte, 16+16*len(keys))
+	binary.LittleEndian.PutUint64(p, id)
+	binary.LittleEndian.PutUint16(p[8:], 0x02)
+	binary.LittleEndian.PutUint16(p[10:], uint16(len(keys)))
+	for i := range keys {
+		e := p[16+16*i:]
+		if flags != nil {
+			binary.LittleEndian.PutUint32(e, flags[i])
+		}
+		binary.LittleEndian.PutUint32(e[4:], uint32(len(p)-16-16*i))
+		binary.LittleEndian.PutUint32(e[8:], uint32(len(keys[i])))
+		binary.LittleEndian.PutUint32(e[12:], uint32(len(values[i])))
+		p = append(append(p, keys[i]...), values[i]...)
+	}
+	return pad(p)
+}
+
+func branch(id uint64, keys [][]byte, children []uint64) []byte {
+	p := make([]byte, 16+16*len(keys))
+	binary.LittleEndian.PutUint64(p, id)
+	binary.LittleEndian.PutUint16(p[8:], 0x01)
+	binary.LittleEndian.PutUint16(p[10:], uint16(len(keys)))
+	for i := range keys {
+		e := p[16+16*i:]
+		binary.LittleEndian.PutUint32(e, uint32(len(p)-16-16*i))
+		binary.LittleEndian.PutUint32(e[4:], uint32(len(keys[i])))
+		binary.LittleEndian.PutUint64(e[8:], children[i])
+		p = append(p, keys[i]...)
+	}
+	return pad(p)
+}
+
+// pad fills the page up to a whole number of pages, recording the pages
+// past the first as overflow.
+func pad(p []byte) []byte {
+	n := (len(p) + pageSize - 1) / pageSize
+	binary.LittleEndian.PutUint32(p[12:], uint32(n-1))
+	return append(p, make([]byte, n*pageSize-len(p))...)
+}
+
+// keyValue encodes an mvccpb.KeyValue.
+func keyValue(key, value string, create, mod, version, lease int64) []byte {
+	var b []byte
+	bytesField := func(field uint64, s string) {
+		b = appendUvarint(b, field<<3|2)
+		b = appendUvarint(b, uint64(len(s)))
+		b = append(b, s...)
+	}
+	intField := func(field uint64, v int64) {
+		if v != 0 {
+			b = appendUvarint(b, field<<3)
+			b = appendUvarint(b, uint64(v))
+		}
+	}
+	bytesField(1, key)
+	intField(2, create)
+	intField(3, mod)
+	intField(4, version)
+	if value != "" {
+		bytesField(5, value)
+	}
+	intField(6, lease)
+	return b
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
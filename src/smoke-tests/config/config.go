@@ -0,0 +1,153 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// Groups lists the test groups that can be enabled in test_groups.
+var Groups = []string{
+	"system-components",
+	"deployment",
+	"port-forwarding",
+	"dns",
+	"metrics-server",
+	"networking",
+	"rbac",
+	"psp",
+}
+
+// Config is the suite's view of the test-config.json rendered by the
+// smoke-tests job.
+type Config struct {
+	Kubernetes struct {
+		PathToKubeconfig string `json:"path_to_kubeconfig"`
+	} `json:"kubernetes"`
+	Runner     string   `json:"runner"`
+	TestImage  string   `json:"test_image"`
+	Timeouts   Timeouts `json:"timeouts"`
+	TestGroups []string `json:"test_groups"`
+	DNS        DNS      `json:"dns"`
+	RBAC       RBAC     `json:"rbac"`
+	Cleanup    Cleanup  `json:"cleanup"`
+}
+
+type Cleanup struct {
+	// MaxAge is how old the namespaces and fixtures of other runs must be
+	// before the suite deletes them. Zero turns the cleanup off.
+	MaxAge Duration `json:"max_age"`
+}
+
+type DNS struct {
+	// ServiceIP is the cluster IP the kube-dns Service is expected to have.
+	ServiceIP string `json:"service_ip"`
+	// ExternalName is a name outside the cluster that pods must be able to
+	// resolve. The lookup is skipped when it is empty.
+	ExternalName string `json:"external_name"`
+}
+
+type RBAC struct {
+	// AdminUsername is the user tokens.csv puts in system:masters.
+	AdminUsername string `json:"admin_username"`
+	// CloudProvider is the type of the cloud-provider link, e.g. vsphere or
+	// azure. It selects which cloud-provider grants are checked.
+	CloudProvider string `json:"cloud_provider"`
+}
+
+type Timeouts struct {
+	// Default bounds individual kubectl and API calls.
+	Default Duration `json:"default"`
+	// Rollout bounds waiting for a deployment to become available.
+	Rollout Duration `json:"rollout"`
+}
+
+// Duration is a time.Duration written as a string such as "60s".
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"60s\": %s", data)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// Default returns the configuration used when no config file is given. It
+// matches the defaults of the smoke-tests job spec.
+func Default() *Config {
+	return &Config{
+		Runner:     "kubectl",
+		TestImage:  "pcfkubo/nginx-bionic:1.0.0",
+		Timeouts:   Timeouts{Default: Duration{60 * time.Second}, Rollout: Duration{120 * time.Second}},
+		TestGroups: append([]string{}, Groups...),
+		DNS:        DNS{ServiceIP: "10.100.200.10", ExternalName: "cloudfoundry.org"},
+		RBAC:       RBAC{AdminUsername: "admin"},
+		Cleanup:    Cleanup{MaxAge: Duration{2 * time.Hour}},
+	}
+}
+
+// Load reads the config file at path. Settings missing from the file keep
+// their default values.
+func Load(path string) (*Config, error) {
+	c := Default()
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, c); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return c, nil
+}
+
+func (c *Config) Validate() error {
+	if c.Runner != "kubectl" && c.Runner != "native" {
+		return fmt.Errorf("runner must be kubectl or native, got %q", c.Runner)
+	}
+	if c.TestImage == "" {
+		return fmt.Errorf("test_image must not be empty")
+	}
+	if c.Timeouts.Default.Duration <= 0 || c.Timeouts.Rollout.Duration <= 0 {
+		return fmt.Errorf("timeouts must be positive")
+	}
+	if c.Cleanup.MaxAge.Duration < 0 {
+		return fmt.Errorf("cleanup max_age must not be negative")
+	}
+	for _, g := range c.TestGroups {
+		if !contains(Groups, g) {
+			return fmt.Errorf("unknown test group %q, expected one of %s", g, strings.Join(Groups, ", "))
+		}
+	}
+	return nil
+}
+
+// Enabled reports whether specs in group should run.
+func (c *Config) Enabled(group string) bool {
+	return contains(c.TestGroups, group)
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
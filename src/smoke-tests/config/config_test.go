@@ -0,0 +1,107 @@
+package config_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"smoke-tests/config"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Config", func() {
+	var (
+		tmpDir string
+		path   string
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "config")
+		Expect(err).NotTo(HaveOccurred())
+		path = filepath.Join(tmpDir, "test-config.json")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	write := func(contents string) {
+		Expect(ioutil.WriteFile(path, []byte(contents), 0600)).To(Succeed())
+	}
+
+	It("keeps the defaults for settings the file omits", func() {
+		write(`{"kubernetes": {"path_to_kubeconfig": "/var/vcap/jobs/smoke-tests/config/kubeconfig"}}`)
+
+		c, err := config.Load(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c.Kubernetes.PathToKubeconfig).To(Equal("/var/vcap/jobs/smoke-tests/config/kubeconfig"))
+		Expect(c.Runner).To(Equal("kubectl"))
+		Expect(c.TestImage).To(Equal("pcfkubo/nginx-bionic:1.0.0"))
+		Expect(c.Timeouts.Default.Duration).To(Equal(60 * time.Second))
+		Expect(c.Timeouts.Rollout.Duration).To(Equal(120 * time.Second))
+		Expect(c.TestGroups).To(Equal(config.Groups))
+		Expect(c.DNS.ServiceIP).To(Equal("10.100.200.10"))
+		Expect(c.DNS.ExternalName).To(Equal("cloudfoundry.org"))
+		Expect(c.RBAC.AdminUsername).To(Equal("admin"))
+		Expect(c.RBAC.CloudProvider).To(BeEmpty())
+		Expect(c.Cleanup.MaxAge.Duration).To(Equal(2 * time.Hour))
+	})
+
+	It("reads every setting", func() {
+		write(`{
+			"runner": "native",
+			"test_image": "registry.local/nginx:1.2",
+			"timeouts": {"default": "30s", "rollout": "5m"},
+			"test_groups": ["system-components"],
+			"dns": {"service_ip": "10.200.0.10", "external_name": ""},
+			"rbac": {"admin_username": "meatloaf", "cloud_provider": "vsphere"},
+			"cleanup": {"max_age": "0s"}
+		}`)
+
+		c, err := config.Load(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c.Runner).To(Equal("native"))
+		Expect(c.TestImage).To(Equal("registry.local/nginx:1.2"))
+		Expect(c.Timeouts.Default.Duration).To(Equal(30 * time.Second))
+		Expect(c.Timeouts.Rollout.Duration).To(Equal(5 * time.Minute))
+		Expect(c.Enabled("system-components")).To(BeTrue())
+		Expect(c.Enabled("deployment")).To(BeFalse())
+		Expect(c.DNS.ServiceIP).To(Equal("10.200.0.10"))
+		Expect(c.DNS.ExternalName).To(BeEmpty())
+		Expect(c.RBAC.AdminUsername).To(Equal("meatloaf"))
+		Expect(c.RBAC.CloudProvider).To(Equal("vsphere"))
+		Expect(c.Cleanup.MaxAge.Duration).To(BeZero())
+	})
+
+	It("rejects unknown test groups", func() {
+		write(`{"test_groups": ["system-components", "dsn"]}`)
+
+		_, err := config.Load(path)
+		Expect(err).To(MatchError(ContainSubstring(`unknown test group "dsn"`)))
+	})
+
+	It("rejects malformed timeouts", func() {
+		write(`{"timeouts": {"default": 60}}`)
+
+		_, err := config.Load(path)
+		Expect(err).To(MatchError(ContainSubstring(`duration must be a string such as "60s"`)))
+	})
+
+	It("rejects a negative cleanup age", func() {
+		write(`{"cleanup": {"max_age": "-1h"}}`)
+
+		_, err := config.Load(path)
+		Expect(err).To(MatchError(ContainSubstring("cleanup max_age must not be negative")))
+	})
+
+	It("rejects unknown runners", func() {
+		write(`{"runner": "curl"}`)
+
+		_, err := config.Load(path)
+		Expect(err).To(MatchError(ContainSubstring(`runner must be kubectl or native, got "curl"`)))
+	})
+})
@@ -0,0 +1,160 @@
+package smoke_tests_test
+
+import (
+	"fmt"
+	"smoke-tests/runner"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// dnsClients starts one pod pinned to every schedulable node, so that each
+// lookup is tried from every node's view of cluster DNS.
+func dnsClients(prefix string) []runner.Pod {
+	var names []string
+	for _, node := range schedulableNodes() {
+		pod := testPod(prefix + "-" + randSeq(6))
+		pod.Spec.NodeName = node.Name
+		_, err := k8sRunner.CreatePod(pod)
+		Expect(err).NotTo(HaveOccurred())
+		names = append(names, pod.Name)
+	}
+	Expect(names).NotTo(BeEmpty(), "no schedulable nodes to run DNS lookups from")
+
+	var clients []runner.Pod
+	for _, name := range names {
+		pod, err := runner.WaitForPodRunning(k8sRunner, name, testConfig.Timeouts.Rollout.Duration)
+		Expect(err).NotTo(HaveOccurred())
+		clients = append(clients, *pod)
+	}
+	return clients
+}
+
+func testPod(name string) *runner.Pod {
+	return &runner.Pod{
+		ObjectMeta: runner.ObjectMeta{Name: name, Labels: map[string]string{"app": name}},
+		Spec: runner.PodSpec{
+			ServiceAccountName: "default",
+			Containers: []runner.Container{{
+				Name:            "nginx",
+				Image:           testConfig.TestImage,
+				ImagePullPolicy: "Never",
+			}},
+		},
+	}
+}
+
+// failedLookups resolves name from every client and describes each lookup
+// that failed or, if want is set, did not return want.
+func failedLookups(clients []runner.Pod, name, want string) []string {
+	var failures []string
+	for _, client := range clients {
+		out, err := kubectl.Exec(client.Name, "getent", "hosts", name)
+		fields := strings.Fields(out)
+		switch {
+		case err != nil:
+			failures = append(failures, fmt.Sprintf("lookup of %s from node %s (pod %s) failed: %v", name, client.Spec.NodeName, client.Name, err))
+		case len(fields) == 0:
+			failures = append(failures, fmt.Sprintf("lookup of %s from node %s (pod %s) returned no address", name, client.Spec.NodeName, client.Name))
+		case want != "" && fields[0] != want:
+			failures = append(failures, fmt.Sprintf("lookup of %s from node %s (pod %s) returned %s, expected %s", name, client.Spec.NodeName, client.Name, fields[0], want))
+		}
+	}
+	return failures
+}
+
+var _ = Describe("Cluster DNS", func() {
+	BeforeEach(func() {
+		skipUnlessEnabled("dns")
+	})
+
+	It("gives the kube-dns service the configured cluster IP", func() {
+		svc, err := k8sRunner.GetServiceInNamespace("kube-system", "kube-dns")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(svc.Spec.ClusterIP).To(Equal(testConfig.DNS.ServiceIP))
+	})
+
+	Context("from every node", func() {
+		var (
+			targetName   string
+			headlessName string
+			target       *runner.Pod
+			clients      []runner.Pod
+		)
+
+		BeforeEach(func() {
+			targetName = "dns-target-" + randSeq(6)
+			headlessName = targetName + "-headless"
+
+			pod := testPod(targetName)
+			pod.Spec.Hostname = "target"
+			pod.Spec.Subdomain = headlessName
+			_, err := k8sRunner.CreatePod(pod)
+			Expect(err).NotTo(HaveOccurred())
+
+			for _, svc := range []*runner.Service{
+				{
+					ObjectMeta: runner.ObjectMeta{Name: targetName},
+					Spec: runner.ServiceSpec{
+						Selector: map[string]string{"app": targetName},
+						Ports:    []runner.ServicePort{{Port: 80}},
+					},
+				},
+				{
+					ObjectMeta: runner.ObjectMeta{Name: headlessName},
+					Spec: runner.ServiceSpec{
+						ClusterIP: "None",
+						Selector:  map[string]string{"app": targetName},
+						Ports:     []runner.ServicePort{{Port: 80}},
+					},
+				},
+			} {
+				_, err := k8sRunner.CreateService(svc)
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			target, err = runner.WaitForPodRunning(k8sRunner, targetName, testConfig.Timeouts.Rollout.Duration)
+			Expect(err).NotTo(HaveOccurred())
+
+			clients = dnsClients("dns-client")
+		})
+
+		AfterEach(func() {
+			for _, client := range clients {
+				Expect(k8sRunner.DeletePod(client.Name)).To(Succeed())
+			}
+			clients = nil
+			Expect(k8sRunner.DeleteService(headlessName)).To(Succeed())
+			Expect(k8sRunner.DeleteService(targetName)).To(Succeed())
+			Expect(k8sRunner.DeletePod(targetName)).To(Succeed())
+		})
+
+		It("resolves a service name to its cluster IP", func() {
+			svc, err := k8sRunner.GetService(targetName)
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(func() []string {
+				return failedLookups(clients, targetName, svc.Spec.ClusterIP)
+			}, testConfig.Timeouts.Default.Duration, "2s").Should(BeEmpty())
+		})
+
+		It("resolves the pod record of a headless service", func() {
+			record := "target." + headlessName
+
+			Eventually(func() []string {
+				return failedLookups(clients, record, target.Status.PodIP)
+			}, testConfig.Timeouts.Default.Duration, "2s").Should(BeEmpty())
+		})
+
+		It("resolves an external name", func() {
+			if testConfig.DNS.ExternalName == "" {
+				Skip("no external name configured")
+			}
+
+			Eventually(func() []string {
+				return failedLookups(clients, testConfig.DNS.ExternalName, "")
+			}, testConfig.Timeouts.Default.Duration, "2s").Should(BeEmpty())
+		})
+	})
+})
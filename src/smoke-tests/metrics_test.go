@@ -0,0 +1,101 @@
+package smoke_tests_test
+
+import (
+	"fmt"
+	"smoke-tests/runner"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const metricsAPIService = "v1beta1.metrics.k8s.io"
+
+func metricsAvailable() error {
+	svc, err := k8sRunner.GetAPIService(metricsAPIService)
+	if err != nil {
+		return err
+	}
+	available := svc.Condition("Available")
+	if available == nil {
+		return fmt.Errorf("APIService %s reports no Available condition", metricsAPIService)
+	}
+	if available.Status != "True" {
+		return fmt.Errorf("APIService %s is not available: %s: %s", metricsAPIService, available.Reason, available.Message)
+	}
+	return nil
+}
+
+var _ = Describe("Metrics Server", func() {
+	BeforeEach(func() {
+		skipUnlessEnabled("metrics-server")
+
+		_, err := k8sRunner.GetAPIService(metricsAPIService)
+		if runner.IsNotFound(err) {
+			Skip("the metrics-server addon is not deployed")
+		}
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(metricsAvailable, testConfig.Timeouts.Rollout.Duration, "5s").Should(Succeed())
+	})
+
+	It("serves node metrics", func() {
+		nodes, err := k8sRunner.ListNodes()
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(func() []string {
+			metrics, err := k8sRunner.NodeMetrics()
+			if err != nil {
+				return []string{err.Error()}
+			}
+			usage := map[string]map[string]string{}
+			for _, m := range metrics {
+				usage[m.Name] = m.Usage
+			}
+
+			var missing []string
+			for _, node := range nodes {
+				if usage[node.Name]["cpu"] == "" || usage[node.Name]["memory"] == "" {
+					missing = append(missing, "no cpu and memory usage for node "+node.Name)
+				}
+			}
+			return missing
+		}, testConfig.Timeouts.Rollout.Duration, "5s").Should(BeEmpty())
+	})
+
+	Context("for the test deployment", func() {
+		var deploymentName string
+
+		BeforeEach(func() {
+			deploymentName = randSeq(10)
+			_, err := k8sRunner.CreateDeployment(nginxDeployment(deploymentName))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(runner.WaitForRollout(k8sRunner, deploymentName, testConfig.Timeouts.Rollout.Duration)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			Expect(k8sRunner.DeleteDeployment(deploymentName)).To(Succeed())
+		})
+
+		It("serves pod metrics", func() {
+			Eventually(func() []string {
+				metrics, err := k8sRunner.PodMetrics("app=" + deploymentName)
+				if err != nil {
+					return []string{err.Error()}
+				}
+				if len(metrics) == 0 {
+					return []string{"no metrics for pods of deployment " + deploymentName}
+				}
+
+				var missing []string
+				for _, m := range metrics {
+					for _, c := range m.Containers {
+						if c.Usage["cpu"] == "" || c.Usage["memory"] == "" {
+							missing = append(missing, fmt.Sprintf("no cpu and memory usage for container %s of pod %s", c.Name, m.Name))
+						}
+					}
+				}
+				return missing
+			}, testConfig.Timeouts.Rollout.Duration, "5s").Should(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,178 @@
+package smoke_tests_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"smoke-tests/runner"
+	"text/tabwriter"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func schedulableNodes() []runner.Node {
+	nodes, err := k8sRunner.ListNodes()
+	Expect(err).NotTo(HaveOccurred())
+
+	var schedulable []runner.Node
+	for _, node := range nodes {
+		if !node.Spec.Unschedulable {
+			schedulable = append(schedulable, node)
+		}
+	}
+	return schedulable
+}
+
+// tcpConnect opens a TCP connection from inside pod using bash's /dev/tcp,
+// which the test image provides without extra tooling.
+func tcpConnect(from runner.Pod, ip string, port int) error {
+	_, err := kubectl.Exec(from.Name, "timeout", "5", "bash", "-c", fmt.Sprintf("echo > /dev/tcp/%s/%d", ip, port))
+	return err
+}
+
+type target struct {
+	label string
+	ip    string
+	// pod is the pod behind ip, or empty for a service.
+	pod string
+}
+
+// connectivityMatrix records the outcome of connecting every source pod to
+// every target.
+type connectivityMatrix struct {
+	sources []runner.Pod
+	targets []target
+	results [][]error
+}
+
+func checkConnectivity(sources []runner.Pod, targets []target, port int) connectivityMatrix {
+	m := connectivityMatrix{sources: sources, targets: targets}
+	for _, source := range sources {
+		row := make([]error, len(targets))
+		for i, t := range targets {
+			if t.pod == source.Name {
+				continue
+			}
+			row[i] = tcpConnect(source, t.ip, port)
+		}
+		m.results = append(m.results, row)
+	}
+	return m
+}
+
+// Err returns nil if every connection succeeded, otherwise an error holding
+// the whole matrix so that a failure shows which paths are broken.
+func (m connectivityMatrix) Err() error {
+	failed := false
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+
+	fmt.Fprint(w, "from \\ to")
+	for _, t := range m.targets {
+		fmt.Fprintf(w, "\t%s", t.label)
+	}
+	fmt.Fprintln(w)
+
+	for i, source := range m.sources {
+		fmt.Fprintf(w, "%s (%s, %s)", source.Name, source.Spec.NodeName, source.Status.PodIP)
+		for j, t := range m.targets {
+			switch {
+			case t.pod == source.Name:
+				fmt.Fprint(w, "\t-")
+			case m.results[i][j] != nil:
+				failed = true
+				fmt.Fprint(w, "\tFAIL")
+			default:
+				fmt.Fprint(w, "\tok")
+			}
+		}
+		fmt.Fprintln(w)
+	}
+	w.Flush()
+
+	if !failed {
+		return nil
+	}
+	return errors.New("connectivity matrix:\n" + buf.String())
+}
+
+var _ = Describe("Pod networking", func() {
+	var (
+		name string
+		pods []runner.Pod
+	)
+
+	BeforeEach(func() {
+		skipUnlessEnabled("networking")
+
+		nodes := schedulableNodes()
+		if len(nodes) < 2 {
+			Skip(fmt.Sprintf("cross-node networking needs at least two schedulable nodes, found %d", len(nodes)))
+		}
+
+		name = "net-" + randSeq(6)
+		replicas := int32(len(nodes))
+		d := nginxDeployment(name)
+		d.Spec.Replicas = &replicas
+		d.Spec.Template.Spec.Affinity = &runner.Affinity{
+			PodAntiAffinity: &runner.PodAntiAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: []runner.PodAffinityTerm{{
+					LabelSelector: &runner.LabelSelector{MatchLabels: map[string]string{"app": name}},
+					TopologyKey:   "kubernetes.io/hostname",
+				}},
+			},
+		}
+		_, err := k8sRunner.CreateDeployment(d)
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = k8sRunner.CreateService(&runner.Service{
+			ObjectMeta: runner.ObjectMeta{Name: name},
+			Spec: runner.ServiceSpec{
+				Selector: map[string]string{"app": name},
+				Ports:    []runner.ServicePort{{Port: 80}},
+			},
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(runner.WaitForRollout(k8sRunner, name, testConfig.Timeouts.Rollout.Duration)).To(Succeed())
+
+		pods, err = k8sRunner.ListPods("app=" + name)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pods).To(HaveLen(len(nodes)))
+	})
+
+	AfterEach(func() {
+		if name == "" {
+			return
+		}
+		Expect(k8sRunner.DeleteService(name)).To(Succeed())
+		Expect(k8sRunner.DeleteDeployment(name)).To(Succeed())
+		name = ""
+	})
+
+	It("connects pods on different nodes in both directions", func() {
+		var targets []target
+		for _, pod := range pods {
+			targets = append(targets, target{
+				label: fmt.Sprintf("%s (%s)", pod.Status.PodIP, pod.Spec.NodeName),
+				ip:    pod.Status.PodIP,
+				pod:   pod.Name,
+			})
+		}
+
+		Eventually(func() error {
+			return checkConnectivity(pods, targets, 80).Err()
+		}, testConfig.Timeouts.Default.Duration, "5s").Should(Succeed())
+	})
+
+	It("reaches the service cluster IP from every node", func() {
+		svc, err := k8sRunner.GetService(name)
+		Expect(err).NotTo(HaveOccurred())
+		targets := []target{{label: "ClusterIP " + svc.Spec.ClusterIP, ip: svc.Spec.ClusterIP}}
+
+		Eventually(func() error {
+			return checkConnectivity(pods, targets, 80).Err()
+		}, testConfig.Timeouts.Default.Duration, "5s").Should(Succeed())
+	})
+})
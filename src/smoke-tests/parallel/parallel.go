@@ -0,0 +1,186 @@
+// Package parallel runs a compiled Ginkgo suite as several parallel nodes.
+// It stands in for the ginkgo CLI, which the smoke-tests errand does not
+// ship: it starts the nodes and serves the synchronization endpoints they
+// use to share SynchronizedBeforeSuite data, hand out specs and wait for
+// each other in SynchronizedAfterSuite.
+package parallel
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/onsi/ginkgo/types"
+)
+
+// Run starts total copies of binary with args plus the Ginkgo parallel
+// flags, and waits for them. Every node uses seed, so that all of them
+// shuffle the specs into the same order. Each line a node prints is written
+// to out prefixed with its node number. Run fails if any node failed.
+func Run(binary string, args []string, total int, seed int64, out io.Writer) error {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	s := NewServer(total)
+	go http.Serve(listener, s)
+	syncHost := "http://" + listener.Addr().String()
+
+	var (
+		wg     sync.WaitGroup
+		outMu  sync.Mutex
+		errsMu sync.Mutex
+		errs   = map[int]error{}
+	)
+	for node := 1; node <= total; node++ {
+		nodeArgs := append(append([]string{}, args...),
+			fmt.Sprintf("-ginkgo.parallel.node=%d", node),
+			fmt.Sprintf("-ginkgo.parallel.total=%d", total),
+			"-ginkgo.parallel.synchost="+syncHost,
+			fmt.Sprintf("-ginkgo.seed=%d", seed),
+		)
+		w := &prefixWriter{prefix: fmt.Sprintf("[node %d] ", node), out: out, mu: &outMu}
+		cmd := exec.Command(binary, nodeArgs...)
+		cmd.Stdout, cmd.Stderr = w, w
+
+		if err := cmd.Start(); err != nil {
+			s.Exited(node)
+			errsMu.Lock()
+			errs[node] = err
+			errsMu.Unlock()
+			continue
+		}
+		wg.Add(1)
+		go func(node int) {
+			defer wg.Done()
+			err := cmd.Wait()
+			w.Flush()
+			s.Exited(node)
+			if err != nil {
+				errsMu.Lock()
+				errs[node] = err
+				errsMu.Unlock()
+			}
+		}(node)
+	}
+	wg.Wait()
+
+	if len(errs) == 0 {
+		return nil
+	}
+	var failed []int
+	for node := range errs {
+		failed = append(failed, node)
+	}
+	sort.Ints(failed)
+	var msgs []string
+	for _, node := range failed {
+		msgs = append(msgs, fmt.Sprintf("node %d: %v", node, errs[node]))
+	}
+	return fmt.Errorf("%d of %d nodes failed: %s", len(failed), total, strings.Join(msgs, ", "))
+}
+
+// Server implements the synchronization endpoints of the ginkgo CLI's
+// remote server. Streaming endpoints are not needed, as every node reports
+// on its own.
+type Server struct {
+	mu          sync.Mutex
+	total       int
+	beforeSuite types.RemoteBeforeSuiteData
+	counter     int
+	done        map[int]bool
+}
+
+func NewServer(total int) *Server {
+	return &Server{
+		total:       total,
+		beforeSuite: types.RemoteBeforeSuiteData{State: types.RemoteBeforeSuiteStatePending},
+		done:        map[int]bool{},
+	}
+}
+
+// Exited records that node has finished, which releases the nodes waiting
+// for it.
+func (s *Server) Exited(node int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.done[node] = true
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch r.URL.Path {
+	case "/BeforeSuiteState":
+		if r.Method == http.MethodPost {
+			json.NewDecoder(r.Body).Decode(&s.beforeSuite)
+			return
+		}
+		state := s.beforeSuite
+		if state.State == types.RemoteBeforeSuiteStatePending && s.done[1] {
+			state.State = types.RemoteBeforeSuiteStateDisappeared
+		}
+		json.NewEncoder(w).Encode(state)
+
+	case "/RemoteAfterSuiteData":
+		canRun := true
+		for node := 2; node <= s.total; node++ {
+			canRun = canRun && s.done[node]
+		}
+		json.NewEncoder(w).Encode(types.RemoteAfterSuiteData{CanRun: canRun})
+
+	case "/counter":
+		json.NewEncoder(w).Encode(struct{ Index int }{s.counter})
+		s.counter++
+
+	case "/has-counter":
+		// An empty 200 tells the nodes to take specs from /counter rather
+		// than each running a fixed shard.
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// prefixWriter writes whole lines to out, each starting with prefix, so that
+// the output of concurrent nodes does not interleave within a line.
+type prefixWriter struct {
+	prefix string
+	out    io.Writer
+	mu     *sync.Mutex
+	buf    bytes.Buffer
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+	for {
+		i := bytes.IndexByte(w.buf.Bytes(), '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		w.emit(w.buf.Next(i + 1))
+	}
+}
+
+// Flush writes out a final line that did not end in a newline.
+func (w *prefixWriter) Flush() {
+	if w.buf.Len() > 0 {
+		w.emit(append(w.buf.Next(w.buf.Len()), '\n'))
+	}
+}
+
+func (w *prefixWriter) emit(line []byte) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	io.WriteString(w.out, w.prefix)
+	w.out.Write(line)
+}
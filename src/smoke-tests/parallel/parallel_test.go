@@ -0,0 +1,109 @@
+package parallel_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"smoke-tests/parallel"
+
+	"github.com/onsi/ginkgo/types"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Run", func() {
+	It("starts every node with the parallel flags and the shared seed", func() {
+		var out bytes.Buffer
+		err := parallel.Run("/bin/sh", []string{"-c", `echo "$@"`, "sh", "-parallelism=1"}, 2, 42, &out)
+		Expect(err).NotTo(HaveOccurred())
+
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		Expect(lines).To(HaveLen(2))
+		Expect(lines).To(ContainElement(MatchRegexp(`^\[node 1\] -parallelism=1 -ginkgo.parallel.node=1 -ginkgo.parallel.total=2 -ginkgo.parallel.synchost=http://127.0.0.1:\d+ -ginkgo.seed=42$`)))
+		Expect(lines).To(ContainElement(HavePrefix("[node 2] -parallelism=1 -ginkgo.parallel.node=2 ")))
+	})
+
+	It("prefixes a final line without a newline", func() {
+		var out bytes.Buffer
+		Expect(parallel.Run("/bin/sh", []string{"-c", "printf 'a\\nb'", "sh"}, 1, 1, &out)).To(Succeed())
+		Expect(out.String()).To(Equal("[node 1] a\n[node 1] b\n"))
+	})
+
+	It("reports which nodes failed", func() {
+		var out bytes.Buffer
+		err := parallel.Run("/bin/sh", []string{"-c", `case "$1" in *node=2) exit 1;; esac`, "sh"}, 3, 1, &out)
+		Expect(err).To(MatchError("1 of 3 nodes failed: node 2: exit status 1"))
+	})
+})
+
+var _ = Describe("Server", func() {
+	var (
+		s      *parallel.Server
+		server *httptest.Server
+	)
+
+	BeforeEach(func() {
+		s = parallel.NewServer(3)
+		server = httptest.NewServer(s)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	getJSON := func(path string, into interface{}) {
+		resp, err := http.Get(server.URL + path)
+		Expect(err).NotTo(HaveOccurred())
+		defer resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(json.NewDecoder(resp.Body).Decode(into)).To(Succeed())
+	}
+
+	It("hands out every spec index once", func() {
+		resp, err := http.Get(server.URL + "/has-counter")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+		var first, second struct{ Index int }
+		getJSON("/counter", &first)
+		getJSON("/counter", &second)
+		Expect(first.Index).To(Equal(0))
+		Expect(second.Index).To(Equal(1))
+	})
+
+	It("shares the state node 1 posts after its BeforeSuite", func() {
+		var state types.RemoteBeforeSuiteData
+		getJSON("/BeforeSuiteState", &state)
+		Expect(state.State).To(Equal(types.RemoteBeforeSuiteStatePending))
+
+		posted := types.RemoteBeforeSuiteData{Data: []byte("run-id"), State: types.RemoteBeforeSuiteStatePassed}
+		_, err := http.Post(server.URL+"/BeforeSuiteState", "application/json", bytes.NewReader(posted.ToJSON()))
+		Expect(err).NotTo(HaveOccurred())
+
+		getJSON("/BeforeSuiteState", &state)
+		Expect(state).To(Equal(posted))
+	})
+
+	It("tells the other nodes when node 1 exited without running BeforeSuite", func() {
+		s.Exited(1)
+
+		var state types.RemoteBeforeSuiteData
+		getJSON("/BeforeSuiteState", &state)
+		Expect(state.State).To(Equal(types.RemoteBeforeSuiteStateDisappeared))
+	})
+
+	It("lets node 1 run its AfterSuite once every other node has exited", func() {
+		var data types.RemoteAfterSuiteData
+		s.Exited(2)
+		getJSON("/RemoteAfterSuiteData", &data)
+		Expect(data.CanRun).To(BeFalse())
+
+		s.Exited(3)
+		getJSON("/RemoteAfterSuiteData", &data)
+		Expect(data.CanRun).To(BeTrue())
+	})
+})
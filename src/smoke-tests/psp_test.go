@@ -0,0 +1,88 @@
+package smoke_tests_test
+
+import (
+	"smoke-tests/runner"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// pspChecker is the user the PSP fixture grants pod creation to. Pods are
+// submitted as this user because the admin may use every policy in the
+// cluster, which would hide what a policy itself allows.
+func pspChecker(namespace string) string {
+	return "smoke-test-psp-checker-" + namespace
+}
+
+// expectRejectedByPSP asserts that admission refused the pod for reason. A
+// pod that is admitted means PodSecurityPolicy admission is not enforcing.
+func expectRejectedByPSP(err error, reason string) {
+	ExpectWithOffset(1, err).To(HaveOccurred(), "the pod was admitted; is the PodSecurityPolicy admission plugin enabled?")
+	ExpectWithOffset(1, runner.IsForbidden(err)).To(BeTrue(), "expected a Forbidden admission error, got: %v", err)
+	ExpectWithOffset(1, err).To(MatchError(ContainSubstring("unable to validate against any pod security policy")))
+	ExpectWithOffset(1, err).To(MatchError(ContainSubstring(reason)))
+}
+
+// describePolicy adds specs that submit pods in namespace, running as
+// serviceAccount, that policy must admit or reject. The arguments are
+// functions as the test namespace is only known once the suite has started.
+func describePolicy(namespace func() string, serviceAccount string, policy func() string) {
+	submit := func(mutate func(*runner.Pod)) (*runner.Pod, error) {
+		pod := testPod("psp-" + randSeq(6))
+		pod.Spec.ServiceAccountName = serviceAccount
+		mutate(pod)
+		return k8sRunner.DryRunPod(namespace(), pspChecker(k8sRunner.Namespace()), pod)
+	}
+	yes := true
+
+	It("admits an unprivileged pod", func() {
+		admitted, err := submit(func(*runner.Pod) {})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(admitted.Annotations).To(HaveKeyWithValue("kubernetes.io/psp", policy()),
+			"the pod was not admitted by %s; is the PodSecurityPolicy admission plugin enabled?", policy())
+	})
+
+	It("rejects a privileged container", func() {
+		_, err := submit(func(p *runner.Pod) {
+			p.Spec.Containers[0].SecurityContext = &runner.SecurityContext{Privileged: &yes}
+		})
+		expectRejectedByPSP(err, "Privileged containers are not allowed")
+	})
+
+	It("rejects a hostPath volume", func() {
+		_, err := submit(func(p *runner.Pod) {
+			p.Spec.Volumes = []runner.Volume{{Name: "host", HostPath: &runner.HostPathVolumeSource{Path: "/"}}}
+			p.Spec.Containers[0].VolumeMounts = []runner.VolumeMount{{Name: "host", MountPath: "/host"}}
+		})
+		expectRejectedByPSP(err, "hostPath volumes are not allowed to be used")
+	})
+
+	It("rejects privilege escalation", func() {
+		_, err := submit(func(p *runner.Pod) {
+			p.Spec.Containers[0].SecurityContext = &runner.SecurityContext{AllowPrivilegeEscalation: &yes}
+		})
+		expectRejectedByPSP(err, "Allowing privilege escalation for containers is not allowed")
+	})
+}
+
+var _ = Describe("PodSecurityPolicy admission", func() {
+	BeforeEach(func() {
+		skipUnlessEnabled("psp")
+	})
+
+	Context("with the smoke-tests fixture policy", func() {
+		describePolicy(
+			func() string { return k8sRunner.Namespace() },
+			"default",
+			func() string { return "smoke-test-" + k8sRunner.Namespace() },
+		)
+	})
+
+	Context("with kube-system-psp", func() {
+		describePolicy(
+			func() string { return "kube-system" },
+			"coredns",
+			func() string { return "kube-system-psp" },
+		)
+	})
+})
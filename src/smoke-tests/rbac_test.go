@@ -0,0 +1,248 @@
+package smoke_tests_test
+
+import (
+	"fmt"
+	"smoke-tests/runner"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// identity is a user the API server authenticates, with the groups it is
+// given: tokens.csv users are in system:authenticated (and the admin in
+// system:masters), service accounts in the system:serviceaccounts groups.
+type identity struct {
+	user   string
+	groups []string
+}
+
+func tokenUser(user string, groups ...string) identity {
+	return identity{user: user, groups: append(groups, "system:authenticated")}
+}
+
+func serviceAccount(namespace, name string) identity {
+	return identity{
+		user:   "system:serviceaccount:" + namespace + ":" + name,
+		groups: []string{"system:serviceaccounts", "system:serviceaccounts:" + namespace, "system:authenticated"},
+	}
+}
+
+// access is a verb on a resource, cluster-wide. The resource is written as
+// kubectl prints it, e.g. pods/eviction or daemonsets.apps.
+type access struct {
+	verb     string
+	resource string
+}
+
+func (a access) attributes() *runner.ResourceAttributes {
+	attrs := &runner.ResourceAttributes{Verb: a.verb}
+	resource := a.resource
+	if i := strings.Index(resource, "/"); i > 0 {
+		resource, attrs.Subresource = resource[:i], resource[i+1:]
+	}
+	if i := strings.Index(resource, "."); i > 0 {
+		resource, attrs.Group = resource[:i], resource[i+1:]
+	}
+	attrs.Resource = resource
+	return attrs
+}
+
+func (a access) String() string {
+	return a.verb + " " + a.resource
+}
+
+// accessTable lists what the kubernetes-roles policies, together with the
+// default roles they bind, must and must not let an identity do.
+type accessTable struct {
+	identity identity
+	allowed  []access
+	denied   []access
+}
+
+// privileged is denied to every identity except the admin. Any of these
+// would let a component credential take over the cluster.
+var privileged = []access{
+	{"*", "*.*"},
+	{"create", "clusterrolebindings.rbac.authorization.k8s.io"},
+	{"bind", "clusterroles.rbac.authorization.k8s.io"},
+	{"escalate", "clusterroles.rbac.authorization.k8s.io"},
+	{"impersonate", "users"},
+	{"create", "pods/exec"},
+}
+
+func accessTables() []accessTable {
+	tables := []accessTable{
+		{
+			identity: tokenUser(testConfig.RBAC.AdminUsername, "system:masters"),
+			allowed:  privileged,
+		},
+		{
+			identity: tokenUser("kubelet"),
+			allowed: []access{
+				{"get", "nodes"},
+				{"patch", "nodes/status"},
+				{"list", "pods"},
+				{"get", "configmaps"},
+				{"create", "events"},
+			},
+			denied: []access{
+				{"list", "secrets"},
+				{"create", "namespaces"},
+				{"create", "deployments.apps"},
+				{"update", "clusterroles.rbac.authorization.k8s.io"},
+			},
+		},
+		{
+			identity: tokenUser("kubelet-drain"),
+			allowed: []access{
+				{"list", "nodes"},
+				{"get", "nodes"},
+				{"patch", "nodes"},
+				{"delete", "nodes"},
+				{"list", "pods"},
+				{"delete", "pods"},
+				{"create", "pods/eviction"},
+				{"list", "poddisruptionbudgets.policy"},
+				{"list", "volumeattachments.storage.k8s.io"},
+				{"create", "events"},
+				{"get", "statefulsets.apps"},
+				{"get", "daemonsets.apps"},
+				{"get", "daemonsets.extensions"},
+				{"get", "replicasets.extensions"},
+				{"get", "jobs.batch"},
+				{"get", "replicationcontrollers"},
+			},
+			denied: []access{
+				{"create", "pods"},
+				{"update", "nodes"},
+				{"get", "secrets"},
+				{"list", "secrets"},
+				{"delete", "deployments.apps"},
+				{"delete", "daemonsets.apps"},
+			},
+		},
+		{
+			identity: tokenUser("kube-proxy"),
+			allowed: []access{
+				{"list", "services"},
+				{"list", "endpoints"},
+				{"get", "nodes"},
+				{"create", "events"},
+			},
+			denied: []access{
+				{"update", "services"},
+				{"delete", "endpoints"},
+				{"get", "secrets"},
+				{"create", "pods"},
+			},
+		},
+		{
+			identity: tokenUser("system:kube-controller-manager"),
+			allowed: []access{
+				{"list", "pods"},
+				{"get", "secrets"},
+				{"create", "serviceaccounts"},
+				{"create", "tokenreviews.authentication.k8s.io"},
+				{"create", "events"},
+			},
+			denied: []access{
+				{"delete", "nodes"},
+				{"update", "clusterroles.rbac.authorization.k8s.io"},
+			},
+		},
+		{
+			identity: tokenUser("system:kube-scheduler"),
+			allowed: []access{
+				{"list", "nodes"},
+				{"list", "pods"},
+				{"create", "pods/binding"},
+				{"update", "pods/status"},
+			},
+			denied: []access{
+				{"create", "pods"},
+				{"update", "nodes"},
+				{"get", "secrets"},
+				{"create", "deployments.apps"},
+			},
+		},
+	}
+
+	switch testConfig.RBAC.CloudProvider {
+	case "vsphere":
+		tables = append(tables, accessTable{
+			identity: serviceAccount("kube-system", "vsphere-cloud-provider"),
+			allowed: []access{
+				{"get", "nodes"},
+				{"patch", "nodes/status"},
+			},
+			denied: []access{
+				{"list", "secrets"},
+				{"create", "deployments.apps"},
+			},
+		})
+	case "azure":
+		tables = append(tables, accessTable{
+			identity: serviceAccount("kube-system", "persistent-volume-binder"),
+			allowed: []access{
+				{"get", "secrets"},
+				{"create", "secrets"},
+			},
+			denied: []access{
+				{"update", "secrets"},
+				{"delete", "nodes"},
+			},
+		})
+	}
+
+	for i := range tables {
+		if tables[i].identity.user != testConfig.RBAC.AdminUsername {
+			tables[i].denied = append(tables[i].denied, privileged...)
+		}
+	}
+	return tables
+}
+
+// accessMismatches reviews every entry of table and describes each one the
+// API server answers differently. Widened access is listed first, as it is
+// the more dangerous regression.
+func accessMismatches(table accessTable) []string {
+	review := func(a access) bool {
+		r, err := k8sRunner.CreateSubjectAccessReview(&runner.SubjectAccessReview{
+			Spec: runner.SubjectAccessReviewSpec{
+				User:               table.identity.user,
+				Groups:             table.identity.groups,
+				ResourceAttributes: a.attributes(),
+			},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		return r.Status.Allowed
+	}
+
+	var widened, narrowed []string
+	for _, a := range table.denied {
+		if review(a) {
+			widened = append(widened, fmt.Sprintf("WIDENED: %s may %s, which must be denied", table.identity.user, a))
+		}
+	}
+	for _, a := range table.allowed {
+		if !review(a) {
+			narrowed = append(narrowed, fmt.Sprintf("NARROWED: %s may not %s, which it needs", table.identity.user, a))
+		}
+	}
+	return append(widened, narrowed...)
+}
+
+var _ = Describe("RBAC policies", func() {
+	BeforeEach(func() {
+		skipUnlessEnabled("rbac")
+	})
+
+	It("grants every identity exactly the access its policy intends", func() {
+		var mismatches []string
+		for _, table := range accessTables() {
+			mismatches = append(mismatches, accessMismatches(table)...)
+		}
+		Expect(mismatches).To(BeEmpty(), "RBAC policies do not match the expected access:\n"+strings.Join(mismatches, "\n"))
+	})
+})
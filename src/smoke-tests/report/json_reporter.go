@@ -0,0 +1,174 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/onsi/ginkgo/config"
+	"github.com/onsi/ginkgo/types"
+)
+
+// Summary is the machine readable result of a suite run, as written by
+// JSONReporter.
+type Summary struct {
+	Suite          string    `json:"suite"`
+	StartedAt      time.Time `json:"started_at"`
+	RunTimeSeconds float64   `json:"run_time_seconds"`
+	Success        bool      `json:"success"`
+	Counts         Counts    `json:"counts"`
+	SetupFailures  []Result  `json:"setup_failures"`
+	Specs          []Result  `json:"specs"`
+}
+
+type Counts struct {
+	Total   int `json:"total"`
+	Passed  int `json:"passed"`
+	Failed  int `json:"failed"`
+	Skipped int `json:"skipped"`
+	Pending int `json:"pending"`
+}
+
+type Result struct {
+	Name            string   `json:"name"`
+	State           string   `json:"state"`
+	DurationSeconds float64  `json:"duration_seconds"`
+	Failure         *Failure `json:"failure,omitempty"`
+	// Output holds the kubectl commands run by the spec and whatever they
+	// wrote, as captured through the reporter's Write method.
+	Output string `json:"output,omitempty"`
+}
+
+type Failure struct {
+	Message  string `json:"message"`
+	Location string `json:"location"`
+	Panic    string `json:"panic,omitempty"`
+}
+
+// JSONReporter is a ginkgo reporter that writes a Summary to a file when the
+// suite ends. It is also an io.Writer: anything written to it while a spec
+// runs is recorded as that spec's output.
+type JSONReporter struct {
+	filename string
+	summary  Summary
+
+	mu     sync.Mutex
+	output bytes.Buffer
+}
+
+func NewJSONReporter(filename string) *JSONReporter {
+	return &JSONReporter{filename: filename}
+}
+
+func (r *JSONReporter) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.output.Write(p)
+}
+
+func (r *JSONReporter) takeOutput() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := r.output.String()
+	r.output.Reset()
+	return out
+}
+
+func (r *JSONReporter) SpecSuiteWillBegin(config config.GinkgoConfigType, summary *types.SuiteSummary) {
+	r.summary = Summary{
+		Suite:         summary.SuiteDescription,
+		StartedAt:     time.Now().UTC(),
+		SetupFailures: []Result{},
+		Specs:         []Result{},
+	}
+}
+
+func (r *JSONReporter) BeforeSuiteDidRun(setupSummary *types.SetupSummary) {
+	r.handleSetupSummary("BeforeSuite", setupSummary)
+}
+
+func (r *JSONReporter) AfterSuiteDidRun(setupSummary *types.SetupSummary) {
+	r.handleSetupSummary("AfterSuite", setupSummary)
+}
+
+func (r *JSONReporter) handleSetupSummary(name string, setupSummary *types.SetupSummary) {
+	output := r.takeOutput()
+	if setupSummary.State == types.SpecStatePassed {
+		return
+	}
+	r.summary.SetupFailures = append(r.summary.SetupFailures, Result{
+		Name:            name,
+		State:           stateName(setupSummary.State),
+		DurationSeconds: setupSummary.RunTime.Seconds(),
+		Failure:         failure(setupSummary.Failure),
+		Output:          output,
+	})
+}
+
+func (r *JSONReporter) SpecWillRun(specSummary *types.SpecSummary) {
+	r.takeOutput()
+}
+
+func (r *JSONReporter) SpecDidComplete(specSummary *types.SpecSummary) {
+	result := Result{
+		Name:            strings.Join(specSummary.ComponentTexts[1:], " "),
+		State:           stateName(specSummary.State),
+		DurationSeconds: specSummary.RunTime.Seconds(),
+		Output:          r.takeOutput(),
+	}
+	if specSummary.HasFailureState() {
+		result.Failure = failure(specSummary.Failure)
+	}
+	r.summary.Specs = append(r.summary.Specs, result)
+}
+
+func (r *JSONReporter) SpecSuiteDidEnd(summary *types.SuiteSummary) {
+	r.summary.RunTimeSeconds = summary.RunTime.Seconds()
+	r.summary.Success = summary.SuiteSucceeded
+	r.summary.Counts = Counts{
+		Total:   summary.NumberOfTotalSpecs,
+		Passed:  summary.NumberOfPassedSpecs,
+		Failed:  summary.NumberOfFailedSpecs,
+		Skipped: summary.NumberOfSkippedSpecs,
+		Pending: summary.NumberOfPendingSpecs,
+	}
+
+	raw, err := json.MarshalIndent(r.summary, "", "  ")
+	if err == nil {
+		err = ioutil.WriteFile(r.filename, raw, 0644)
+	}
+	if err != nil {
+		fmt.Printf("Failed to write JSON summary %s: %s\n", r.filename, err)
+	}
+}
+
+func failure(f types.SpecFailure) *Failure {
+	return &Failure{
+		Message:  f.Message,
+		Location: f.Location.String(),
+		Panic:    f.ForwardedPanic,
+	}
+}
+
+func stateName(state types.SpecState) string {
+	switch state {
+	case types.SpecStatePending:
+		return "pending"
+	case types.SpecStateSkipped:
+		return "skipped"
+	case types.SpecStatePassed:
+		return "passed"
+	case types.SpecStateFailed:
+		return "failed"
+	case types.SpecStatePanicked:
+		return "panicked"
+	case types.SpecStateTimedOut:
+		return "timedout"
+	default:
+		return "invalid"
+	}
+}
@@ -0,0 +1,100 @@
+package report_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"smoke-tests/report"
+
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/config"
+	"github.com/onsi/ginkgo/types"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("JSONReporter", func() {
+	var (
+		tmpDir   string
+		filename string
+		reporter *report.JSONReporter
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "report")
+		Expect(err).NotTo(HaveOccurred())
+		filename = filepath.Join(tmpDir, "summary.json")
+		reporter = report.NewJSONReporter(filename)
+		reporter.SpecSuiteWillBegin(config.GinkgoConfigType{}, &types.SuiteSummary{SuiteDescription: "CFCR Smoke-Tests Suite"})
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	readSummary := func() report.Summary {
+		raw, err := ioutil.ReadFile(filename)
+		Expect(err).NotTo(HaveOccurred())
+		var s report.Summary
+		Expect(json.Unmarshal(raw, &s)).To(Succeed())
+		return s
+	}
+
+	spec := func(state types.SpecState, runTime time.Duration) *types.SpecSummary {
+		return &types.SpecSummary{
+			ComponentTexts: []string{"[Top Level]", "CFCR Smoke Tests", "Deployment", "shows the pods are healthy"},
+			State:          state,
+			RunTime:        runTime,
+			Failure: types.SpecFailure{
+				Message:  "Expected <string>: Pending to equal <string>: Running",
+				Location: types.CodeLocation{FileName: "smoke_test.go", LineNumber: 42},
+			},
+		}
+	}
+
+	It("records each spec with its duration, failure and captured output", func() {
+		reporter.SpecWillRun(spec(types.SpecStateFailed, 0))
+		reporter.Write([]byte("kubectl --namespace test get pods -o json\n"))
+		reporter.SpecDidComplete(spec(types.SpecStateFailed, 1500*time.Millisecond))
+
+		reporter.SpecWillRun(spec(types.SpecStatePassed, 0))
+		reporter.SpecDidComplete(spec(types.SpecStatePassed, 2*time.Second))
+
+		reporter.SpecSuiteDidEnd(&types.SuiteSummary{NumberOfTotalSpecs: 2, NumberOfPassedSpecs: 1, NumberOfFailedSpecs: 1})
+
+		s := readSummary()
+		Expect(s.Suite).To(Equal("CFCR Smoke-Tests Suite"))
+		Expect(s.Success).To(BeFalse())
+		Expect(s.Counts).To(Equal(report.Counts{Total: 2, Passed: 1, Failed: 1}))
+		Expect(s.Specs).To(HaveLen(2))
+
+		failed := s.Specs[0]
+		Expect(failed.Name).To(Equal("CFCR Smoke Tests Deployment shows the pods are healthy"))
+		Expect(failed.State).To(Equal("failed"))
+		Expect(failed.DurationSeconds).To(Equal(1.5))
+		Expect(failed.Failure.Message).To(ContainSubstring("to equal <string>: Running"))
+		Expect(failed.Failure.Location).To(Equal("smoke_test.go:42"))
+		Expect(failed.Output).To(Equal("kubectl --namespace test get pods -o json\n"))
+
+		passed := s.Specs[1]
+		Expect(passed.State).To(Equal("passed"))
+		Expect(passed.Failure).To(BeNil())
+		Expect(passed.Output).To(BeEmpty())
+	})
+
+	It("records setup failures only", func() {
+		reporter.Write([]byte("kubectl --namespace test create namespace test\n"))
+		reporter.BeforeSuiteDidRun(&types.SetupSummary{State: types.SpecStateFailed, Failure: types.SpecFailure{Message: "namespace exists"}})
+		reporter.AfterSuiteDidRun(&types.SetupSummary{State: types.SpecStatePassed})
+		reporter.SpecSuiteDidEnd(&types.SuiteSummary{})
+
+		s := readSummary()
+		Expect(s.SetupFailures).To(HaveLen(1))
+		Expect(s.SetupFailures[0].Name).To(Equal("BeforeSuite"))
+		Expect(s.SetupFailures[0].Failure.Message).To(Equal("namespace exists"))
+		Expect(s.SetupFailures[0].Output).To(ContainSubstring("create namespace"))
+	})
+})
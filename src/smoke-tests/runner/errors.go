@@ -0,0 +1,44 @@
+package runner
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+
+	"kubo-tools/kube"
+)
+
+// Error reports a failed operation against the cluster. It records the verb
+// and resource so that a failing spec says what broke, not just that
+// something did. The native runner returns the errors of package kube as
+// they are; the kubectl runner builds them from kubectl's output.
+type Error = kube.Error
+
+func IsNotFound(err error) bool {
+	return kube.IsNotFound(err)
+}
+
+func IsAlreadyExists(err error) bool {
+	return kube.IsAlreadyExists(err)
+}
+
+// IsForbidden reports whether the API server refused the request, either
+// because RBAC denied it or because an admission plugin rejected the object.
+func IsForbidden(err error) bool {
+	return kube.IsForbidden(err)
+}
+
+var kubectlReason = regexp.MustCompile(`Error from server \((\w+)\)`)
+
+// kubectlError builds an Error from a failed kubectl invocation, recovering
+// the Status reason from its stderr where possible.
+func kubectlError(verb, resource, namespace, name string, stderr []byte, err error) error {
+	e := &Error{Verb: verb, Resource: resource, Namespace: namespace, Name: name, Err: err}
+	if m := kubectlReason.FindSubmatch(stderr); m != nil {
+		e.Reason = string(m[1])
+	}
+	if msg := bytes.TrimSpace(stderr); len(msg) > 0 {
+		e.Err = fmt.Errorf("%v: %s", err, msg)
+	}
+	return e
+}
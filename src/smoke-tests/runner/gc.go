@@ -0,0 +1,59 @@
+package runner
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// RunIDLabel marks every object a suite run creates with the ID of that run,
+// so that CollectGarbage only ever deletes objects the suite created.
+const RunIDLabel = "smoke-tests.cfcr.io/run-id"
+
+// garbageResources are collected in this order. Namespaces go last; deleting
+// one also deletes the Roles and RoleBindings in it.
+var garbageResources = []string{
+	"podsecuritypolicies.policy",
+	"clusterrolebindings.rbac.authorization.k8s.io",
+	"clusterroles.rbac.authorization.k8s.io",
+	"namespaces",
+}
+
+// CollectGarbage deletes the objects left behind by earlier runs that were
+// killed before their AfterSuite: test-* namespaces and the cluster-scoped
+// fixture objects, if they carry a run ID other than runID and are older than
+// maxAge. It returns a line for every object it deleted.
+func CollectGarbage(r Runner, runID string, maxAge time.Duration, now time.Time) ([]string, error) {
+	var deleted []string
+	for _, resource := range garbageResources {
+		objects, err := r.ListMetadata(resource, RunIDLabel)
+		if err != nil {
+			return deleted, err
+		}
+		for _, o := range objects {
+			if !isGarbage(resource, o, runID, maxAge, now) {
+				continue
+			}
+			if err := r.DeleteObject(resource, o.Name); err != nil && !IsNotFound(err) {
+				return deleted, err
+			}
+			deleted = append(deleted, fmt.Sprintf("%s %s (run %s, created %s)", resource, o.Name, o.Labels[RunIDLabel], o.CreationTimestamp))
+		}
+	}
+	return deleted, nil
+}
+
+func isGarbage(resource string, o ObjectMeta, runID string, maxAge time.Duration, now time.Time) bool {
+	owner := o.Labels[RunIDLabel]
+	if owner == "" || owner == runID {
+		return false
+	}
+	if resource == "namespaces" && !strings.HasPrefix(o.Name, "test-") {
+		return false
+	}
+	created, err := time.Parse(time.RFC3339, o.CreationTimestamp)
+	if err != nil {
+		return false
+	}
+	return now.Sub(created) > maxAge
+}
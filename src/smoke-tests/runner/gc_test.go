@@ -0,0 +1,84 @@
+package runner_test
+
+import (
+	"io/ioutil"
+	"os"
+	"time"
+
+	"kubo-tools/kube"
+	"kubo-tools/kube/kubetest"
+	"smoke-tests/runner"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CollectGarbage", func() {
+	var (
+		server *kubetest.Server
+		tmpDir string
+		r      *runner.NativeRunner
+		now    time.Time
+	)
+
+	object := func(name, runID string, age time.Duration) runner.Namespace {
+		o := runner.Namespace{ObjectMeta: runner.ObjectMeta{
+			Name:              name,
+			CreationTimestamp: now.Add(-age).Format(time.RFC3339),
+		}}
+		if runID != "" {
+			o.Labels = map[string]string{runner.RunIDLabel: runID}
+		}
+		return o
+	}
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "gc")
+		Expect(err).NotTo(HaveOccurred())
+
+		server = kubetest.NewServer()
+		r, err = runner.NewNativeRunner(server.WriteKubeconfig(tmpDir, "s3cr3t"), "test-current")
+		Expect(err).NotTo(HaveOccurred())
+		now = time.Now()
+	})
+
+	AfterEach(func() {
+		server.Close()
+		os.RemoveAll(tmpDir)
+	})
+
+	It("deletes stale namespaces and PSPs of other runs", func() {
+		server.Put(kube.Namespaces, object("test-stale", "stale", 3*time.Hour))
+		server.Put(kube.PodSecurityPolicies, object("smoke-test-test-stale", "stale", 3*time.Hour))
+
+		deleted, err := runner.CollectGarbage(r, "current", 2*time.Hour, now)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(deleted).To(HaveLen(2))
+		Expect(deleted[0]).To(HavePrefix("podsecuritypolicies.policy smoke-test-test-stale (run stale"))
+		Expect(deleted[1]).To(HavePrefix("namespaces test-stale (run stale"))
+
+		Expect(server.Get(kube.Namespaces, "", "test-stale")).To(BeNil())
+		Expect(server.Get(kube.PodSecurityPolicies, "", "smoke-test-test-stale")).To(BeNil())
+	})
+
+	It("keeps objects of the current run and recent runs", func() {
+		server.Put(kube.Namespaces, object("test-current", "current", 3*time.Hour))
+		server.Put(kube.Namespaces, object("test-recent", "recent", time.Minute))
+
+		deleted, err := runner.CollectGarbage(r, "current", 2*time.Hour, now)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(deleted).To(BeEmpty())
+	})
+
+	It("never touches objects without a run ID or outside the test namespaces", func() {
+		server.Put(kube.Namespaces, object("test-unlabelled", "", 3*time.Hour))
+		server.Put(kube.Namespaces, object("production", "stale", 3*time.Hour))
+		server.Put(kube.PodSecurityPolicies, object("privileged", "", 3*time.Hour))
+
+		deleted, err := runner.CollectGarbage(r, "current", 2*time.Hour, now)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(deleted).To(BeEmpty())
+		Expect(server.RequestLines()).NotTo(ContainElement(HavePrefix("DELETE")))
+	})
+})
@@ -0,0 +1,327 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+
+	"kubo-tools/kube"
+)
+
+var _ Runner = &NativeRunner{}
+
+// NativeRunner talks to the API server directly, using the credentials from
+// a kubeconfig file, rather than shelling out to kubectl.
+type NativeRunner struct {
+	namespace string
+	client    *kube.Client
+}
+
+func NewNativeRunner(kubeconfigPath, namespace string) (*NativeRunner, error) {
+	client, err := kube.NewClient(kubeconfigPath)
+	if err != nil {
+		return nil, err
+	}
+	client.Trace = output{}
+	return &NativeRunner{namespace: namespace, client: client}, nil
+}
+
+// output writes to Output, which the suite sets after the runners may have
+// been created.
+type output struct{}
+
+func (output) Write(p []byte) (int, error) {
+	return Output.Write(p)
+}
+
+// DefaultKubeconfig returns the kubeconfig kubectl would use: $KUBECONFIG if
+// set, otherwise ~/.kube/config.
+func DefaultKubeconfig() string {
+	if path := os.Getenv("KUBECONFIG"); path != "" {
+		return path
+	}
+	home, _ := os.UserHomeDir()
+	return home + "/.kube/config"
+}
+
+func (runner *NativeRunner) Namespace() string {
+	return runner.namespace
+}
+
+func (runner *NativeRunner) CreateNamespace(ns *Namespace) (*Namespace, error) {
+	ns.APIVersion, ns.Kind = "v1", "Namespace"
+	created := &Namespace{}
+	return created, runner.client.Do(kube.Request{Verb: "create", Resource: namespaces, Name: ns.Name, Body: ns}, created)
+}
+
+func (runner *NativeRunner) DeleteNamespace(name string) error {
+	return runner.client.Do(kube.Request{Verb: "delete", Resource: namespaces, Name: name}, nil)
+}
+
+func (runner *NativeRunner) ListMetadata(resourceName, selector string) ([]ObjectMeta, error) {
+	res, ok := clusterResources[resourceName]
+	if !ok {
+		return nil, &Error{Verb: "list", Resource: resourceName, Err: errors.New("unsupported resource")}
+	}
+	var list struct {
+		Items []struct {
+			ObjectMeta `json:"metadata"`
+		}
+	}
+	err := runner.client.Do(kube.Request{Verb: "list", Resource: res, Query: url.Values{"labelSelector": {selector}}}, &list)
+	metas := make([]ObjectMeta, len(list.Items))
+	for i, item := range list.Items {
+		metas[i] = item.ObjectMeta
+	}
+	return metas, err
+}
+
+func (runner *NativeRunner) DeleteObject(resourceName, name string) error {
+	res, ok := clusterResources[resourceName]
+	if !ok {
+		return &Error{Verb: "delete", Resource: resourceName, Name: name, Err: errors.New("unsupported resource")}
+	}
+	return runner.client.Do(kube.Request{Verb: "delete", Resource: res, Name: name}, nil)
+}
+
+func (runner *NativeRunner) ComponentStatuses() ([]ComponentStatus, error) {
+	var list struct{ Items []ComponentStatus }
+	err := runner.client.Do(kube.Request{Verb: "list", Resource: componentStatuses}, &list)
+	return list.Items, err
+}
+
+func (runner *NativeRunner) ListNodes() ([]Node, error) {
+	var list struct{ Items []Node }
+	err := runner.client.Do(kube.Request{Verb: "list", Resource: nodes}, &list)
+	return list.Items, err
+}
+
+func (runner *NativeRunner) CreatePod(p *Pod) (*Pod, error) {
+	p.APIVersion, p.Kind = "v1", "Pod"
+	created := &Pod{}
+	return created, runner.client.Do(kube.Request{Verb: "create", Resource: pods, Namespace: runner.namespace, Name: p.Name, Body: p}, created)
+}
+
+func (runner *NativeRunner) GetPod(name string) (*Pod, error) {
+	p := &Pod{}
+	return p, runner.client.Do(kube.Request{Verb: "get", Resource: pods, Namespace: runner.namespace, Name: name}, p)
+}
+
+func (runner *NativeRunner) DeletePod(name string) error {
+	return runner.client.Do(kube.Request{Verb: "delete", Resource: pods, Namespace: runner.namespace, Name: name}, nil)
+}
+
+func (runner *NativeRunner) ListPods(selector string) ([]Pod, error) {
+	var list struct{ Items []Pod }
+	err := runner.client.Do(kube.Request{
+		Verb:      "list",
+		Resource:  pods,
+		Namespace: runner.namespace,
+		Query:     url.Values{"labelSelector": {selector}},
+	}, &list)
+	return list.Items, err
+}
+
+func (runner *NativeRunner) PodLogs(name string) (string, error) {
+	var logs []byte
+	err := runner.client.Do(kube.Request{Verb: "get", Resource: pods, Namespace: runner.namespace, Name: name, Subresource: "log"}, &logs)
+	return string(logs), err
+}
+
+func (runner *NativeRunner) DryRunPod(namespace, user string, p *Pod) (*Pod, error) {
+	p.APIVersion, p.Kind = "v1", "Pod"
+	admitted := &Pod{}
+	return admitted, runner.client.Do(kube.Request{
+		Verb:      "create",
+		Resource:  pods,
+		Namespace: namespace,
+		Name:      p.Name,
+		Query:     url.Values{"dryRun": {"All"}},
+		As:        user,
+		Body:      p,
+	}, admitted)
+}
+
+func (runner *NativeRunner) CreateDeployment(d *Deployment) (*Deployment, error) {
+	d.APIVersion, d.Kind = "apps/v1", "Deployment"
+	created := &Deployment{}
+	return created, runner.client.Do(kube.Request{Verb: "create", Resource: deployments, Namespace: runner.namespace, Name: d.Name, Body: d}, created)
+}
+
+func (runner *NativeRunner) GetDeployment(name string) (*Deployment, error) {
+	d := &Deployment{}
+	return d, runner.client.Do(kube.Request{Verb: "get", Resource: deployments, Namespace: runner.namespace, Name: name}, d)
+}
+
+func (runner *NativeRunner) DeleteDeployment(name string) error {
+	return runner.client.Do(kube.Request{Verb: "delete", Resource: deployments, Namespace: runner.namespace, Name: name}, nil)
+}
+
+func (runner *NativeRunner) CreateService(s *Service) (*Service, error) {
+	s.APIVersion, s.Kind = "v1", "Service"
+	created := &Service{}
+	return created, runner.client.Do(kube.Request{Verb: "create", Resource: services, Namespace: runner.namespace, Name: s.Name, Body: s}, created)
+}
+
+func (runner *NativeRunner) GetService(name string) (*Service, error) {
+	return runner.GetServiceInNamespace(runner.namespace, name)
+}
+
+func (runner *NativeRunner) GetServiceInNamespace(namespace, name string) (*Service, error) {
+	s := &Service{}
+	return s, runner.client.Do(kube.Request{Verb: "get", Resource: services, Namespace: namespace, Name: name}, s)
+}
+
+func (runner *NativeRunner) DeleteService(name string) error {
+	return runner.client.Do(kube.Request{Verb: "delete", Resource: services, Namespace: runner.namespace, Name: name}, nil)
+}
+
+func (runner *NativeRunner) GetAPIService(name string) (*APIService, error) {
+	s := &APIService{}
+	return s, runner.client.Do(kube.Request{Verb: "get", Resource: apiServices, Name: name}, s)
+}
+
+func (runner *NativeRunner) NodeMetrics() ([]NodeMetrics, error) {
+	var list struct{ Items []NodeMetrics }
+	err := runner.client.Do(kube.Request{Verb: "list", Resource: nodeMetrics}, &list)
+	return list.Items, err
+}
+
+func (runner *NativeRunner) PodMetrics(selector string) ([]PodMetrics, error) {
+	var list struct{ Items []PodMetrics }
+	err := runner.client.Do(kube.Request{
+		Verb:      "list",
+		Resource:  podMetrics,
+		Namespace: runner.namespace,
+		Query:     url.Values{"labelSelector": {selector}},
+	}, &list)
+	return list.Items, err
+}
+
+func (runner *NativeRunner) CreateSubjectAccessReview(r *SubjectAccessReview) (*SubjectAccessReview, error) {
+	r.APIVersion, r.Kind = "authorization.k8s.io/v1", "SubjectAccessReview"
+	created := &SubjectAccessReview{}
+	return created, runner.client.Do(kube.Request{Verb: "create", Resource: subjectAccessReviews, Body: r}, created)
+}
+
+// ApplyManifest creates every object in a multi-document YAML file, updating
+// those that already exist. Namespaced objects without a namespace are put in
+// the runner's namespace.
+func (runner *NativeRunner) ApplyManifest(path string) error {
+	objects, err := runner.readManifest(path)
+	if err != nil {
+		return &Error{Verb: "apply", Resource: path, Err: err}
+	}
+
+	for _, o := range objects {
+		err := runner.client.Do(o.request("create"), nil)
+		if !IsAlreadyExists(err) {
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		var existing struct {
+			ObjectMeta `json:"metadata"`
+		}
+		if err := runner.client.Do(o.request("get"), &existing); err != nil {
+			return err
+		}
+		o.body["metadata"].(map[string]interface{})["resourceVersion"] = existing.ResourceVersion
+		if err := runner.client.Do(o.request("update"), nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (runner *NativeRunner) DeleteManifest(path string) error {
+	objects, err := runner.readManifest(path)
+	if err != nil {
+		return &Error{Verb: "delete", Resource: path, Err: err}
+	}
+
+	for _, o := range objects {
+		if err := runner.client.Do(o.request("delete"), nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var (
+	namespaces           = kube.Namespaces
+	nodes                = kube.Nodes
+	pods                 = kube.Pods
+	services             = kube.Services
+	deployments          = kube.Deployments
+	apiServices          = kube.APIServices
+	componentStatuses    = kube.Resource{GroupVersion: "v1", Name: "componentstatuses"}
+	nodeMetrics          = kube.Resource{GroupVersion: "metrics.k8s.io/v1beta1", Name: "nodes"}
+	podMetrics           = kube.Resource{GroupVersion: "metrics.k8s.io/v1beta1", Name: "pods", Namespaced: true}
+	subjectAccessReviews = kube.Resource{GroupVersion: "authorization.k8s.io/v1", Name: "subjectaccessreviews"}
+)
+
+// clusterResources are the cluster-scoped resources ListMetadata and
+// DeleteObject accept, keyed by their qualified name.
+var clusterResources = map[string]kube.Resource{
+	"namespaces":                                    kube.Namespaces,
+	"podsecuritypolicies.policy":                    kube.PodSecurityPolicies,
+	"clusterroles.rbac.authorization.k8s.io":        kube.ClusterRoles,
+	"clusterrolebindings.rbac.authorization.k8s.io": kube.ClusterRoleBindings,
+}
+
+// manifestKinds maps the kinds that appear in the suite's fixtures to their
+// REST resource. The group version is taken from the manifest itself.
+var manifestKinds = map[string]kube.Resource{
+	"Namespace":          {Name: "namespaces"},
+	"ServiceAccount":     {Name: "serviceaccounts", Namespaced: true},
+	"ConfigMap":          {Name: "configmaps", Namespaced: true},
+	"Pod":                {Name: "pods", Namespaced: true},
+	"Service":            {Name: "services", Namespaced: true},
+	"Deployment":         {Name: "deployments", Namespaced: true},
+	"DaemonSet":          {Name: "daemonsets", Namespaced: true},
+	"PodSecurityPolicy":  {Name: "podsecuritypolicies"},
+	"ClusterRole":        {Name: "clusterroles"},
+	"ClusterRoleBinding": {Name: "clusterrolebindings"},
+	"Role":               {Name: "roles", Namespaced: true},
+	"RoleBinding":        {Name: "rolebindings", Namespaced: true},
+}
+
+type manifestObject struct {
+	resource  kube.Resource
+	namespace string
+	name      string
+	body      kube.Object
+}
+
+func (o manifestObject) request(verb string) kube.Request {
+	req := kube.Request{Verb: verb, Resource: o.resource, Namespace: o.namespace, Name: o.name}
+	if verb == "create" || verb == "update" {
+		req.Body = o.body
+	}
+	return req
+}
+
+func (runner *NativeRunner) readManifest(path string) ([]manifestObject, error) {
+	objs, err := kube.ReadManifests(path)
+	if err != nil {
+		return nil, err
+	}
+	var objects []manifestObject
+	for _, obj := range objs {
+		res, known := manifestKinds[obj.Kind()]
+		if !known {
+			return nil, fmt.Errorf("unsupported kind %q", obj.Kind())
+		}
+		res.GroupVersion = obj.APIVersion()
+		namespace := obj.Namespace()
+		if res.Namespaced && namespace == "" {
+			namespace = runner.namespace
+		}
+		objects = append(objects, manifestObject{resource: res, namespace: namespace, name: obj.Name(), body: obj})
+	}
+	return objects, nil
+}
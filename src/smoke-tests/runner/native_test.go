@@ -0,0 +1,308 @@
+package runner_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"kubo-tools/kube"
+	"kubo-tools/kube/kubetest"
+	"smoke-tests/runner"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// podMetrics is the resource of the metrics API that PodMetrics lists.
+var podMetrics = kube.Resource{GroupVersion: "metrics.k8s.io/v1beta1", Name: "pods", Namespaced: true}
+
+var _ = Describe("NativeRunner", func() {
+	var (
+		server *kubetest.Server
+		tmpDir string
+		r      *runner.NativeRunner
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "runner")
+		Expect(err).NotTo(HaveOccurred())
+
+		server = kubetest.NewServer()
+		r, err = runner.NewNativeRunner(server.WriteKubeconfig(tmpDir, "s3cr3t"), "test-ns")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		server.Close()
+		os.RemoveAll(tmpDir)
+	})
+
+	It("authenticates with the kubeconfig token over verified TLS", func() {
+		_, err := r.ListNodes()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(server.Requests).To(HaveLen(1))
+		Expect(server.Requests[0].Token).To(Equal("s3cr3t"))
+	})
+
+	It("fails to load a kubeconfig whose current-context does not exist", func() {
+		path := filepath.Join(tmpDir, "broken")
+		Expect(ioutil.WriteFile(path, []byte("current-context: nope\n"), 0600)).To(Succeed())
+
+		_, err := runner.NewNativeRunner(path, "test-ns")
+		Expect(err).To(MatchError(ContainSubstring(`current-context "nope" not found`)))
+	})
+
+	Describe("deployments", func() {
+		It("returns the created deployment as a typed object", func() {
+			d, err := r.CreateDeployment(&runner.Deployment{
+				ObjectMeta: runner.ObjectMeta{Name: "nginx", Labels: map[string]string{"app": "nginx"}},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(d.Namespace).To(Equal("test-ns"))
+			Expect(d.Labels).To(HaveKeyWithValue("app", "nginx"))
+			Expect(server.RequestLines()).To(ConsistOf("POST /apis/apps/v1/namespaces/test-ns/deployments"))
+		})
+
+		It("names the verb and resource when creation fails", func() {
+			_, err := r.CreateDeployment(&runner.Deployment{ObjectMeta: runner.ObjectMeta{Name: "nginx"}})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = r.CreateDeployment(&runner.Deployment{ObjectMeta: runner.ObjectMeta{Name: "nginx"}})
+			Expect(runner.IsAlreadyExists(err)).To(BeTrue())
+			Expect(err).To(MatchError(`create deployments.apps nginx in namespace test-ns: AlreadyExists: deployments "nginx" already exists`))
+		})
+
+		It("waits for the rollout to complete", func() {
+			replicas := int32(2)
+			server.Put(kube.Deployments, runner.Deployment{
+				ObjectMeta: runner.ObjectMeta{Name: "nginx", Namespace: "test-ns", Generation: 1},
+				Spec:       runner.DeploymentSpec{Replicas: &replicas},
+				Status:     runner.DeploymentStatus{ObservedGeneration: 1, UpdatedReplicas: 2, AvailableReplicas: 2},
+			})
+
+			Expect(runner.WaitForRollout(r, "nginx", time.Second)).To(Succeed())
+		})
+
+		It("reports how far the rollout got when it times out", func() {
+			server.Put(kube.Deployments, runner.Deployment{
+				ObjectMeta: runner.ObjectMeta{Name: "nginx", Namespace: "test-ns", Generation: 1},
+				Status:     runner.DeploymentStatus{ObservedGeneration: 1, UpdatedReplicas: 1},
+			})
+
+			err := runner.WaitForRollout(r, "nginx", 10*time.Millisecond)
+			Expect(err).To(MatchError(ContainSubstring("rollout deployments.apps nginx in namespace test-ns")))
+			Expect(err).To(MatchError(ContainSubstring("0 of 1 replicas available")))
+		})
+	})
+
+	Describe("services", func() {
+		It("reports NotFound for a missing service", func() {
+			_, err := r.GetService("missing")
+			Expect(runner.IsNotFound(err)).To(BeTrue())
+			Expect(err).To(MatchError(ContainSubstring("get services missing in namespace test-ns: NotFound")))
+
+			rerr, ok := err.(*runner.Error)
+			Expect(ok).To(BeTrue())
+			Expect(rerr.Code).To(Equal(404))
+		})
+
+		It("looks up services in other namespaces", func() {
+			server.Put(kube.Services, runner.Service{
+				ObjectMeta: runner.ObjectMeta{Name: "kube-dns", Namespace: "kube-system"},
+				Spec:       runner.ServiceSpec{ClusterIP: "10.100.200.10"},
+			})
+
+			svc, err := r.GetServiceInNamespace("kube-system", "kube-dns")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(svc.Spec.ClusterIP).To(Equal("10.100.200.10"))
+		})
+
+		It("decodes the allocated node port", func() {
+			server.Put(kube.Services, runner.Service{
+				ObjectMeta: runner.ObjectMeta{Name: "nginx", Namespace: "test-ns"},
+				Spec: runner.ServiceSpec{
+					Type:  "NodePort",
+					Ports: []runner.ServicePort{{Port: 80, TargetPort: runner.IntOrString{StrVal: "http"}, NodePort: 31234}},
+				},
+			})
+
+			svc, err := r.GetService("nginx")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(svc.Spec.Ports[0].NodePort).To(BeEquivalentTo(31234))
+			Expect(svc.Spec.Ports[0].TargetPort.String()).To(Equal("http"))
+		})
+	})
+
+	Describe("pods", func() {
+		BeforeEach(func() {
+			for _, p := range []runner.Pod{
+				{ObjectMeta: runner.ObjectMeta{Name: "a", Namespace: "test-ns", Labels: map[string]string{"app": "nginx"}}, Status: runner.PodStatus{Phase: "Running"}},
+				{ObjectMeta: runner.ObjectMeta{Name: "b", Namespace: "test-ns", Labels: map[string]string{"app": "other"}}},
+			} {
+				server.Put(kube.Pods, p)
+			}
+		})
+
+		It("lists pods matching the label selector", func() {
+			pods, err := r.ListPods("app=nginx")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pods).To(HaveLen(1))
+			Expect(pods[0].Name).To(Equal("a"))
+			Expect(pods[0].Status.Phase).To(Equal("Running"))
+		})
+
+		It("waits for a pod to be running", func() {
+			pod, err := runner.WaitForPodRunning(r, "a", time.Second)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pod.Name).To(Equal("a"))
+		})
+
+		It("reports the phase and node of a pod that never runs", func() {
+			_, err := r.CreatePod(&runner.Pod{
+				ObjectMeta: runner.ObjectMeta{Name: "c"},
+				Spec:       runner.PodSpec{NodeName: "worker-0"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = runner.WaitForPodRunning(r, "c", 10*time.Millisecond)
+			Expect(err).To(MatchError(ContainSubstring(`wait pods c in namespace test-ns: timed out after 10ms in phase "" on node "worker-0"`)))
+		})
+
+		It("submits a dry run as another user without creating the pod", func() {
+			admitted, err := r.DryRunPod("kube-system", "psp-checker", &runner.Pod{ObjectMeta: runner.ObjectMeta{Name: "c"}})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(admitted.Namespace).To(Equal("kube-system"))
+
+			Expect(server.Requests).To(HaveLen(1))
+			Expect(server.Requests[0].As).To(Equal("psp-checker"))
+			Expect(server.Get(kube.Pods, "kube-system", "c")).To(BeNil())
+		})
+
+		It("fetches pod logs", func() {
+			server.SetLogs("test-ns", "a", "GET / HTTP/1.1 curl\n")
+
+			logs, err := r.PodLogs("a")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(logs).To(ContainSubstring("curl"))
+		})
+
+		It("names the subresource when fetching logs fails", func() {
+			_, err := r.PodLogs("missing")
+			Expect(err).To(MatchError(ContainSubstring("get pods/log missing in namespace test-ns: NotFound")))
+		})
+	})
+
+	Describe("metrics", func() {
+		It("reads the APIService conditions", func() {
+			svc := runner.APIService{ObjectMeta: runner.ObjectMeta{Name: "v1beta1.metrics.k8s.io"}}
+			svc.Status.Conditions = []runner.APIServiceCondition{{Type: "Available", Status: "False", Reason: "FailedDiscoveryCheck"}}
+			server.Put(kube.APIServices, svc)
+
+			got, err := r.GetAPIService("v1beta1.metrics.k8s.io")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(got.Condition("Available").Reason).To(Equal("FailedDiscoveryCheck"))
+			Expect(got.Condition("Missing")).To(BeNil())
+		})
+
+		It("names the API group when the APIService is missing", func() {
+			_, err := r.GetAPIService("v1beta1.metrics.k8s.io")
+			Expect(runner.IsNotFound(err)).To(BeTrue())
+			Expect(err).To(MatchError(ContainSubstring("get apiservices.apiregistration.k8s.io v1beta1.metrics.k8s.io: NotFound")))
+		})
+
+		It("lists pod metrics matching the label selector", func() {
+			for _, m := range []runner.PodMetrics{
+				{ObjectMeta: runner.ObjectMeta{Name: "a", Namespace: "test-ns", Labels: map[string]string{"app": "nginx"}}, Containers: []runner.ContainerMetrics{{Name: "nginx", Usage: map[string]string{"cpu": "1m"}}}},
+				{ObjectMeta: runner.ObjectMeta{Name: "b", Namespace: "test-ns"}},
+			} {
+				server.Put(podMetrics, m)
+			}
+
+			metrics, err := r.PodMetrics("app=nginx")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(metrics).To(HaveLen(1))
+			Expect(metrics[0].Containers[0].Usage).To(HaveKeyWithValue("cpu", "1m"))
+		})
+	})
+
+	Describe("access reviews", func() {
+		review := func(user, verb, resource, subresource string) *runner.SubjectAccessReview {
+			return &runner.SubjectAccessReview{Spec: runner.SubjectAccessReviewSpec{
+				User:               user,
+				Groups:             []string{"system:authenticated"},
+				ResourceAttributes: &runner.ResourceAttributes{Verb: verb, Resource: resource, Subresource: subresource},
+			}}
+		}
+
+		It("returns whether the user is allowed", func() {
+			server.Allow("kubelet-drain", "create", "pods/eviction")
+
+			got, err := r.CreateSubjectAccessReview(review("kubelet-drain", "create", "pods", "eviction"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(got.Status.Allowed).To(BeTrue())
+
+			got, err = r.CreateSubjectAccessReview(review("kubelet-drain", "create", "pods", ""))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(got.Status.Allowed).To(BeFalse())
+
+			Expect(server.RequestLines()).To(ConsistOf(
+				"POST /apis/authorization.k8s.io/v1/subjectaccessreviews",
+				"POST /apis/authorization.k8s.io/v1/subjectaccessreviews",
+			))
+		})
+	})
+
+	Describe("manifests", func() {
+		var manifest string
+
+		BeforeEach(func() {
+			manifest = filepath.Join(tmpDir, "psp.yml")
+			Expect(ioutil.WriteFile(manifest, []byte(`---
+apiVersion: policy/v1beta1
+kind: PodSecurityPolicy
+metadata:
+  name: smoke
+spec:
+  privileged: false
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: Role
+metadata:
+  name: psp:smoke
+rules:
+- apiGroups: [extensions]
+  resources: [podsecuritypolicies]
+  verbs: [use]
+`), 0600)).To(Succeed())
+		})
+
+		It("creates cluster-scoped and namespaced objects", func() {
+			Expect(r.ApplyManifest(manifest)).To(Succeed())
+
+			Expect(server.Get(kube.PodSecurityPolicies, "", "smoke")).NotTo(BeNil())
+			Expect(server.Get(kube.Roles, "test-ns", "psp:smoke")).NotTo(BeNil())
+		})
+
+		It("updates objects that already exist", func() {
+			Expect(r.ApplyManifest(manifest)).To(Succeed())
+			Expect(r.ApplyManifest(manifest)).To(Succeed())
+
+			Expect(server.RequestLines()).To(ContainElement("PUT /apis/policy/v1beta1/podsecuritypolicies/smoke"))
+		})
+
+		It("deletes the objects again", func() {
+			Expect(r.ApplyManifest(manifest)).To(Succeed())
+			Expect(r.DeleteManifest(manifest)).To(Succeed())
+
+			Expect(server.Get(kube.PodSecurityPolicies, "", "smoke")).To(BeNil())
+		})
+
+		It("rejects kinds it does not know how to apply", func() {
+			Expect(ioutil.WriteFile(manifest, []byte("apiVersion: v1\nkind: Widget\nmetadata:\n  name: w\n"), 0600)).To(Succeed())
+
+			Expect(r.ApplyManifest(manifest)).To(MatchError(ContainSubstring(`unsupported kind "Widget"`)))
+		})
+	})
+})
@@ -1,7 +1,14 @@
 package runner
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
 	"os/exec"
+	"strings"
+	"time"
 
 	uuid "github.com/satori/go.uuid"
 
@@ -10,6 +17,57 @@ import (
 	"github.com/onsi/gomega/gexec"
 )
 
+// Runner is the interface the suite drives the cluster through. Methods that
+// do not take a namespace act on the runner's own test namespace.
+type Runner interface {
+	Namespace() string
+
+	CreateNamespace(ns *Namespace) (*Namespace, error)
+	DeleteNamespace(name string) error
+
+	// ListMetadata and DeleteObject act on any of the cluster-scoped
+	// resources the suite creates, named as kubectl prints them, e.g.
+	// podsecuritypolicies.policy.
+	ListMetadata(resource, selector string) ([]ObjectMeta, error)
+	DeleteObject(resource, name string) error
+
+	ApplyManifest(path string) error
+	DeleteManifest(path string) error
+
+	ComponentStatuses() ([]ComponentStatus, error)
+	ListNodes() ([]Node, error)
+
+	CreatePod(p *Pod) (*Pod, error)
+	GetPod(name string) (*Pod, error)
+	DeletePod(name string) error
+	ListPods(selector string) ([]Pod, error)
+	PodLogs(name string) (string, error)
+	// DryRunPod submits p for admission in namespace as user, without
+	// persisting it, and returns the pod as admission mutated it.
+	DryRunPod(namespace, user string, p *Pod) (*Pod, error)
+
+	CreateDeployment(d *Deployment) (*Deployment, error)
+	GetDeployment(name string) (*Deployment, error)
+	DeleteDeployment(name string) error
+
+	CreateService(s *Service) (*Service, error)
+	GetService(name string) (*Service, error)
+	GetServiceInNamespace(namespace, name string) (*Service, error)
+	DeleteService(name string) error
+
+	GetAPIService(name string) (*APIService, error)
+	NodeMetrics() ([]NodeMetrics, error)
+	PodMetrics(selector string) ([]PodMetrics, error)
+
+	CreateSubjectAccessReview(r *SubjectAccessReview) (*SubjectAccessReview, error)
+}
+
+var _ Runner = KubectlRunner{}
+
+// Output receives the kubectl commands the runner executes and what they
+// print. The suite points it at its reporters as well as the GinkgoWriter.
+var Output io.Writer = GinkgoWriter
+
 type KubectlRunner struct {
 	namespace string
 	Timeout   string
@@ -29,7 +87,7 @@ func (runner KubectlRunner) RunKubectlCommand(args ...string) *gexec.Session {
 func (runner KubectlRunner) RunKubectlCommandInNamespace(namespace string, args ...string) *gexec.Session {
 	newArgs := append([]string{"--namespace", namespace}, args...)
 	command := exec.Command("kubectl", newArgs...)
-	session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+	session, err := gexec.Start(command, Output, Output)
 
 	Expect(err).NotTo(HaveOccurred())
 	return session
@@ -38,3 +96,264 @@ func (runner KubectlRunner) RunKubectlCommandInNamespace(namespace string, args
 func (runner KubectlRunner) Namespace() string {
 	return runner.namespace
 }
+
+func (runner KubectlRunner) CreateNamespace(ns *Namespace) (*Namespace, error) {
+	ns.APIVersion, ns.Kind = "v1", "Namespace"
+	body, err := json.Marshal(ns)
+	if err != nil {
+		return nil, &Error{Verb: "create", Resource: "namespaces", Name: ns.Name, Err: err}
+	}
+	created := &Namespace{}
+	return created, runner.run("create", "namespaces", "", ns.Name, body, created, "create", "-f", "-", "-o", "json")
+}
+
+func (runner KubectlRunner) DeleteNamespace(name string) error {
+	return runner.run("delete", "namespaces", "", name, nil, nil, "delete", "namespace", name)
+}
+
+func (runner KubectlRunner) ListMetadata(resource, selector string) ([]ObjectMeta, error) {
+	var list struct {
+		Items []struct {
+			ObjectMeta `json:"metadata"`
+		}
+	}
+	err := runner.run("list", resource, "", "", nil, &list, "get", resource, "-l", selector, "-o", "json")
+	metas := make([]ObjectMeta, len(list.Items))
+	for i, item := range list.Items {
+		metas[i] = item.ObjectMeta
+	}
+	return metas, err
+}
+
+func (runner KubectlRunner) DeleteObject(resource, name string) error {
+	return runner.run("delete", resource, "", name, nil, nil, "delete", resource, name)
+}
+
+func (runner KubectlRunner) ApplyManifest(path string) error {
+	return runner.run("apply", path, runner.namespace, "", nil, nil, "apply", "-f", path)
+}
+
+func (runner KubectlRunner) DeleteManifest(path string) error {
+	return runner.run("delete", path, runner.namespace, "", nil, nil, "delete", "-f", path)
+}
+
+func (runner KubectlRunner) ComponentStatuses() ([]ComponentStatus, error) {
+	var list struct{ Items []ComponentStatus }
+	err := runner.run("list", "componentstatuses", "", "", nil, &list, "get", "componentstatuses", "-o", "json")
+	return list.Items, err
+}
+
+func (runner KubectlRunner) ListNodes() ([]Node, error) {
+	var list struct{ Items []Node }
+	err := runner.run("list", "nodes", "", "", nil, &list, "get", "nodes", "-o", "json")
+	return list.Items, err
+}
+
+func (runner KubectlRunner) CreatePod(p *Pod) (*Pod, error) {
+	p.APIVersion, p.Kind = "v1", "Pod"
+	created := &Pod{}
+	return created, runner.create("pods", p.Name, p, created)
+}
+
+func (runner KubectlRunner) GetPod(name string) (*Pod, error) {
+	p := &Pod{}
+	return p, runner.run("get", "pods", runner.namespace, name, nil, p, "get", "pod", name, "-o", "json")
+}
+
+func (runner KubectlRunner) DeletePod(name string) error {
+	return runner.run("delete", "pods", runner.namespace, name, nil, nil, "delete", "pod", name)
+}
+
+func (runner KubectlRunner) ListPods(selector string) ([]Pod, error) {
+	var list struct{ Items []Pod }
+	err := runner.run("list", "pods", runner.namespace, "", nil, &list, "get", "pods", "-l", selector, "-o", "json")
+	return list.Items, err
+}
+
+func (runner KubectlRunner) PodLogs(name string) (string, error) {
+	var logs []byte
+	err := runner.run("get", "pods/log", runner.namespace, name, nil, &logs, "logs", name)
+	return string(logs), err
+}
+
+// DryRunPod uses apply, as kubectl 1.17 only offers server-side dry runs
+// there.
+func (runner KubectlRunner) DryRunPod(namespace, user string, p *Pod) (*Pod, error) {
+	p.APIVersion, p.Kind = "v1", "Pod"
+	body, err := json.Marshal(p)
+	if err != nil {
+		return nil, &Error{Verb: "create", Resource: "pods", Namespace: namespace, Name: p.Name, Err: err}
+	}
+	admitted := &Pod{}
+	return admitted, runner.run("create", "pods", namespace, p.Name, body, admitted, "apply", "--server-dry-run", "--as", user, "-f", "-", "-o", "json")
+}
+
+func (runner KubectlRunner) CreateDeployment(d *Deployment) (*Deployment, error) {
+	d.APIVersion, d.Kind = "apps/v1", "Deployment"
+	created := &Deployment{}
+	return created, runner.create("deployments.apps", d.Name, d, created)
+}
+
+func (runner KubectlRunner) GetDeployment(name string) (*Deployment, error) {
+	d := &Deployment{}
+	return d, runner.run("get", "deployments.apps", runner.namespace, name, nil, d, "get", "deployment", name, "-o", "json")
+}
+
+func (runner KubectlRunner) DeleteDeployment(name string) error {
+	return runner.run("delete", "deployments.apps", runner.namespace, name, nil, nil, "delete", "deployment", name)
+}
+
+func (runner KubectlRunner) CreateService(s *Service) (*Service, error) {
+	s.APIVersion, s.Kind = "v1", "Service"
+	created := &Service{}
+	return created, runner.create("services", s.Name, s, created)
+}
+
+func (runner KubectlRunner) GetService(name string) (*Service, error) {
+	s := &Service{}
+	return s, runner.run("get", "services", runner.namespace, name, nil, s, "get", "service", name, "-o", "json")
+}
+
+func (runner KubectlRunner) GetServiceInNamespace(namespace, name string) (*Service, error) {
+	s := &Service{}
+	return s, runner.run("get", "services", namespace, name, nil, s, "get", "service", name, "-o", "json")
+}
+
+func (runner KubectlRunner) DeleteService(name string) error {
+	return runner.run("delete", "services", runner.namespace, name, nil, nil, "delete", "service", name)
+}
+
+func (runner KubectlRunner) GetAPIService(name string) (*APIService, error) {
+	s := &APIService{}
+	return s, runner.run("get", "apiservices.apiregistration.k8s.io", "", name, nil, s, "get", "apiservice", name, "-o", "json")
+}
+
+func (runner KubectlRunner) NodeMetrics() ([]NodeMetrics, error) {
+	var list struct{ Items []NodeMetrics }
+	err := runner.run("list", "nodes.metrics.k8s.io", "", "", nil, &list, "get", "--raw", "/apis/metrics.k8s.io/v1beta1/nodes")
+	return list.Items, err
+}
+
+func (runner KubectlRunner) PodMetrics(selector string) ([]PodMetrics, error) {
+	var list struct{ Items []PodMetrics }
+	path := "/apis/metrics.k8s.io/v1beta1/namespaces/" + runner.namespace + "/pods?labelSelector=" + url.QueryEscape(selector)
+	err := runner.run("list", "pods.metrics.k8s.io", runner.namespace, "", nil, &list, "get", "--raw", path)
+	return list.Items, err
+}
+
+func (runner KubectlRunner) CreateSubjectAccessReview(r *SubjectAccessReview) (*SubjectAccessReview, error) {
+	r.APIVersion, r.Kind = "authorization.k8s.io/v1", "SubjectAccessReview"
+	created := &SubjectAccessReview{}
+	body, err := json.Marshal(r)
+	if err != nil {
+		return nil, &Error{Verb: "create", Resource: "subjectaccessreviews.authorization.k8s.io", Err: err}
+	}
+	return created, runner.run("create", "subjectaccessreviews.authorization.k8s.io", "", "", body, created, "create", "-f", "-", "-o", "json")
+}
+
+// Exec runs command in the first container of the named pod and returns
+// what it printed on stdout. The native runner has no equivalent, as exec
+// needs a streaming connection to the kubelet.
+func (runner KubectlRunner) Exec(pod string, command ...string) (string, error) {
+	var out []byte
+	args := append([]string{"exec", pod, "--"}, command...)
+	err := runner.run("exec", "pods/exec", runner.namespace, pod, nil, &out, args...)
+	return string(out), err
+}
+
+func (runner KubectlRunner) create(resource, name string, obj, into interface{}) error {
+	body, err := json.Marshal(obj)
+	if err != nil {
+		return &Error{Verb: "create", Resource: resource, Namespace: runner.namespace, Name: name, Err: err}
+	}
+	return runner.run("create", resource, runner.namespace, name, body, into, "create", "-f", "-", "-o", "json")
+}
+
+// run invokes kubectl in namespace, or in the runner's namespace if it is
+// empty. If into is a *[]byte it receives stdout verbatim, otherwise stdout is
+// decoded as JSON.
+func (runner KubectlRunner) run(verb, resource, namespace, name string, stdin []byte, into interface{}, args ...string) error {
+	kubectlNamespace := namespace
+	if kubectlNamespace == "" {
+		kubectlNamespace = runner.namespace
+	}
+	newArgs := append([]string{"--namespace", kubectlNamespace}, args...)
+	fmt.Fprintf(Output, "kubectl %s\n", strings.Join(newArgs, " "))
+
+	var stdout, stderr bytes.Buffer
+	command := exec.Command("kubectl", newArgs...)
+	command.Stdin = bytes.NewReader(stdin)
+	command.Stdout = &stdout
+	command.Stderr = &stderr
+	if err := command.Run(); err != nil {
+		Output.Write(stderr.Bytes())
+		return kubectlError(verb, resource, namespace, name, stderr.Bytes(), err)
+	}
+
+	switch into := into.(type) {
+	case nil:
+		return nil
+	case *[]byte:
+		*into = stdout.Bytes()
+		return nil
+	default:
+		if err := json.Unmarshal(stdout.Bytes(), into); err != nil {
+			return &Error{Verb: verb, Resource: resource, Namespace: namespace, Name: name, Err: fmt.Errorf("decoding kubectl output: %v", err)}
+		}
+		return nil
+	}
+}
+
+// WaitForRollout polls the named deployment until every replica has been
+// updated and is available, or the timeout expires.
+func WaitForRollout(r Runner, name string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		d, err := r.GetDeployment(name)
+		if err == nil && rolledOut(d) {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			if err != nil {
+				return err
+			}
+			return &Error{Verb: "rollout", Resource: "deployments.apps", Namespace: r.Namespace(), Name: name,
+				Err: fmt.Errorf("timed out after %s: %d of %d replicas available", timeout, d.Status.AvailableReplicas, desiredReplicas(d))}
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// WaitForPodRunning polls the named pod until it is Running, or the timeout
+// expires.
+func WaitForPodRunning(r Runner, name string, timeout time.Duration) (*Pod, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		p, err := r.GetPod(name)
+		if err == nil && p.Status.Phase == "Running" {
+			return p, nil
+		}
+		if time.Now().After(deadline) {
+			if err != nil {
+				return nil, err
+			}
+			return nil, &Error{Verb: "wait", Resource: "pods", Namespace: r.Namespace(), Name: name,
+				Err: fmt.Errorf("timed out after %s in phase %q on node %q", timeout, p.Status.Phase, p.Spec.NodeName)}
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+func rolledOut(d *Deployment) bool {
+	desired := desiredReplicas(d)
+	return d.Status.ObservedGeneration >= d.Generation &&
+		d.Status.UpdatedReplicas == desired &&
+		d.Status.AvailableReplicas == desired
+}
+
+func desiredReplicas(d *Deployment) int32 {
+	if d.Spec.Replicas == nil {
+		return 1
+	}
+	return *d.Spec.Replicas
+}
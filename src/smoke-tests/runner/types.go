@@ -0,0 +1,319 @@
+package runner
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// The types below mirror the subset of the Kubernetes API objects that the
+// smoke tests read and write. Field names and JSON tags follow the upstream
+// API so that objects round-trip through both kubectl and the API server.
+
+type TypeMeta struct {
+	APIVersion string `json:"apiVersion,omitempty"`
+	Kind       string `json:"kind,omitempty"`
+}
+
+type ObjectMeta struct {
+	Name              string            `json:"name,omitempty"`
+	Namespace         string            `json:"namespace,omitempty"`
+	Labels            map[string]string `json:"labels,omitempty"`
+	Annotations       map[string]string `json:"annotations,omitempty"`
+	ResourceVersion   string            `json:"resourceVersion,omitempty"`
+	Generation        int64             `json:"generation,omitempty"`
+	CreationTimestamp string            `json:"creationTimestamp,omitempty"`
+}
+
+type Namespace struct {
+	TypeMeta
+	ObjectMeta `json:"metadata,omitempty"`
+	Status     struct {
+		Phase string `json:"phase,omitempty"`
+	} `json:"status,omitempty"`
+}
+
+type ComponentStatus struct {
+	TypeMeta
+	ObjectMeta `json:"metadata,omitempty"`
+	Conditions []ComponentCondition `json:"conditions,omitempty"`
+}
+
+type ComponentCondition struct {
+	Type    string `json:"type"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+type Node struct {
+	TypeMeta
+	ObjectMeta `json:"metadata,omitempty"`
+	Spec       NodeSpec   `json:"spec,omitempty"`
+	Status     NodeStatus `json:"status,omitempty"`
+}
+
+type NodeSpec struct {
+	PodCIDR       string `json:"podCIDR,omitempty"`
+	Unschedulable bool   `json:"unschedulable,omitempty"`
+}
+
+type NodeStatus struct {
+	Addresses  []NodeAddress   `json:"addresses,omitempty"`
+	Conditions []NodeCondition `json:"conditions,omitempty"`
+}
+
+type NodeAddress struct {
+	Type    string `json:"type"`
+	Address string `json:"address"`
+}
+
+type NodeCondition struct {
+	Type   string `json:"type"`
+	Status string `json:"status"`
+}
+
+// Address returns the first address of the given type (e.g. InternalIP), or
+// an empty string if the node does not report one.
+func (n Node) Address(addressType string) string {
+	for _, a := range n.Status.Addresses {
+		if a.Type == addressType {
+			return a.Address
+		}
+	}
+	return ""
+}
+
+type Pod struct {
+	TypeMeta
+	ObjectMeta `json:"metadata,omitempty"`
+	Spec       PodSpec   `json:"spec,omitempty"`
+	Status     PodStatus `json:"status,omitempty"`
+}
+
+type PodSpec struct {
+	Containers         []Container `json:"containers"`
+	NodeName           string      `json:"nodeName,omitempty"`
+	ServiceAccountName string      `json:"serviceAccountName,omitempty"`
+	RestartPolicy      string      `json:"restartPolicy,omitempty"`
+	Hostname           string      `json:"hostname,omitempty"`
+	Subdomain          string      `json:"subdomain,omitempty"`
+	Affinity           *Affinity   `json:"affinity,omitempty"`
+	Volumes            []Volume    `json:"volumes,omitempty"`
+}
+
+type Volume struct {
+	Name     string                `json:"name"`
+	HostPath *HostPathVolumeSource `json:"hostPath,omitempty"`
+	EmptyDir *EmptyDirVolumeSource `json:"emptyDir,omitempty"`
+}
+
+type HostPathVolumeSource struct {
+	Path string `json:"path"`
+}
+
+type EmptyDirVolumeSource struct{}
+
+type Affinity struct {
+	PodAntiAffinity *PodAntiAffinity `json:"podAntiAffinity,omitempty"`
+}
+
+type PodAntiAffinity struct {
+	RequiredDuringSchedulingIgnoredDuringExecution []PodAffinityTerm `json:"requiredDuringSchedulingIgnoredDuringExecution,omitempty"`
+}
+
+type PodAffinityTerm struct {
+	LabelSelector *LabelSelector `json:"labelSelector,omitempty"`
+	TopologyKey   string         `json:"topologyKey"`
+}
+
+type Container struct {
+	Name            string           `json:"name"`
+	Image           string           `json:"image"`
+	ImagePullPolicy string           `json:"imagePullPolicy,omitempty"`
+	Command         []string         `json:"command,omitempty"`
+	Args            []string         `json:"args,omitempty"`
+	Ports           []ContainerPort  `json:"ports,omitempty"`
+	VolumeMounts    []VolumeMount    `json:"volumeMounts,omitempty"`
+	SecurityContext *SecurityContext `json:"securityContext,omitempty"`
+}
+
+type VolumeMount struct {
+	Name      string `json:"name"`
+	MountPath string `json:"mountPath"`
+}
+
+type SecurityContext struct {
+	Privileged               *bool `json:"privileged,omitempty"`
+	AllowPrivilegeEscalation *bool `json:"allowPrivilegeEscalation,omitempty"`
+}
+
+type ContainerPort struct {
+	Name          string `json:"name,omitempty"`
+	ContainerPort int32  `json:"containerPort"`
+	Protocol      string `json:"protocol,omitempty"`
+}
+
+type PodStatus struct {
+	Phase  string `json:"phase,omitempty"`
+	HostIP string `json:"hostIP,omitempty"`
+	PodIP  string `json:"podIP,omitempty"`
+}
+
+type PodTemplateSpec struct {
+	ObjectMeta `json:"metadata,omitempty"`
+	Spec       PodSpec `json:"spec,omitempty"`
+}
+
+type LabelSelector struct {
+	MatchLabels map[string]string `json:"matchLabels,omitempty"`
+}
+
+type Deployment struct {
+	TypeMeta
+	ObjectMeta `json:"metadata,omitempty"`
+	Spec       DeploymentSpec   `json:"spec,omitempty"`
+	Status     DeploymentStatus `json:"status,omitempty"`
+}
+
+type DeploymentSpec struct {
+	Replicas *int32          `json:"replicas,omitempty"`
+	Selector *LabelSelector  `json:"selector,omitempty"`
+	Template PodTemplateSpec `json:"template"`
+}
+
+type DeploymentStatus struct {
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	Replicas           int32 `json:"replicas,omitempty"`
+	UpdatedReplicas    int32 `json:"updatedReplicas,omitempty"`
+	ReadyReplicas      int32 `json:"readyReplicas,omitempty"`
+	AvailableReplicas  int32 `json:"availableReplicas,omitempty"`
+}
+
+type Service struct {
+	TypeMeta
+	ObjectMeta `json:"metadata,omitempty"`
+	Spec       ServiceSpec `json:"spec,omitempty"`
+}
+
+type ServiceSpec struct {
+	Type         string            `json:"type,omitempty"`
+	ClusterIP    string            `json:"clusterIP,omitempty"`
+	ExternalName string            `json:"externalName,omitempty"`
+	Selector     map[string]string `json:"selector,omitempty"`
+	Ports        []ServicePort     `json:"ports,omitempty"`
+}
+
+type ServicePort struct {
+	Name       string      `json:"name,omitempty"`
+	Protocol   string      `json:"protocol,omitempty"`
+	Port       int32       `json:"port"`
+	TargetPort IntOrString `json:"targetPort,omitempty"`
+	NodePort   int32       `json:"nodePort,omitempty"`
+}
+
+// IntOrString holds a port that the API may express either as a number or
+// as a named port.
+type IntOrString struct {
+	IntVal int32
+	StrVal string
+}
+
+func (i IntOrString) MarshalJSON() ([]byte, error) {
+	if i.StrVal != "" {
+		return json.Marshal(i.StrVal)
+	}
+	return json.Marshal(i.IntVal)
+}
+
+func (i *IntOrString) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		return json.Unmarshal(data, &i.StrVal)
+	}
+	return json.Unmarshal(data, &i.IntVal)
+}
+
+func (i IntOrString) String() string {
+	if i.StrVal != "" {
+		return i.StrVal
+	}
+	return strconv.Itoa(int(i.IntVal))
+}
+
+type APIService struct {
+	TypeMeta
+	ObjectMeta `json:"metadata,omitempty"`
+	Status     struct {
+		Conditions []APIServiceCondition `json:"conditions,omitempty"`
+	} `json:"status,omitempty"`
+}
+
+type APIServiceCondition struct {
+	Type    string `json:"type"`
+	Status  string `json:"status"`
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// Condition returns the condition of the given type, or nil if the API
+// service does not report it.
+func (s APIService) Condition(conditionType string) *APIServiceCondition {
+	for i := range s.Status.Conditions {
+		if s.Status.Conditions[i].Type == conditionType {
+			return &s.Status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// NodeMetrics and PodMetrics are served by metrics-server under
+// metrics.k8s.io/v1beta1. Usage maps resource names such as cpu and memory to
+// quantities.
+type NodeMetrics struct {
+	ObjectMeta `json:"metadata,omitempty"`
+	Timestamp  string            `json:"timestamp"`
+	Window     string            `json:"window"`
+	Usage      map[string]string `json:"usage"`
+}
+
+type PodMetrics struct {
+	ObjectMeta `json:"metadata,omitempty"`
+	Timestamp  string             `json:"timestamp"`
+	Window     string             `json:"window"`
+	Containers []ContainerMetrics `json:"containers"`
+}
+
+type ContainerMetrics struct {
+	Name  string            `json:"name"`
+	Usage map[string]string `json:"usage"`
+}
+
+// SubjectAccessReview asks the API server's authorizers whether a user may
+// perform an action. The suite uses it to check the RBAC policies of users
+// it has no credentials for.
+type SubjectAccessReview struct {
+	TypeMeta
+	ObjectMeta `json:"metadata,omitempty"`
+	Spec       SubjectAccessReviewSpec   `json:"spec"`
+	Status     SubjectAccessReviewStatus `json:"status,omitempty"`
+}
+
+type SubjectAccessReviewSpec struct {
+	ResourceAttributes *ResourceAttributes `json:"resourceAttributes,omitempty"`
+	User               string              `json:"user,omitempty"`
+	Groups             []string            `json:"groups,omitempty"`
+}
+
+type ResourceAttributes struct {
+	Namespace   string `json:"namespace,omitempty"`
+	Verb        string `json:"verb,omitempty"`
+	Group       string `json:"group,omitempty"`
+	Resource    string `json:"resource,omitempty"`
+	Subresource string `json:"subresource,omitempty"`
+	Name        string `json:"name,omitempty"`
+}
+
+type SubjectAccessReviewStatus struct {
+	Allowed bool   `json:"allowed"`
+	Denied  bool   `json:"denied,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"math/rand"
 	"os/exec"
+	"regexp"
+	"smoke-tests/runner"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -22,6 +24,17 @@ func randSeq(n int) string {
 	return string(b)
 }
 
+var forwarding = regexp.MustCompile(`Forwarding from 127\.0\.0\.1:(\d+) ->`)
+
+// forwardedPort returns the local port kubectl port-forward reports it
+// listens on, or an empty string until it has done so.
+func forwardedPort(out []byte) string {
+	if m := forwarding.FindSubmatch(out); m != nil {
+		return string(m[1])
+	}
+	return ""
+}
+
 func curlLater(endpoint string) func() (string, error) {
 	return func() (string, error) {
 		cmd := exec.Command("curl", "--head", "--max-time", "120", endpoint)
@@ -30,15 +43,68 @@ func curlLater(endpoint string) func() (string, error) {
 	}
 }
 
+func nginxDeployment(name string) *runner.Deployment {
+	labels := map[string]string{"app": name}
+	return &runner.Deployment{
+		ObjectMeta: runner.ObjectMeta{Name: name, Labels: labels},
+		Spec: runner.DeploymentSpec{
+			Selector: &runner.LabelSelector{MatchLabels: labels},
+			Template: runner.PodTemplateSpec{
+				ObjectMeta: runner.ObjectMeta{Labels: labels},
+				Spec: runner.PodSpec{
+					ServiceAccountName: "default",
+					Containers: []runner.Container{{
+						Name:            name,
+						Image:           testConfig.TestImage,
+						ImagePullPolicy: "Never",
+					}},
+				},
+			},
+		},
+	}
+}
+
+func nodePortService(name string) *runner.Service {
+	return &runner.Service{
+		ObjectMeta: runner.ObjectMeta{Name: name, Labels: map[string]string{"app": name}},
+		Spec: runner.ServiceSpec{
+			Type:     "NodePort",
+			Selector: map[string]string{"app": name},
+			Ports:    []runner.ServicePort{{Port: 80, TargetPort: runner.IntOrString{IntVal: 80}}},
+		},
+	}
+}
+
+func isHealthy(component runner.ComponentStatus) bool {
+	for _, condition := range component.Conditions {
+		if condition.Type == "Healthy" && condition.Status == "True" {
+			return true
+		}
+	}
+	return false
+}
+
+func firstPodName(selector string) string {
+	pods, err := k8sRunner.ListPods(selector)
+	Expect(err).NotTo(HaveOccurred())
+	Expect(pods).NotTo(BeEmpty())
+	return pods[0].Name
+}
+
 var _ = Describe("CFCR Smoke Tests", func() {
 	Describe("System Compenents", func() {
+		BeforeEach(func() {
+			skipUnlessEnabled("system-components")
+		})
+
 		It("should be healthy", func() {
-			command := exec.Command("kubectl", "get", "componentstatuses", "-o", "jsonpath={.items[*].conditions[*].type}")
-			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			statuses, err := k8sRunner.ComponentStatuses()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(statuses).NotTo(BeEmpty())
 
-			Eventually(session, "60s").Should(gexec.Exit(0))
-			Expect(err).ToNot(HaveOccurred())
-			Expect(session.Out).To(gbytes.Say("^(Healthy )+Healthy$"))
+			for _, component := range statuses {
+				Expect(isHealthy(component)).To(BeTrue(), "component %s is not healthy: %+v", component.Name, component.Conditions)
+			}
 		})
 	})
 
@@ -46,86 +112,89 @@ var _ = Describe("CFCR Smoke Tests", func() {
 		var deploymentName string
 
 		BeforeEach(func() {
+			skipUnlessEnabled("deployment")
+
 			deploymentName = randSeq(10)
-			args := []string{"run", deploymentName, "--image=pcfkubo/nginx-bionic:1.0.0", "--image-pull-policy=Never", "-l", "app=" + deploymentName, "--serviceaccount=default"}
-			session := k8sRunner.RunKubectlCommand(args...)
-			Eventually(session, "60s").Should(gexec.Exit(0))
+			_, err := k8sRunner.CreateDeployment(nginxDeployment(deploymentName))
+			Expect(err).NotTo(HaveOccurred())
 
-			exposeArgs := []string{"expose", "deployment", deploymentName, "--port=80", "--type=NodePort"}
-			session = k8sRunner.RunKubectlCommand(exposeArgs...)
-			Eventually(session, "120s").Should(gexec.Exit(0))
+			_, err = k8sRunner.CreateService(nodePortService(deploymentName))
+			Expect(err).NotTo(HaveOccurred())
 
-			rolloutWatch := k8sRunner.RunKubectlCommand("rollout", "status", "deployment/"+deploymentName, "-w")
-			Eventually(rolloutWatch, "120s").Should(gexec.Exit(0))
+			Expect(runner.WaitForRollout(k8sRunner, deploymentName, testConfig.Timeouts.Rollout.Duration)).To(Succeed())
 		})
 
 		AfterEach(func() {
-			session := k8sRunner.RunKubectlCommand("delete", "deployment", deploymentName)
-			Eventually(session, "60s").Should(gexec.Exit(0))
+			Expect(k8sRunner.DeleteService(deploymentName)).To(Succeed())
+			Expect(k8sRunner.DeleteDeployment(deploymentName)).To(Succeed())
 		})
 
 		It("shows the pods are healthy", func() {
-			args := []string{"get", "pods", "-l", "app=" + deploymentName, "-o", "jsonpath={.items[:].status.phase}"}
-			session := k8sRunner.RunKubectlCommand(args...)
-			Eventually(session, "60s").Should(gexec.Exit(0))
-			Expect(session.Out).To(gbytes.Say("Running"))
+			pods, err := k8sRunner.ListPods("app=" + deploymentName)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pods).NotTo(BeEmpty())
+			for _, pod := range pods {
+				Expect(pod.Status.Phase).To(Equal("Running"), "pod %s", pod.Name)
+			}
 		})
 
 		It("allows commands to be executed on a container", func() {
-			args := []string{"get", "pods", "-l", "app=" + deploymentName, "-o", "jsonpath={.items[0].metadata.name}"}
-			session := k8sRunner.RunKubectlCommand(args...)
-			Eventually(session, "15s").Should(gexec.Exit(0))
-			podName := string(session.Out.Contents())
+			podName := firstPodName("app=" + deploymentName)
 
 			execArgs := []string{"exec", podName, "--", "which", "nginx"}
-			execSession := k8sRunner.RunKubectlCommand(execArgs...)
-			Eventually(execSession, "60s").Should(gexec.Exit(0))
+			execSession := kubectl.RunKubectlCommand(execArgs...)
+			Eventually(execSession, testConfig.Timeouts.Default.Duration).Should(gexec.Exit(0))
 			Expect(execSession.Out).To(gbytes.Say("/usr/sbin/nginx"))
 		})
 
 		It("allows access to pod logs", func() {
-			args := []string{"get", "pods", "-l", "app=" + deploymentName, "-o", "jsonpath={.items[0].metadata.name}"}
-			session := k8sRunner.RunKubectlCommand(args...)
-			Eventually(session, "15s").Should(gexec.Exit(0))
-			podName := string(session.Out.Contents())
+			podName := firstPodName("app=" + deploymentName)
 
-			session = k8sRunner.RunKubectlCommand("get", "nodes", "-o", "jsonpath={.items[0].status.addresses[?(@.type == \"InternalIP\")].address}")
-			Eventually(session).Should(gexec.Exit(0))
-			nodeIP := session.Out.Contents()
+			nodes, err := k8sRunner.ListNodes()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(nodes).NotTo(BeEmpty())
+			nodeIP := nodes[0].Address("InternalIP")
 
-			session = k8sRunner.RunKubectlCommand("get", "svc", deploymentName, "-o", "jsonpath={.spec.ports[0].nodePort}")
-			Eventually(session).Should(gexec.Exit(0))
-			port := session.Out.Contents()
+			svc, err := k8sRunner.GetService(deploymentName)
+			Expect(err).NotTo(HaveOccurred())
+			port := svc.Spec.Ports[0].NodePort
 
-			endpoint := fmt.Sprintf("http://%s:%s", nodeIP, port)
+			endpoint := fmt.Sprintf("http://%s:%d", nodeIP, port)
 			Eventually(curlLater(endpoint), "5s").Should(ContainSubstring("Server: nginx"))
 
-			getLogs := k8sRunner.RunKubectlCommand("logs", podName)
-			Eventually(getLogs, "15s").Should(gexec.Exit(0))
-			logContent := string(getLogs.Out.Contents())
-
+			logContent, err := k8sRunner.PodLogs(podName)
+			Expect(err).NotTo(HaveOccurred())
 			Expect(logContent).To(ContainSubstring("curl"))
 		})
 
 		Context("Port Forwarding", func() {
 			var cmd *gexec.Session
-			var port = "57869"
 
 			BeforeEach(func() {
-				args := []string{"get", "pods", "-l", "app=" + deploymentName, "-o", "jsonpath={.items[0].metadata.name}"}
-				session := k8sRunner.RunKubectlCommand(args...)
-				Eventually(session, "15s").Should(gexec.Exit(0))
-				podName := string(session.Out.Contents())
+				skipUnlessEnabled("port-forwarding")
 
-				args = []string{"port-forward", podName, port + ":80"}
-				cmd = k8sRunner.RunKubectlCommand(args...)
+				podName := firstPodName("app=" + deploymentName)
+
+				// Let kubectl pick a free local port, so that parallel nodes
+				// on the same host do not compete for one.
+				args := []string{"port-forward", podName, ":80"}
+				cmd = kubectl.RunKubectlCommand(args...)
 			})
 
 			AfterEach(func() {
-				cmd.Terminate().Wait("15s")
+				if cmd != nil {
+					cmd.Terminate().Wait("15s")
+					cmd = nil
+				}
 			})
 
 			It("successfully curls the nginx service", func() {
+				var port string
+				Eventually(func() string {
+					port = forwardedPort(cmd.Out.Contents())
+					return port
+				}, "15s").ShouldNot(BeEmpty())
+
 				Eventually(curlLater("http://localhost:"+port), "15s").Should(ContainSubstring("Server: nginx"))
 			})
 		})
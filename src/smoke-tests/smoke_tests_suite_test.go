@@ -1,44 +1,169 @@
 package smoke_tests_test
 
 import (
+	"flag"
+	"fmt"
 	"html/template"
+	"io"
 	"io/ioutil"
 	"math/rand"
 	"os"
 	"path/filepath"
 	"runtime"
+	"smoke-tests/config"
+	"smoke-tests/parallel"
+	"smoke-tests/report"
 	"smoke-tests/runner"
 	"testing"
 	"time"
 
+	uuid "github.com/satori/go.uuid"
+
 	. "github.com/onsi/ginkgo"
+	ginkgoconfig "github.com/onsi/ginkgo/config"
+	"github.com/onsi/ginkgo/reporters"
 	. "github.com/onsi/gomega"
-	"github.com/onsi/gomega/gexec"
 )
 
 func TestK8SCluster(t *testing.T) {
+	if parallelism > 1 && ginkgoconfig.GinkgoConfig.ParallelTotal == 1 {
+		args := append(os.Args[1:], "-parallelism=1")
+		if err := parallel.Run(os.Args[0], args, parallelism, ginkgoconfig.GinkgoConfig.RandomSeed, os.Stdout); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
 	RegisterFailHandler(Fail)
-	RunSpecs(t, "CFCR Smoke-Tests Suite")
+	RunSpecsWithDefaultAndCustomReporters(t, "CFCR Smoke-Tests Suite", customReporters())
+}
+
+// customReporters writes a JUnit report and a JSON summary into -report-dir,
+// if one was given. Parallel nodes each write their own, e.g. junit-2.xml.
+func customReporters() []Reporter {
+	if reportDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(reportDir, 0755); err != nil {
+		fmt.Printf("Not writing reports: %s\n", err)
+		return nil
+	}
+
+	suffix := ""
+	if node := ginkgoconfig.GinkgoConfig.ParallelNode; ginkgoconfig.GinkgoConfig.ParallelTotal > 1 {
+		suffix = fmt.Sprintf("-%d", node)
+	}
+	jsonReporter := report.NewJSONReporter(filepath.Join(reportDir, "summary"+suffix+".json"))
+	runner.Output = io.MultiWriter(GinkgoWriter, jsonReporter)
+	return []Reporter{
+		reporters.NewJUnitReporter(filepath.Join(reportDir, "junit"+suffix+".xml")),
+		jsonReporter,
+	}
 }
 
 var (
-	k8sRunner *runner.KubectlRunner
-	tmpDir    string
-	pspSpec   string
+	k8sRunner   runner.Runner
+	kubectl     *runner.KubectlRunner
+	runID       string
+	testConfig  *config.Config
+	tmpDir      string
+	pspSpec     string
+	configPath  string
+	backend     string
+	reportDir   string
+	parallelism int
 )
 
-var _ = BeforeSuite(func() {
+func init() {
+	flag.StringVar(&configPath, "config", "", "path to test-config.json; built-in defaults are used when unset")
+	flag.StringVar(&backend, "runner", "", "how the suite talks to the cluster, kubectl or native; overrides the config file")
+	flag.StringVar(&reportDir, "report-dir", "", "directory to write junit.xml and summary.json to")
+	flag.IntVar(&parallelism, "parallelism", 1, "number of parallel nodes to run the specs on")
+}
+
+// The first function runs on node 1 only. It cleans up after earlier runs
+// and picks the run ID. The second runs on every node, each of which then
+// tests in a namespace of its own.
+var _ = SynchronizedBeforeSuite(func() []byte {
+	testConfig = loadConfig()
+	Expect(os.Setenv("KUBECONFIG", testConfig.Kubernetes.PathToKubeconfig)).To(Succeed())
+	k8sRunner = newRunner(runner.NewKubectlRunner())
+
+	runID = uuid.NewV4().String()
+	collectGarbage()
+	return []byte(runID)
+}, func(data []byte) {
 	var err error
 	rand.Seed(time.Now().UnixNano())
 	tmpDir, err = ioutil.TempDir("", "smoke-tests")
 	Expect(err).NotTo(HaveOccurred())
 
-	k8sRunner = runner.NewKubectlRunner()
-	k8sRunner.RunKubectlCommand("create", "namespace", k8sRunner.Namespace()).Wait("60s")
+	runID = string(data)
+	testConfig = loadConfig()
+	Expect(os.Setenv("KUBECONFIG", testConfig.Kubernetes.PathToKubeconfig)).To(Succeed())
+
+	kubectl = runner.NewKubectlRunner()
+	kubectl.Timeout = testConfig.Timeouts.Default.String()
+	k8sRunner = newRunner(kubectl)
+
+	_, err = k8sRunner.CreateNamespace(&runner.Namespace{ObjectMeta: runner.ObjectMeta{
+		Name:   k8sRunner.Namespace(),
+		Labels: map[string]string{runner.RunIDLabel: runID},
+	}})
+	Expect(err).NotTo(HaveOccurred())
 	pspSpec = templatePSPWithNamespace(tmpDir, k8sRunner.Namespace())
-	k8sRunner.RunKubectlCommand("apply", "-f", pspSpec)
+	Expect(k8sRunner.ApplyManifest(pspSpec)).To(Succeed())
 })
 
+func loadConfig() *config.Config {
+	c := config.Default()
+	if configPath != "" {
+		var err error
+		c, err = config.Load(configPath)
+		Expect(err).NotTo(HaveOccurred())
+	}
+	if backend != "" {
+		c.Runner = backend
+	}
+	if c.Kubernetes.PathToKubeconfig == "" {
+		c.Kubernetes.PathToKubeconfig = runner.DefaultKubeconfig()
+	}
+	Expect(c.Validate()).To(Succeed())
+	return c
+}
+
+// newRunner returns the configured backend. Exec and port-forward always go
+// through kubectl, so the native runner shares its namespace.
+func newRunner(kubectl *runner.KubectlRunner) runner.Runner {
+	if testConfig.Runner == "native" {
+		r, err := runner.NewNativeRunner(testConfig.Kubernetes.PathToKubeconfig, kubectl.Namespace())
+		Expect(err).NotTo(HaveOccurred())
+		return r
+	}
+	return kubectl
+}
+
+// collectGarbage deletes what earlier runs left behind when they were killed
+// before their AfterSuite.
+func collectGarbage() {
+	if testConfig.Cleanup.MaxAge.Duration == 0 {
+		return
+	}
+	deleted, err := runner.CollectGarbage(k8sRunner, runID, testConfig.Cleanup.MaxAge.Duration, time.Now())
+	for _, d := range deleted {
+		fmt.Fprintf(GinkgoWriter, "Deleted leftover %s\n", d)
+	}
+	Expect(err).NotTo(HaveOccurred())
+}
+
+// skipUnlessEnabled skips the current spec when its test group has been
+// turned off in the config.
+func skipUnlessEnabled(group string) {
+	if !testConfig.Enabled(group) {
+		Skip("test group " + group + " is disabled")
+	}
+}
+
 func getFixturePath(filename string) string {
 	srcDir, err := filepath.Abs(filepath.Dir(filename))
 	Expect(err).NotTo(HaveOccurred())
@@ -64,15 +189,23 @@ func templatePSPWithNamespace(tmpDir, namespace string) string {
 	Expect(err).NotTo(HaveOccurred())
 	defer f.Close()
 
-	type templateInfo struct{ PSPName, Namespace string }
-	Expect(t.Execute(f, templateInfo{PSPName: pspName, Namespace: namespace})).To(Succeed())
+	type templateInfo struct{ PSPName, Namespace, PSPChecker, RunIDLabel, RunID string }
+	Expect(t.Execute(f, templateInfo{
+		PSPName:    pspName,
+		Namespace:  namespace,
+		PSPChecker: pspChecker(namespace),
+		RunIDLabel: runner.RunIDLabel,
+		RunID:      runID,
+	})).To(Succeed())
 
 	return f.Name()
 }
 
-var _ = AfterSuite(func() {
-	if k8sRunner != nil {
-		Eventually(k8sRunner.RunKubectlCommand("delete", "-f", pspSpec), "60s").Should(gexec.Exit(0))
-		Eventually(k8sRunner.RunKubectlCommand("delete", "namespace", k8sRunner.Namespace()), "60s").Should(gexec.Exit(0))
+// Every node removes its own namespace and fixtures; there is nothing shared
+// left for node 1 to clean up.
+var _ = SynchronizedAfterSuite(func() {
+	if kubectl != nil {
+		Expect(k8sRunner.DeleteManifest(pspSpec)).To(Succeed())
+		Expect(k8sRunner.DeleteNamespace(k8sRunner.Namespace())).To(Succeed())
 	}
-})
+}, func() {})